@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	"github.com/hsfish/guacamole_client_go/gservlet"
+	logger "github.com/sirupsen/logrus"
+)
+
+/**
+ * How often, and with which timeout, unreachable backends are probed.
+ */
+const (
+	healthInterval = 10 * time.Second
+	dialTimeout    = 5 * time.Second
+)
+
+/**
+ * The guacd backends, used in turn. A backend which cannot be reached is
+ * skipped until a health check reaches it again.
+ */
+type backendPool struct {
+	backends []backendConfig
+	up       []bool
+	next     int
+	lock     sync.Mutex
+}
+
+func newBackendPool(backends []backendConfig) (ret *backendPool) {
+	ret = &backendPool{backends: backends, up: make([]bool, len(backends))}
+	for i := range ret.up {
+		ret.up[i] = true
+	}
+	return
+}
+
+/**
+ * Returns the backends in the order they should be tried: those reachable
+ * first, starting with the next in turn, then the others.
+ */
+func (opt *backendPool) order() (ret []int) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	start := opt.next
+	opt.next = (opt.next + 1) % len(opt.backends)
+
+	var down []int
+	for i := range opt.backends {
+		index := (start + i) % len(opt.backends)
+		if opt.up[index] {
+			ret = append(ret, index)
+		} else {
+			down = append(down, index)
+		}
+	}
+	return append(ret, down...)
+}
+
+func (opt *backendPool) setUp(index int, up bool) {
+	opt.lock.Lock()
+	changed := opt.up[index] != up
+	opt.up[index] = up
+	opt.lock.Unlock()
+	if changed {
+		logger.Infof("guacd at %v is now %v.", opt.backends[index], map[bool]string{true: "up", false: "down"}[up])
+	}
+}
+
+/**
+ * Dials the given backend.
+ */
+func (opt *backendPool) dial(ctx context.Context, backend backendConfig) (gnet.GuacamoleSocket, exp.ExceptionInterface) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if backend.SSL {
+		socket, err := gnet.NewSSLGuacamoleSocketContext(ctx, backend.Host, backend.Port)
+		if err != nil {
+			return nil, exp.GuacamoleUpstreamUnavailableException.Wrap(err, "Unable to connect to guacd.")
+		}
+		return &socket, nil
+	}
+	socket, err := gnet.NewInetGuacamoleSocketContext(ctx, backend.Host, backend.Port)
+	if err != nil {
+		return nil, err
+	}
+	return &socket, nil
+}
+
+/**
+ * Connects to the first backend which can be reached and completes the
+ * handshake. Errors reported by guacd itself are not retried elsewhere.
+ */
+func (opt *backendPool) connect(ctx context.Context, config gprotocol.GuacamoleConfiguration,
+	info gprotocol.GuacamoleClientInformation) (ret gnet.GuacamoleTunnel, err exp.ExceptionInterface) {
+
+	reservation := gservlet.QuotaReservationFromContext(ctx)
+	for _, index := range opt.order() {
+		backend := opt.backends[index]
+
+		// Skip backends having reached their quota
+		if e := reservation.SetBackend(backend.String()); e != nil {
+			err = e
+			continue
+		}
+		socket, e := opt.dial(ctx, backend)
+		if e != nil {
+			logger.Warnf("Unable to reach guacd at %v: %v", backend, e.GetMessage())
+			opt.setUp(index, false)
+			err = e
+			continue
+		}
+		opt.setUp(index, true)
+
+		configured, e := gnet.NewConfiguredGuacamoleSocketContext(ctx, socket, config, info)
+		if e != nil {
+			socket.Close()
+			return nil, e
+		}
+		return gnet.NewSimpleGuacamoleTunnel(&configured, config), nil
+	}
+	return nil, err
+}
+
+/**
+ * Probes every backend periodically until the given channel is closed.
+ */
+func (opt *backendPool) watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		opt.check()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+/**
+ * Probes every backend once by connecting and disconnecting.
+ */
+func (opt *backendPool) check() {
+	for index, backend := range opt.backends {
+		socket, err := opt.dial(context.Background(), backend)
+		if err == nil {
+			socket.Close()
+		}
+		opt.setUp(index, err == nil)
+	}
+}
+
+/**
+ * The state of a backend, as reported by the health endpoint.
+ */
+type backendStatus struct {
+	Address string `json:"address"`
+	Up      bool   `json:"up"`
+}
+
+func (opt *backendPool) status() (ret []backendStatus, anyUp bool) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	for index, backend := range opt.backends {
+		ret = append(ret, backendStatus{Address: backend.String(), Up: opt.up[index]})
+		anyUp = anyUp || opt.up[index]
+	}
+	return
+}
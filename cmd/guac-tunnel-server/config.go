@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+/**
+ * The configuration file of the server.
+ */
+type serverConfig struct {
+	// Listen is the address of the HTTP server, e.g. ":8080".
+	Listen string `yaml:"listen"`
+
+	// TLS enables HTTPS with the given certificate and key files.
+	TLS struct {
+		Cert string `yaml:"cert"`
+		Key  string `yaml:"key"`
+	} `yaml:"tls"`
+
+	// Guacd lists the guacd backends, tried in turn.
+	Guacd []backendConfig `yaml:"guacd"`
+
+	// Catalog is the connection catalog file, in the YAML, JSON or
+	// user-mapping.xml format, reloaded when it changes.
+	Catalog string `yaml:"catalog"`
+
+	// Auth configures the signed tokens authorizing connect requests.
+	Auth struct {
+		// Secret is the HMAC-SHA256 key of the tokens. SecretFile, if set,
+		// is read instead. Without either, connect requests name the
+		// connection with GUAC_ID and are not authenticated.
+		Secret     string `yaml:"secret"`
+		SecretFile string `yaml:"secretFile"`
+	} `yaml:"auth"`
+
+	// Recording enables session recording by guacd into Path, which must
+	// be writable by guacd. Name may use the ${GUAC_*} tokens.
+	Recording struct {
+		Path        string `yaml:"path"`
+		Name        string `yaml:"name"`
+		IncludeKeys bool   `yaml:"includeKeys"`
+	} `yaml:"recording"`
+
+	// Paths of the endpoints served.
+	Paths struct {
+		Tunnel    string `yaml:"tunnel"`
+		WebSocket string `yaml:"websocket"`
+		Health    string `yaml:"health"`
+		Metrics   string `yaml:"metrics"`
+
+		// NoticeScript serves the script displaying session notices,
+		// such as drain and timeout warnings, in the JavaScript client.
+		NoticeScript string `yaml:"noticeScript"`
+	} `yaml:"paths"`
+
+	// AllowedOrigins lists the origins allowed to open WebSocket tunnels,
+	// "*" allowing any. By default, only the origin of the server is.
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+
+	// BindClientAddress rejects HTTP tunnel requests sent from another
+	// client address than the connect request.
+	BindClientAddress bool `yaml:"bindClientAddress"`
+
+	// TunnelTimeout closes HTTP tunnels no longer polled by their client.
+	TunnelTimeout time.Duration `yaml:"tunnelTimeout"`
+
+	// ResumeWindow lets HTTP tunnels resume after a lost connection, for
+	// clients sending "resume" requests (see gservlet.RESUME_PREFIX).
+	ResumeWindow time.Duration `yaml:"resumeWindow"`
+
+	// DrainTimeout bounds the graceful drain on SIGTERM or SIGINT.
+	DrainTimeout time.Duration `yaml:"drainTimeout"`
+
+	// InputLimits limits the input written by clients over both tunnels.
+	// Per-user limits apply with bindClientAddress, per client address.
+	// Zero values are unlimited or, for sizes, the protocol maximum.
+	InputLimits struct {
+		TunnelBytesPerSecond        int `yaml:"tunnelBytesPerSecond"`
+		TunnelInstructionsPerSecond int `yaml:"tunnelInstructionsPerSecond"`
+		UserBytesPerSecond          int `yaml:"userBytesPerSecond"`
+		UserInstructionsPerSecond   int `yaml:"userInstructionsPerSecond"`
+		MaxInstructionLength        int `yaml:"maxInstructionLength"`
+		MaxInstructionElements      int `yaml:"maxInstructionElements"`
+	} `yaml:"inputLimits"`
+
+	// Quotas limits the number of active tunnels of both tunnels, in
+	// total, per user (the subject of the token), per connection and per
+	// guacd backend. Zero values are unlimited.
+	Quotas struct {
+		Total         int `yaml:"total"`
+		PerUser       int `yaml:"perUser"`
+		PerConnection int `yaml:"perConnection"`
+		PerBackend    int `yaml:"perBackend"`
+	} `yaml:"quotas"`
+}
+
+/**
+ * A guacd backend.
+ */
+type backendConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	SSL  bool   `yaml:"ssl"`
+}
+
+func (opt backendConfig) String() string {
+	return fmt.Sprintf("%v:%v", opt.Host, opt.Port)
+}
+
+/**
+ * Reads the configuration file at the given path, applying defaults.
+ */
+func loadConfig(path string) (ret serverConfig, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = yaml.UnmarshalStrict(data, &ret); err != nil {
+		return ret, fmt.Errorf("%v: %v", path, err)
+	}
+
+	if len(ret.Listen) == 0 {
+		ret.Listen = ":8080"
+	}
+	if len(ret.Guacd) == 0 {
+		ret.Guacd = []backendConfig{{Host: "localhost"}}
+	}
+	for i := range ret.Guacd {
+		if ret.Guacd[i].Port == 0 {
+			ret.Guacd[i].Port = 4822
+		}
+	}
+	if len(ret.Catalog) == 0 {
+		return ret, fmt.Errorf("%v: no connection catalog configured", path)
+	}
+	if len(ret.Auth.SecretFile) > 0 {
+		secret, e := ioutil.ReadFile(ret.Auth.SecretFile)
+		if e != nil {
+			return ret, e
+		}
+		ret.Auth.Secret = strings.TrimSpace(string(secret))
+	}
+	if len(ret.Recording.Name) == 0 {
+		ret.Recording.Name = "${GUAC_DATE}-${GUAC_TIME}-${GUAC_USERNAME}"
+	}
+	if len(ret.Paths.Tunnel) == 0 {
+		ret.Paths.Tunnel = "/tunnel"
+	}
+	if len(ret.Paths.WebSocket) == 0 {
+		ret.Paths.WebSocket = "/websocket-tunnel"
+	}
+	if len(ret.Paths.Health) == 0 {
+		ret.Paths.Health = "/healthz"
+	}
+	if len(ret.Paths.Metrics) == 0 {
+		ret.Paths.Metrics = "/metrics"
+	}
+	if len(ret.Paths.NoticeScript) == 0 {
+		ret.Paths.NoticeScript = "/guacamole-notice.js"
+	}
+	if ret.DrainTimeout == 0 {
+		ret.DrainTimeout = 5 * time.Minute
+	}
+	return
+}
@@ -0,0 +1,127 @@
+// Command guac-tunnel-server serves the HTTP and WebSocket tunnels of the
+// Guacamole JavaScript client to the connections of a catalog file,
+// through one or more guacd backends, with health and metrics endpoints.
+//
+// Usage:
+//
+//	guac-tunnel-server -config FILE
+//	guac-tunnel-server -config FILE -issue-token -user NAME -connection NAME [-ttl DURATION]
+//
+// SIGHUP reloads the catalog. SIGTERM and SIGINT drain open sessions,
+// within the drainTimeout of the configuration, before exiting.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+func main() {
+	configPath := flag.String("config", "guac-tunnel-server.yaml", "configuration file")
+	issue := flag.Bool("issue-token", false, "print a connect token signed with the configured secret, and exit")
+	user := flag.String("user", "", "user name of the issued token")
+	connection := flag.String("connection", "", "connection of the issued token")
+	ttl := flag.Duration("ttl", time.Hour, "validity of the issued token")
+	flag.Parse()
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "guac-tunnel-server:", err)
+		os.Exit(1)
+	}
+
+	if *issue {
+		if err := issueToken(config, *user, *connection, *ttl); err != nil {
+			fmt.Fprintln(os.Stderr, "guac-tunnel-server:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := run(config); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+/**
+ * Prints a token letting the given user open the given connection.
+ */
+func issueToken(config serverConfig, user, connection string, ttl time.Duration) error {
+	if len(config.Auth.Secret) == 0 {
+		return fmt.Errorf("no auth secret configured")
+	}
+	if len(connection) == 0 {
+		return fmt.Errorf("-connection is required")
+	}
+	token, err := signToken(config.Auth.Secret, tokenClaims{
+		Subject:    user,
+		Connection: connection,
+		Expires:    time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+/**
+ * Serves until SIGTERM or SIGINT, then drains open sessions.
+ */
+func run(config serverConfig) error {
+	srv, err := newServer(config)
+	if err != nil {
+		return err
+	}
+	srv.start()
+
+	httpServer := &http.Server{Addr: config.Listen, Handler: srv.handler()}
+	failed := make(chan error, 1)
+	go func() {
+		logger.Infof("Listening on %v.", config.Listen)
+		var e error
+		if len(config.TLS.Cert) > 0 {
+			e = httpServer.ListenAndServeTLS(config.TLS.Cert, config.TLS.Key)
+		} else {
+			e = httpServer.ListenAndServe()
+		}
+		if e != http.ErrServerClosed {
+			failed <- e
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	for {
+		select {
+		case err := <-failed:
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if err := srv.catalog.Reload(); err != nil {
+					logger.Warnf("Unable to reload catalog: %v", err.GetMessage())
+				} else {
+					logger.Info("Catalog reloaded.")
+				}
+				continue
+			}
+
+			logger.Infof("Received %v, draining sessions for up to %v.", sig, config.DrainTimeout)
+			ctx, cancel := context.WithTimeout(context.Background(), config.DrainTimeout)
+			srv.drain(ctx)
+			cancel()
+
+			ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return httpServer.Shutdown(ctx)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gcatalog"
+	"github.com/hsfish/guacamole_client_go/gmetrics"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	"github.com/hsfish/guacamole_client_go/gservlet"
+	"github.com/hsfish/guacamole_client_go/gwebsocket"
+	logger "github.com/sirupsen/logrus"
+)
+
+/**
+ * The maximum size of the connect data sent by the client.
+ */
+const maxConnectDataSize = 8192
+
+/**
+ * The names of the connect parameters sent by the Guacamole web
+ * application, which the JavaScript client passes to connect().
+ */
+const (
+	tokenParameter  = "token"
+	idParameter     = "GUAC_ID"
+	widthParameter  = "GUAC_WIDTH"
+	heightParameter = "GUAC_HEIGHT"
+	dpiParameter    = "GUAC_DPI"
+	audioParameter  = "GUAC_AUDIO"
+	videoParameter  = "GUAC_VIDEO"
+	imageParameter  = "GUAC_IMAGE"
+)
+
+/**
+ * The tunnel server: HTTP and WebSocket tunnels to the connections of a
+ * catalog, served through a pool of guacd backends.
+ */
+type server struct {
+	config    serverConfig
+	catalog   *gcatalog.ConnectionCatalog
+	backends  *backendPool
+	servlet   gservlet.GuacamoleHTTPTunnelServlet
+	websocket *gwebsocket.GuacamoleWebSocketTunnelEndpoint
+	metrics   *gmetrics.Registry
+	draining  int32
+	stop      chan struct{}
+}
+
+func newServer(config serverConfig) (ret *server, err error) {
+	catalog, e := gcatalog.LoadConnectionCatalog(config.Catalog)
+	if e != nil {
+		return nil, e
+	}
+
+	ret = &server{
+		config:   config,
+		catalog:  catalog,
+		backends: newBackendPool(config.Guacd),
+		metrics:  gmetrics.NewRegistry(),
+		stop:     make(chan struct{}),
+	}
+	gmetrics.Enable(ret.metrics)
+
+	options := gservlet.DefaultGuacamoleHTTPTunnelMapOptions()
+	if config.TunnelTimeout > 0 {
+		options.TunnelTimeout = config.TunnelTimeout
+		options.SweepInterval = config.TunnelTimeout / 2
+	}
+	options.ResumeWindow = config.ResumeWindow
+	ret.servlet = gservlet.NewGuacamoleHTTPTunnelServlet2(nil, options)
+	ret.servlet.SetDoConnectContext(ret.doConnect)
+	var owners gservlet.TunnelOwnerResolverInterface
+	if config.BindClientAddress {
+		owners = gservlet.RemoteAddressOwnerResolver(32, 128)
+		ret.servlet.SetOwnerResolver(owners)
+	}
+	var inputLimiter *gservlet.InputLimiter
+	if limits := gservlet.InputLimits(config.InputLimits); limits != (gservlet.InputLimits{}) {
+		inputLimiter = gservlet.NewInputLimiter(limits)
+		ret.servlet.SetInputLimiter(inputLimiter)
+	}
+	var quotas *gservlet.QuotaManager
+	if limits := gservlet.QuotaLimits(config.Quotas); limits != (gservlet.QuotaLimits{}) {
+		quotas = gservlet.NewQuotaManager(limits)
+		ret.servlet.SetQuotaManager(quotas)
+	}
+
+	// Both tunnels publish on the same bus
+	ret.websocket = gwebsocket.NewGuacamoleWebSocketTunnelEndpoint(ret.doConnect, ret.servlet.GetEventBus())
+	if len(config.AllowedOrigins) > 0 {
+		ret.websocket.SetCheckOrigin(ret.checkOrigin)
+	}
+	if quotas != nil {
+		ret.websocket.SetQuotaManager(quotas)
+	}
+	if inputLimiter != nil {
+		ret.websocket.SetInputLimiter(inputLimiter, owners)
+	}
+
+	if len(config.Auth.Secret) == 0 {
+		logger.Warn("No auth secret configured: any client may open any connection of the catalog.")
+	}
+	return
+}
+
+/**
+ * Returns the handler serving every endpoint of the server.
+ */
+func (opt *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(opt.config.Paths.Tunnel, &opt.servlet)
+	mux.Handle(opt.config.Paths.WebSocket, opt.websocket)
+	mux.HandleFunc(opt.config.Paths.Health, opt.serveHealth)
+	mux.Handle(opt.config.Paths.Metrics, opt.metrics)
+	mux.HandleFunc(opt.config.Paths.NoticeScript, gservlet.ServeNoticeScript)
+	return mux
+}
+
+/**
+ * Starts the background tasks of the server.
+ */
+func (opt *server) start() {
+	go opt.backends.watch(opt.stop)
+	opt.catalog.Watch(5 * time.Second)
+}
+
+/**
+ * Drains both tunnels within the given context, then stops the background
+ * tasks of the server.
+ */
+func (opt *server) drain(ctx context.Context) {
+	atomic.StoreInt32(&opt.draining, 1)
+	done := make(chan int, 2)
+	go func() { done <- opt.servlet.Drain(ctx) }()
+	go func() { done <- opt.websocket.Drain(ctx) }()
+	closed := <-done + <-done
+	if closed > 0 {
+		logger.Infof("Drain ended, %v sessions were closed.", closed)
+	}
+	opt.servlet.Destroy()
+	opt.websocket.Destroy()
+	opt.catalog.Close()
+	close(opt.stop)
+}
+
+/**
+ * Establishes the tunnel requested by a connect request of either tunnel.
+ */
+func (opt *server) doConnect(ctx context.Context, request gservlet.HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error) {
+	parameters, err := connectParameters(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find out who may open which connection
+	username, connection := "", parameters.Get(idParameter)
+	if len(opt.config.Auth.Secret) > 0 {
+		claims, err := verifyToken(opt.config.Auth.Secret, parameters.Get(tokenParameter), time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if len(connection) > 0 && connection != claims.Connection {
+			return nil, exp.GuacamoleSecurityException.Throw("Permission denied.")
+		}
+		username, connection = claims.Subject, claims.Connection
+	}
+	if len(connection) == 0 {
+		return nil, exp.GuacamoleClientException.Throw("No connection requested.")
+	}
+
+	// Count the tunnel against the quotas of the user and connection
+	reservation := gservlet.QuotaReservationFromContext(ctx)
+	if err := reservation.SetUser(username); err != nil {
+		return nil, err
+	}
+	if err := reservation.SetConnection(connection); err != nil {
+		return nil, err
+	}
+
+	config, err := opt.catalog.Get(connection)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.config.Recording.Path) > 0 {
+		config.SetParameter("recording-path", opt.config.Recording.Path)
+		config.SetParameter("recording-name", opt.config.Recording.Name)
+		config.SetParameter("create-recording-path", "true")
+		if opt.config.Recording.IncludeKeys {
+			config.SetParameter("recording-include-keys", "true")
+		}
+	}
+
+	// Expand ${GUAC_*} tokens for this user
+	credentials := gprotocol.StandardCredentials{Username: username}
+	if addr, ok := request.(gservlet.HTTPServletRequestRemoteAddrInterface); ok {
+		credentials.RemoteAddress = addr.GetRemoteAddr()
+	}
+	filter := gprotocol.NewTokenFilter()
+	gprotocol.AddStandardTokens(&filter, credentials)
+	config = config.Resolve(&filter)
+
+	logger.Infof("User \"%v\" connecting to \"%v\" (%v).", username, connection, credentials.RemoteAddress)
+	tunnel, err := opt.backends.connect(ctx, config, clientInformation(parameters))
+	if err != nil {
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+/**
+ * Returns the connect parameters of the given request: the body of HTTP
+ * tunnel connect requests, or the query string of WebSocket tunnel
+ * requests.
+ */
+func connectParameters(request gservlet.HTTPServletRequestInterface) (url.Values, exp.ExceptionInterface) {
+	data := request.GetQueryString()
+	if data == gservlet.CONNECT_OPERATION {
+		body, e := ioutil.ReadAll(io.LimitReader(request, maxConnectDataSize+1))
+		if e != nil {
+			return nil, exp.GuacamoleClientException.Wrap(e, "Unable to read connect data.")
+		}
+		if len(body) > maxConnectDataSize {
+			return nil, exp.GuacamoleClientOverrunException.Throw("Connect data too large.")
+		}
+		data = string(body)
+	}
+	parameters, e := url.ParseQuery(data)
+	if e != nil {
+		return nil, exp.GuacamoleClientException.Wrap(e, "Invalid connect data.")
+	}
+	return parameters, nil
+}
+
+/**
+ * Returns the client information given by the connect parameters.
+ */
+func clientInformation(parameters url.Values) (ret gprotocol.GuacamoleClientInformation) {
+	ret = gprotocol.NewGuacamoleClientInformation()
+	if value, e := strconv.Atoi(parameters.Get(widthParameter)); e == nil && value > 0 {
+		ret.SetOptimalScreenWidth(value)
+	}
+	if value, e := strconv.Atoi(parameters.Get(heightParameter)); e == nil && value > 0 {
+		ret.SetOptimalScreenHeight(value)
+	}
+	if value, e := strconv.Atoi(parameters.Get(dpiParameter)); e == nil && value > 0 {
+		ret.SetOptimalResolution(value)
+	}
+	ret.SetAudioMimetypes(parameters[audioParameter])
+	ret.SetVideoMimetypes(parameters[videoParameter])
+	ret.SetImageMimetypes(parameters[imageParameter])
+	return
+}
+
+/**
+ * Returns whether the origin of the given WebSocket upgrade request is
+ * allowed.
+ */
+func (opt *server) checkOrigin(request *http.Request) bool {
+	origin := request.Header.Get("Origin")
+	for _, allowed := range opt.config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * The body of the health endpoint.
+ */
+type healthStatus struct {
+	Status   string               `json:"status"`
+	Draining bool                 `json:"draining"`
+	Tunnels  map[string]int       `json:"tunnels"`
+	Quotas   *gservlet.QuotaUsage `json:"quotas,omitempty"`
+	Backends []backendStatus      `json:"backends"`
+}
+
+/**
+ * Answers 200 while the server accepts connections and a backend is up,
+ * and 503 otherwise, so that load balancers stop sending new sessions.
+ */
+func (opt *server) serveHealth(writer http.ResponseWriter, request *http.Request) {
+	backends, anyUp := opt.backends.status()
+	health := healthStatus{
+		Status:   "ok",
+		Draining: atomic.LoadInt32(&opt.draining) == 1,
+		Tunnels: map[string]int{
+			"http":      opt.servlet.Size(),
+			"websocket": opt.websocket.Size(),
+		},
+		Backends: backends,
+	}
+	if quotas := opt.servlet.GetQuotaManager(); quotas != nil {
+		usage := quotas.Usage()
+		health.Quotas = &usage
+	}
+	code := http.StatusOK
+	switch {
+	case health.Draining:
+		health.Status, code = "draining", http.StatusServiceUnavailable
+	case !anyUp:
+		health.Status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(code)
+	json.NewEncoder(writer).Encode(health)
+}
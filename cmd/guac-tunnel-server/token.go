@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+/**
+ * The claims of a connect token, issued by the application embedding the
+ * JavaScript client and passed as the "token" connect parameter.
+ */
+type tokenClaims struct {
+	// Subject is the name of the user.
+	Subject string `json:"sub"`
+
+	// Connection is the name of the catalog connection the user may open.
+	Connection string `json:"conn"`
+
+	// Expires is the Unix time after which the token is refused. Tokens
+	// without expiry are invalid.
+	Expires int64 `json:"exp"`
+}
+
+/**
+ * Returns the token carrying the given claims, signed with the given
+ * secret, of the form BASE64URL(claims JSON) "." BASE64URL(HMAC-SHA256).
+ */
+func signToken(secret string, claims tokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(secret, encoded)), nil
+}
+
+func tokenSignature(secret, encoded string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+/**
+ * Verifies the signature and expiry of the given token, returning its
+ * claims.
+ *
+ * @throws GuacamoleUnauthorizedException If the token is missing, invalid
+ *                                        or expired.
+ */
+func verifyToken(secret, token string, now time.Time) (claims tokenClaims, err exp.ExceptionInterface) {
+	if len(token) == 0 {
+		return claims, exp.GuacamoleUnauthorizedException.Throw("Missing token.")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return claims, exp.GuacamoleUnauthorizedException.Throw("Invalid token.")
+	}
+	signature, e := base64.RawURLEncoding.DecodeString(parts[1])
+	if e != nil || !hmac.Equal(signature, tokenSignature(secret, parts[0])) {
+		return claims, exp.GuacamoleUnauthorizedException.Throw("Invalid token.")
+	}
+	payload, e := base64.RawURLEncoding.DecodeString(parts[0])
+	if e != nil || json.Unmarshal(payload, &claims) != nil || len(claims.Connection) == 0 || claims.Expires == 0 {
+		return claims, exp.GuacamoleUnauthorizedException.Throw("Invalid token.")
+	}
+	if now.Unix() > claims.Expires {
+		return claims, exp.GuacamoleUnauthorizedException.Throw("Token expired.")
+	}
+	return
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+const testSecret = "token secret"
+
+/**
+ * Returns a token whose payload is the given JSON, signed with the given
+ * secret.
+ */
+func rawToken(secret, payload string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(secret, encoded))
+}
+
+func Test_VerifyToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	claims := tokenClaims{Subject: "alice", Connection: "rdp", Expires: now.Unix() + 60}
+	token, err := signToken(testSecret, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","conn":"admin","exp":1700000060}`))
+
+	cases := []struct {
+		name  string
+		token string
+		now   time.Time
+		valid bool
+	}{
+		{"valid", token, now, true},
+		{"at expiry", token, time.Unix(claims.Expires, 0), true},
+		{"expired", token, time.Unix(claims.Expires+1, 0), false},
+		{"missing", "", now, false},
+		{"one segment", parts[0], now, false},
+		{"three segments", token + "." + parts[1], now, false},
+		{"bad signature", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte("signature")), now, false},
+		{"undecodable signature", parts[0] + ".!!!", now, false},
+		{"wrong secret", rawToken("other secret", `{"sub":"alice","conn":"rdp","exp":1700000060}`), now, false},
+		{"tampered claims", tampered + "." + parts[1], now, false},
+		{"missing exp", rawToken(testSecret, `{"sub":"alice","conn":"rdp"}`), now, false},
+		{"missing conn", rawToken(testSecret, `{"sub":"alice","exp":1700000060}`), now, false},
+		{"invalid JSON", rawToken(testSecret, `{"sub":`), now, false},
+	}
+	for _, one := range cases {
+		verified, err := verifyToken(testSecret, one.token, one.now)
+		if !one.valid {
+			if !errors.Is(err, exp.GuacamoleUnauthorizedException) {
+				t.Errorf("%v: err %v", one.name, err)
+			}
+			continue
+		}
+		if err != nil || verified != claims {
+			t.Errorf("%v: claims %+v, err %v", one.name, verified, err)
+		}
+	}
+}
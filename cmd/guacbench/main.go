@@ -0,0 +1,364 @@
+// Command guacbench measures how many concurrent sessions a tunnel server
+// handles. It opens many tunnels through the HTTP or WebSocket tunnel to
+// a mock guacd, which sends frames of drawing instructions and image
+// data, while each simulated browser answers every "sync" and sends mouse
+// and key events. It then reports throughput, frame latency and memory.
+//
+// Usage:
+//
+//	guacbench [flags]
+//
+// By default the tunnel server is the one of this module, run within
+// guacbench. With -target, an external server is measured instead, which
+// must be configured to connect to the mock guacd at -guacd-listen.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/cmd/internal/mockguacd"
+	logger "github.com/sirupsen/logrus"
+)
+
+/**
+ * The flags of the benchmark.
+ */
+type benchFlags struct {
+	tunnels     int
+	transport   string
+	duration    time.Duration
+	ramp        time.Duration
+	output      mockguacd.OutputConfig
+	inputRate   int
+	target      string
+	tunnelPath  string
+	wsPath      string
+	guacdListen string
+	connectData string
+	asJSON      bool
+	verbose     bool
+}
+
+func (opt *benchFlags) register(flags *flag.FlagSet) {
+	flags.IntVar(&opt.tunnels, "tunnels", 10, "number of concurrent tunnels")
+	flags.StringVar(&opt.transport, "transport", "http", "tunnel used by the clients, http or websocket")
+	flags.DurationVar(&opt.duration, "duration", 30*time.Second, "length of the measurement, once all tunnels are open")
+	flags.DurationVar(&opt.ramp, "ramp", 5*time.Second, "time over which the tunnels are opened")
+	flags.IntVar(&opt.output.FPS, "fps", 30, "frames per second sent by guacd to each tunnel")
+	flags.IntVar(&opt.output.Ops, "ops", 20, "drawing operations per frame")
+	flags.IntVar(&opt.output.ImageBytes, "image-bytes", 4096, "bytes of image data per frame")
+	flags.IntVar(&opt.output.MaxLag, "max-lag", 5, "frames awaiting their sync reply before guacd skips frames")
+	flags.IntVar(&opt.inputRate, "input-rate", 20, "mouse and key events per second sent by each client")
+	flags.StringVar(&opt.target, "target", "", "base URL of an external tunnel server, e.g. http://localhost:8080")
+	flags.StringVar(&opt.tunnelPath, "tunnel-path", "/tunnel", "path of the HTTP tunnel of the target")
+	flags.StringVar(&opt.wsPath, "websocket-path", "/websocket-tunnel", "path of the WebSocket tunnel of the target")
+	flags.StringVar(&opt.guacdListen, "guacd-listen", "", "address of the mock guacd, by default 127.0.0.1:4822 with -target and any port otherwise")
+	flags.StringVar(&opt.connectData, "connect-data", "", "connect parameters sent by the clients, e.g. GUAC_ID=bench or token=...")
+	flags.BoolVar(&opt.asJSON, "json", false, "print the report as JSON")
+	flags.BoolVar(&opt.verbose, "v", false, "log tunnel server activity")
+}
+
+func (opt *benchFlags) validate() error {
+	switch {
+	case opt.tunnels <= 0:
+		return fmt.Errorf("-tunnels must be positive")
+	case opt.transport != "http" && opt.transport != "websocket":
+		return fmt.Errorf("-transport must be http or websocket")
+	case opt.output.FPS <= 0:
+		return fmt.Errorf("-fps must be positive")
+	case opt.output.MaxLag <= 0:
+		return fmt.Errorf("-max-lag must be positive")
+	case opt.inputRate < 0 || opt.output.Ops < 0 || opt.output.ImageBytes < 0:
+		return fmt.Errorf("rates and sizes must not be negative")
+	}
+	return nil
+}
+
+func main() {
+	var flags benchFlags
+	flags.register(flag.CommandLine)
+	flag.Parse()
+	if err := flags.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "guacbench:", err)
+		os.Exit(2)
+	}
+	if !flags.verbose {
+		logger.SetLevel(logger.WarnLevel)
+	}
+
+	report, err := run(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "guacbench:", err)
+		os.Exit(1)
+	}
+	if flags.asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+	report.print()
+}
+
+/**
+ * The results of a benchmark, over the measurement window which starts
+ * once all tunnels are open.
+ */
+type benchReport struct {
+	Transport      string      `json:"transport"`
+	External       bool        `json:"external"`
+	Tunnels        int         `json:"tunnels"`
+	Opened         int         `json:"opened"`
+	Failed         int         `json:"failed"`
+	Closed         int         `json:"closedEarly"`
+	Errors         []string    `json:"errors,omitempty"`
+	Seconds        float64     `json:"seconds"`
+	ConnectLatency percentiles `json:"connectLatency"`
+
+	BytesPerSecond        float64 `json:"bytesPerSecond"`
+	InstructionsPerSecond float64 `json:"instructionsPerSecond"`
+	BytesPerTunnel        float64 `json:"bytesPerSecondPerTunnel"`
+
+	FramesSent    int64       `json:"framesSent"`
+	FramesSkipped int64       `json:"framesSkipped"`
+	FrameLatency  percentiles `json:"frameLatency"`
+
+	InputSent     int64 `json:"inputSent"`
+	InputReceived int64 `json:"inputReceived"`
+
+	Memory *memoryReport `json:"memory,omitempty"`
+}
+
+/**
+ * The memory used per tunnel, measured within the process. It includes
+ * the tunnel server, the mock guacd and the simulated clients.
+ */
+type memoryReport struct {
+	HeapBytesPerTunnel  float64 `json:"heapBytesPerTunnel"`
+	GoroutinesPerTunnel float64 `json:"goroutinesPerTunnel"`
+	HeapInUse           uint64  `json:"heapInUse"`
+}
+
+/**
+ * The failures of sessions, of which the first few are kept.
+ */
+type failures struct {
+	count    int64
+	messages []string
+	lock     sync.Mutex
+}
+
+func (opt *failures) add(err error) {
+	atomic.AddInt64(&opt.count, 1)
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if len(opt.messages) < 5 {
+		opt.messages = append(opt.messages, err.Error())
+	}
+}
+
+func run(flags benchFlags) (report benchReport, err error) {
+	report.Transport, report.Tunnels, report.External = flags.transport, flags.tunnels, len(flags.target) > 0
+
+	listen := flags.guacdListen
+	if len(listen) == 0 {
+		listen = "127.0.0.1:0"
+		if report.External {
+			listen = "127.0.0.1:4822"
+		}
+	}
+	frameLatency := newLatencies()
+	mock, err := mockguacd.NewServer(listen, flags.output, frameLatency.add)
+	if err != nil {
+		return
+	}
+	defer mock.Close()
+
+	base := flags.target
+	if !report.External {
+		host, portText, _ := net.SplitHostPort(mock.Address())
+		port, _ := strconv.Atoi(portText)
+		server, e := newLocalServer(host, port)
+		if e != nil {
+			return report, e
+		}
+		defer server.close()
+		base = server.baseURL()
+	}
+
+	newTransport := func() transport {
+		return &httpTransport{client: httpClient(), url: base + flags.tunnelPath}
+	}
+	if flags.transport == "websocket" {
+		wsBase, e := webSocketURL(base)
+		if e != nil {
+			return report, e
+		}
+		newTransport = func() transport {
+			return &webSocketTransport{url: wsBase + flags.wsPath}
+		}
+	}
+
+	before := memoryUsage()
+
+	// Open the tunnels, spread over the ramp
+	counters := &clientCounters{}
+	connectLatency := newLatencies()
+	connectFailures, sessionFailures := &failures{}, &failures{}
+	var sessions []*session
+	var sessionsLock sync.Mutex
+	var connecting, running sync.WaitGroup
+	var closedEarly int64
+	for n := 0; n < flags.tunnels; n++ {
+		connecting.Add(1)
+		go func() {
+			defer connecting.Done()
+			tunnel := newTransport()
+			start := time.Now()
+			if _, err := tunnel.connect(flags.connectData); err != nil {
+				connectFailures.add(err)
+				return
+			}
+			connectLatency.add(time.Since(start))
+
+			one := newSession(tunnel, counters, flags.inputRate)
+			sessionsLock.Lock()
+			sessions = append(sessions, one)
+			sessionsLock.Unlock()
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				if err := one.run(); err != nil {
+					atomic.AddInt64(&closedEarly, 1)
+					sessionFailures.add(err)
+				}
+			}()
+		}()
+		time.Sleep(flags.ramp / time.Duration(flags.tunnels))
+	}
+	connecting.Wait()
+	report.Opened = len(sessions)
+	report.Failed = int(connectFailures.count)
+	if report.Opened == 0 {
+		return report, fmt.Errorf("no tunnel could be opened: %v", connectFailures.messages)
+	}
+
+	// Measure over a window starting once all tunnels are open
+	after := memoryUsage()
+	if !report.External {
+		report.Memory = &memoryReport{
+			HeapBytesPerTunnel:  float64(after.heap-before.heap) / float64(report.Opened),
+			GoroutinesPerTunnel: float64(after.goroutines-before.goroutines) / float64(report.Opened),
+			HeapInUse:           after.heap,
+		}
+	}
+	frameLatency.reset()
+	startCounters := counters.snapshot()
+	startSent, startSkipped, startInput := mock.FramesSent(), mock.FramesSkipped(), mock.InputEvents()
+	start := time.Now()
+
+	if !flags.asJSON {
+		fmt.Fprintf(os.Stderr, "guacbench: %v tunnels open, measuring for %v\n", report.Opened, flags.duration)
+	}
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-time.After(flags.duration):
+	case <-interrupted:
+	}
+
+	elapsed := time.Since(start)
+	endCounters := counters.snapshot()
+	report.Seconds = elapsed.Seconds()
+	report.FramesSent = mock.FramesSent() - startSent
+	report.FramesSkipped = mock.FramesSkipped() - startSkipped
+	report.InputReceived = mock.InputEvents() - startInput
+	report.FrameLatency = frameLatency.percentiles()
+	report.ConnectLatency = connectLatency.percentiles()
+	report.InputSent = endCounters.inputSent - startCounters.inputSent
+	report.BytesPerSecond = float64(endCounters.bytesReceived-startCounters.bytesReceived) / report.Seconds
+	report.InstructionsPerSecond = float64(endCounters.instructionsReceived-startCounters.instructionsReceived) / report.Seconds
+	report.BytesPerTunnel = report.BytesPerSecond / float64(report.Opened)
+
+	// Writes racing the disconnects are expected to fail from now on
+	if !flags.verbose {
+		logger.SetLevel(logger.FatalLevel)
+	}
+	for _, one := range sessions {
+		one.stop()
+	}
+	running.Wait()
+	report.Closed = int(atomic.LoadInt64(&closedEarly))
+	report.Errors = append(connectFailures.messages, sessionFailures.messages...)
+	return
+}
+
+/**
+ * Returns the HTTP client of one simulated browser, with connections of
+ * its own, enough for a pending read, a read ahead and a write.
+ */
+func httpClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	}}
+}
+
+type memorySample struct {
+	heap       uint64
+	goroutines int
+}
+
+func memoryUsage() memorySample {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return memorySample{heap: stats.HeapInuse, goroutines: runtime.NumGoroutine()}
+}
+
+func (opt benchReport) print() {
+	server := "in-process tunnel server"
+	if opt.External {
+		server = "external tunnel server"
+	}
+	fmt.Printf("Tunnels:       %v over %v (%v), %v opened, %v failed, %v closed early\n",
+		opt.Tunnels, opt.Transport, server, opt.Opened, opt.Failed, opt.Closed)
+	fmt.Printf("Measured:      %.1fs\n", opt.Seconds)
+	fmt.Println()
+	fmt.Printf("Connect:       %v\n", opt.ConnectLatency)
+	fmt.Printf("Throughput:    %.2f MB/s, %.0f instructions/s, %.1f KB/s per tunnel\n",
+		opt.BytesPerSecond/1e6, opt.InstructionsPerSecond, opt.BytesPerTunnel/1e3)
+	fmt.Printf("Frames:        %v sent, %v skipped while clients lagged\n", opt.FramesSent, opt.FramesSkipped)
+	fmt.Printf("Frame latency: %v\n", opt.FrameLatency)
+	fmt.Printf("Input:         %v events sent, %v received by guacd\n", opt.InputSent, opt.InputReceived)
+	if opt.Memory != nil {
+		fmt.Printf("Memory:        %.1f KB heap and %.1f goroutines per tunnel (server, mock guacd and client)\n",
+			opt.Memory.HeapBytesPerTunnel/1e3, opt.Memory.GoroutinesPerTunnel)
+	}
+	if len(opt.Errors) > 0 {
+		fmt.Println()
+		fmt.Println("Errors:")
+		for _, message := range opt.Errors {
+			fmt.Printf("  %v\n", message)
+		}
+	}
+}
+
+func (opt percentiles) String() string {
+	if opt.Count == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("p50 %.1fms  p90 %.1fms  p99 %.1fms  max %.1fms  (%v samples)", opt.P50, opt.P90, opt.P99, opt.Max, opt.Count)
+}
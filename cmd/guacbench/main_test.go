@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+/**
+ * Returns the flags of a benchmark short enough for a test.
+ */
+func testFlags(t *testing.T, args ...string) (ret benchFlags) {
+	t.Helper()
+	flags := flag.NewFlagSet("guacbench", flag.ContinueOnError)
+	ret.register(flags)
+	args = append([]string{"-tunnels", "3", "-duration", "500ms", "-ramp", "30ms", "-fps", "20",
+		"-ops", "4", "-image-bytes", "256", "-input-rate", "20", "-json"}, args...)
+	if e := flags.Parse(args); e != nil {
+		t.Fatal(e)
+	}
+	if e := ret.validate(); e != nil {
+		t.Fatal(e)
+	}
+	return
+}
+
+func Test_Benchmark(t *testing.T) {
+	for _, transport := range []string{"http", "websocket"} {
+		t.Run(transport, func(t *testing.T) {
+			report, err := run(testFlags(t, "-transport", transport))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every tunnel carries frames one way and input the other
+			if report.Opened != 3 || report.Failed != 0 || report.Closed != 0 || len(report.Errors) != 0 {
+				t.Errorf("opened %v, failed %v, closed %v: %v", report.Opened, report.Failed, report.Closed, report.Errors)
+			}
+			if report.FramesSent == 0 || report.FrameLatency.Count == 0 || report.BytesPerSecond <= 0 ||
+				report.InstructionsPerSecond <= 0 {
+				t.Errorf("frames %v, latency %v, %v B/s, %v instructions/s", report.FramesSent,
+					report.FrameLatency, report.BytesPerSecond, report.InstructionsPerSecond)
+			}
+			if report.InputSent == 0 || report.InputReceived == 0 {
+				t.Errorf("input sent %v, received %v", report.InputSent, report.InputReceived)
+			}
+			if report.ConnectLatency.Count != 3 || report.Memory == nil {
+				t.Errorf("connect latency %v, memory %v", report.ConnectLatency, report.Memory)
+			}
+		})
+	}
+}
+
+func Test_BenchFlagsValidate(t *testing.T) {
+	tests := [][]string{
+		{"-tunnels", "0"},
+		{"-transport", "ftp"},
+		{"-fps", "0"},
+		{"-max-lag", "0"},
+		{"-input-rate", "-1"},
+		{"-image-bytes", "-1"},
+	}
+	for _, args := range tests {
+		flags := flag.NewFlagSet("guacbench", flag.ContinueOnError)
+		var one benchFlags
+		one.register(flags)
+		if e := flags.Parse(args); e != nil {
+			t.Fatal(e)
+		}
+		if one.validate() == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+func Test_Percentiles(t *testing.T) {
+	samples := newLatencies()
+	if report := samples.percentiles(); report.Count != 0 || report.String() != "no samples" {
+		t.Errorf("empty %+v", report)
+	}
+	for i := 100; i >= 1; i-- {
+		samples.add(time.Duration(i) * time.Millisecond)
+	}
+	report := samples.percentiles()
+	if report.Count != 100 || report.P50 != 50 || report.P90 != 90 || report.P99 != 99 || report.Max != 100 {
+		t.Errorf("percentiles %+v", report)
+	}
+	samples.reset()
+	if report = samples.percentiles(); report.Count != 0 {
+		t.Errorf("after reset %+v", report)
+	}
+}
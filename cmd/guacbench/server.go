@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	"github.com/hsfish/guacamole_client_go/gservlet"
+	"github.com/hsfish/guacamole_client_go/gwebsocket"
+)
+
+/**
+ * A tunnel server run within the benchmark, serving the HTTP tunnel at
+ * /tunnel and the WebSocket tunnel at /websocket-tunnel, both connected
+ * to the given guacd.
+ */
+type localServer struct {
+	servlet   gservlet.GuacamoleHTTPTunnelServlet
+	websocket *gwebsocket.GuacamoleWebSocketTunnelEndpoint
+	server    *http.Server
+	listener  net.Listener
+}
+
+func newLocalServer(guacdHost string, guacdPort int) (ret *localServer, err error) {
+	doConnect := func(ctx context.Context, request gservlet.HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error) {
+		socket, err := gnet.NewInetGuacamoleSocketContext(ctx, guacdHost, guacdPort)
+		if err != nil {
+			return nil, err
+		}
+		config := gprotocol.NewGuacamoleConfiguration()
+		config.SetProtocol("vnc")
+		configured, err := gnet.NewConfiguredGuacamoleSocketContext(ctx, &socket, config, gprotocol.NewGuacamoleClientInformation())
+		if err != nil {
+			socket.Close()
+			return nil, err
+		}
+		return gnet.NewSimpleGuacamoleTunnel(&configured, config), nil
+	}
+
+	ret = &localServer{}
+	ret.servlet = gservlet.NewGuacamoleHTTPTunnelServlet2(nil, gservlet.DefaultGuacamoleHTTPTunnelMapOptions())
+	ret.servlet.SetDoConnectContext(doConnect)
+	ret.websocket = gwebsocket.NewGuacamoleWebSocketTunnelEndpoint(doConnect, ret.servlet.GetEventBus())
+
+	mux := http.NewServeMux()
+	mux.Handle("/tunnel", &ret.servlet)
+	mux.Handle("/websocket-tunnel", ret.websocket)
+	if ret.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	ret.server = &http.Server{Handler: mux}
+	go ret.server.Serve(ret.listener)
+	return
+}
+
+func (opt *localServer) baseURL() string {
+	return "http://" + opt.listener.Addr().String()
+}
+
+func (opt *localServer) close() {
+	opt.server.Close()
+	opt.servlet.Destroy()
+	opt.websocket.Destroy()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * The counters shared by all sessions.
+ */
+type clientCounters struct {
+	bytesReceived        int64
+	instructionsReceived int64
+	inputSent            int64
+	syncsSent            int64
+}
+
+func (opt *clientCounters) snapshot() clientCounters {
+	return clientCounters{
+		bytesReceived:        atomic.LoadInt64(&opt.bytesReceived),
+		instructionsReceived: atomic.LoadInt64(&opt.instructionsReceived),
+		inputSent:            atomic.LoadInt64(&opt.inputSent),
+		syncsSent:            atomic.LoadInt64(&opt.syncsSent),
+	}
+}
+
+/**
+ * One simulated browser: it answers every "sync" once the frame is
+ * received, and sends mouse and key events at the configured rate.
+ */
+type session struct {
+	transport transport
+	counters  *clientCounters
+	inputRate int
+	outbox    chan string
+	done      chan struct{}
+}
+
+func newSession(transport transport, counters *clientCounters, inputRate int) *session {
+	return &session{
+		transport: transport,
+		counters:  counters,
+		inputRate: inputRate,
+		outbox:    make(chan string, 256),
+		done:      make(chan struct{}),
+	}
+}
+
+/**
+ * Runs the session until it is stopped or its tunnel fails, returning the
+ * error which ended it, if any.
+ */
+func (opt *session) run() error {
+	go opt.send()
+	if opt.inputRate > 0 {
+		go opt.input()
+	}
+	err := opt.transport.read(opt.receive)
+	select {
+	case <-opt.done:
+		return nil
+	default:
+		close(opt.done)
+		return err
+	}
+}
+
+func (opt *session) stop() {
+	select {
+	case <-opt.done:
+	default:
+		close(opt.done)
+		opt.transport.close()
+	}
+}
+
+func (opt *session) receive(instructions []gprotocol.GuacamoleInstruction, bytes int) error {
+	atomic.AddInt64(&opt.counters.bytesReceived, int64(bytes))
+	atomic.AddInt64(&opt.counters.instructionsReceived, int64(len(instructions)))
+	for _, instruction := range instructions {
+		switch instruction.GetOpcode() {
+		case "sync":
+			reply := gprotocol.NewGuacamoleInstruction("sync", instruction.GetArgs()...)
+			opt.queue(reply.String())
+			atomic.AddInt64(&opt.counters.syncsSent, 1)
+		case "error":
+			return fmt.Errorf("guacd error: %v", strings.Join(instruction.GetArgs(), ", "))
+		case "disconnect":
+			return fmt.Errorf("disconnected by the server")
+		}
+	}
+	return nil
+}
+
+func (opt *session) queue(data string) {
+	select {
+	case opt.outbox <- data:
+	case <-opt.done:
+	}
+}
+
+/**
+ * Sends queued instructions, batching those queued while a write is in
+ * progress, as the JavaScript client does.
+ */
+func (opt *session) send() {
+	for {
+		var batch strings.Builder
+		select {
+		case data := <-opt.outbox:
+			batch.WriteString(data)
+		case <-opt.done:
+			return
+		}
+	more:
+		for {
+			select {
+			case data := <-opt.outbox:
+				batch.WriteString(data)
+			default:
+				break more
+			}
+		}
+		if err := opt.transport.write(batch.String()); err != nil {
+			return
+		}
+	}
+}
+
+/**
+ * Sends mouse moves, with a key press and release every fourth event.
+ */
+func (opt *session) input() {
+	ticker := time.NewTicker(time.Second / time.Duration(opt.inputRate))
+	defer ticker.Stop()
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for n := 0; ; n++ {
+		select {
+		case <-opt.done:
+			return
+		case <-ticker.C:
+		}
+		if n%4 == 3 {
+			keysym := strconv.Itoa('a' + random.Intn(26))
+			press := gprotocol.NewGuacamoleInstruction("key", keysym, "1")
+			release := gprotocol.NewGuacamoleInstruction("key", keysym, "0")
+			opt.queue(press.String() + release.String())
+			atomic.AddInt64(&opt.counters.inputSent, 2)
+			continue
+		}
+		mouse := gprotocol.NewGuacamoleInstruction("mouse", strconv.Itoa(random.Intn(1024)), strconv.Itoa(random.Intn(768)), "0")
+		opt.queue(mouse.String())
+		atomic.AddInt64(&opt.counters.inputSent, 1)
+	}
+}
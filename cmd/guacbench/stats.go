@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+ * A set of latency samples, safe for concurrent use.
+ */
+type latencies struct {
+	samples []time.Duration
+	lock    sync.Mutex
+}
+
+func newLatencies() *latencies {
+	return &latencies{samples: make([]time.Duration, 0, 1024)}
+}
+
+func (opt *latencies) add(latency time.Duration) {
+	opt.lock.Lock()
+	opt.samples = append(opt.samples, latency)
+	opt.lock.Unlock()
+}
+
+/**
+ * Discards all samples, starting the measurement window.
+ */
+func (opt *latencies) reset() {
+	opt.lock.Lock()
+	opt.samples = opt.samples[:0]
+	opt.lock.Unlock()
+}
+
+/**
+ * The distribution of a set of latency samples, in milliseconds.
+ */
+type percentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50Ms"`
+	P90   float64 `json:"p90Ms"`
+	P99   float64 `json:"p99Ms"`
+	Max   float64 `json:"maxMs"`
+}
+
+func (opt *latencies) percentiles() (ret percentiles) {
+	opt.lock.Lock()
+	sorted := append([]time.Duration(nil), opt.samples...)
+	opt.lock.Unlock()
+	ret.Count = len(sorted)
+	if len(sorted) == 0 {
+		return
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(p float64) float64 {
+		index := int(p * float64(len(sorted)-1))
+		return milliseconds(sorted[index])
+	}
+	ret.P50, ret.P90, ret.P99 = at(0.50), at(0.90), at(0.99)
+	ret.Max = milliseconds(sorted[len(sorted)-1])
+	return
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
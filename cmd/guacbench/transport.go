@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * Bytes received in one HTTP tunnel read response after which the next
+ * read request is sent, so that the server can switch to it without a gap,
+ * as the JavaScript client does.
+ */
+const readAheadBytes = 1 << 20
+
+/**
+ * One client side of a tunnel, over HTTP or WebSocket.
+ */
+type transport interface {
+	// connect opens the tunnel with the given connect data, returning its
+	// UUID.
+	connect(connectData string) (string, error)
+
+	// read calls the handler with the instructions received, as they
+	// arrive, until the tunnel is closed or the handler fails.
+	read(handler func(instructions []gprotocol.GuacamoleInstruction, bytes int) error) error
+
+	// write sends the given instructions, in protocol form.
+	write(data string) error
+
+	// close ends the tunnel.
+	close()
+}
+
+/**
+ * The HTTP tunnel, as used by the JavaScript client: a connect request,
+ * long polling read requests, and one write request per batch of
+ * instructions.
+ */
+type httpTransport struct {
+	client *http.Client
+	url    string
+	uuid   string
+}
+
+func (opt *httpTransport) connect(connectData string) (string, error) {
+	response, err := opt.client.Post(opt.url+"?connect", "application/x-www-form-urlencoded; charset=UTF-8",
+		strings.NewReader(connectData))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("connect failed with %v: %v", response.StatusCode, response.Header.Get("Guacamole-Error-Message"))
+	}
+	opt.uuid = string(body)
+	return opt.uuid, nil
+}
+
+func (opt *httpTransport) get(counter int) (*http.Response, error) {
+	response, err := opt.client.Get(opt.url + "?read:" + opt.uuid + ":" + strconv.Itoa(counter))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("read failed with %v: %v", response.StatusCode, response.Header.Get("Guacamole-Error-Message"))
+	}
+	return response, nil
+}
+
+type readResult struct {
+	response *http.Response
+	err      error
+}
+
+func (opt *httpTransport) read(handler func([]gprotocol.GuacamoleInstruction, int) error) error {
+	counter := 0
+	response, err := opt.get(counter)
+	if err != nil {
+		return err
+	}
+	buffer := make([]byte, 64*1024)
+
+	for {
+		var next chan readResult
+		parser := gprotocol.NewGuacamoleStreamParser()
+		received, ended := 0, false
+
+		for !ended {
+			n, readErr := response.Body.Read(buffer)
+			received += n
+			instructions, perr := parser.Append(buffer[:n])
+			if perr != nil {
+				response.Body.Close()
+				return fmt.Errorf("%v", perr.GetMessage())
+			}
+
+			// The empty instruction ends the response
+			for i, instruction := range instructions {
+				if instruction.GetOpcode() == "" && len(instruction.GetArgs()) == 0 {
+					instructions, ended = instructions[:i], true
+					break
+				}
+			}
+			if err := handler(instructions, n); err != nil {
+				response.Body.Close()
+				return err
+			}
+
+			if received >= readAheadBytes && next == nil && !ended {
+				next = make(chan readResult, 1)
+				go func(counter int) {
+					response, err := opt.get(counter)
+					next <- readResult{response, err}
+				}(counter + 1)
+			}
+			if readErr == io.EOF && !ended {
+				response.Body.Close()
+				return nil
+			}
+			if readErr != nil && readErr != io.EOF {
+				response.Body.Close()
+				return readErr
+			}
+		}
+		response.Body.Close()
+
+		counter++
+		if next != nil {
+			result := <-next
+			response, err = result.response, result.err
+		} else {
+			response, err = opt.get(counter)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (opt *httpTransport) write(data string) error {
+	response, err := opt.client.Post(opt.url+"?write:"+opt.uuid, "application/octet-stream", strings.NewReader(data))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("write failed with %v: %v", response.StatusCode, response.Header.Get("Guacamole-Error-Message"))
+	}
+	return nil
+}
+
+func (opt *httpTransport) close() {
+	opt.write("10.disconnect;")
+}
+
+/**
+ * The WebSocket tunnel, carrying instructions in text messages.
+ */
+type webSocketTransport struct {
+	url  string
+	conn *websocket.Conn
+	lock sync.Mutex
+}
+
+func (opt *webSocketTransport) connect(connectData string) (string, error) {
+	dialer := websocket.Dialer{Subprotocols: []string{"guacamole"}}
+	target := opt.url
+	if len(connectData) > 0 {
+		target += "?" + connectData
+	}
+	conn, response, err := dialer.Dial(target, nil)
+	if err != nil {
+		if response != nil {
+			return "", fmt.Errorf("connect failed with %v: %v", response.StatusCode, err)
+		}
+		return "", err
+	}
+	opt.conn = conn
+
+	// The first message is the internal instruction giving the UUID
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		conn.Close()
+		return "", err
+	}
+	parser := gprotocol.NewGuacamoleStreamParser()
+	instructions, perr := parser.Append(message)
+	if perr != nil || len(instructions) == 0 || instructions[0].GetOpcode() != "" || len(instructions[0].GetArgs()) == 0 {
+		conn.Close()
+		return "", fmt.Errorf("unexpected first message %q", message)
+	}
+	return instructions[0].GetArgs()[0], nil
+}
+
+func (opt *webSocketTransport) read(handler func([]gprotocol.GuacamoleInstruction, int) error) error {
+	parser := gprotocol.NewGuacamoleStreamParser()
+	for {
+		_, message, err := opt.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return nil
+			}
+			return err
+		}
+		instructions, perr := parser.Append(message)
+		if perr != nil {
+			return fmt.Errorf("%v", perr.GetMessage())
+		}
+		if err := handler(instructions, len(message)); err != nil {
+			return err
+		}
+	}
+}
+
+func (opt *webSocketTransport) write(data string) error {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	return opt.conn.WriteMessage(websocket.TextMessage, []byte(data))
+}
+
+func (opt *webSocketTransport) close() {
+	opt.write("10.disconnect;")
+	opt.lock.Lock()
+	opt.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	opt.lock.Unlock()
+	opt.conn.Close()
+}
+
+/**
+ * Returns the WebSocket URL corresponding to the given HTTP URL.
+ */
+func webSocketURL(base string) (string, error) {
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	switch parsed.Scheme {
+	case "http":
+		parsed.Scheme = "ws"
+	case "https":
+		parsed.Scheme = "wss"
+	}
+	return parsed.String(), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * How instructions received from guacd are printed.
+ */
+type printer struct {
+	raw       bool
+	maxLength int
+	only      map[string]bool
+	start     time.Time
+}
+
+func (opt *printer) register(flags *flag.FlagSet) {
+	flags.BoolVar(&opt.raw, "raw", false, "print instructions in protocol form instead of decoded")
+	flags.IntVar(&opt.maxLength, "max", 64, "truncate decoded arguments longer than this, 0 for no limit")
+}
+
+/**
+ * Restricts printing to the given comma-separated opcodes, if any.
+ */
+func (opt *printer) filter(opcodes string) {
+	if len(opcodes) == 0 {
+		return
+	}
+	opt.only = make(map[string]bool)
+	for _, opcode := range strings.Split(opcodes, ",") {
+		opt.only[strings.TrimSpace(opcode)] = true
+	}
+}
+
+func (opt *printer) print(instruction gprotocol.GuacamoleInstruction) {
+	if opt.only != nil && !opt.only[instruction.GetOpcode()] {
+		return
+	}
+	elapsed := time.Since(opt.start).Seconds()
+	if opt.raw {
+		fmt.Printf("%10.3f %v\n", elapsed, instruction.String())
+		return
+	}
+	fmt.Printf("%10.3f %-10s %v\n", elapsed, instruction.GetOpcode(), opt.formatArgs(instruction.GetArgs()))
+}
+
+/**
+ * Returns the given arguments quoted, truncating long ones such as image
+ * data.
+ */
+func (opt *printer) formatArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if opt.maxLength > 0 && len(arg) > opt.maxLength {
+			quoted[i] = strconv.Quote(arg[:opt.maxLength]) + fmt.Sprintf("...(%d bytes)", len(arg))
+		} else {
+			quoted[i] = strconv.Quote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+/**
+ * Reads instructions from guacd until the connection ends, printing them
+ * and answering each "sync" as a browser would, so that guacd keeps
+ * sending. Returns nil once guacd disconnects cleanly.
+ */
+func receive(socket gnet.GuacamoleSocket, out *printer, ack bool) error {
+	reader := socket.GetReader()
+	writer := socket.GetWriter()
+	for {
+		instruction, err := reader.ReadInstruction()
+		if err != nil {
+			return describe(err)
+		}
+		if len(instruction.GetOpcode()) == 0 {
+			return nil
+		}
+		out.print(instruction)
+
+		switch instruction.GetOpcode() {
+		case "sync":
+			if ack && len(instruction.GetArgs()) > 0 {
+				if err = acknowledge(writer, instruction.GetArgs()[0]); err != nil {
+					return describe(err)
+				}
+			}
+		case "error":
+			return upstreamError(instruction)
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+func acknowledge(writer gio.GuacamoleWriter, timestamp string) exp.ExceptionInterface {
+	return writer.WriteInstruction(gprotocol.NewGuacamoleInstruction("sync", timestamp))
+}
+
+/**
+ * Returns the error reported by the given "error" instruction.
+ */
+func upstreamError(instruction gprotocol.GuacamoleInstruction) error {
+	status, message := gprotocol.ParseErrorInstruction(instruction.String())
+	return fmt.Errorf("guacd closed the connection: %v (%v)", message, status)
+}
+
+/**
+ * Completes a handshake and prints every instruction received until the
+ * connection ends.
+ */
+func runDump(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	var connection connectionFlags
+	connection.register(flags)
+	var out printer
+	out.register(flags)
+	only := flags.String("only", "", "print only these comma-separated opcodes")
+	ack := flags.Bool("ack", true, "answer each \"sync\" like a browser")
+	flags.Parse(args)
+	out.filter(*only)
+
+	socket, err := connection.connect()
+	if err != nil {
+		return err
+	}
+	defer socket.Close()
+
+	fmt.Printf("# connection ID %v\n", socket.GetConnectionID())
+	out.start = time.Now()
+	return receive(&socket, &out, *ack)
+}
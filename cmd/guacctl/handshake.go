@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/gcatalog"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * Repeatable "-set name=value" flag overriding connection parameters.
+ */
+type parameterFlag map[string]string
+
+func (opt parameterFlag) String() string {
+	return fmt.Sprint(map[string]string(opt))
+}
+
+func (opt parameterFlag) Set(value string) error {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 || len(pair[0]) == 0 {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	opt[pair[0]] = pair[1]
+	return nil
+}
+
+/**
+ * The flags describing the connection to establish, shared by the
+ * commands performing a full handshake.
+ */
+type connectionFlags struct {
+	guacdFlags
+	config     string
+	connection string
+	join       string
+	parameters parameterFlag
+	width      int
+	height     int
+	dpi        int
+}
+
+func (opt *connectionFlags) register(flags *flag.FlagSet) {
+	opt.guacdFlags.register(flags)
+	opt.parameters = parameterFlag{}
+	flags.StringVar(&opt.config, "config", "", "connection file, in the YAML, JSON or user-mapping.xml catalog format")
+	flags.StringVar(&opt.connection, "connection", "", "name of the connection within the file, if it defines several")
+	flags.StringVar(&opt.join, "join", "", "ID of an existing guacd connection to join instead")
+	flags.Var(opt.parameters, "set", "override a parameter, as name=value (repeatable)")
+	flags.IntVar(&opt.width, "width", 1024, "optimal screen width")
+	flags.IntVar(&opt.height, "height", 768, "optimal screen height")
+	flags.IntVar(&opt.dpi, "dpi", 96, "optimal screen resolution")
+}
+
+/**
+ * Returns the configuration selected by the flags.
+ */
+func (opt *connectionFlags) configuration() (config gprotocol.GuacamoleConfiguration, err error) {
+	config = gprotocol.NewGuacamoleConfiguration()
+	if len(opt.config) > 0 {
+		if config, err = loadConfiguration(opt.config, opt.connection); err != nil {
+			return
+		}
+	} else if len(opt.join) == 0 {
+		return config, fmt.Errorf("either -config or -join is required")
+	}
+	if len(opt.join) > 0 {
+		config.SetConnectionID(opt.join)
+	}
+	for name, value := range opt.parameters {
+		config.SetParameter(name, value)
+	}
+	return
+}
+
+/**
+ * Loads the named connection from the given catalog file. The name may be
+ * omitted if the file defines a single connection, or defines no
+ * connection but a protocol and parameters at its root.
+ */
+func loadConfiguration(path, name string) (config gprotocol.GuacamoleConfiguration, err error) {
+	catalog, e := gcatalog.LoadConnectionCatalog(path)
+	if e != nil {
+		return config, describe(e)
+	}
+	if len(name) > 0 {
+		config, e = catalog.Get(name)
+		return config, describe(e)
+	}
+
+	names := catalog.GetConnectionNames()
+	switch len(names) {
+	case 0:
+		root := catalog.GetDefinition()
+		if len(root.Protocol) == 0 {
+			return config, fmt.Errorf("%v defines no connection", path)
+		}
+		config = gprotocol.NewGuacamoleConfiguration()
+		config.SetProtocol(root.Protocol)
+		config.SetParameters(root.Parameters)
+		return config, nil
+	case 1:
+		config, e = catalog.Get(names[0])
+		return config, describe(e)
+	}
+	return config, fmt.Errorf("%v defines several connections, choose one with -connection: %v",
+		path, strings.Join(names, ", "))
+}
+
+/**
+ * Connects to guacd and completes the handshake described by the flags.
+ */
+func (opt *connectionFlags) connect() (socket gnet.ConfiguredGuacamoleSocket, err error) {
+	config, err := opt.configuration()
+	if err != nil {
+		return
+	}
+	info := gprotocol.NewGuacamoleClientInformation()
+	info.SetOptimalScreenWidth(opt.width)
+	info.SetOptimalScreenHeight(opt.height)
+	info.SetOptimalResolution(opt.dpi)
+
+	raw, err := opt.dial()
+	if err != nil {
+		return
+	}
+	socket, e := gnet.NewConfiguredGuacamoleSocket3(raw, config, info)
+	if e != nil {
+		raw.Close()
+		return socket, describe(e)
+	}
+	return
+}
+
+/**
+ * Completes a full handshake, prints the resulting connection ID and
+ * closes the connection.
+ */
+func runHandshake(args []string) error {
+	flags := flag.NewFlagSet("handshake", flag.ExitOnError)
+	var connection connectionFlags
+	connection.register(flags)
+	flags.Parse(args)
+
+	start := time.Now()
+	socket, err := connection.connect()
+	if err != nil {
+		return err
+	}
+	defer socket.Close()
+
+	config := socket.GetConfiguration()
+	if len(config.GetProtocol()) > 0 {
+		fmt.Printf("protocol:      %v\n", config.GetProtocol())
+	}
+	fmt.Printf("connection ID: %v\n", socket.GetConnectionID())
+	fmt.Printf("handshake:     %v\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
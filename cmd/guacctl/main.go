@@ -0,0 +1,113 @@
+// Command guacctl talks to a Guacamole proxy server (guacd) directly, for
+// debugging guacd without a browser or hand-typed length prefixes.
+//
+// Usage:
+//
+//	guacctl probe     [flags] PROTOCOL
+//	guacctl handshake [flags] -config FILE [-connection NAME]
+//	guacctl dump      [flags] -config FILE [-connection NAME]
+//	guacctl send      [flags] -config FILE [-connection NAME] [SCRIPT]
+//
+// Run "guacctl COMMAND -h" for the flags of each command.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gnet"
+)
+
+/**
+ * A subcommand, run with the arguments following its name.
+ */
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"probe", "select a protocol and print the server version and the parameters it asks for", runProbe},
+	{"handshake", "complete a full handshake from a connection file and print the connection ID", runHandshake},
+	{"dump", "complete a handshake and print the decoded instruction stream live", runDump},
+	{"send", "complete a handshake and send scripted instructions", runSend},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, one := range commands {
+		if one.name == os.Args[1] {
+			if err := one.run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "guacctl:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: guacctl COMMAND [flags] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, one := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", one.name, one.summary)
+	}
+}
+
+/**
+ * The flags locating guacd, shared by all commands.
+ */
+type guacdFlags struct {
+	host    string
+	port    int
+	ssl     bool
+	timeout time.Duration
+}
+
+func (opt *guacdFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&opt.host, "host", "localhost", "hostname of guacd")
+	flags.IntVar(&opt.port, "port", 4822, "port of guacd")
+	flags.BoolVar(&opt.ssl, "ssl", false, "connect to guacd over SSL/TLS")
+	flags.DurationVar(&opt.timeout, "timeout", 10*time.Second, "timeout of the connection attempt")
+}
+
+/**
+ * Connects to guacd, returning a socket ready for the handshake.
+ */
+func (opt *guacdFlags) dial() (gnet.GuacamoleSocket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), opt.timeout)
+	defer cancel()
+	if opt.ssl {
+		socket, err := gnet.NewSSLGuacamoleSocketContext(ctx, opt.host, opt.port)
+		if err != nil {
+			return nil, err
+		}
+		return &socket, nil
+	}
+	socket, err := gnet.NewInetGuacamoleSocketContext(ctx, opt.host, opt.port)
+	if err != nil {
+		return nil, describe(err)
+	}
+	return &socket, nil
+}
+
+/**
+ * Returns the given exception as an error naming its status, or nil.
+ */
+func describe(err exp.ExceptionInterface) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%v (%v)", err.GetMessage(), err.GetStatus())
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/cmd/internal/mockguacd"
+)
+
+/**
+ * Starts a mock guacd, returning it, the flags locating it and the number
+ * of frames answered so far.
+ */
+func newTestGuacd(t *testing.T) (*mockguacd.Server, []string, *int64) {
+	t.Helper()
+	answered := new(int64)
+	guacd, e := mockguacd.NewServer("127.0.0.1:0", mockguacd.OutputConfig{FPS: 50, Ops: 2, ImageBytes: 64, MaxLag: 5},
+		func(time.Duration) { atomic.AddInt64(answered, 1) })
+	if e != nil {
+		t.Fatal(e)
+	}
+	host, port, _ := net.SplitHostPort(guacd.Address())
+	return guacd, []string{"-host", host, "-port", port, "-timeout", "5s"}, answered
+}
+
+/**
+ * Runs the given command, returning what it printed and its error.
+ */
+func capture(t *testing.T, command func(args []string) error, args ...string) (string, error) {
+	t.Helper()
+	reader, writer, e := os.Pipe()
+	if e != nil {
+		t.Fatal(e)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	printed := make(chan string)
+	go func() {
+		var output bytes.Buffer
+		io.Copy(&output, reader)
+		printed <- output.String()
+	}()
+	err := command(args)
+	os.Stdout = stdout
+	writer.Close()
+	return <-printed, err
+}
+
+/**
+ * Writes a connection file holding the given catalog, returning its path.
+ */
+func writeConfig(t *testing.T, catalog string) (string, func()) {
+	t.Helper()
+	dir, e := ioutil.TempDir("", "guacctl")
+	if e != nil {
+		t.Fatal(e)
+	}
+	path := filepath.Join(dir, "connection.yaml")
+	if e = ioutil.WriteFile(path, []byte(catalog), 0600); e != nil {
+		os.RemoveAll(dir)
+		t.Fatal(e)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+const testConnection = "protocol: vnc\nconnections: [{name: desktop, parameters: {hostname: localhost, port: \"5900\"}}]\n"
+
+func Test_Probe(t *testing.T) {
+	guacd, flags, _ := newTestGuacd(t)
+	defer guacd.Close()
+
+	output, err := capture(t, runProbe, append(flags, "-schema", "vnc")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"protocol:   vnc\n", "version:    1.1.0\n", "parameters: 2\n", "  hostname ", "  port "} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("output lacks %q:\n%v", expected, output)
+		}
+	}
+	if _, err = capture(t, runProbe, flags...); err == nil {
+		t.Errorf("probe without a protocol accepted")
+	}
+}
+
+func Test_Handshake(t *testing.T) {
+	guacd, flags, _ := newTestGuacd(t)
+	defer guacd.Close()
+	config, remove := writeConfig(t, testConnection)
+	defer remove()
+
+	output, err := capture(t, runHandshake, append(flags, "-config", config)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "protocol:      vnc\n") || !strings.Contains(output, "connection ID: $mock-") {
+		t.Errorf("output:\n%v", output)
+	}
+
+	// The connection to use must be named when the file holds several
+	several, removeSeveral := writeConfig(t, "protocol: vnc\nconnections: [{name: a}, {name: b}]\n")
+	defer removeSeveral()
+	if _, err = capture(t, runHandshake, append(flags, "-config", several)...); err == nil ||
+		!strings.Contains(err.Error(), "a, b") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err = capture(t, runHandshake, append(flags, "-config", several, "-connection", "c")...); err == nil {
+		t.Errorf("missing connection accepted")
+	}
+	if _, err = capture(t, runHandshake, flags...); err == nil {
+		t.Errorf("handshake without a connection accepted")
+	}
+}
+
+func Test_ConnectionFlags(t *testing.T) {
+	config, remove := writeConfig(t, "protocol: rdp\nparameters: {hostname: server}\n")
+	defer remove()
+
+	// Files without connections describe one at their root, and flags
+	// override its parameters
+	connection := connectionFlags{config: config, join: "$existing", parameters: parameterFlag{}}
+	if e := connection.parameters.Set("port=3390"); e != nil {
+		t.Fatal(e)
+	}
+	if e := connection.parameters.Set("port"); e == nil {
+		t.Errorf("parameter without a value accepted")
+	}
+	one, e := connection.configuration()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if one.GetProtocol() != "rdp" || one.GetParameter("hostname") != "server" || one.GetParameter("port") != "3390" ||
+		one.GetConnectionID() != "$existing" {
+		t.Errorf("configuration %v %v %v", one.GetProtocol(), one.GetParameters(), one.GetConnectionID())
+	}
+}
+
+func Test_Dump(t *testing.T) {
+	guacd, flags, answered := newTestGuacd(t)
+	config, remove := writeConfig(t, testConnection)
+	defer remove()
+
+	// Frames are printed and answered until guacd goes away
+	go func() {
+		for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(answered) < 3 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		guacd.Close()
+	}()
+	output, err := capture(t, runDump, append(flags, "-config", config, "-only", "sync,img")...)
+	if err == nil {
+		t.Errorf("end of the connection not reported")
+	}
+	if atomic.LoadInt64(answered) < 3 || strings.Count(output, " sync ") < 3 || !strings.Contains(output, `"image/png"`) {
+		t.Errorf("%v frames answered, output:\n%v", atomic.LoadInt64(answered), output)
+	}
+	if strings.Contains(output, " rect ") || !strings.HasPrefix(output, "# connection ID $mock-") {
+		t.Errorf("output:\n%v", output)
+	}
+}
+
+func Test_Send(t *testing.T) {
+	guacd, flags, answered := newTestGuacd(t)
+	defer guacd.Close()
+	config, remove := writeConfig(t, testConnection)
+	defer remove()
+	script, removeScript := writeConfig(t, strings.Join([]string{
+		"# Press and release Escape, then click",
+		"key 65307 1",
+		"3.key,5.65307,1.0;",
+		`mouse "10" 10 1`,
+		"",
+		"wait sync 5s",
+		"sleep 10ms",
+	}, "\n"))
+	defer removeScript()
+
+	output, err := capture(t, runSend, append(flags, "-config", config, "-quiet", "-linger", "10ms", script)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, "# connection ID $mock-") || strings.Count(output, "\n") != 1 {
+		t.Errorf("output:\n%v", output)
+	}
+	for deadline := time.Now().Add(5 * time.Second); guacd.InputEvents() < 3 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if guacd.InputEvents() != 3 || atomic.LoadInt64(answered) == 0 {
+		t.Errorf("%v input events, %v frames answered", guacd.InputEvents(), atomic.LoadInt64(answered))
+	}
+}
+
+func Test_ScriptErrors(t *testing.T) {
+	writer := &lockedWriter{}
+	received, ended := make(chan string), make(chan error)
+	for _, line := range []string{
+		"3.key,5.65307",
+		"3.key,5.65307,1.1;3.key",
+		"sleep",
+		"sleep soon",
+		"wait",
+		"wait sync soon",
+		`key "65307`,
+		`key "\q"`,
+	} {
+		if err := runScriptLine(line, writer, received, ended); err == nil {
+			t.Errorf("%q accepted", line)
+		}
+	}
+	if err := runScriptLine("wait sync 10ms", writer, received, ended); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v", err)
+	}
+
+	words, err := splitWords(`clipboard  "a \"quoted\" word" 1`)
+	if err != nil || len(words) != 3 || words[1] != `a "quoted" word` {
+		t.Errorf("words %q, err %v", words, err)
+	}
+}
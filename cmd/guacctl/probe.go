@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * The prefix of the protocol version sent by guacd 1.1.0 and later as the
+ * first argument of "args".
+ */
+const versionPrefix = "VERSION_"
+
+/**
+ * Selects a protocol and prints the "args" guacd answers with, then aborts
+ * the handshake by closing the connection.
+ */
+func runProbe(args []string) error {
+	flags := flag.NewFlagSet("probe", flag.ExitOnError)
+	var guacd guacdFlags
+	guacd.register(flags)
+	describeParameters := flags.Bool("schema", false, "also print the known type of each parameter")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: guacctl probe [flags] PROTOCOL")
+	}
+	protocol := flags.Arg(0)
+
+	socket, err := guacd.dial()
+	if err != nil {
+		return err
+	}
+	defer socket.Close()
+
+	if e := socket.GetWriter().WriteInstruction(gprotocol.NewGuacamoleInstruction("select", protocol)); e != nil {
+		return describe(e)
+	}
+	instruction, e := socket.GetReader().ReadInstruction()
+	if e != nil {
+		return describe(e)
+	}
+	switch instruction.GetOpcode() {
+	case "args":
+	case "error":
+		return fmt.Errorf("guacd refused protocol %q: %v", protocol, strings.Join(instruction.GetArgs(), ", "))
+	default:
+		return fmt.Errorf("expected \"args\", received %q", instruction.GetOpcode())
+	}
+
+	parameters := instruction.GetArgs()
+	version := "VERSION_1_0_0"
+	if len(parameters) > 0 && strings.HasPrefix(parameters[0], versionPrefix) {
+		version, parameters = parameters[0], parameters[1:]
+	}
+	fmt.Printf("server:     %v:%v\n", guacd.host, guacd.port)
+	fmt.Printf("protocol:   %v\n", protocol)
+	fmt.Printf("version:    %v\n", strings.Replace(strings.TrimPrefix(version, versionPrefix), "_", ".", -1))
+	fmt.Printf("parameters: %v\n", len(parameters))
+
+	info, known := gprotocol.GetProtocolInfo(protocol)
+	for _, name := range parameters {
+		if !*describeParameters {
+			fmt.Println("  " + name)
+			continue
+		}
+		kind := "unknown"
+		if known {
+			if parameter, ok := info.GetParameter(name); ok {
+				kind = strings.ToLower(parameter.GetType().String())
+				if parameter.IsSensitive() {
+					kind += ", sensitive"
+				}
+			}
+		}
+		fmt.Printf("  %-32s %v\n", name, kind)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * A GuacamoleWriter shared by the script and the goroutine answering
+ * "sync", writing whole instructions one at a time.
+ */
+type lockedWriter struct {
+	writer gio.GuacamoleWriter
+	lock   sync.Mutex
+}
+
+func (opt *lockedWriter) WriteAll(chunk []byte) exp.ExceptionInterface {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	return opt.writer.WriteAll(chunk)
+}
+
+/**
+ * Completes a handshake, then sends the instructions of a script read from
+ * a file or standard input. Each line of the script is one of:
+ *
+ *	# comment
+ *	sleep DURATION          pause, e.g. "sleep 500ms"
+ *	wait OPCODE [TIMEOUT]   wait for an instruction from guacd, e.g. "wait sync"
+ *	3.key,5.65307,1.1;      instructions in protocol form, sent verbatim
+ *	key 65307 1             an instruction as opcode and arguments, which
+ *	                        may be double-quoted Go strings
+ *
+ * Instructions received meanwhile are printed as by the dump command.
+ */
+func runSend(args []string) error {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	var connection connectionFlags
+	connection.register(flags)
+	var out printer
+	out.register(flags)
+	quiet := flags.Bool("quiet", false, "do not print instructions received")
+	linger := flags.Duration("linger", time.Second, "how long to keep receiving once the script ends")
+	flags.Parse(args)
+	if *quiet {
+		out.only = map[string]bool{}
+	}
+
+	var script io.Reader = os.Stdin
+	if flags.NArg() > 0 && flags.Arg(0) != "-" {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		script = file
+	}
+
+	socket, err := connection.connect()
+	if err != nil {
+		return err
+	}
+	defer socket.Close()
+	fmt.Printf("# connection ID %v\n", socket.GetConnectionID())
+	out.start = time.Now()
+
+	// Receive, print and answer "sync" in the background, reporting each
+	// opcode received to "wait"
+	writer := &lockedWriter{writer: socket.GetWriter()}
+	received := make(chan string, 1024)
+	ended := make(chan error, 1)
+	go func() {
+		reader := socket.GetReader()
+		for {
+			instruction, e := reader.ReadInstruction()
+			if e != nil || len(instruction.GetOpcode()) == 0 {
+				ended <- describe(e)
+				return
+			}
+			out.print(instruction)
+			switch instruction.GetOpcode() {
+			case "sync":
+				if len(instruction.GetArgs()) > 0 {
+					sync := gprotocol.NewGuacamoleInstruction("sync", instruction.GetArgs()[0])
+					if e = writer.WriteAll([]byte(sync.String())); e != nil {
+						ended <- describe(e)
+						return
+					}
+				}
+			case "error":
+				ended <- upstreamError(instruction)
+				return
+			case "disconnect":
+				ended <- nil
+				return
+			}
+			select {
+			case received <- instruction.GetOpcode():
+			default:
+			}
+		}
+	}()
+
+	lines := bufio.NewScanner(script)
+	lines.Buffer(make([]byte, 64*1024), gprotocol.INSTRUCTION_MAX_LENGTH*4)
+	for number := 1; lines.Scan(); number++ {
+		if err = runScriptLine(lines.Text(), writer, received, ended); err != nil {
+			return fmt.Errorf("line %d: %v", number, err)
+		}
+	}
+	if err = lines.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case err = <-ended:
+		return err
+	case <-time.After(*linger):
+		return nil
+	}
+}
+
+/**
+ * Runs one line of a script.
+ */
+func runScriptLine(line string, writer *lockedWriter, received <-chan string, ended <-chan error) error {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	// Instructions already in protocol form
+	if len(line) > 0 && line[0] >= '0' && line[0] <= '9' {
+		parser := gprotocol.NewGuacamoleStreamParser()
+		instructions, e := parser.Append([]byte(line))
+		if e != nil {
+			return describe(e)
+		}
+		if len(instructions) == 0 || parser.Pending() > 0 {
+			return fmt.Errorf("incomplete instruction %q", line)
+		}
+		return describe(writer.WriteAll([]byte(line)))
+	}
+
+	words, err := splitWords(line)
+	if err != nil {
+		return err
+	}
+	switch words[0] {
+	case "sleep":
+		if len(words) != 2 {
+			return fmt.Errorf("usage: sleep DURATION")
+		}
+		duration, err := time.ParseDuration(words[1])
+		if err != nil {
+			return err
+		}
+		time.Sleep(duration)
+		return nil
+	case "wait":
+		if len(words) < 2 || len(words) > 3 {
+			return fmt.Errorf("usage: wait OPCODE [TIMEOUT]")
+		}
+		timeout := 10 * time.Second
+		if len(words) == 3 {
+			if timeout, err = time.ParseDuration(words[2]); err != nil {
+				return err
+			}
+		}
+		return waitFor(words[1], timeout, received, ended)
+	}
+	instruction := gprotocol.NewGuacamoleInstruction(words[0], words[1:]...)
+	return describe(writer.WriteAll([]byte(instruction.String())))
+}
+
+/**
+ * Waits until an instruction having the given opcode is received.
+ */
+func waitFor(opcode string, timeout time.Duration, received <-chan string, ended <-chan error) error {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case one := <-received:
+			if one == opcode {
+				return nil
+			}
+		case err := <-ended:
+			if err == nil {
+				err = fmt.Errorf("connection closed while waiting for %q", opcode)
+			}
+			return err
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for %q", opcode)
+		}
+	}
+}
+
+/**
+ * Splits a script line into words separated by spaces, unquoting words
+ * written as double-quoted Go strings.
+ */
+func splitWords(line string) (words []string, err error) {
+	for len(line) > 0 {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if len(line) == 0 {
+			break
+		}
+		if line[0] != '"' {
+			end := strings.IndexFunc(line, unicode.IsSpace)
+			if end < 0 {
+				end = len(line)
+			}
+			words, line = append(words, line[:end]), line[end:]
+			continue
+		}
+		end := closingQuote(line)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string in %q", line)
+		}
+		word, e := strconv.Unquote(line[:end+1])
+		if e != nil {
+			return nil, fmt.Errorf("invalid string %v", line[:end+1])
+		}
+		words, line = append(words, word), line[end+1:]
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty instruction")
+	}
+	return
+}
+
+/**
+ * Returns the index of the quote ending the double-quoted string at the
+ * start of the given text, or -1 if it is unterminated.
+ */
+func closingQuote(text string) int {
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
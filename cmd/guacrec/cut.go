@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/gdisplay"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * The number of base64 characters per "blob" of the snapshot, keeping
+ * each instruction well within INSTRUCTION_MAX_LENGTH.
+ */
+const blobSize = 6144
+
+/**
+ * The instructions opening a stream, whose first argument is the stream
+ * index.
+ */
+var streamOpcodes = map[string]bool{
+	"img": true, "audio": true, "video": true, "file": true,
+	"pipe": true, "clipboard": true, "argv": true,
+}
+
+func writeInstruction(output io.Writer, opcode string, args ...string) error {
+	instruction := gprotocol.NewGuacamoleInstruction(opcode, args...)
+	_, err := io.WriteString(output, instruction.String())
+	return err
+}
+
+func runCut(args []string) error {
+	flags := flag.NewFlagSet("cut", flag.ContinueOnError)
+	var timeRange rangeFlags
+	timeRange.register(flags)
+	outputPath := flags.String("o", "", "output recording, - for standard output")
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := timeRange.validate(); err != nil {
+		return err
+	}
+	if len(*outputPath) == 0 {
+		return fmt.Errorf("-o is required")
+	}
+
+	file := os.Stdout
+	if *outputPath != "-" {
+		if file, err = os.Create(*outputPath); err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+	output := bufio.NewWriter(file)
+
+	display := gdisplay.NewDisplay()
+	var lastMouse *gprotocol.GuacamoleInstruction
+	dropped := make(map[string]bool)
+	cutting := false
+
+	err = replay(path, func(instruction gprotocol.GuacamoleInstruction, elapsed time.Duration) error {
+		opcode, instructionArgs := instruction.GetOpcode(), instruction.GetArgs()
+
+		// Before the range, only build the display state
+		if !cutting {
+			if opcode != "sync" || elapsed < timeRange.from {
+				updateDisplay(display, instruction)
+				if opcode == "mouse" {
+					lastMouse = &instruction
+				}
+				if len(instructionArgs) > 0 {
+					if streamOpcodes[opcode] {
+						dropped[instructionArgs[0]] = true
+					} else if opcode == "end" {
+						delete(dropped, instructionArgs[0])
+					}
+				}
+				return nil
+			}
+			cutting = true
+			if err := writeSnapshot(output, display, lastMouse); err != nil {
+				return err
+			}
+			return writeInstruction(output, opcode, instructionArgs...)
+		}
+
+		if opcode == "sync" && timeRange.past(elapsed) {
+			return errStop
+		}
+
+		// Drop the rest of the streams opened before the range
+		if len(instructionArgs) > 0 {
+			switch {
+			case streamOpcodes[opcode]:
+				delete(dropped, instructionArgs[0])
+			case (opcode == "blob" || opcode == "end") && dropped[instructionArgs[0]]:
+				if opcode == "end" {
+					delete(dropped, instructionArgs[0])
+				}
+				return nil
+			}
+		}
+		return writeInstruction(output, opcode, instructionArgs...)
+	})
+	if err != nil {
+		return err
+	}
+	if !cutting {
+		return fmt.Errorf("%v ends before %v", path, timeRange.from)
+	}
+	reportUnsupported(display)
+	return output.Flush()
+}
+
+/**
+ * Writes instructions recreating the given display state: every layer and
+ * buffer with its content, the cursor, and the last mouse position.
+ */
+func writeSnapshot(output io.Writer, display *gdisplay.Display, lastMouse *gprotocol.GuacamoleInstruction) error {
+	layers := display.GetLayers()
+	lowest := 0
+	for _, layer := range layers {
+		if layer.GetIndex() < lowest {
+			lowest = layer.GetIndex()
+		}
+		if err := writeInstruction(output, "size", strconv.Itoa(layer.GetIndex()),
+			strconv.Itoa(layer.GetWidth()), strconv.Itoa(layer.GetHeight())); err != nil {
+			return err
+		}
+	}
+
+	for _, layer := range layers {
+		index := strconv.Itoa(layer.GetIndex())
+		if layer.GetIndex() > 0 {
+			x, y, z := layer.GetPosition()
+			if err := writeInstruction(output, "move", index, strconv.Itoa(layer.GetParent()),
+				strconv.Itoa(x), strconv.Itoa(y), strconv.Itoa(z)); err != nil {
+				return err
+			}
+			if layer.GetOpacity() != 255 {
+				if err := writeInstruction(output, "shade", index, strconv.Itoa(layer.GetOpacity())); err != nil {
+					return err
+				}
+			}
+		}
+		if err := writeImage(output, index, layer); err != nil {
+			return err
+		}
+	}
+
+	// The cursor needs a buffer of its own, freed once set
+	cursor, hotspotX, hotspotY, _, _ := display.GetCursor()
+	if cursor != nil && !cursor.Rect.Empty() {
+		index := strconv.Itoa(lowest - 1)
+		width, height := strconv.Itoa(cursor.Rect.Dx()), strconv.Itoa(cursor.Rect.Dy())
+		if err := writeInstruction(output, "size", index, width, height); err != nil {
+			return err
+		}
+		if err := writePNG(output, index, cursor); err != nil {
+			return err
+		}
+		if err := writeInstruction(output, "cursor", strconv.Itoa(hotspotX), strconv.Itoa(hotspotY),
+			index, "0", "0", width, height); err != nil {
+			return err
+		}
+		if err := writeInstruction(output, "dispose", index); err != nil {
+			return err
+		}
+	}
+
+	if lastMouse != nil {
+		return writeInstruction(output, lastMouse.GetOpcode(), lastMouse.GetArgs()...)
+	}
+	return nil
+}
+
+/**
+ * Writes the content of the given layer, unless it is fully transparent.
+ */
+func writeImage(output io.Writer, index string, layer *gdisplay.Layer) error {
+	img := layer.GetImage()
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0 {
+			return writePNG(output, index, img)
+		}
+	}
+	return nil
+}
+
+/**
+ * Writes the given image as a PNG stream drawn at the origin of the given
+ * layer.
+ */
+func writePNG(output io.Writer, index string, img *image.RGBA) error {
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data.Bytes())
+
+	const stream = "0"
+	if err := writeInstruction(output, "img", stream, strconv.Itoa(gdisplay.SRC), index, "image/png", "0", "0"); err != nil {
+		return err
+	}
+	for len(encoded) > 0 {
+		chunk := encoded
+		if len(chunk) > blobSize {
+			chunk = chunk[:blobSize]
+		}
+		encoded = encoded[len(chunk):]
+		if err := writeInstruction(output, "blob", stream, chunk); err != nil {
+			return err
+		}
+	}
+	return writeInstruction(output, "end", stream)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/gdisplay"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * A recording of four frames, one second apart, with an audio stream
+ * spanning the first two.
+ */
+var testRecording = [][]string{
+	{"sync", "1000"},
+	{"size", "0", "16", "16"},
+	{"rect", "0", "0", "0", "16", "16"},
+	{"cfill", "12", "0", "255", "0", "0", "255"},
+	{"size", "1", "4", "4"},
+	{"move", "1", "0", "2", "2", "0"},
+	{"rect", "1", "0", "0", "4", "4"},
+	{"cfill", "12", "1", "0", "255", "0", "255"},
+	{"shade", "1", "200"},
+	{"rect", "-1", "0", "0", "2", "2"},
+	{"cfill", "12", "-1", "255", "255", "255", "255"},
+	{"cursor", "1", "1", "-1", "0", "0", "2", "2"},
+	{"mouse", "10", "12", "0", "1000"},
+	{"audio", "5", "1", "audio/L16"},
+	{"blob", "5", "AAAA"},
+	{"sync", "2000"},
+	{"blob", "5", "AAAA"},
+	{"end", "5"},
+	{"rect", "0", "8", "8", "4", "4"},
+	{"cfill", "12", "0", "0", "0", "255", "255"},
+	{"sync", "3000"},
+	{"rect", "0", "0", "0", "16", "16"},
+	{"cfill", "12", "0", "255", "255", "0", "255"},
+	{"sync", "4000"},
+}
+
+/**
+ * Writes the given instructions to a new recording, returning its
+ * directory and path.
+ */
+func writeRecording(t *testing.T, instructions [][]string) (string, string) {
+	t.Helper()
+	dir, e := ioutil.TempDir("", "guacrec")
+	if e != nil {
+		t.Fatal(e)
+	}
+	var data strings.Builder
+	for _, one := range instructions {
+		instruction := gprotocol.NewGuacamoleInstruction(one[0], one[1:]...)
+		data.WriteString(instruction.String())
+	}
+	path := filepath.Join(dir, "recording.guac")
+	if e = ioutil.WriteFile(path, []byte(data.String()), 0600); e != nil {
+		os.RemoveAll(dir)
+		t.Fatal(e)
+	}
+	return dir, path
+}
+
+func Test_Cut(t *testing.T) {
+	dir, path := writeRecording(t, testRecording)
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "cut.guac")
+	if e := runCut([]string{"-from", "1s", "-to", "1500ms", "-o", output, path}); e != nil {
+		t.Fatal(e)
+	}
+
+	// The cut starts with a snapshot of the display, then the frame of
+	// the range, without the rest of the streams opened before it
+	var opcodes []string
+	cut := gdisplay.NewDisplay()
+	e := replay(output, func(instruction gprotocol.GuacamoleInstruction, elapsed time.Duration) error {
+		opcodes = append(opcodes, instruction.GetOpcode())
+		if instruction.GetOpcode() == "sync" && instruction.GetArgs()[0] != "2000" {
+			t.Errorf("sync %v outside the range", instruction.GetArgs())
+		}
+		if len(instruction.GetArgs()) > 0 && instruction.GetArgs()[0] == "5" {
+			t.Errorf("%v of a stream opened before the range", instruction.GetOpcode())
+		}
+		if e := cut.Handle(instruction); e != nil {
+			t.Fatal(e.GetMessage())
+		}
+		return nil
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	joined := strings.Join(opcodes, ",")
+	if !strings.HasPrefix(joined, "size,size,size,img,") || !strings.Contains(joined, ",end,move,shade,img,") ||
+		!strings.HasSuffix(joined, ",end,cursor,dispose,mouse,sync,rect,cfill") {
+		t.Errorf("instructions %v", joined)
+	}
+
+	// Replaying the cut shows what the recording showed at its end
+	original := gdisplay.NewDisplay()
+	for _, one := range testRecording[:20] {
+		original.Handle(gprotocol.NewGuacamoleInstruction(one[0], one[1:]...))
+	}
+	expected, actual := original.Flatten(true), cut.Flatten(true)
+	if expected.Rect != actual.Rect || !bytes.Equal(expected.Pix, actual.Pix) {
+		t.Errorf("cut display differs from the original")
+	}
+	_, hotspotX, hotspotY, mouseX, mouseY := cut.GetCursor()
+	if hotspotX != 1 || hotspotY != 1 || mouseX != 10 || mouseY != 12 {
+		t.Errorf("cursor hotspot %v,%v, mouse %v,%v", hotspotX, hotspotY, mouseX, mouseY)
+	}
+	if layer := cut.GetLayer(1); layer.GetOpacity() != 200 || layer.GetWidth() != 4 {
+		t.Errorf("layer 1 opacity %v, width %v", layer.GetOpacity(), layer.GetWidth())
+	}
+}
+
+func Test_CutErrors(t *testing.T) {
+	dir, path := writeRecording(t, testRecording)
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "cut.guac")
+
+	for _, args := range [][]string{
+		{"-from", "10s", "-o", output, path},
+		{"-from", "2s", "-to", "1s", "-o", output, path},
+		{"-from", "1s", path},
+		{"-from", "1s", "-o", output},
+		{"-from", "1s", "-o", output, filepath.Join(dir, "missing.guac")},
+	} {
+		if e := runCut(args); e == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/gdisplay"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+func runFrames(args []string) error {
+	flags := flag.NewFlagSet("frames", flag.ContinueOnError)
+	var timeRange rangeFlags
+	timeRange.register(flags)
+	every := flags.Duration("every", time.Second, "interval between frames")
+	withCursor := flags.Bool("cursor", false, "draw the mouse cursor")
+	outputDir := flags.String("o", "", "directory of the PNG files, named by elapsed milliseconds")
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := timeRange.validate(); err != nil {
+		return err
+	}
+	if *every <= 0 {
+		return fmt.Errorf("invalid interval %v", *every)
+	}
+	if len(*outputDir) == 0 {
+		return fmt.Errorf("-o is required")
+	}
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		return err
+	}
+
+	display := gdisplay.NewDisplay()
+	next := timeRange.from
+	frames := 0
+	err = replay(path, func(instruction gprotocol.GuacamoleInstruction, elapsed time.Duration) error {
+		if instruction.GetOpcode() != "sync" {
+			updateDisplay(display, instruction)
+			return nil
+		}
+
+		// Frames are complete at each sync
+		if timeRange.past(elapsed) {
+			return errStop
+		}
+		if elapsed < next {
+			return nil
+		}
+		for next <= elapsed {
+			next += *every
+		}
+		frames++
+		name := filepath.Join(*outputDir, fmt.Sprintf("frame-%09d.png", elapsed/time.Millisecond))
+		return writeFrame(name, display, *withCursor)
+	})
+	if err != nil {
+		return err
+	}
+	reportUnsupported(display)
+	fmt.Fprintf(os.Stderr, "guacrec: wrote %v frames to %v\n", frames, *outputDir)
+	return nil
+}
+
+func writeFrame(name string, display *gdisplay.Display, withCursor bool) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, display.Flatten(withCursor)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
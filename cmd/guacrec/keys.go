@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * Calls the given function for each key event of a recording.
+ */
+func replayKeys(path string, handle func(keysym int, pressed bool, elapsed time.Duration)) (count int, err error) {
+	err = replay(path, func(instruction gprotocol.GuacamoleInstruction, elapsed time.Duration) error {
+		args := instruction.GetArgs()
+		if instruction.GetOpcode() != "key" || len(args) < 2 {
+			return nil
+		}
+		keysym, e := strconv.Atoi(args[0])
+		if e != nil {
+			return nil
+		}
+		count++
+		handle(keysym, args[1] == "1", elapsed)
+		return nil
+	})
+	return
+}
+
+func runKeys(args []string) error {
+	flags := flag.NewFlagSet("keys", flag.ContinueOnError)
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	output := bufio.NewWriter(os.Stdout)
+	defer output.Flush()
+	count, err := replayKeys(path, func(keysym int, pressed bool, elapsed time.Duration) {
+		state := "up"
+		if pressed {
+			state = "down"
+		}
+		fmt.Fprintf(output, "%v  %-4s  0x%04x  %v\n", formatElapsed(elapsed), state, keysym, keysymName(keysym))
+	})
+	if err == nil && count == 0 {
+		fmt.Fprintln(os.Stderr, "guacrec: no key events; was the recording made with recording-include-keys?")
+	}
+	return err
+}
+
+/**
+ * Rebuilds the text typed from key events, like guaclog: printable keys
+ * are written as typed, Return ends the line, and other keys and key
+ * combinations are written as <Name> or <Ctrl+x>.
+ */
+type typescript struct {
+	output    *bufio.Writer
+	raw       bool
+	line      []string
+	modifiers map[int]bool
+}
+
+func (opt *typescript) key(keysym int, pressed bool) {
+	if _, ok := keysymModifiers[keysym]; ok {
+		opt.modifiers[keysym] = pressed
+		return
+	}
+	if !pressed || keysym == 0xffe1 || keysym == 0xffe2 || keysym == 0xfe03 {
+		return
+	}
+
+	if held := opt.heldModifiers(); len(held) > 0 {
+		opt.write("<" + strings.Join(append(held, keysymName(keysym)), "+") + ">")
+		return
+	}
+	switch keysym {
+	case 0xff0d, 0xff8d:
+		opt.flush()
+		return
+	case 0xff08:
+		// Only erase characters, not keys written by name
+		if last := len(opt.line) - 1; !opt.raw && last >= 0 && utf8.RuneCountInString(opt.line[last]) == 1 {
+			opt.line = opt.line[:last]
+			return
+		}
+	}
+	if r, ok := keysymRune(keysym); ok {
+		opt.line = append(opt.line, string(r))
+		return
+	}
+	opt.write("<" + keysymName(keysym) + ">")
+}
+
+/**
+ * Returns the names of the modifiers held, in a stable order.
+ */
+func (opt *typescript) heldModifiers() (ret []string) {
+	seen := make(map[string]bool)
+	for keysym, held := range opt.modifiers {
+		name := keysymModifiers[keysym]
+		if held && !seen[name] {
+			seen[name] = true
+			ret = append(ret, name)
+		}
+	}
+	order := map[string]int{"Ctrl": 0, "Alt": 1, "Meta": 2, "Super": 3}
+	sort.Slice(ret, func(i, j int) bool { return order[ret[i]] < order[ret[j]] })
+	return
+}
+
+func (opt *typescript) write(text string) {
+	opt.line = append(opt.line, text)
+}
+
+func (opt *typescript) flush() {
+	opt.output.WriteString(strings.Join(opt.line, ""))
+	opt.output.WriteByte('\n')
+	opt.line = opt.line[:0]
+}
+
+func runTypescript(args []string) error {
+	flags := flag.NewFlagSet("typescript", flag.ContinueOnError)
+	raw := flags.Bool("raw", false, "write BackSpace as <BackSpace> instead of erasing the last character")
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	output := bufio.NewWriter(os.Stdout)
+	defer output.Flush()
+	script := typescript{output: output, raw: *raw, modifiers: make(map[int]bool)}
+	count, err := replayKeys(path, func(keysym int, pressed bool, elapsed time.Duration) {
+		script.key(keysym, pressed)
+	})
+	if len(script.line) > 0 {
+		script.flush()
+	}
+	if err == nil && count == 0 {
+		fmt.Fprintln(os.Stderr, "guacrec: no key events; was the recording made with recording-include-keys?")
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+/**
+ * The names of the X11 keysyms without a printable character, as sent by
+ * the JavaScript client.
+ */
+var keysymNames = map[int]string{
+	0xfe03: "AltGr",
+	0xff08: "BackSpace",
+	0xff09: "Tab",
+	0xff0d: "Return",
+	0xff13: "Pause",
+	0xff14: "Scroll_Lock",
+	0xff1b: "Escape",
+	0xff50: "Home",
+	0xff51: "Left",
+	0xff52: "Up",
+	0xff53: "Right",
+	0xff54: "Down",
+	0xff55: "Page_Up",
+	0xff56: "Page_Down",
+	0xff57: "End",
+	0xff61: "Print",
+	0xff63: "Insert",
+	0xff67: "Menu",
+	0xff7f: "Num_Lock",
+	0xff8d: "KP_Enter",
+	0xffe1: "Shift_L",
+	0xffe2: "Shift_R",
+	0xffe3: "Control_L",
+	0xffe4: "Control_R",
+	0xffe5: "Caps_Lock",
+	0xffe7: "Meta_L",
+	0xffe8: "Meta_R",
+	0xffe9: "Alt_L",
+	0xffea: "Alt_R",
+	0xffeb: "Super_L",
+	0xffec: "Super_R",
+	0xffff: "Delete",
+}
+
+/**
+ * The modifiers shown in key combinations, by keysym.
+ */
+var keysymModifiers = map[int]string{
+	0xffe3: "Ctrl",
+	0xffe4: "Ctrl",
+	0xffe7: "Meta",
+	0xffe8: "Meta",
+	0xffe9: "Alt",
+	0xffea: "Alt",
+	0xffeb: "Super",
+	0xffec: "Super",
+}
+
+func init() {
+	for n := 0; n < 12; n++ {
+		keysymNames[0xffbe+n] = fmt.Sprintf("F%d", n+1)
+	}
+}
+
+/**
+ * Returns the character typed by the given keysym, if any.
+ */
+func keysymRune(keysym int) (rune, bool) {
+	switch {
+	case keysym >= 0x20 && keysym <= 0x7e, keysym >= 0xa0 && keysym <= 0xff:
+		return rune(keysym), true
+	case keysym >= 0x1000020 && keysym <= 0x110ffff:
+		r := rune(keysym - 0x1000000)
+		return r, unicode.IsPrint(r)
+	case keysym >= 0xffb0 && keysym <= 0xffb9:
+		return rune('0' + keysym - 0xffb0), true
+	}
+	switch keysym {
+	case 0xff80:
+		return ' ', true
+	case 0xffaa:
+		return '*', true
+	case 0xffab:
+		return '+', true
+	case 0xffad:
+		return '-', true
+	case 0xffae:
+		return '.', true
+	case 0xffaf:
+		return '/', true
+	}
+	return 0, false
+}
+
+/**
+ * Returns a readable name of the given keysym.
+ */
+func keysymName(keysym int) string {
+	if name, ok := keysymNames[keysym]; ok {
+		return name
+	}
+	if r, ok := keysymRune(keysym); ok {
+		if r == ' ' {
+			return "space"
+		}
+		return string(r)
+	}
+	return "0x" + strconv.FormatInt(int64(keysym), 16)
+}
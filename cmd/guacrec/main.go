@@ -0,0 +1,79 @@
+// Command guacrec reads session recordings written by guacd, without the
+// C guacenc and guaclog tools.
+//
+// Usage:
+//
+//	guacrec stats      [-json] RECORDING
+//	guacrec keys       RECORDING
+//	guacrec typescript [-raw] RECORDING
+//	guacrec cut        -from DURATION [-to DURATION] -o OUTPUT RECORDING
+//	guacrec frames     [-every DURATION] [-from DURATION] [-to DURATION] [-cursor] -o DIRECTORY RECORDING
+//
+// Durations are relative to the first frame of the recording. A recording
+// of "-" is read from standard input.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+/**
+ * A subcommand, run with the arguments following its name.
+ */
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"stats", "print the duration, instruction counts, resolution changes and input events", runStats},
+	{"keys", "print the key events of a recording made with recording-include-keys", runKeys},
+	{"typescript", "print the text typed during a recording made with recording-include-keys", runTypescript},
+	{"cut", "write the given time range of a recording as a new recording", runCut},
+	{"frames", "render frames sampled at a regular interval to PNG files", runFrames},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, one := range commands {
+		if one.name == os.Args[1] {
+			if err := one.run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "guacrec:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: guacrec COMMAND [flags] RECORDING")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, one := range commands {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", one.name, one.summary)
+	}
+}
+
+/**
+ * Parses the flags of a command, which must leave exactly the recording
+ * as argument.
+ */
+func parseFlags(flags *flag.FlagSet, args []string) (recording string, err error) {
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return "", fmt.Errorf("exactly one recording expected")
+	}
+	return flags.Arg(0), nil
+}
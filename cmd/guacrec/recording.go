@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/gdisplay"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * Called for each instruction of a recording, with the time elapsed since
+ * the first "sync" of the recording. A "sync" is reported with its own
+ * time.
+ */
+type instructionHandler func(instruction gprotocol.GuacamoleInstruction, elapsed time.Duration) error
+
+/**
+ * Sentinel returned by handlers to stop reading the recording early.
+ */
+var errStop = fmt.Errorf("stop")
+
+/**
+ * Reads the given recording, calling the handler for each instruction.
+ */
+func replay(path string, handler instructionHandler) error {
+	input := io.Reader(os.Stdin)
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	parser := gprotocol.NewGuacamoleStreamParser()
+	buffer := make([]byte, 64*1024)
+	clock := recordingClock{}
+	for {
+		n, readErr := input.Read(buffer)
+		instructions, err := parser.Append(buffer[:n])
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err.GetMessage())
+		}
+		for _, instruction := range instructions {
+			if err := handler(instruction, clock.update(instruction)); err != nil {
+				if err == errStop {
+					return nil
+				}
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			if parser.Pending() > 0 {
+				fmt.Fprintf(os.Stderr, "guacrec: %v: ignoring %v bytes of truncated instruction\n", path, parser.Pending())
+			}
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+/**
+ * The position of the timestamp argument of the input events recorded by
+ * guacd.
+ */
+var inputTimestamps = map[string]int{"key": 2, "mouse": 3, "touch": 6}
+
+/**
+ * Tracks the time of a recording from the timestamps of its "sync"
+ * instructions, in milliseconds.
+ */
+type recordingClock struct {
+	first   int64
+	current int64
+	started bool
+}
+
+func (opt *recordingClock) update(instruction gprotocol.GuacamoleInstruction) time.Duration {
+	if instruction.GetOpcode() == "sync" && len(instruction.GetArgs()) > 0 {
+		if timestamp, err := strconv.ParseInt(instruction.GetArgs()[0], 10, 64); err == nil {
+			if !opt.started {
+				opt.first, opt.started = timestamp, true
+			}
+			if timestamp > opt.current {
+				opt.current = timestamp
+			}
+		}
+	}
+	if !opt.started {
+		return 0
+	}
+
+	// Input events carry the time they were received
+	if index, ok := inputTimestamps[instruction.GetOpcode()]; ok && len(instruction.GetArgs()) > index {
+		if timestamp, err := strconv.ParseInt(instruction.GetArgs()[index], 10, 64); err == nil && timestamp >= opt.first {
+			return time.Duration(timestamp-opt.first) * time.Millisecond
+		}
+	}
+	return time.Duration(opt.current-opt.first) * time.Millisecond
+}
+
+/**
+ * Formats the given elapsed time as H:MM:SS.mmm.
+ */
+func formatElapsed(elapsed time.Duration) string {
+	ms := int64(elapsed / time.Millisecond)
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+/**
+ * The flags selecting a time range of a recording.
+ */
+type rangeFlags struct {
+	from time.Duration
+	to   time.Duration
+}
+
+func (opt *rangeFlags) register(flags *flag.FlagSet) {
+	flags.DurationVar(&opt.from, "from", 0, "start of the range, relative to the start of the recording")
+	flags.DurationVar(&opt.to, "to", 0, "end of the range, 0 for the end of the recording")
+}
+
+func (opt *rangeFlags) validate() error {
+	if opt.from < 0 || opt.to < 0 || (opt.to > 0 && opt.to <= opt.from) {
+		return fmt.Errorf("invalid time range %v to %v", opt.from, opt.to)
+	}
+	return nil
+}
+
+/**
+ * Returns whether the given time is past the end of the range.
+ */
+func (opt *rangeFlags) past(elapsed time.Duration) bool {
+	return opt.to > 0 && elapsed > opt.to
+}
+
+/**
+ * Updates the display, warning about instructions it cannot process
+ * rather than giving up on the recording.
+ */
+func updateDisplay(display *gdisplay.Display, instruction gprotocol.GuacamoleInstruction) {
+	if err := display.Handle(instruction); err != nil {
+		fmt.Fprintf(os.Stderr, "guacrec: %v\n", err.GetMessage())
+	}
+}
+
+func reportUnsupported(display *gdisplay.Display) {
+	for mimetype, count := range display.GetUnsupportedTypes() {
+		fmt.Fprintf(os.Stderr, "guacrec: skipped %v images of type %v which cannot be decoded\n", count, mimetype)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * The statistics of a recording, as printed by "stats".
+ */
+type recordingStats struct {
+	Duration     time.Duration      `json:"-"`
+	DurationMs   int64              `json:"durationMs"`
+	Frames       int                `json:"frames"`
+	Instructions int                `json:"instructions"`
+	Opcodes      map[string]int     `json:"opcodes"`
+	Resolutions  []resolutionChange `json:"resolutions"`
+	Input        inputStats         `json:"input"`
+}
+
+/**
+ * A change of the size of the default layer.
+ */
+type resolutionChange struct {
+	AtMs   int64 `json:"atMs"`
+	Width  int   `json:"width"`
+	Height int   `json:"height"`
+}
+
+/**
+ * The input events recorded, present if the recording includes input.
+ */
+type inputStats struct {
+	KeyPresses  int `json:"keyPresses"`
+	KeyReleases int `json:"keyReleases"`
+	MouseEvents int `json:"mouseEvents"`
+	MouseClicks int `json:"mouseClicks"`
+	TouchEvents int `json:"touchEvents"`
+}
+
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the statistics as JSON")
+	path, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	stats := recordingStats{Opcodes: make(map[string]int), Resolutions: []resolutionChange{}}
+	lastMask := 0
+	err = replay(path, func(instruction gprotocol.GuacamoleInstruction, elapsed time.Duration) error {
+		opcode, instructionArgs := instruction.GetOpcode(), instruction.GetArgs()
+		stats.Instructions++
+		stats.Opcodes[opcode]++
+		if elapsed > stats.Duration {
+			stats.Duration = elapsed
+		}
+
+		switch opcode {
+		case "sync":
+			stats.Frames++
+		case "size":
+			if len(instructionArgs) < 3 || instructionArgs[0] != "0" {
+				break
+			}
+			width, _ := strconv.Atoi(instructionArgs[1])
+			height, _ := strconv.Atoi(instructionArgs[2])
+			last := len(stats.Resolutions) - 1
+			if last < 0 || stats.Resolutions[last].Width != width || stats.Resolutions[last].Height != height {
+				stats.Resolutions = append(stats.Resolutions, resolutionChange{
+					AtMs: int64(elapsed / time.Millisecond), Width: width, Height: height,
+				})
+			}
+		case "key":
+			if len(instructionArgs) >= 2 && instructionArgs[1] == "1" {
+				stats.Input.KeyPresses++
+			} else {
+				stats.Input.KeyReleases++
+			}
+		case "mouse":
+			stats.Input.MouseEvents++
+			if len(instructionArgs) >= 3 {
+				mask, _ := strconv.Atoi(instructionArgs[2])
+				// Count buttons newly pressed, ignoring the scroll wheel
+				if pressed := mask &^ lastMask & 0x7; pressed != 0 {
+					stats.Input.MouseClicks++
+				}
+				lastMask = mask
+			}
+		case "touch":
+			stats.Input.TouchEvents++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	stats.DurationMs = int64(stats.Duration / time.Millisecond)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	}
+	printStats(stats)
+	return nil
+}
+
+func printStats(stats recordingStats) {
+	fmt.Printf("Duration:      %v\n", formatElapsed(stats.Duration))
+	fmt.Printf("Frames:        %v\n", stats.Frames)
+	fmt.Printf("Instructions:  %v\n", stats.Instructions)
+
+	fmt.Println()
+	fmt.Println("Resolution changes:")
+	for _, change := range stats.Resolutions {
+		fmt.Printf("  %v  %vx%v\n", formatElapsed(time.Duration(change.AtMs)*time.Millisecond), change.Width, change.Height)
+	}
+
+	fmt.Println()
+	fmt.Println("Input events:")
+	fmt.Printf("  key presses   %v\n", stats.Input.KeyPresses)
+	fmt.Printf("  key releases  %v\n", stats.Input.KeyReleases)
+	fmt.Printf("  mouse events  %v\n", stats.Input.MouseEvents)
+	fmt.Printf("  mouse clicks  %v\n", stats.Input.MouseClicks)
+	fmt.Printf("  touch events  %v\n", stats.Input.TouchEvents)
+
+	opcodes := make([]string, 0, len(stats.Opcodes))
+	for opcode := range stats.Opcodes {
+		opcodes = append(opcodes, opcode)
+	}
+	sort.Slice(opcodes, func(i, j int) bool {
+		if stats.Opcodes[opcodes[i]] != stats.Opcodes[opcodes[j]] {
+			return stats.Opcodes[opcodes[i]] > stats.Opcodes[opcodes[j]]
+		}
+		return opcodes[i] < opcodes[j]
+	})
+	fmt.Println()
+	fmt.Println("Instructions by opcode:")
+	for _, opcode := range opcodes {
+		fmt.Printf("  %-10s %v\n", opcode, stats.Opcodes[opcode])
+	}
+}
@@ -0,0 +1,315 @@
+// Package mockguacd provides a fake Guacamole proxy server (guacd), used by
+// guacbench to load tunnel servers and by the tests of the commands.
+package mockguacd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	mathrand "math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+// OutputConfig *
+//  * What the mock guacd sends to each session.
+type OutputConfig struct {
+	// Frames per second.
+	FPS int
+
+	// Drawing operations per frame.
+	Ops int
+
+	// Bytes of image data per frame, sent as "img" stream blobs.
+	ImageBytes int
+
+	// Frames which may await their "sync" reply before frames are
+	// skipped, as guacd does when a client lags.
+	MaxLag int
+}
+
+// LatencyRecorderInterface Tool interface for Server
+//  * Receives the time a client took to answer the "sync" of each frame.
+type LatencyRecorderInterface func(latency time.Duration)
+
+// Server *
+//  * A fake guacd which completes the handshake for any protocol, then sends
+//  * frames of drawing instructions and image data, and measures the time
+//  * clients take to answer each "sync".
+type Server struct {
+	config   OutputConfig
+	listener net.Listener
+	imageB64 string
+	latency  LatencyRecorderInterface
+
+	framesSent    int64
+	framesSkipped int64
+	inputEvents   int64
+	connections   sync.WaitGroup
+	stop          chan struct{}
+}
+
+/*NewServer *
+ * Starts a mock guacd listening on the given address.
+ *
+ * @param address The address to listen on, such as "127.0.0.1:0".
+ * @param config What to send to each session. FPS and MaxLag must be
+ *               positive.
+ * @param latency Receives the latency of each frame answered, or nil.
+ */
+func NewServer(address string, config OutputConfig, latency LatencyRecorderInterface) (ret *Server, err error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if latency == nil {
+		latency = func(time.Duration) {}
+	}
+	ret = &Server{
+		config:   config,
+		listener: listener,
+		imageB64: imageData(config.ImageBytes),
+		latency:  latency,
+		stop:     make(chan struct{}),
+	}
+	go ret.serve()
+	return
+}
+
+/**
+ * Returns base64 image data of the given decoded size. The data is random
+ * so that it does not compress, and never contains the word "error",
+ * which the HTTP tunnel takes for an error instruction.
+ */
+func imageData(size int) string {
+	raw := make([]byte, size)
+	for {
+		rand.Read(raw)
+		encoded := base64.StdEncoding.EncodeToString(raw)
+		if !strings.Contains(encoded, "error") {
+			return encoded
+		}
+	}
+}
+
+// Address Returns the address the mock guacd listens on.
+func (opt *Server) Address() string {
+	return opt.listener.Addr().String()
+}
+
+// FramesSent Returns the number of frames sent to all sessions.
+func (opt *Server) FramesSent() int64 {
+	return atomic.LoadInt64(&opt.framesSent)
+}
+
+// FramesSkipped Returns the number of frames skipped as clients lagged.
+func (opt *Server) FramesSkipped() int64 {
+	return atomic.LoadInt64(&opt.framesSkipped)
+}
+
+// InputEvents Returns the number of "key" and "mouse" instructions received.
+func (opt *Server) InputEvents() int64 {
+	return atomic.LoadInt64(&opt.inputEvents)
+}
+
+func (opt *Server) serve() {
+	for {
+		conn, err := opt.listener.Accept()
+		if err != nil {
+			return
+		}
+		opt.connections.Add(1)
+		go opt.handle(conn)
+	}
+}
+
+/*Close *
+ * Stops accepting connections and ends every session.
+ */
+func (opt *Server) Close() {
+	opt.listener.Close()
+	close(opt.stop)
+	opt.connections.Wait()
+}
+
+/**
+ * The state of one session of the mock guacd.
+ */
+type session struct {
+	server *Server
+	conn   net.Conn
+	writer *bufio.Writer
+
+	// Send times of the frames awaiting their "sync" reply, by timestamp
+	pending map[int64]time.Time
+	last    int64
+	lock    sync.Mutex
+}
+
+func (opt *Server) handle(conn net.Conn) {
+	defer opt.connections.Done()
+	defer conn.Close()
+
+	one := &session{server: opt, conn: conn, writer: bufio.NewWriterSize(conn, 64*1024), pending: make(map[int64]time.Time)}
+	parser := gprotocol.NewGuacamoleStreamParser()
+	buffer := make([]byte, 8192)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Sessions still in the handshake end with the server too
+	go func() {
+		select {
+		case <-done:
+		case <-opt.stop:
+			conn.Close()
+		}
+	}()
+
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return
+		}
+		instructions, perr := parser.Append(buffer[:n])
+		if perr != nil {
+			return
+		}
+		for _, instruction := range instructions {
+			switch instruction.GetOpcode() {
+			case "select":
+				one.send(gprotocol.NewGuacamoleInstruction("args", "VERSION_1_1_0", "hostname", "port"))
+			case "connect":
+				one.send(gprotocol.NewGuacamoleInstruction("ready", fmt.Sprintf("$mock-%p", one)))
+				go one.produce(done)
+			case "sync":
+				one.acknowledged(instruction.GetArgs())
+			case "key", "mouse":
+				atomic.AddInt64(&opt.inputEvents, 1)
+			case "disconnect":
+				return
+			}
+		}
+	}
+}
+
+func (opt *session) send(instructions ...gprotocol.GuacamoleInstruction) error {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	for _, instruction := range instructions {
+		opt.writer.WriteString(instruction.String())
+	}
+	return opt.writer.Flush()
+}
+
+/**
+ * Records the latency of the frame answered by the given "sync" reply.
+ */
+func (opt *session) acknowledged(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	timestamp, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return
+	}
+	opt.lock.Lock()
+	sent, ok := opt.pending[timestamp]
+	delete(opt.pending, timestamp)
+	opt.lock.Unlock()
+	if ok {
+		opt.server.latency(time.Since(sent))
+	}
+}
+
+/**
+ * Sends frames at the configured rate until the session ends.
+ */
+func (opt *session) produce(done <-chan struct{}) {
+	config := opt.server.config
+	ticker := time.NewTicker(time.Second / time.Duration(config.FPS))
+	defer ticker.Stop()
+	random := mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
+	stream := 0
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-opt.server.stop:
+			opt.conn.Close()
+			return
+		case <-ticker.C:
+		}
+
+		opt.lock.Lock()
+		lagging := len(opt.pending) >= config.MaxLag
+		opt.lock.Unlock()
+		if lagging {
+			atomic.AddInt64(&opt.server.framesSkipped, 1)
+			continue
+		}
+
+		frame := opt.frame(random, stream)
+		stream = (stream + 1) % 64
+
+		// Timestamps must be unique to match the replies
+		opt.lock.Lock()
+		timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+		if timestamp <= opt.last {
+			timestamp = opt.last + 1
+		}
+		opt.last = timestamp
+		opt.pending[timestamp] = time.Now()
+		opt.lock.Unlock()
+
+		frame = append(frame, gprotocol.NewGuacamoleInstruction("sync", strconv.FormatInt(timestamp, 10)))
+		if err := opt.send(frame...); err != nil {
+			return
+		}
+		atomic.AddInt64(&opt.server.framesSent, 1)
+	}
+}
+
+/**
+ * Returns the drawing instructions of one frame: filled rectangles,
+ * copies, and one image stream.
+ */
+func (opt *session) frame(random *mathrand.Rand, stream int) (ret []gprotocol.GuacamoleInstruction) {
+	itoa := strconv.Itoa
+	for n := 0; n < opt.server.config.Ops; n++ {
+		x, y := itoa(random.Intn(1024)), itoa(random.Intn(768))
+		if n%4 == 3 {
+			ret = append(ret, gprotocol.NewGuacamoleInstruction("copy", "0", x, y, "64", "64", "12", "0",
+				itoa(random.Intn(1024)), itoa(random.Intn(768))))
+			continue
+		}
+		ret = append(ret,
+			gprotocol.NewGuacamoleInstruction("rect", "0", x, y, itoa(1+random.Intn(200)), itoa(1+random.Intn(50))),
+			gprotocol.NewGuacamoleInstruction("cfill", "14", "0", itoa(random.Intn(256)), itoa(random.Intn(256)),
+				itoa(random.Intn(256)), "255"))
+	}
+
+	data := opt.server.imageB64
+	if len(data) == 0 {
+		return
+	}
+	index := itoa(stream)
+	ret = append(ret, gprotocol.NewGuacamoleInstruction("img", index, "14", "0", "image/png",
+		itoa(random.Intn(1024)), itoa(random.Intn(768))))
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > 4096 {
+			chunk = chunk[:4096]
+		}
+		data = data[len(chunk):]
+		ret = append(ret, gprotocol.NewGuacamoleInstruction("blob", index, chunk))
+	}
+	return append(ret, gprotocol.NewGuacamoleInstruction("end", index))
+}
@@ -2,9 +2,12 @@ package guacamole_client_go
 
 import (
 	"fmt"
+	"runtime"
 	"strings"
 )
 
+// ExceptionKind The kind of an exception, which is itself an error so that
+// exceptions may be matched against it with errors.Is
 type ExceptionKind int
 
 type ExceptionInterface interface {
@@ -12,12 +15,51 @@ type ExceptionInterface interface {
 	GetStatus() GuacamoleStatus
 	GetMessage() string
 	Kind() ExceptionKind
+
+	// Unwrap Returns the error which caused this exception, or nil
+	Unwrap() error
+
+	// Is Returns whether this exception is of the given ExceptionKind, or
+	// of a kind extending it
+	Is(target error) bool
+
+	// WithField Returns a copy of this exception having the given context
+	// field
+	WithField(key string, value interface{}) ExceptionInterface
+
+	// GetFields Returns the context fields of this exception
+	GetFields() map[string]interface{}
+
+	// GetStackTrace Returns the stack of the goroutine which threw this
+	// exception, one "function\n\tfile:line" entry per frame
+	GetStackTrace() string
 }
 
+/**
+ * The maximum number of frames of stack recorded by each exception.
+ */
+const maxStackDepth = 32
+
 type exceptionData struct {
 	err    error
 	status GuacamoleStatus
 	kind   ExceptionKind
+
+	/**
+	 * The error which caused this exception, if any.
+	 */
+	cause error
+
+	/**
+	 * Context fields attached with WithField, if any.
+	 */
+	fields map[string]interface{}
+
+	/**
+	 * The program counters of the stack of the goroutine which threw this
+	 * exception.
+	 */
+	stack []uintptr
 }
 
 func (opt *exceptionData) GetStatus() GuacamoleStatus {
@@ -36,6 +78,56 @@ func (opt *exceptionData) Kind() ExceptionKind {
 	return opt.kind
 }
 
+func (opt *exceptionData) Unwrap() error {
+	return opt.cause
+}
+
+func (opt *exceptionData) Is(target error) bool {
+	kind, ok := target.(ExceptionKind)
+	if !ok {
+		return false
+	}
+	for current := opt.kind; ; {
+		if current == kind {
+			return true
+		}
+		parent, ok := exceptionParents[current]
+		if !ok {
+			return false
+		}
+		current = parent
+	}
+}
+
+func (opt *exceptionData) WithField(key string, value interface{}) ExceptionInterface {
+	one := *opt
+	one.fields = make(map[string]interface{}, len(opt.fields)+1)
+	for k, v := range opt.fields {
+		one.fields[k] = v
+	}
+	one.fields[key] = value
+	return &one
+}
+
+func (opt *exceptionData) GetFields() map[string]interface{} {
+	return opt.fields
+}
+
+func (opt *exceptionData) GetStackTrace() string {
+	var builder strings.Builder
+	frames := runtime.CallersFrames(opt.stack)
+	for {
+		frame, more := frames.Next()
+		if len(frame.Function) > 0 {
+			fmt.Fprintf(&builder, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return builder.String()
+}
+
 // Value of ExceptionKind
 const (
 	GuacamoleClientBadTypeException ExceptionKind = iota
@@ -62,6 +154,71 @@ const (
 	GuacamoleUpstreamUnavailableException
 )
 
+/**
+ * The kind each kind of exception extends, as the exception classes of the
+ * Java Guacamole API extend each other. GuacamoleException extends none.
+ */
+var exceptionParents = map[ExceptionKind]ExceptionKind{
+	GuacamoleClientException:              GuacamoleException,
+	GuacamoleClientBadTypeException:       GuacamoleClientException,
+	GuacamoleClientOverrunException:       GuacamoleClientException,
+	GuacamoleClientTimeoutException:       GuacamoleClientException,
+	GuacamoleClientTooManyException:       GuacamoleClientException,
+	GuacamoleResourceClosedException:      GuacamoleClientException,
+	GuacamoleResourceConflictException:    GuacamoleClientException,
+	GuacamoleResourceNotFoundException:    GuacamoleClientException,
+	GuacamoleSecurityException:            GuacamoleClientException,
+	GuacamoleUnauthorizedException:        GuacamoleSecurityException,
+	GuacamoleServerException:              GuacamoleException,
+	GuacamoleConnectionClosedException:    GuacamoleServerException,
+	GuacamoleServerBusyException:          GuacamoleServerException,
+	GuacamoleUnsupportedException:         GuacamoleServerException,
+	GuacamoleUpstreamException:            GuacamoleServerException,
+	GuacamoleSessionClosedException:       GuacamoleUpstreamException,
+	GuacamoleSessionConflictException:     GuacamoleUpstreamException,
+	GuacamoleSessionTimeoutException:      GuacamoleUpstreamException,
+	GuacamoleUpstreamNotFoundException:    GuacamoleUpstreamException,
+	GuacamoleUpstreamTimeoutException:     GuacamoleUpstreamException,
+	GuacamoleUpstreamUnavailableException: GuacamoleUpstreamException,
+}
+
+/**
+ * The name of each kind of exception.
+ */
+var exceptionNames = map[ExceptionKind]string{
+	GuacamoleClientBadTypeException:       "GuacamoleClientBadTypeException",
+	GuacamoleClientException:              "GuacamoleClientException",
+	GuacamoleClientOverrunException:       "GuacamoleClientOverrunException",
+	GuacamoleClientTimeoutException:       "GuacamoleClientTimeoutException",
+	GuacamoleClientTooManyException:       "GuacamoleClientTooManyException",
+	GuacamoleConnectionClosedException:    "GuacamoleConnectionClosedException",
+	GuacamoleException:                    "GuacamoleException",
+	GuacamoleResourceClosedException:      "GuacamoleResourceClosedException",
+	GuacamoleResourceConflictException:    "GuacamoleResourceConflictException",
+	GuacamoleResourceNotFoundException:    "GuacamoleResourceNotFoundException",
+	GuacamoleSecurityException:            "GuacamoleSecurityException",
+	GuacamoleServerBusyException:          "GuacamoleServerBusyException",
+	GuacamoleServerException:              "GuacamoleServerException",
+	GuacamoleSessionClosedException:       "GuacamoleSessionClosedException",
+	GuacamoleSessionConflictException:     "GuacamoleSessionConflictException",
+	GuacamoleSessionTimeoutException:      "GuacamoleSessionTimeoutException",
+	GuacamoleUnauthorizedException:        "GuacamoleUnauthorizedException",
+	GuacamoleUnsupportedException:         "GuacamoleUnsupportedException",
+	GuacamoleUpstreamException:            "GuacamoleUpstreamException",
+	GuacamoleUpstreamNotFoundException:    "GuacamoleUpstreamNotFoundException",
+	GuacamoleUpstreamTimeoutException:     "GuacamoleUpstreamTimeoutException",
+	GuacamoleUpstreamUnavailableException: "GuacamoleUpstreamUnavailableException",
+}
+
+// Error Returns the name of the ExceptionKind, making it usable as the
+// target of errors.Is
+func (exception ExceptionKind) Error() string {
+	if name, ok := exceptionNames[exception]; ok {
+		return name
+	}
+	return fmt.Sprintf("ExceptionKind(%d)", int(exception))
+}
+
 // Status convert ExceptionKind to GuacamoleStatus
 func (exception ExceptionKind) Status() (state GuacamoleStatus) {
 	switch exception {
@@ -113,12 +270,67 @@ func (exception ExceptionKind) Status() (state GuacamoleStatus) {
 	return
 }
 
+/**
+ * The kind of exception corresponding to each status other than SUCCESS,
+ * as thrown for the "error" instructions reporting it.
+ */
+var statusExceptionKinds = map[GuacamoleStatus]ExceptionKind{
+	UNSUPPORTED:          GuacamoleUnsupportedException,
+	SERVER_ERROR:         GuacamoleServerException,
+	SERVER_BUSY:          GuacamoleServerBusyException,
+	UPSTREAM_TIMEOUT:     GuacamoleUpstreamTimeoutException,
+	UPSTREAM_ERROR:       GuacamoleUpstreamException,
+	RESOURCE_NOT_FOUND:   GuacamoleResourceNotFoundException,
+	RESOURCE_CONFLICT:    GuacamoleResourceConflictException,
+	RESOURCE_CLOSED:      GuacamoleResourceClosedException,
+	UPSTREAM_NOT_FOUND:   GuacamoleUpstreamNotFoundException,
+	UPSTREAM_UNAVAILABLE: GuacamoleUpstreamUnavailableException,
+	SESSION_CONFLICT:     GuacamoleSessionConflictException,
+	SESSION_TIMEOUT:      GuacamoleSessionTimeoutException,
+	SESSION_CLOSED:       GuacamoleSessionClosedException,
+	CLIENT_BAD_REQUEST:   GuacamoleClientException,
+	CLIENT_UNAUTHORIZED:  GuacamoleUnauthorizedException,
+	CLIENT_FORBIDDEN:     GuacamoleSecurityException,
+	CLIENT_TIMEOUT:       GuacamoleClientTimeoutException,
+	CLIENT_OVERRUN:       GuacamoleClientOverrunException,
+	CLIENT_BAD_TYPE:      GuacamoleClientBadTypeException,
+	CLIENT_TOO_MANY:      GuacamoleClientTooManyException,
+}
+
+// ExceptionKindFromStatus Returns the ExceptionKind corresponding to the
+// given GuacamoleStatus, whose Status is that status. SUCCESS and unknown
+// statuses correspond to GuacamoleServerException.
+func ExceptionKindFromStatus(status GuacamoleStatus) ExceptionKind {
+	if kind, ok := statusExceptionKinds[status]; ok {
+		return kind
+	}
+	return GuacamoleServerException
+}
+
 // Throw Build one ExceptionInterface by ExceptionKind
 func (exception ExceptionKind) Throw(args ...string) (err ExceptionInterface) {
-	err = &exceptionData{
+	return exception.build(nil, args)
+}
+
+// Wrap Build one ExceptionInterface by ExceptionKind, caused by the given
+// error. The message of the cause follows the given args in the message of
+// the exception, and the cause is returned by Unwrap.
+func (exception ExceptionKind) Wrap(cause error, args ...string) (err ExceptionInterface) {
+	if cause != nil {
+		args = append(args, cause.Error())
+	}
+	return exception.build(cause, args)
+}
+
+func (exception ExceptionKind) build(cause error, args []string) *exceptionData {
+	stack := make([]uintptr, maxStackDepth)
+	// Skip runtime.Callers, build, and Throw or Wrap
+	stack = stack[:runtime.Callers(3, stack)]
+	return &exceptionData{
 		err:    fmt.Errorf("%v", strings.Join(args, ", ")),
 		status: exception.Status(),
 		kind:   exception,
+		cause:  cause,
+		stack:  stack,
 	}
-	return
 }
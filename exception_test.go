@@ -0,0 +1,84 @@
+package guacamole_client_go
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_ExceptionWrap(t *testing.T) {
+	err := GuacamoleUpstreamTimeoutException.Wrap(io.ErrUnexpectedEOF, "Connection to guacd timed out.")
+	if err.GetMessage() != "Connection to guacd timed out., unexpected EOF" {
+		t.Errorf("GetMessage() = %q", err.GetMessage())
+	}
+	if err.GetStatus() != UPSTREAM_TIMEOUT || err.Kind() != GuacamoleUpstreamTimeoutException {
+		t.Errorf("status = %v, kind = %v", err.GetStatus(), err.Kind())
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error("cause is not matched by errors.Is")
+	}
+	if errors.Unwrap(GuacamoleServerException.Throw("no cause")) != nil {
+		t.Error("Throw has a cause")
+	}
+}
+
+func Test_ExceptionIs(t *testing.T) {
+	err := GuacamoleUpstreamTimeoutException.Throw("timeout")
+	for _, kind := range []ExceptionKind{GuacamoleUpstreamTimeoutException, GuacamoleUpstreamException,
+		GuacamoleServerException, GuacamoleException} {
+		if !errors.Is(err, kind) {
+			t.Errorf("errors.Is(err, %v) = false", kind)
+		}
+	}
+	for _, kind := range []ExceptionKind{GuacamoleClientException, GuacamoleUpstreamNotFoundException} {
+		if errors.Is(err, kind) {
+			t.Errorf("errors.Is(err, %v) = true", kind)
+		}
+	}
+	if !errors.Is(GuacamoleUnauthorizedException.Throw("denied"), GuacamoleClientException) {
+		t.Error("GuacamoleUnauthorizedException does not extend GuacamoleClientException")
+	}
+}
+
+func Test_ExceptionAs(t *testing.T) {
+	inner := GuacamoleResourceNotFoundException.Throw("No such tunnel.")
+	outer := GuacamoleServerException.Wrap(inner, "Forwarding failed.")
+
+	var found ExceptionInterface
+	if !errors.As(outer, &found) || found != outer {
+		t.Fatal("errors.As did not find the outer exception")
+	}
+	if !errors.Is(outer, GuacamoleResourceNotFoundException) {
+		t.Error("wrapped exception is not matched by errors.Is")
+	}
+}
+
+func Test_ExceptionFields(t *testing.T) {
+	err := GuacamoleServerException.Throw("failed")
+	with := err.WithField("tunnel", "abc")
+	if len(err.GetFields()) != 0 {
+		t.Error("WithField modified the original exception")
+	}
+	if with.GetFields()["tunnel"] != "abc" || with.Kind() != err.Kind() || with.GetMessage() != err.GetMessage() {
+		t.Errorf("WithField = %v", with.GetFields())
+	}
+	if !strings.Contains(err.GetStackTrace(), "Test_ExceptionFields") {
+		t.Errorf("stack trace does not start at the thrower:\n%s", err.GetStackTrace())
+	}
+}
+
+func Test_ExceptionKindFromStatus(t *testing.T) {
+	for status := range guacamoleStatusMap {
+		kind := ExceptionKindFromStatus(status)
+		if status == SUCCESS {
+			if kind != GuacamoleServerException {
+				t.Errorf("ExceptionKindFromStatus(SUCCESS) = %v", kind)
+			}
+			continue
+		}
+		if kind.Status() != status {
+			t.Errorf("ExceptionKindFromStatus(%v) = %v, whose status is %v", status, kind, kind.Status())
+		}
+	}
+}
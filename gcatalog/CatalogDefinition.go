@@ -0,0 +1,159 @@
+package gcatalog
+
+import (
+	"fmt"
+	"sort"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+// ConnectionDefinition *
+//  * A single named connection as written in a catalog file. Parameters not
+//  * given here are inherited from the enclosing groups.
+type ConnectionDefinition struct {
+	Name       string            `yaml:"name" json:"name"`
+	Protocol   string            `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Parameters map[string]string `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	Attributes map[string]string `yaml:"attributes,omitempty" json:"attributes,omitempty"`
+}
+
+// GroupDefinition *
+//  * A group of connections and nested groups. The protocol, parameters
+//  * and attributes of a group are inherited by everything below it, and
+//  * may be overridden at any level.
+type GroupDefinition struct {
+	Name        string                 `yaml:"name" json:"name"`
+	Type        string                 `yaml:"type,omitempty" json:"type,omitempty"`
+	Protocol    string                 `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Parameters  map[string]string      `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	Attributes  map[string]string      `yaml:"attributes,omitempty" json:"attributes,omitempty"`
+	Groups      []GroupDefinition      `yaml:"groups,omitempty" json:"groups,omitempty"`
+	Connections []ConnectionDefinition `yaml:"connections,omitempty" json:"connections,omitempty"`
+}
+
+// UserDefinition *
+//  * A user allowed to use a set of connections, as defined by the
+//  * "authorize" elements of user-mapping.xml.
+type UserDefinition struct {
+	Username    string   `yaml:"username" json:"username"`
+	Password    string   `yaml:"password,omitempty" json:"password,omitempty"`
+	Encoding    string   `yaml:"encoding,omitempty" json:"encoding,omitempty"`
+	Connections []string `yaml:"connections,omitempty" json:"connections,omitempty"`
+}
+
+// CatalogDefinition *
+//  * The root of a catalog file: an unnamed group which may also list
+//  * users.
+type CatalogDefinition struct {
+	GroupDefinition `yaml:",inline"`
+	Users           []UserDefinition `yaml:"users,omitempty" json:"users,omitempty"`
+}
+
+// ResolvedConnection *
+//  * A connection with all inherited values applied.
+type ResolvedConnection struct {
+	/**
+	 * The unique name of the connection.
+	 */
+	Name string
+
+	/**
+	 * The names of the enclosing groups, outermost first.
+	 */
+	Path []string
+
+	/**
+	 * The effective attributes of the connection.
+	 */
+	Attributes map[string]string
+
+	/**
+	 * The effective configuration of the connection.
+	 */
+	Config gprotocol.GuacamoleConfiguration
+}
+
+func mergeValues(inherited, own map[string]string) (ret map[string]string) {
+	ret = make(map[string]string, len(inherited)+len(own))
+	for k, v := range inherited {
+		ret[k] = v
+	}
+	for k, v := range own {
+		ret[k] = v
+	}
+	return
+}
+
+/*Resolve *
+ * Flattens the group tree of this definition, applying parameter,
+ * protocol and attribute inheritance to every connection.
+ *
+ * @return All connections indexed by name, or a GuacamoleServerException
+ *         if a connection name is duplicated or a connection has no
+ *         protocol.
+ */
+func (opt *CatalogDefinition) Resolve() (ret map[string]ResolvedConnection, err exp.ExceptionInterface) {
+	ret = make(map[string]ResolvedConnection)
+	err = resolveGroup(ret, opt.GroupDefinition, make([]string, 0), "", nil, nil)
+	return
+}
+
+func resolveGroup(ret map[string]ResolvedConnection, group GroupDefinition, path []string,
+	protocol string, parameters, attributes map[string]string) (err exp.ExceptionInterface) {
+
+	// Apply values of this group
+	if len(group.Protocol) > 0 {
+		protocol = group.Protocol
+	}
+	parameters = mergeValues(parameters, group.Parameters)
+	attributes = mergeValues(attributes, group.Attributes)
+
+	for _, connection := range group.Connections {
+		if len(connection.Name) == 0 {
+			err = exp.GuacamoleServerException.Throw(fmt.Sprintf("Connection without name in group %v.", path))
+			return
+		}
+		if _, ok := ret[connection.Name]; ok {
+			err = exp.GuacamoleServerException.Throw(fmt.Sprintf("Duplicate connection \"%v\".", connection.Name))
+			return
+		}
+
+		one := ResolvedConnection{
+			Name:       connection.Name,
+			Path:       path,
+			Attributes: mergeValues(attributes, connection.Attributes),
+			Config:     gprotocol.NewGuacamoleConfiguration(),
+		}
+		one.Config.SetProtocol(protocol)
+		if len(connection.Protocol) > 0 {
+			one.Config.SetProtocol(connection.Protocol)
+		}
+		if len(one.Config.GetProtocol()) == 0 {
+			err = exp.GuacamoleServerException.Throw(fmt.Sprintf("Connection \"%v\" has no protocol.", connection.Name))
+			return
+		}
+		one.Config.SetParameters(mergeValues(parameters, connection.Parameters))
+		ret[connection.Name] = one
+	}
+
+	for _, child := range group.Groups {
+		childPath := make([]string, len(path), len(path)+1)
+		copy(childPath, path)
+		childPath = append(childPath, child.Name)
+		err = resolveGroup(ret, child, childPath, protocol, parameters, attributes)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func sortedNames(connections map[string]ResolvedConnection) (ret []string) {
+	ret = make([]string, 0, len(connections))
+	for k := range connections {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return
+}
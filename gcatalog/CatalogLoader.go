@@ -0,0 +1,163 @@
+package gcatalog
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// CatalogLoaderInterface Tool interface for ConnectionCatalog
+//  * Parses the raw content of a catalog file.
+type CatalogLoaderInterface func(data []byte) (CatalogDefinition, exp.ExceptionInterface)
+
+/*LoadYAML *
+ * Parses a connection catalog written in YAML.
+ *
+ * @param data The content of the YAML file.
+ * @return The parsed catalog definition.
+ */
+func LoadYAML(data []byte) (ret CatalogDefinition, err exp.ExceptionInterface) {
+	if e := yaml.UnmarshalStrict(data, &ret); e != nil {
+		err = exp.GuacamoleServerException.Wrap(e, "Unable to parse YAML connection catalog.")
+	}
+	return
+}
+
+/*LoadJSON *
+ * Parses a connection catalog written in JSON, using the same structure as
+ * the YAML format.
+ *
+ * @param data The content of the JSON file.
+ * @return The parsed catalog definition.
+ */
+func LoadJSON(data []byte) (ret CatalogDefinition, err exp.ExceptionInterface) {
+	if e := json.Unmarshal(data, &ret); e != nil {
+		err = exp.GuacamoleServerException.Wrap(e, "Unable to parse JSON connection catalog.")
+	}
+	return
+}
+
+// Structure of the classic user-mapping.xml format
+type userMappingParam struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type userMappingConnection struct {
+	Name     string             `xml:"name,attr"`
+	Protocol string             `xml:"protocol"`
+	Params   []userMappingParam `xml:"param"`
+}
+
+type userMappingAuthorize struct {
+	Username    string                  `xml:"username,attr"`
+	Password    string                  `xml:"password,attr"`
+	Encoding    string                  `xml:"encoding,attr"`
+	Protocol    string                  `xml:"protocol"`
+	Params      []userMappingParam      `xml:"param"`
+	Connections []userMappingConnection `xml:"connection"`
+}
+
+type userMapping struct {
+	XMLName   xml.Name               `xml:"user-mapping"`
+	Authorize []userMappingAuthorize `xml:"authorize"`
+}
+
+func userMappingParameters(params []userMappingParam) (ret map[string]string) {
+	ret = make(map[string]string, len(params))
+	for _, param := range params {
+		ret[param.Name] = param.Value
+	}
+	return
+}
+
+/*LoadUserMapping *
+ * Parses the classic user-mapping.xml format. Each "authorize" element
+ * becomes a group named after the user. A user authorized with a single
+ * protocol/param set, rather than "connection" elements, receives one
+ * connection named after that user. Connection names must be unique across
+ * the whole file, unless every definition of that name is identical.
+ *
+ * @param data The content of the XML file.
+ * @return The parsed catalog definition.
+ */
+func LoadUserMapping(data []byte) (ret CatalogDefinition, err exp.ExceptionInterface) {
+	var mapping userMapping
+	if e := xml.Unmarshal(data, &mapping); e != nil {
+		err = exp.GuacamoleServerException.Wrap(e, "Unable to parse user-mapping.xml.")
+		return
+	}
+
+	seen := make(map[string]ConnectionDefinition)
+	for _, authorize := range mapping.Authorize {
+		group := GroupDefinition{Name: authorize.Username}
+		user := UserDefinition{
+			Username: authorize.Username,
+			Password: authorize.Password,
+			Encoding: authorize.Encoding,
+		}
+
+		connections := make([]ConnectionDefinition, 0, len(authorize.Connections)+1)
+		if len(authorize.Protocol) > 0 {
+			connections = append(connections, ConnectionDefinition{
+				Name:       authorize.Username,
+				Protocol:   authorize.Protocol,
+				Parameters: userMappingParameters(authorize.Params),
+			})
+		}
+		for _, connection := range authorize.Connections {
+			connections = append(connections, ConnectionDefinition{
+				Name:       connection.Name,
+				Protocol:   connection.Protocol,
+				Parameters: userMappingParameters(connection.Params),
+			})
+		}
+
+		for _, connection := range connections {
+			user.Connections = append(user.Connections, connection.Name)
+
+			// Identical connections shared by several users are defined once
+			if previous, ok := seen[connection.Name]; ok {
+				if fmt.Sprint(previous) != fmt.Sprint(connection) {
+					err = exp.GuacamoleServerException.Throw(fmt.Sprintf("Conflicting definitions of connection \"%v\".", connection.Name))
+					return
+				}
+				continue
+			}
+			seen[connection.Name] = connection
+			group.Connections = append(group.Connections, connection)
+		}
+
+		ret.Groups = append(ret.Groups, group)
+		ret.Users = append(ret.Users, user)
+	}
+	return
+}
+
+/*LoaderForFile *
+ * Returns the loader matching the extension of the given file name:
+ * ".yaml" or ".yml" for YAML, ".json" for JSON and ".xml" for
+ * user-mapping.xml.
+ *
+ * @param path The name of the catalog file.
+ * @return The matching loader, or a GuacamoleUnsupportedException if the
+ *         extension is not recognized.
+ */
+func LoaderForFile(path string) (ret CatalogLoaderInterface, err exp.ExceptionInterface) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		ret = LoadYAML
+	case ".json":
+		ret = LoadJSON
+	case ".xml":
+		ret = LoadUserMapping
+	default:
+		err = exp.GuacamoleUnsupportedException.Throw("Unknown connection catalog format.", path)
+	}
+	return
+}
@@ -0,0 +1,184 @@
+package gcatalog
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+/**
+ * A connection expected of a fixture, with inheritance applied.
+ */
+type expectedConnection struct {
+	path       []string
+	protocol   string
+	parameters map[string]string
+	attributes map[string]string
+}
+
+/**
+ * Checks that the catalog holds exactly the expected connections.
+ */
+func checkConnections(t *testing.T, catalog *ConnectionCatalog, expected map[string]expectedConnection) {
+	t.Helper()
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	if actual := catalog.GetConnectionNames(); len(actual) != len(names) {
+		t.Errorf("connections = %v", actual)
+	}
+	for name, one := range expected {
+		connection, ok := catalog.GetConnection(name)
+		if !ok {
+			t.Errorf("connection %q missing", name)
+			continue
+		}
+		if len(connection.Path) != len(one.path) || (len(one.path) > 0 && !reflect.DeepEqual(connection.Path, one.path)) {
+			t.Errorf("%v: path = %v, expected %v", name, connection.Path, one.path)
+		}
+		if protocol := connection.Config.GetProtocol(); protocol != one.protocol {
+			t.Errorf("%v: protocol = %q, expected %q", name, protocol, one.protocol)
+		}
+		if parameters := connection.Config.GetParameters(); !reflect.DeepEqual(parameters, one.parameters) {
+			t.Errorf("%v: parameters = %v, expected %v", name, parameters, one.parameters)
+		}
+		if one.attributes == nil {
+			one.attributes = map[string]string{}
+		}
+		if !reflect.DeepEqual(connection.Attributes, one.attributes) {
+			t.Errorf("%v: attributes = %v, expected %v", name, connection.Attributes, one.attributes)
+		}
+	}
+}
+
+func Test_LoadCatalogFixtures(t *testing.T) {
+	expected := map[string]expectedConnection{
+		"office-1": {
+			path:       []string{"office"},
+			protocol:   "rdp",
+			parameters: map[string]string{"hostname": "10.0.0.1", "port": "3389", "security": "nla", "domain": "CORP"},
+			attributes: map[string]string{"max-connections": "10", "max-connections-per-user": "1"},
+		},
+		"office-2": {
+			path:       []string{"office"},
+			protocol:   "vnc",
+			parameters: map[string]string{"hostname": "10.0.0.2", "port": "5900", "security": "nla", "domain": "CORP"},
+			attributes: map[string]string{"max-connections": "1", "max-connections-per-user": "1"},
+		},
+		"bastion": {
+			protocol:   "ssh",
+			parameters: map[string]string{"hostname": "bastion.example.com", "port": "22", "security": "nla"},
+			attributes: map[string]string{"max-connections": "10"},
+		},
+	}
+
+	// The YAML and JSON fixtures hold the same catalog
+	for _, name := range []string{"catalog.yaml", "catalog.json"} {
+		t.Run(name, func(t *testing.T) {
+			catalog, err := LoadConnectionCatalog(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkConnections(t, catalog, expected)
+
+			definition := catalog.GetDefinition()
+			if len(definition.Groups) != 1 || definition.Groups[0].Type != "balancing" {
+				t.Errorf("groups = %+v", definition.Groups)
+			}
+			users := []UserDefinition{{Username: "alice", Password: "secret", Connections: []string{"office-1", "bastion"}}}
+			if !reflect.DeepEqual(definition.Users, users) {
+				t.Errorf("users = %+v", definition.Users)
+			}
+
+			// Configurations are copies
+			config, err := catalog.Get("bastion")
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.SetParameter("hostname", "changed")
+			if one, _ := catalog.GetConnection("bastion"); one.Config.GetParameter("hostname") != "bastion.example.com" {
+				t.Errorf("catalog changed through a configuration")
+			}
+			if _, err = catalog.Get("missing"); !errors.Is(err, exp.GuacamoleResourceNotFoundException) {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+func Test_LoadUserMappingFixture(t *testing.T) {
+	catalog, err := LoadConnectionCatalog(filepath.Join("testdata", "user-mapping.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users become groups, and shared connections are defined once
+	checkConnections(t, catalog, map[string]expectedConnection{
+		"alice": {
+			path:       []string{"alice"},
+			protocol:   "vnc",
+			parameters: map[string]string{"hostname": "10.0.0.1", "port": "5900"},
+		},
+		"bob-rdp": {
+			path:       []string{"bob"},
+			protocol:   "rdp",
+			parameters: map[string]string{"hostname": "10.0.0.2"},
+		},
+		"shared": {
+			path:       []string{"bob"},
+			protocol:   "ssh",
+			parameters: map[string]string{"hostname": "bastion.example.com"},
+		},
+	})
+	users := []UserDefinition{
+		{Username: "alice", Password: "5ebe2294ecd0e0f08eab7690d2a6ee69", Encoding: "md5", Connections: []string{"alice"}},
+		{Username: "bob", Password: "secret", Connections: []string{"bob-rdp", "shared"}},
+		{Username: "carol", Password: "secret", Connections: []string{"shared"}},
+	}
+	if definition := catalog.GetDefinition(); !reflect.DeepEqual(definition.Users, users) {
+		t.Errorf("users = %+v", definition.Users)
+	}
+}
+
+func Test_LoadCatalogErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		loader CatalogLoaderInterface
+		data   string
+	}{
+		{"yaml syntax", LoadYAML, "connections: [name: a"},
+		{"yaml unknown field", LoadYAML, "connections: [{name: a, protocol: vnc, hostname: a}]"},
+		{"yaml duplicate", LoadYAML, "protocol: vnc\nconnections: [{name: a}]\ngroups: [{name: g, connections: [{name: a}]}]"},
+		{"yaml no protocol", LoadYAML, "connections: [{name: a}]"},
+		{"yaml no name", LoadYAML, "protocol: vnc\nconnections: [{parameters: {hostname: a}}]"},
+		{"json syntax", LoadJSON, `{"connections": [`},
+		{"json no protocol", LoadJSON, `{"connections": [{"name": "a"}]}`},
+		{"xml syntax", LoadUserMapping, "<user-mapping><authorize>"},
+		{"xml conflict", LoadUserMapping, `<user-mapping>
+			<authorize username="a"><connection name="c"><protocol>vnc</protocol></connection></authorize>
+			<authorize username="b"><connection name="c"><protocol>rdp</protocol></connection></authorize>
+		</user-mapping>`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			definition, err := test.loader([]byte(test.data))
+			if err == nil {
+				_, err = NewConnectionCatalog(definition)
+			}
+			if !errors.Is(err, exp.GuacamoleServerException) {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+
+	if _, err := LoadConnectionCatalog(filepath.Join("testdata", "catalog.ini")); !errors.Is(err, exp.GuacamoleUnsupportedException) {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := LoadConnectionCatalog(filepath.Join("testdata", "missing.yaml")); !errors.Is(err, exp.GuacamoleResourceNotFoundException) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package gcatalog
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	logger "github.com/sirupsen/logrus"
+)
+
+// CatalogReloadListenerInterface Tool interface for ConnectionCatalog
+//  * Called with the catalog each time it was reloaded successfully.
+type CatalogReloadListenerInterface func(catalog *ConnectionCatalog)
+
+// ConnectionCatalog *
+//  * A set of named connections loaded from a file. The file may be
+//  * watched, in which case the catalog is reloaded whenever the file
+//  * changes. A catalog which fails to reload keeps its previous content.
+type ConnectionCatalog struct {
+	/**
+	 * The file the catalog is loaded from, if any.
+	 */
+	path string
+
+	/**
+	 * The loader used to parse the file.
+	 */
+	loader CatalogLoaderInterface
+
+	/**
+	 * The modification time of the file when it was last loaded.
+	 */
+	modTime time.Time
+
+	/**
+	 * The raw definition last loaded.
+	 */
+	definition CatalogDefinition
+
+	/**
+	 * All connections, with inheritance applied, indexed by name.
+	 */
+	connections map[string]ResolvedConnection
+
+	/**
+	 * Ticker which drives hot reload, if watching.
+	 */
+	watcher *time.Ticker
+	stop    chan struct{}
+
+	/**
+	 * The functions called after each successful reload.
+	 */
+	listeners []CatalogReloadListenerInterface
+
+	lock sync.RWMutex
+}
+
+/*NewConnectionCatalog *
+ * Creates a new ConnectionCatalog from the given definition. The catalog is
+ * not backed by any file and cannot be reloaded.
+ *
+ * @param definition The definition of all groups and connections.
+ * @throws GuacamoleException If the definition is invalid.
+ */
+func NewConnectionCatalog(definition CatalogDefinition) (ret *ConnectionCatalog, err exp.ExceptionInterface) {
+	connections, err := definition.Resolve()
+	if err != nil {
+		return
+	}
+	ret = &ConnectionCatalog{
+		definition:  definition,
+		connections: connections,
+	}
+	return
+}
+
+/*LoadConnectionCatalog *
+ * Creates a new ConnectionCatalog from the given file, choosing the loader
+ * by file extension.
+ *
+ * @param path The catalog file to load.
+ * @throws GuacamoleException If the file cannot be read or parsed.
+ */
+func LoadConnectionCatalog(path string) (ret *ConnectionCatalog, err exp.ExceptionInterface) {
+	loader, err := LoaderForFile(path)
+	if err != nil {
+		return
+	}
+	return LoadConnectionCatalog2(path, loader)
+}
+
+/*LoadConnectionCatalog2 *
+ * Creates a new ConnectionCatalog from the given file using the given
+ * loader.
+ *
+ * @param path The catalog file to load.
+ * @param loader The loader which parses the file.
+ * @throws GuacamoleException If the file cannot be read or parsed.
+ */
+func LoadConnectionCatalog2(path string, loader CatalogLoaderInterface) (ret *ConnectionCatalog, err exp.ExceptionInterface) {
+	ret = &ConnectionCatalog{
+		path:   path,
+		loader: loader,
+	}
+	err = ret.Reload()
+	if err != nil {
+		ret = nil
+	}
+	return
+}
+
+/*Reload *
+ * Reads and parses the backing file again. On failure the previous content
+ * of the catalog is kept.
+ *
+ * @throws GuacamoleException If the file cannot be read or parsed.
+ */
+func (opt *ConnectionCatalog) Reload() (err exp.ExceptionInterface) {
+	if len(opt.path) == 0 {
+		return exp.GuacamoleUnsupportedException.Throw("Connection catalog is not backed by a file.")
+	}
+
+	info, e := os.Stat(opt.path)
+	if e != nil {
+		return exp.GuacamoleResourceNotFoundException.Wrap(e, "Unable to read connection catalog.")
+	}
+	data, e := ioutil.ReadFile(opt.path)
+	if e != nil {
+		return exp.GuacamoleResourceNotFoundException.Wrap(e, "Unable to read connection catalog.")
+	}
+
+	definition, err := opt.loader(data)
+	if err != nil {
+		return
+	}
+	connections, err := definition.Resolve()
+	if err != nil {
+		return
+	}
+
+	opt.lock.Lock()
+	opt.modTime = info.ModTime()
+	opt.definition = definition
+	opt.connections = connections
+	listeners := opt.listeners
+	opt.lock.Unlock()
+
+	logger.Debugf("Loaded %v connections from \"%v\".", len(connections), opt.path)
+	for _, listener := range listeners {
+		listener(opt)
+	}
+	return
+}
+
+/*AddReloadListener *
+ * Registers the given function to be called after each successful reload
+ * of the catalog, whether by Reload or while watching.
+ *
+ * @param listener The function to call.
+ */
+func (opt *ConnectionCatalog) AddReloadListener(listener CatalogReloadListenerInterface) {
+	opt.lock.Lock()
+	opt.listeners = append(opt.listeners, listener)
+	opt.lock.Unlock()
+}
+
+/*Watch *
+ * Checks the backing file for changes at the given interval, reloading the
+ * catalog whenever the modification time of the file changes.
+ *
+ * @param interval The time between checks.
+ */
+func (opt *ConnectionCatalog) Watch(interval time.Duration) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if opt.watcher != nil || len(opt.path) == 0 {
+		return
+	}
+	opt.watcher = time.NewTicker(interval)
+	opt.stop = make(chan struct{})
+	go opt.watchTask(opt.watcher.C, opt.stop)
+}
+
+func (opt *ConnectionCatalog) watchTask(c <-chan time.Time, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c:
+		}
+
+		// A tick may still be pending once closed
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		info, e := os.Stat(opt.path)
+		if e != nil {
+			logger.Warnf("Unable to check connection catalog \"%v\": %v", opt.path, e)
+			continue
+		}
+
+		opt.lock.RLock()
+		changed := !info.ModTime().Equal(opt.modTime)
+		opt.lock.RUnlock()
+
+		if changed {
+			if err := opt.Reload(); err != nil {
+				logger.Warnf("Keeping previous connection catalog, reload of \"%v\" failed: %v", opt.path, err.GetMessage())
+			}
+		}
+	}
+}
+
+/*Close *
+ * Stops watching the backing file, if watching.
+ */
+func (opt *ConnectionCatalog) Close() {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if opt.watcher != nil {
+		opt.watcher.Stop()
+		close(opt.stop)
+		opt.watcher = nil
+	}
+}
+
+/*Get *
+ * Returns a ready GuacamoleConfiguration for the connection having the
+ * given name. The returned configuration is a copy and may be modified
+ * freely.
+ *
+ * @param name The name of the connection.
+ * @return The configuration of the connection.
+ * @throws GuacamoleResourceNotFoundException If no such connection exists.
+ */
+func (opt *ConnectionCatalog) Get(name string) (ret gprotocol.GuacamoleConfiguration, err exp.ExceptionInterface) {
+	one, ok := opt.GetConnection(name)
+	if !ok {
+		err = exp.GuacamoleResourceNotFoundException.Throw("No such connection.", name)
+		return
+	}
+	ret = gprotocol.NewGuacamoleConfiguration2(one.Config)
+	return
+}
+
+/*GetConnection *
+ * Returns the connection having the given name, including its group path
+ * and attributes.
+ *
+ * @param name The name of the connection.
+ * @return The connection, and whether such a connection exists.
+ */
+func (opt *ConnectionCatalog) GetConnection(name string) (ret ResolvedConnection, ok bool) {
+	opt.lock.RLock()
+	ret, ok = opt.connections[name]
+	opt.lock.RUnlock()
+	return
+}
+
+/*GetConnectionNames *
+ * Returns the sorted names of all connections in the catalog.
+ */
+func (opt *ConnectionCatalog) GetConnectionNames() []string {
+	opt.lock.RLock()
+	defer opt.lock.RUnlock()
+	return sortedNames(opt.connections)
+}
+
+/*GetDefinition *
+ * Returns the raw definition last loaded, including the group tree and
+ * any users.
+ */
+func (opt *ConnectionCatalog) GetDefinition() CatalogDefinition {
+	opt.lock.RLock()
+	defer opt.lock.RUnlock()
+	return opt.definition
+}
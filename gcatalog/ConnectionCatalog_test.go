@@ -0,0 +1,141 @@
+package gcatalog
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+/**
+ * Copies the given fixture to a temporary directory, returning its new path
+ * and a function removing it.
+ */
+func copyFixture(t *testing.T, name string) (string, func()) {
+	t.Helper()
+	data, e := ioutil.ReadFile(filepath.Join("testdata", name))
+	if e != nil {
+		t.Fatal(e)
+	}
+	dir, e := ioutil.TempDir("", "gcatalog")
+	if e != nil {
+		t.Fatal(e)
+	}
+	path := filepath.Join(dir, name)
+	if e = ioutil.WriteFile(path, data, 0600); e != nil {
+		os.RemoveAll(dir)
+		t.Fatal(e)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+/**
+ * Rewrites the given file, moving its modification time forward so that
+ * watchers notice the change however coarse the file system clock.
+ */
+func rewrite(t *testing.T, path, data string) {
+	t.Helper()
+	info, e := os.Stat(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e = ioutil.WriteFile(path, []byte(data), 0600); e != nil {
+		t.Fatal(e)
+	}
+	modTime := info.ModTime().Add(time.Second)
+	if e = os.Chtimes(path, modTime, modTime); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func Test_ConnectionCatalogReload(t *testing.T) {
+	path, remove := copyFixture(t, "catalog.yaml")
+	defer remove()
+	catalog, err := LoadConnectionCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := 0
+	catalog.AddReloadListener(func(one *ConnectionCatalog) {
+		if one != catalog {
+			t.Errorf("listener called with another catalog")
+		}
+		reloads++
+	})
+
+	rewrite(t, path, "protocol: vnc\nconnections: [{name: desktop, parameters: {hostname: desktop}}]")
+	if err = catalog.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if names := catalog.GetConnectionNames(); len(names) != 1 || names[0] != "desktop" || reloads != 1 {
+		t.Errorf("connections = %v after %v reloads", names, reloads)
+	}
+
+	// A broken file leaves the catalog as it was, without notifying
+	rewrite(t, path, "protocol: vnc\nconnections: [{name: a}, {name: a}]")
+	if err = catalog.Reload(); !errors.Is(err, exp.GuacamoleServerException) {
+		t.Errorf("err = %v", err)
+	}
+	os.Remove(path)
+	if err = catalog.Reload(); !errors.Is(err, exp.GuacamoleResourceNotFoundException) {
+		t.Errorf("err = %v", err)
+	}
+	if names := catalog.GetConnectionNames(); len(names) != 1 || names[0] != "desktop" || reloads != 1 {
+		t.Errorf("connections = %v after %v reloads", names, reloads)
+	}
+
+	// Catalogs not backed by a file cannot be reloaded
+	definition, _ := LoadYAML([]byte("protocol: vnc\nconnections: [{name: desktop}]"))
+	memory, err := NewConnectionCatalog(definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = memory.Reload(); !errors.Is(err, exp.GuacamoleUnsupportedException) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func Test_ConnectionCatalogWatch(t *testing.T) {
+	path, remove := copyFixture(t, "catalog.json")
+	defer remove()
+	catalog, err := LoadConnectionCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan []string, 4)
+	catalog.AddReloadListener(func(one *ConnectionCatalog) {
+		reloaded <- one.GetConnectionNames()
+	})
+	catalog.Watch(10 * time.Millisecond)
+	defer catalog.Close()
+
+	// Changes are picked up without reloading explicitly
+	rewrite(t, path, `{"protocol": "vnc", "connections": [{"name": "desktop"}]}`)
+	select {
+	case names := <-reloaded:
+		if len(names) != 1 || names[0] != "desktop" {
+			t.Errorf("connections = %v", names)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("catalog not reloaded")
+	}
+
+	// Broken files are not, and nothing is watched once closed
+	rewrite(t, path, `{"connections": [`)
+	time.Sleep(50 * time.Millisecond)
+	catalog.Close()
+	rewrite(t, path, `{"protocol": "rdp", "connections": [{"name": "other"}]}`)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case names := <-reloaded:
+		t.Errorf("reloaded with %v", names)
+	default:
+	}
+	if _, ok := catalog.GetConnection("desktop"); !ok {
+		t.Errorf("catalog changed by a broken file")
+	}
+}
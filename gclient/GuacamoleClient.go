@@ -0,0 +1,624 @@
+package gclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strconv"
+	"sync"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+const (
+	/*MOUSE_LEFT *
+	 * The button mask bit of the left mouse button.
+	 */
+	MOUSE_LEFT = 1
+
+	/*MOUSE_MIDDLE *
+	 * The button mask bit of the middle mouse button.
+	 */
+	MOUSE_MIDDLE = 2
+
+	/*MOUSE_RIGHT *
+	 * The button mask bit of the right mouse button.
+	 */
+	MOUSE_RIGHT = 4
+
+	/*MOUSE_UP *
+	 * The button mask bit of scrolling up.
+	 */
+	MOUSE_UP = 8
+
+	/*MOUSE_DOWN *
+	 * The button mask bit of scrolling down.
+	 */
+	MOUSE_DOWN = 16
+
+	/*BLOB_SIZE *
+	 * The number of bytes of stream data sent within each "blob"
+	 * instruction, as the JavaScript client does, keeping the instruction
+	 * within the maximum length guacd accepts.
+	 */
+	BLOB_SIZE = 6048
+
+	/*MAX_STREAMS *
+	 * The number of streams which guacd allows each user to open at once.
+	 */
+	MAX_STREAMS = 64
+)
+
+/**
+ * The opcodes of the instructions which draw, given to the drawing
+ * handler.
+ */
+var drawingOpcodes = map[string]bool{
+	"arc": true, "cfill": true, "clip": true, "close": true, "copy": true,
+	"cstroke": true, "cursor": true, "curve": true, "dispose": true,
+	"distort": true, "identity": true, "img": true, "lfill": true,
+	"line": true, "lstroke": true, "move": true, "pop": true, "push": true,
+	"rect": true, "reset": true, "set": true, "shade": true, "size": true,
+	"start": true, "transfer": true, "transform": true,
+}
+
+// InstructionHandlerInterface Handler of received instructions
+type InstructionHandlerInterface func(instruction gprotocol.GuacamoleInstruction)
+
+// SyncHandlerInterface Handler of the end of each frame, given its timestamp
+type SyncHandlerInterface func(timestamp int64)
+
+// ImageHandlerInterface Handler of each image received, once complete
+type ImageHandlerInterface func(layer, x, y int, mimetype string, data []byte)
+
+// ClipboardHandlerInterface Handler of each change of the remote clipboard
+type ClipboardHandlerInterface func(mimetype string, data []byte)
+
+// FileHandlerInterface Handler of each file downloaded from the remote desktop
+type FileHandlerInterface func(mimetype, filename string, data []byte)
+
+// AudioHandlerInterface Handler of each packet of audio received
+type AudioHandlerInterface func(mimetype string, data []byte)
+
+/**
+ * A stream opened by the server, whose blobs are being received.
+ */
+type inputStream struct {
+	opcode   string
+	mimetype string
+	filename string
+	layer    int
+	x, y     int
+	data     bytes.Buffer
+}
+
+// GuacamoleClient *
+//  * A Guacamole client for automation, speaking to guacd, or to a tunnel
+//  * server, over any GuacamoleSocket. It answers each "sync" as a browser
+//  * would, acknowledges the blobs of every stream, gives what it receives
+//  * to its handlers, and sends input.
+//  *
+//  * Handlers must be set before Run is called, and are called by the
+//  * goroutine calling Run. The Send functions may be called by any
+//  * goroutine.
+type GuacamoleClient struct {
+	socket    gnet.GuacamoleSocket
+	writeLock sync.Mutex
+
+	onInstruction InstructionHandlerInterface
+	onDrawing     InstructionHandlerInterface
+	onSync        SyncHandlerInterface
+	onImage       ImageHandlerInterface
+	onClipboard   ClipboardHandlerInterface
+	onFile        FileHandlerInterface
+	onAudio       AudioHandlerInterface
+
+	/**
+	 * The streams opened by the server, by index.
+	 */
+	streams map[string]*inputStream
+
+	/**
+	 * The streams opened by this client, by index, each receiving the
+	 * status of every "ack" of the stream.
+	 */
+	outputs    map[int]chan exp.ExceptionInterface
+	outputLock sync.Mutex
+
+	/**
+	 * Closed once Run returns, ending any wait for an "ack".
+	 */
+	done chan struct{}
+
+	closeOnce sync.Once
+	closed    bool
+}
+
+/*NewGuacamoleClient *
+ * Creates a new GuacamoleClient using the given socket, whose connection
+ * must be established already, such as an HTTPTunnelSocket, a
+ * WebSocketTunnelSocket, or the socket returned by ConnectGuacd.
+ *
+ * @param socket The socket of the connection.
+ */
+func NewGuacamoleClient(socket gnet.GuacamoleSocket) (ret *GuacamoleClient) {
+	return &GuacamoleClient{
+		socket:  socket,
+		streams: make(map[string]*inputStream),
+		outputs: make(map[int]chan exp.ExceptionInterface),
+		done:    make(chan struct{}),
+	}
+}
+
+/*ConnectGuacd *
+ * Connects directly to guacd at the given host and port, completing the
+ * protocol handshake with the given configuration and client information.
+ *
+ * @param ctx The context of the connection attempt and handshake.
+ * @param hostname The hostname of guacd.
+ * @param port The port of guacd.
+ * @param config The configuration of the connection.
+ * @param info The client information given during the handshake.
+ * @return The socket of the established connection.
+ * @throws GuacamoleException If guacd cannot be reached or the handshake
+ *                            fails.
+ */
+func ConnectGuacd(ctx context.Context, hostname string, port int, config gprotocol.GuacamoleConfiguration,
+	info gprotocol.GuacamoleClientInformation) (ret gnet.GuacamoleSocket, err exp.ExceptionInterface) {
+	socket, err := gnet.NewInetGuacamoleSocketContext(ctx, hostname, port)
+	if err != nil {
+		return
+	}
+	configured, err := gnet.NewConfiguredGuacamoleSocketContext(ctx, &socket, config, info)
+	if err != nil {
+		socket.Close()
+		return
+	}
+	return &configured, nil
+}
+
+// SetOnInstruction Sets the handler called with every instruction received
+func (opt *GuacamoleClient) SetOnInstruction(handler InstructionHandlerInterface) {
+	opt.onInstruction = handler
+}
+
+// SetOnDrawing Sets the handler called with every drawing instruction
+// received, such as "rect", "cfill", "copy" or "img".
+func (opt *GuacamoleClient) SetOnDrawing(handler InstructionHandlerInterface) {
+	opt.onDrawing = handler
+}
+
+// SetOnSync Sets the handler called at the end of each frame, before the
+// "sync" is answered.
+func (opt *GuacamoleClient) SetOnSync(handler SyncHandlerInterface) {
+	opt.onSync = handler
+}
+
+// SetOnImage Sets the handler called with the data of each "img" stream
+// once it ends.
+func (opt *GuacamoleClient) SetOnImage(handler ImageHandlerInterface) {
+	opt.onImage = handler
+}
+
+// SetOnClipboard Sets the handler called with the data of each
+// "clipboard" stream once it ends.
+func (opt *GuacamoleClient) SetOnClipboard(handler ClipboardHandlerInterface) {
+	opt.onClipboard = handler
+}
+
+// SetOnFile Sets the handler called with the data of each "file" stream
+// once it ends. Without one, files offered by the server are refused.
+func (opt *GuacamoleClient) SetOnFile(handler FileHandlerInterface) {
+	opt.onFile = handler
+}
+
+// SetOnAudio Sets the handler called with the data of each blob of every
+// "audio" stream.
+func (opt *GuacamoleClient) SetOnAudio(handler AudioHandlerInterface) {
+	opt.onAudio = handler
+}
+
+/*Run *
+ * Reads and handles instructions until the connection ends, returning
+ * nil if it was ended by either side with "disconnect", or by Disconnect.
+ *
+ * @throws GuacamoleException If the server reports an error, with the
+ *                            status it reported, or if the connection
+ *                            fails.
+ */
+func (opt *GuacamoleClient) Run() (err exp.ExceptionInterface) {
+	defer close(opt.done)
+	defer opt.socket.Close()
+
+	reader := opt.socket.GetReader()
+	for {
+		instruction, e := reader.ReadInstruction()
+		if e != nil {
+			if opt.isClosed() {
+				return nil
+			}
+			return e
+		}
+		if len(instruction.GetOpcode()) == 0 {
+			return nil
+		}
+
+		done, e := opt.handle(instruction)
+		if e != nil || done {
+			return e
+		}
+	}
+}
+
+/**
+ * Handles one instruction, returning whether it ends the connection.
+ */
+func (opt *GuacamoleClient) handle(instruction gprotocol.GuacamoleInstruction) (done bool, err exp.ExceptionInterface) {
+	opcode, args := instruction.GetOpcode(), instruction.GetArgs()
+	if opt.onInstruction != nil {
+		opt.onInstruction(instruction)
+	}
+	if drawingOpcodes[opcode] && opt.onDrawing != nil {
+		opt.onDrawing(instruction)
+	}
+
+	switch opcode {
+	case "sync":
+		if len(args) == 0 {
+			return
+		}
+		if opt.onSync != nil {
+			timestamp, _ := strconv.ParseInt(args[0], 10, 64)
+			opt.onSync(timestamp)
+		}
+		err = opt.SendInstruction(gprotocol.NewGuacamoleInstruction("sync", args[0]))
+
+	case "img":
+		if len(args) >= 6 {
+			layer, _ := strconv.Atoi(args[2])
+			x, _ := strconv.Atoi(args[4])
+			y, _ := strconv.Atoi(args[5])
+			opt.streams[args[0]] = &inputStream{opcode: opcode, mimetype: args[3], layer: layer, x: x, y: y}
+		}
+
+	case "clipboard", "audio":
+		if len(args) >= 2 {
+			opt.streams[args[0]] = &inputStream{opcode: opcode, mimetype: args[1]}
+		}
+
+	case "file":
+		if len(args) < 3 {
+			return
+		}
+		if opt.onFile == nil {
+			return false, opt.sendAck(args[0], "File transfer unsupported", exp.UNSUPPORTED)
+		}
+		opt.streams[args[0]] = &inputStream{opcode: opcode, mimetype: args[1], filename: args[2]}
+		err = opt.sendAck(args[0], "OK", exp.SUCCESS)
+
+	case "pipe", "video":
+		if len(args) >= 1 {
+			err = opt.sendAck(args[0], "Unsupported", exp.UNSUPPORTED)
+		}
+
+	case "blob":
+		if len(args) >= 2 {
+			err = opt.blob(args[0], args[1])
+		}
+
+	case "end":
+		if len(args) >= 1 {
+			opt.end(args[0])
+		}
+
+	case "ack":
+		if len(args) >= 3 {
+			opt.acknowledged(args)
+		}
+
+	case "error":
+		return true, gprotocol.ErrorInstructionException(instruction)
+
+	case "disconnect":
+		return true, nil
+	}
+	return
+}
+
+/**
+ * Receives one blob of the given stream, acknowledging it.
+ */
+func (opt *GuacamoleClient) blob(index, data string) exp.ExceptionInterface {
+	stream, ok := opt.streams[index]
+	if !ok {
+		return nil
+	}
+	decoded, e := base64.StdEncoding.DecodeString(data)
+	if e != nil {
+		delete(opt.streams, index)
+		return opt.sendAck(index, "Invalid base64 data", exp.CLIENT_BAD_REQUEST)
+	}
+	if stream.opcode == "audio" {
+		if opt.onAudio != nil {
+			opt.onAudio(stream.mimetype, decoded)
+		}
+	} else {
+		stream.data.Write(decoded)
+	}
+	return opt.sendAck(index, "OK", exp.SUCCESS)
+}
+
+/**
+ * Ends the given stream, giving its data to its handler.
+ */
+func (opt *GuacamoleClient) end(index string) {
+	stream, ok := opt.streams[index]
+	if !ok {
+		return
+	}
+	delete(opt.streams, index)
+	data := stream.data.Bytes()
+
+	switch stream.opcode {
+	case "img":
+		if opt.onImage != nil {
+			opt.onImage(stream.layer, stream.x, stream.y, stream.mimetype, data)
+		}
+	case "clipboard":
+		if opt.onClipboard != nil {
+			opt.onClipboard(stream.mimetype, data)
+		}
+	case "file":
+		if opt.onFile != nil {
+			opt.onFile(stream.mimetype, stream.filename, data)
+		}
+	}
+}
+
+/**
+ * Gives the status of an "ack" to the output stream awaiting it.
+ */
+func (opt *GuacamoleClient) acknowledged(args []string) {
+	index, e := strconv.Atoi(args[0])
+	if e != nil {
+		return
+	}
+	opt.outputLock.Lock()
+	acks, ok := opt.outputs[index]
+	opt.outputLock.Unlock()
+	if !ok {
+		return
+	}
+
+	var status exp.ExceptionInterface
+	if code, _ := strconv.Atoi(args[2]); code != exp.SUCCESS.GetGuacamoleStatusCode() {
+		status = statusException(code, args[1])
+	}
+	select {
+	case acks <- status:
+	default:
+	}
+}
+
+func (opt *GuacamoleClient) sendAck(index, message string, status exp.GuacamoleStatus) exp.ExceptionInterface {
+	return opt.SendInstruction(gprotocol.NewGuacamoleInstruction("ack", index, message,
+		strconv.Itoa(status.GetGuacamoleStatusCode())))
+}
+
+/*SendInstruction *
+ * Sends the given instruction to the server.
+ *
+ * @param instruction The instruction to send.
+ * @throws GuacamoleException If the instruction cannot be sent.
+ */
+func (opt *GuacamoleClient) SendInstruction(instruction gprotocol.GuacamoleInstruction) exp.ExceptionInterface {
+	opt.writeLock.Lock()
+	defer opt.writeLock.Unlock()
+	return opt.socket.GetWriter().WriteInstruction(instruction)
+}
+
+/*SendMouse *
+ * Sends the state of the mouse.
+ *
+ * @param x The X coordinate of the mouse pointer.
+ * @param y The Y coordinate of the mouse pointer.
+ * @param buttons The buttons pressed, as a mask of MOUSE_LEFT, MOUSE_MIDDLE,
+ *                MOUSE_RIGHT, MOUSE_UP and MOUSE_DOWN.
+ */
+func (opt *GuacamoleClient) SendMouse(x, y, buttons int) exp.ExceptionInterface {
+	return opt.SendInstruction(gprotocol.NewGuacamoleInstruction("mouse",
+		strconv.Itoa(x), strconv.Itoa(y), strconv.Itoa(buttons)))
+}
+
+/*SendKey *
+ * Sends the press or release of the key having the given X11 keysym.
+ *
+ * @param keysym The keysym of the key, such as 0xff0d for Return.
+ * @param pressed Whether the key is pressed rather than released.
+ */
+func (opt *GuacamoleClient) SendKey(keysym int, pressed bool) exp.ExceptionInterface {
+	state := "0"
+	if pressed {
+		state = "1"
+	}
+	return opt.SendInstruction(gprotocol.NewGuacamoleInstruction("key", strconv.Itoa(keysym), state))
+}
+
+/*SendText *
+ * Types the given text, pressing and releasing the key of each character
+ * in turn. Newlines are typed as Return and tabs as Tab.
+ *
+ * @param text The text to type.
+ */
+func (opt *GuacamoleClient) SendText(text string) exp.ExceptionInterface {
+	for _, char := range text {
+		keysym := keysymOf(char)
+		if err := opt.SendKey(keysym, true); err != nil {
+			return err
+		}
+		if err := opt.SendKey(keysym, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Returns the keysym typing the given character.
+ */
+func keysymOf(char rune) int {
+	switch {
+	case char == '\n' || char == '\r':
+		return 0xff0d
+	case char == '\t':
+		return 0xff09
+	case char == '\b':
+		return 0xff08
+	case char >= 0x20 && char <= 0x7e, char >= 0xa0 && char <= 0xff:
+		return int(char)
+	}
+	return 0x1000000 | int(char)
+}
+
+/*SendSize *
+ * Sends the size of the display of the client, asking the server to
+ * resize the remote display to match.
+ *
+ * @param width The width of the display, in pixels.
+ * @param height The height of the display, in pixels.
+ */
+func (opt *GuacamoleClient) SendSize(width, height int) exp.ExceptionInterface {
+	return opt.SendInstruction(gprotocol.NewGuacamoleInstruction("size", strconv.Itoa(width), strconv.Itoa(height)))
+}
+
+/*SendClipboard *
+ * Sets the clipboard of the remote desktop.
+ *
+ * @param mimetype The mimetype of the data, such as "text/plain".
+ * @param data The new contents of the clipboard.
+ */
+func (opt *GuacamoleClient) SendClipboard(mimetype string, data []byte) exp.ExceptionInterface {
+	index, err := opt.openStream()
+	if err != nil {
+		return err
+	}
+	defer opt.closeStream(index)
+
+	stream := strconv.Itoa(index)
+	if err = opt.SendInstruction(gprotocol.NewGuacamoleInstruction("clipboard", stream, mimetype)); err != nil {
+		return err
+	}
+	return opt.sendBlobs(index, data, false)
+}
+
+/*SendFile *
+ * Uploads the given file to the remote desktop, waiting for each blob to
+ * be acknowledged before sending the next. Run must be running, as it
+ * receives the acknowledgements.
+ *
+ * @param mimetype The mimetype of the file.
+ * @param filename The name of the file.
+ * @param data The contents of the file.
+ * @throws GuacamoleException If the server refuses the file, with the
+ *                            status it reported.
+ */
+func (opt *GuacamoleClient) SendFile(mimetype, filename string, data []byte) exp.ExceptionInterface {
+	index, err := opt.openStream()
+	if err != nil {
+		return err
+	}
+	defer opt.closeStream(index)
+
+	stream := strconv.Itoa(index)
+	if err = opt.SendInstruction(gprotocol.NewGuacamoleInstruction("file", stream, mimetype, filename)); err != nil {
+		return err
+	}
+	if err = opt.awaitAck(index); err != nil {
+		return err
+	}
+	return opt.sendBlobs(index, data, true)
+}
+
+/**
+ * Sends the given data as the blobs of the given stream, then ends it,
+ * waiting for each blob to be acknowledged if required.
+ */
+func (opt *GuacamoleClient) sendBlobs(index int, data []byte, acknowledged bool) (err exp.ExceptionInterface) {
+	stream := strconv.Itoa(index)
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > BLOB_SIZE {
+			chunk = chunk[:BLOB_SIZE]
+		}
+		data = data[len(chunk):]
+		if err = opt.SendInstruction(gprotocol.NewGuacamoleInstruction("blob", stream,
+			base64.StdEncoding.EncodeToString(chunk))); err != nil {
+			return
+		}
+		if acknowledged {
+			if err = opt.awaitAck(index); err != nil {
+				return
+			}
+		}
+	}
+	return opt.SendInstruction(gprotocol.NewGuacamoleInstruction("end", stream))
+}
+
+/**
+ * Allocates the lowest free output stream index.
+ */
+func (opt *GuacamoleClient) openStream() (int, exp.ExceptionInterface) {
+	opt.outputLock.Lock()
+	defer opt.outputLock.Unlock()
+	for index := 0; index < MAX_STREAMS; index++ {
+		if _, used := opt.outputs[index]; !used {
+			opt.outputs[index] = make(chan exp.ExceptionInterface, 1)
+			return index, nil
+		}
+	}
+	return -1, exp.GuacamoleClientTooManyException.Throw("Too many streams are open.")
+}
+
+func (opt *GuacamoleClient) closeStream(index int) {
+	opt.outputLock.Lock()
+	delete(opt.outputs, index)
+	opt.outputLock.Unlock()
+}
+
+/**
+ * Waits for the next "ack" of the given output stream, returning the
+ * error it reports, if any.
+ */
+func (opt *GuacamoleClient) awaitAck(index int) exp.ExceptionInterface {
+	opt.outputLock.Lock()
+	acks := opt.outputs[index]
+	opt.outputLock.Unlock()
+	select {
+	case err := <-acks:
+		return err
+	case <-opt.done:
+		return exp.GuacamoleConnectionClosedException.Throw("Connection closed before the stream was acknowledged.")
+	}
+}
+
+/*Disconnect *
+ * Ends the connection, sending "disconnect" to the server first. Run
+ * then returns nil.
+ */
+func (opt *GuacamoleClient) Disconnect() {
+	opt.closeOnce.Do(func() {
+		opt.outputLock.Lock()
+		opt.closed = true
+		opt.outputLock.Unlock()
+		opt.SendInstruction(gprotocol.NewGuacamoleInstruction("disconnect"))
+		opt.socket.Close()
+	})
+}
+
+func (opt *GuacamoleClient) isClosed() bool {
+	opt.outputLock.Lock()
+	defer opt.outputLock.Unlock()
+	return opt.closed
+}
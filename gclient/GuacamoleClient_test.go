@@ -0,0 +1,295 @@
+package gclient
+
+import (
+	"encoding/base64"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * A socket whose server side is played by the test: the data given to
+ * serve is read by the client, and the instructions written by the client
+ * are recorded and passed to reply, if set.
+ */
+type testSocket struct {
+	received chan []byte
+	closed   chan struct{}
+	reply    func(socket *testSocket, instruction gprotocol.GuacamoleInstruction)
+
+	reader    *tunnelReader
+	parser    gprotocol.GuacamoleStreamParser
+	sent      []string
+	lock      sync.Mutex
+	closeOnce sync.Once
+}
+
+func newTestSocket() (ret *testSocket) {
+	ret = &testSocket{
+		received: make(chan []byte, 64),
+		closed:   make(chan struct{}),
+		parser:   gprotocol.NewGuacamoleStreamParser(),
+	}
+	ret.reader = newTunnelReader(func() ([]byte, exp.ExceptionInterface) {
+		select {
+		case chunk := <-ret.received:
+			return chunk, nil
+		case <-ret.closed:
+			return nil, exp.GuacamoleConnectionClosedException.Throw("Connection closed.")
+		}
+	})
+	return
+}
+
+/**
+ * Sends the given instructions, written as opcode followed by arguments,
+ * to the client.
+ */
+func (opt *testSocket) serve(instructions ...[]string) {
+	var data strings.Builder
+	for _, one := range instructions {
+		instruction := gprotocol.NewGuacamoleInstruction(one[0], one[1:]...)
+		data.WriteString(instruction.String())
+	}
+	opt.received <- []byte(data.String())
+}
+
+/**
+ * Returns the instructions sent by the client, each as its opcode and
+ * arguments separated by spaces.
+ */
+func (opt *testSocket) sentInstructions() []string {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	return append([]string{}, opt.sent...)
+}
+
+func (opt *testSocket) GetReader() gio.GuacamoleReader { return opt.reader }
+
+func (opt *testSocket) GetWriter() gio.GuacamoleWriter {
+	return &tunnelWriter{send: func(data []byte) exp.ExceptionInterface {
+		if !opt.IsOpen() {
+			return exp.GuacamoleConnectionClosedException.Throw("Connection closed.")
+		}
+		opt.lock.Lock()
+		instructions, err := opt.parser.Append(data)
+		for _, instruction := range instructions {
+			opt.sent = append(opt.sent, strings.Join(append([]string{instruction.GetOpcode()}, instruction.GetArgs()...), " "))
+		}
+		opt.lock.Unlock()
+		if opt.reply != nil {
+			for _, instruction := range instructions {
+				opt.reply(opt, instruction)
+			}
+		}
+		return err
+	}}
+}
+
+func (opt *testSocket) IsOpen() bool {
+	select {
+	case <-opt.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+func (opt *testSocket) Close() exp.ExceptionInterface {
+	opt.closeOnce.Do(func() { close(opt.closed) })
+	return nil
+}
+
+func encode(data string) string {
+	return base64.StdEncoding.EncodeToString([]byte(data))
+}
+
+func checkSent(t *testing.T, actual []string, expected ...string) {
+	t.Helper()
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("sent:\n%v\nexpected:\n%v", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func Test_GuacamoleClientHandle(t *testing.T) {
+	socket := newTestSocket()
+	client := NewGuacamoleClient(socket)
+	var syncs []int64
+	var drawn, images, clipboards, audio []string
+	client.SetOnSync(func(timestamp int64) { syncs = append(syncs, timestamp) })
+	client.SetOnDrawing(func(instruction gprotocol.GuacamoleInstruction) {
+		drawn = append(drawn, instruction.GetOpcode())
+	})
+	client.SetOnImage(func(layer, x, y int, mimetype string, data []byte) {
+		images = append(images, strings.Join([]string{mimetype, string(data)}, " "))
+		if layer != 0 || x != 2 || y != 3 {
+			t.Errorf("image on layer %v at %v,%v", layer, x, y)
+		}
+	})
+	client.SetOnClipboard(func(mimetype string, data []byte) {
+		clipboards = append(clipboards, mimetype+" "+string(data))
+	})
+	client.SetOnAudio(func(mimetype string, data []byte) { audio = append(audio, mimetype+" "+string(data)) })
+
+	// Frames are answered, and the blobs of every stream acknowledged,
+	// whether or not an instruction is split across reads
+	socket.serve(
+		[]string{"sync", "1234"},
+		[]string{"rect", "0", "0", "0", "1", "1"},
+		[]string{"img", "1", "14", "0", "image/png", "2", "3"},
+		[]string{"blob", "1", encode("ab")},
+		[]string{"blob", "1", encode("cd")},
+		[]string{"end", "1"},
+		[]string{"blob", "1", encode("late")},
+		[]string{"clipboard", "2", "text/plain"},
+		[]string{"blob", "2", encode("hi")},
+		[]string{"end", "2"},
+		[]string{"audio", "3", "audio/L16"},
+		[]string{"blob", "3", encode("pcm")},
+		[]string{"pipe", "4", "application/octet-stream", "name"},
+		[]string{"file", "5", "text/plain", "a.txt"},
+		[]string{"img", "6", "14", "0", "image/png", "2", "3"},
+		[]string{"blob", "6", "%%%"},
+		[]string{"sync", "1235"},
+	)
+	disconnect := gprotocol.NewGuacamoleInstruction("disconnect")
+	socket.received <- []byte(disconnect.String()[:4])
+	socket.received <- []byte(disconnect.String()[4:])
+
+	if err := client.Run(); err != nil {
+		t.Fatal(err.GetMessage())
+	}
+	checkSent(t, socket.sentInstructions(),
+		"sync 1234",
+		"ack 1 OK 0",
+		"ack 1 OK 0",
+		"ack 2 OK 0",
+		"ack 3 OK 0",
+		"ack 4 Unsupported 256",
+		"ack 5 File transfer unsupported 256",
+		"ack 6 Invalid base64 data 768",
+		"sync 1235",
+	)
+	if len(syncs) != 2 || syncs[0] != 1234 || syncs[1] != 1235 {
+		t.Errorf("syncs %v", syncs)
+	}
+	if strings.Join(drawn, ",") != "rect,img,img" || len(images) != 1 || images[0] != "image/png abcd" {
+		t.Errorf("drawn %v, images %v", drawn, images)
+	}
+	if len(clipboards) != 1 || clipboards[0] != "text/plain hi" || len(audio) != 1 || audio[0] != "audio/L16 pcm" {
+		t.Errorf("clipboards %v, audio %v", clipboards, audio)
+	}
+	if socket.IsOpen() {
+		t.Errorf("socket left open")
+	}
+}
+
+func Test_GuacamoleClientErrors(t *testing.T) {
+	// Errors reported by the server end the connection, with their status
+	socket := newTestSocket()
+	socket.serve([]string{"sync", "1"}, []string{"error", "Upstream gone.", "519"})
+	err := NewGuacamoleClient(socket).Run()
+	if err == nil || err.GetStatus() != exp.UPSTREAM_NOT_FOUND || err.GetMessage() != "Upstream gone." {
+		t.Errorf("err = %v", err)
+	}
+
+	// As do failures of the connection, unless caused by Disconnect
+	socket = newTestSocket()
+	socket.Close()
+	if err = NewGuacamoleClient(socket).Run(); err == nil {
+		t.Errorf("connection failure not reported")
+	}
+}
+
+func Test_GuacamoleClientSend(t *testing.T) {
+	// Only the streams of files are acknowledged, as by guacd
+	socket := newTestSocket()
+	files := make(map[string]bool)
+	socket.reply = func(socket *testSocket, instruction gprotocol.GuacamoleInstruction) {
+		args := instruction.GetArgs()
+		switch instruction.GetOpcode() {
+		case "clipboard":
+			files[args[0]] = false
+		case "file":
+			files[args[0]] = true
+			status := "0"
+			if args[2] == "refused.txt" {
+				status = "771"
+			}
+			if args[2] != "ignored.txt" {
+				socket.serve([]string{"ack", args[0], "OK", status})
+			}
+		case "blob":
+			if files[args[0]] {
+				socket.serve([]string{"ack", args[0], "OK", "0"})
+			}
+		}
+	}
+	client := NewGuacamoleClient(socket)
+	ran := make(chan exp.ExceptionInterface)
+	go func() { ran <- client.Run() }()
+
+	// Files are sent a blob at a time, each acknowledged
+	data := strings.Repeat("x", BLOB_SIZE+10)
+	if err := client.SendFile("text/plain", "a.txt", []byte(data)); err != nil {
+		t.Fatal(err.GetMessage())
+	}
+	if err := client.SendFile("text/plain", "refused.txt", []byte(data)); err == nil ||
+		err.GetStatus() != exp.CLIENT_FORBIDDEN {
+		t.Errorf("err = %v", err)
+	}
+	if err := client.SendClipboard("text/plain", []byte("hi")); err != nil {
+		t.Fatal(err.GetMessage())
+	}
+	client.SendKey(0xffe3, true)
+	client.SendMouse(10, 20, MOUSE_LEFT|MOUSE_RIGHT)
+	client.SendText("a\n")
+	client.SendSize(1024, 768)
+	checkSent(t, socket.sentInstructions(),
+		"file 0 text/plain a.txt",
+		"blob 0 "+encode(data[:BLOB_SIZE]),
+		"blob 0 "+encode(data[BLOB_SIZE:]),
+		"end 0",
+		"file 0 text/plain refused.txt",
+		"clipboard 0 text/plain",
+		"blob 0 "+encode("hi"),
+		"end 0",
+		"key 65507 1",
+		"mouse 10 20 5",
+		"key 97 1",
+		"key 97 0",
+		"key 65293 1",
+		"key 65293 0",
+		"size 1024 768",
+	)
+
+	// Disconnecting ends Run and any wait for an acknowledgement
+	sent := make(chan exp.ExceptionInterface)
+	go func() { sent <- client.SendFile("text/plain", "ignored.txt", []byte(data)) }()
+	for deadline := time.Now().Add(5 * time.Second); len(socket.sentInstructions()) < 16 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	client.Disconnect()
+	client.Disconnect()
+	for _, one := range []chan exp.ExceptionInterface{ran, sent} {
+		select {
+		case err := <-one:
+			if one == ran && err != nil {
+				t.Errorf("Run returned %v", err)
+			}
+			if one == sent && err == nil {
+				t.Errorf("unacknowledged file sent")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Disconnect did not end the connection")
+		}
+	}
+	if sent := socket.sentInstructions(); sent[len(sent)-1] != "disconnect" {
+		t.Errorf("last sent %v", sent[len(sent)-1])
+	}
+}
@@ -0,0 +1,257 @@
+package gclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gservlet"
+)
+
+// HTTPTunnelSocket ==> GuacamoleSocket
+//  * Provides socket-like access to a Guacamole connection through the HTTP
+//  * tunnel of a tunnel server, such as GuacamoleHTTPTunnelServlet, in the
+//  * way of Guacamole.HTTPTunnel of the JavaScript client: one "connect"
+//  * request, long-polling "read" requests, and one "write" request per
+//  * write.
+type HTTPTunnelSocket struct {
+	client *http.Client
+	header http.Header
+	url    string
+	uuid   string
+
+	reader *tunnelReader
+	writer *tunnelWriter
+
+	/**
+	 * The body of the read response being received, and the number of
+	 * read requests sent so far.
+	 */
+	response *http.Response
+	counter  int
+
+	/**
+	 * Whether the read response being received has ended with the
+	 * internal end-of-response instruction, in which case the end of its
+	 * body is not the end of the tunnel.
+	 */
+	ended bool
+
+	/**
+	 * Cancels every request in progress once the socket is closed.
+	 */
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closeOnce sync.Once
+}
+
+/*NewHTTPTunnelSocket *
+ * Opens a tunnel through the HTTP tunnel at the given URL, using the
+ * default HTTP client.
+ *
+ * @param ctx The context of the "connect" request.
+ * @param tunnelURL The URL of the HTTP tunnel, such as
+ *                  "http://localhost:8080/tunnel".
+ * @param connectData The data given to the "connect" request, such as
+ *                    "GUAC_ID=box&GUAC_WIDTH=1024".
+ * @throws GuacamoleException If the tunnel cannot be opened, with the
+ *                            status reported by the tunnel server.
+ */
+func NewHTTPTunnelSocket(ctx context.Context, tunnelURL, connectData string) (ret *HTTPTunnelSocket, err exp.ExceptionInterface) {
+	return NewHTTPTunnelSocket2(ctx, http.DefaultClient, nil, tunnelURL, connectData)
+}
+
+/*NewHTTPTunnelSocket2 *
+ * Opens a tunnel as NewHTTPTunnelSocket does, sending every request with
+ * the given client and additional headers, such as those authenticating
+ * the owner of the tunnel.
+ *
+ * @param ctx The context of the "connect" request.
+ * @param client The HTTP client sending every request.
+ * @param header Headers added to every request, or nil.
+ * @param tunnelURL The URL of the HTTP tunnel.
+ * @param connectData The data given to the "connect" request.
+ * @throws GuacamoleException If the tunnel cannot be opened, with the
+ *                            status reported by the tunnel server.
+ */
+func NewHTTPTunnelSocket2(ctx context.Context, client *http.Client, header http.Header,
+	tunnelURL, connectData string) (ret *HTTPTunnelSocket, err exp.ExceptionInterface) {
+	ret = &HTTPTunnelSocket{
+		client: client,
+		header: header,
+		url:    tunnelURL,
+	}
+	ret.ctx, ret.cancel = context.WithCancel(context.Background())
+	ret.reader = newTunnelReader(ret.receive)
+	ret.reader.internal = func(args []string) {
+		if len(args) == 0 {
+			ret.ended = true
+		}
+	}
+	ret.writer = &tunnelWriter{send: ret.send}
+
+	body, err := ret.request(ctx, gservlet.CONNECT_OPERATION, "application/x-www-form-urlencoded; charset=UTF-8",
+		[]byte(connectData))
+	if err != nil {
+		ret.cancel()
+		return nil, err
+	}
+	uuid, e := ioutil.ReadAll(body.Body)
+	body.Body.Close()
+	if e != nil {
+		ret.cancel()
+		return nil, exp.GuacamoleServerException.Wrap(e, "Unable to read tunnel UUID.")
+	}
+	ret.uuid = string(uuid)
+	return
+}
+
+/**
+ * Sends one request of the given operation, returning the response once
+ * its status is known to be successful.
+ */
+func (opt *HTTPTunnelSocket) request(ctx context.Context, query, contentType string,
+	data []byte) (ret *http.Response, err exp.ExceptionInterface) {
+	method, body := http.MethodGet, io.Reader(nil)
+	if data != nil {
+		method, body = http.MethodPost, bytes.NewReader(data)
+	}
+	request, e := http.NewRequest(method, opt.url+"?"+query, body)
+	if e != nil {
+		return nil, exp.GuacamoleClientException.Wrap(e)
+	}
+	request = request.WithContext(ctx)
+	for name, values := range opt.header {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+	if len(contentType) > 0 {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	ret, e = opt.client.Do(request)
+	if e != nil {
+		if opt.ctx.Err() != nil {
+			return nil, exp.GuacamoleConnectionClosedException.Throw("Tunnel is closed.")
+		}
+		return nil, exp.GuacamoleUpstreamUnavailableException.Wrap(e, "Unable to reach the tunnel server.")
+	}
+	if ret.StatusCode != http.StatusOK {
+		defer ret.Body.Close()
+		return nil, responseException(ret)
+	}
+	return
+}
+
+/**
+ * Returns the exception reported by the given unsuccessful response of
+ * the tunnel server.
+ */
+func responseException(response *http.Response) exp.ExceptionInterface {
+	message := response.Header.Get("Guacamole-Error-Message")
+	if len(message) == 0 {
+		message = response.Status
+	}
+	code, e := strconv.Atoi(response.Header.Get("Guacamole-Status-Code"))
+	if e != nil {
+		code = exp.SERVER_ERROR.GetGuacamoleStatusCode()
+	}
+	return statusException(code, message)
+}
+
+func (opt *HTTPTunnelSocket) read() (*http.Response, exp.ExceptionInterface) {
+	query := gservlet.READ_OPERATION + ":" + opt.uuid + ":" + strconv.Itoa(opt.counter)
+	opt.counter++
+	return opt.request(opt.ctx, query, "", nil)
+}
+
+/**
+ * Receives the next chunk of the read responses, sending the next read
+ * request whenever one ends.
+ */
+func (opt *HTTPTunnelSocket) receive() (ret []byte, err exp.ExceptionInterface) {
+	if opt.response == nil {
+		if opt.response, err = opt.read(); err != nil {
+			return
+		}
+	}
+
+	buffer := make([]byte, 8192)
+	for {
+		n, e := opt.response.Body.Read(buffer)
+		if n > 0 {
+			return buffer[:n], nil
+		}
+		if e == nil {
+			continue
+		}
+		opt.response.Body.Close()
+		opt.response = nil
+		if e != io.EOF {
+			return nil, exp.GuacamoleConnectionClosedException.Wrap(e, "Tunnel is closed.")
+		}
+
+		// A response ending without the end-of-response instruction is
+		// the end of the tunnel
+		if !opt.ended {
+			return nil, exp.GuacamoleConnectionClosedException.Throw("Tunnel is closed.")
+		}
+		opt.ended = false
+		if opt.response, err = opt.read(); err != nil {
+			return
+		}
+	}
+}
+
+func (opt *HTTPTunnelSocket) send(data []byte) exp.ExceptionInterface {
+	response, err := opt.request(opt.ctx, gservlet.WRITE_OPERATION+":"+opt.uuid, "application/octet-stream", data)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+	return nil
+}
+
+// GetUUID Returns the UUID given to the tunnel by the tunnel server.
+func (opt *HTTPTunnelSocket) GetUUID() string {
+	return opt.uuid
+}
+
+// GetReader override GuacamoleSocket.GetReader
+func (opt *HTTPTunnelSocket) GetReader() gio.GuacamoleReader {
+	return opt.reader
+}
+
+// GetWriter override GuacamoleSocket.GetWriter
+func (opt *HTTPTunnelSocket) GetWriter() gio.GuacamoleWriter {
+	return opt.writer
+}
+
+// Close override GuacamoleSocket.Close
+//  * Asks the tunnel server to close the tunnel, then abandons any request
+//  * in progress.
+func (opt *HTTPTunnelSocket) Close() (err exp.ExceptionInterface) {
+	opt.closeOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(opt.ctx, closeTimeout)
+		defer cancel()
+		if response, e := opt.request(ctx, gservlet.CLOSE_OPERATION+":"+opt.uuid, "", nil); e == nil {
+			response.Body.Close()
+		}
+		opt.cancel()
+	})
+	return
+}
+
+// IsOpen override GuacamoleSocket.IsOpen
+func (opt *HTTPTunnelSocket) IsOpen() bool {
+	return opt.ctx.Err() == nil
+}
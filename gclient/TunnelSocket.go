@@ -0,0 +1,135 @@
+package gclient
+
+import (
+	"bytes"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * The opcode of instructions used internally by tunnels, such as the UUID
+ * sent first over the WebSocket tunnel and the instruction ending each
+ * HTTP tunnel read response. They are never given to the client.
+ */
+const internalDataOpcode = ""
+
+/**
+ * The time given to the tunnel server to acknowledge the closing of a
+ * tunnel before the connection is abandoned.
+ */
+const closeTimeout = 5 * time.Second
+
+/**
+ * A GuacamoleReader over the chunks of instruction data received by a
+ * tunnel, which may split instructions at any point. Internal instructions
+ * of the tunnel are given to the internal function rather than returned.
+ */
+type tunnelReader struct {
+	/**
+	 * Blocks until the next chunk of data is received from the tunnel.
+	 */
+	receive func() ([]byte, exp.ExceptionInterface)
+
+	/**
+	 * Called with the arguments of every internal instruction, if set.
+	 */
+	internal func(args []string)
+
+	parser       gprotocol.GuacamoleStreamParser
+	instructions []gprotocol.GuacamoleInstruction
+}
+
+func newTunnelReader(receive func() ([]byte, exp.ExceptionInterface)) *tunnelReader {
+	return &tunnelReader{
+		receive: receive,
+		parser:  gprotocol.NewGuacamoleStreamParser(),
+	}
+}
+
+/**
+ * Receives data until at least one instruction is complete.
+ */
+func (opt *tunnelReader) fill() (err exp.ExceptionInterface) {
+	for len(opt.instructions) == 0 {
+		chunk, err := opt.receive()
+		if err != nil {
+			return err
+		}
+		instructions, err := opt.parser.Append(chunk)
+		if err != nil {
+			return err
+		}
+		for _, instruction := range instructions {
+			if instruction.GetOpcode() == internalDataOpcode {
+				if opt.internal != nil {
+					opt.internal(instruction.GetArgs())
+				}
+				continue
+			}
+			opt.instructions = append(opt.instructions, instruction)
+		}
+	}
+	return
+}
+
+// Available override GuacamoleReader.Available
+func (opt *tunnelReader) Available() (ok bool, err exp.ExceptionInterface) {
+	return len(opt.instructions) > 0, nil
+}
+
+// Read override GuacamoleReader.Read
+func (opt *tunnelReader) Read() (ret []byte, err exp.ExceptionInterface) {
+	if err = opt.fill(); err != nil {
+		return
+	}
+	var buffer bytes.Buffer
+	for _, instruction := range opt.instructions {
+		buffer.WriteString(instruction.String())
+	}
+	opt.instructions = opt.instructions[:0]
+	return buffer.Bytes(), nil
+}
+
+// ReadInstruction override GuacamoleReader.ReadInstruction
+func (opt *tunnelReader) ReadInstruction() (ret gprotocol.GuacamoleInstruction, err exp.ExceptionInterface) {
+	if err = opt.fill(); err != nil {
+		return
+	}
+	ret = opt.instructions[0]
+	opt.instructions = opt.instructions[1:]
+	return
+}
+
+/**
+ * A GuacamoleWriter sending instruction data through a tunnel, one chunk
+ * per write.
+ */
+type tunnelWriter struct {
+	send func(data []byte) exp.ExceptionInterface
+}
+
+// Write override GuacamoleWriter.Write
+func (opt *tunnelWriter) Write(chunk []byte, off, length int) (err exp.ExceptionInterface) {
+	return opt.send(chunk[off : off+length])
+}
+
+// WriteAll override GuacamoleWriter.WriteAll
+func (opt *tunnelWriter) WriteAll(chunk []byte) (err exp.ExceptionInterface) {
+	return opt.send(chunk)
+}
+
+// WriteInstruction override GuacamoleWriter.WriteInstruction
+func (opt *tunnelWriter) WriteInstruction(instruction gprotocol.GuacamoleInstruction) (err exp.ExceptionInterface) {
+	return opt.send([]byte(instruction.String()))
+}
+
+/**
+ * Returns the exception for the given Guacamole status code and message,
+ * as reported by the server in an "error" or "ack" instruction or in the
+ * headers of an HTTP tunnel response.
+ */
+func statusException(code int, message string) exp.ExceptionInterface {
+	return exp.ExceptionKindFromStatus(exp.FromGuacamoleStatusCode(code)).Throw(message)
+}
@@ -0,0 +1,194 @@
+package gclient
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	"github.com/hsfish/guacamole_client_go/gwebsocket"
+)
+
+// WebSocketTunnelSocket ==> GuacamoleSocket
+//  * Provides socket-like access to a Guacamole connection through the
+//  * WebSocket tunnel of a tunnel server, such as
+//  * GuacamoleWebSocketTunnelEndpoint, in the way of
+//  * Guacamole.WebSocketTunnel of the JavaScript client.
+type WebSocketTunnelSocket struct {
+	conn *websocket.Conn
+	uuid string
+
+	reader *tunnelReader
+	writer *tunnelWriter
+
+	/**
+	 * Serializes the messages sent to the tunnel server.
+	 */
+	writeLock sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+/*NewWebSocketTunnelSocket *
+ * Opens a tunnel through the WebSocket tunnel at the given URL, using the
+ * default dialer.
+ *
+ * @param ctx The context of the WebSocket handshake.
+ * @param tunnelURL The URL of the WebSocket tunnel, such as
+ *                  "ws://localhost:8080/websocket-tunnel".
+ * @param connectData The data given to the tunnel as its query string,
+ *                    such as "GUAC_ID=box&GUAC_WIDTH=1024".
+ * @throws GuacamoleException If the tunnel cannot be opened, with the
+ *                            status reported by the tunnel server.
+ */
+func NewWebSocketTunnelSocket(ctx context.Context, tunnelURL, connectData string) (ret *WebSocketTunnelSocket, err exp.ExceptionInterface) {
+	return NewWebSocketTunnelSocket2(ctx, websocket.DefaultDialer, nil, tunnelURL, connectData)
+}
+
+/*NewWebSocketTunnelSocket2 *
+ * Opens a tunnel as NewWebSocketTunnelSocket does, with the given dialer
+ * and additional headers for the WebSocket handshake.
+ *
+ * @param ctx The context of the WebSocket handshake.
+ * @param dialer The dialer opening the WebSocket connection.
+ * @param header Headers added to the handshake request, or nil.
+ * @param tunnelURL The URL of the WebSocket tunnel.
+ * @param connectData The data given to the tunnel as its query string.
+ * @throws GuacamoleException If the tunnel cannot be opened, with the
+ *                            status reported by the tunnel server.
+ */
+func NewWebSocketTunnelSocket2(ctx context.Context, dialer *websocket.Dialer, header http.Header,
+	tunnelURL, connectData string) (ret *WebSocketTunnelSocket, err exp.ExceptionInterface) {
+	one := *dialer
+	one.Subprotocols = []string{gwebsocket.GUACAMOLE_PROTOCOL}
+	if len(connectData) > 0 {
+		tunnelURL += "?" + connectData
+	}
+
+	conn, response, e := one.DialContext(ctx, tunnelURL, header)
+	if e != nil {
+		if response != nil && response.StatusCode != http.StatusSwitchingProtocols {
+			return nil, responseException(response)
+		}
+		return nil, exp.GuacamoleUpstreamUnavailableException.Wrap(e, "Unable to reach the tunnel server.")
+	}
+
+	ret = &WebSocketTunnelSocket{conn: conn, closed: make(chan struct{})}
+	ret.reader = newTunnelReader(ret.receive)
+	ret.writer = &tunnelWriter{send: ret.send}
+
+	// The first instruction gives the UUID of the tunnel, unless the
+	// connection failed
+	var instruction gprotocol.GuacamoleInstruction
+	ret.reader.internal = func(args []string) {
+		if len(args) > 0 && len(ret.uuid) == 0 {
+			ret.uuid = args[0]
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	for len(ret.uuid) == 0 {
+		chunk, err := ret.receive()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		instructions, err := ret.reader.parser.Append(chunk)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		for len(instructions) > 0 && len(ret.uuid) == 0 {
+			instruction, instructions = instructions[0], instructions[1:]
+			if instruction.GetOpcode() == "error" {
+				conn.Close()
+				return nil, gprotocol.ErrorInstructionException(instruction)
+			}
+			if instruction.GetOpcode() == internalDataOpcode {
+				ret.reader.internal(instruction.GetArgs())
+			}
+		}
+		ret.reader.instructions = append(ret.reader.instructions, instructions...)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// Later internal instructions are the replies to pings
+	ret.reader.internal = nil
+	return
+}
+
+/**
+ * Receives the next message sent by the tunnel server.
+ */
+func (opt *WebSocketTunnelSocket) receive() ([]byte, exp.ExceptionInterface) {
+	for {
+		kind, message, e := opt.conn.ReadMessage()
+		if e != nil {
+			if closeError, ok := e.(*websocket.CloseError); ok {
+				code, _ := strconv.Atoi(closeError.Text)
+				if code != exp.SUCCESS.GetGuacamoleStatusCode() {
+					return nil, statusException(code, "Tunnel closed by the tunnel server.")
+				}
+			}
+			return nil, exp.GuacamoleConnectionClosedException.Wrap(e, "Tunnel is closed.")
+		}
+		if kind == websocket.TextMessage {
+			return message, nil
+		}
+	}
+}
+
+func (opt *WebSocketTunnelSocket) send(data []byte) exp.ExceptionInterface {
+	opt.writeLock.Lock()
+	defer opt.writeLock.Unlock()
+	if e := opt.conn.WriteMessage(websocket.TextMessage, data); e != nil {
+		return exp.GuacamoleConnectionClosedException.Wrap(e, "Tunnel is closed.")
+	}
+	return nil
+}
+
+// GetUUID Returns the UUID given to the tunnel by the tunnel server.
+func (opt *WebSocketTunnelSocket) GetUUID() string {
+	return opt.uuid
+}
+
+// GetReader override GuacamoleSocket.GetReader
+func (opt *WebSocketTunnelSocket) GetReader() gio.GuacamoleReader {
+	return opt.reader
+}
+
+// GetWriter override GuacamoleSocket.GetWriter
+func (opt *WebSocketTunnelSocket) GetWriter() gio.GuacamoleWriter {
+	return opt.writer
+}
+
+// Close override GuacamoleSocket.Close
+//  * Closes the WebSocket connection normally, which closes the tunnel.
+func (opt *WebSocketTunnelSocket) Close() (err exp.ExceptionInterface) {
+	opt.closeOnce.Do(func() {
+		close(opt.closed)
+		opt.writeLock.Lock()
+		message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		opt.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeTimeout))
+		opt.writeLock.Unlock()
+		opt.conn.Close()
+	})
+	return
+}
+
+// IsOpen override GuacamoleSocket.IsOpen
+func (opt *WebSocketTunnelSocket) IsOpen() bool {
+	select {
+	case <-opt.closed:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,111 @@
+package gcluster
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+/**
+ * The tunnel UUIDs accepted as file names.
+ */
+var uuidPattern = regexp.MustCompile(`^[0-9A-Fa-f-]{36}$`)
+
+// FileTunnelRegistry ==> TunnelRegistry
+//  * TunnelRegistry storing one small JSON file per tunnel in a directory
+//  * shared by every node, such as an NFS mount. Each file is written to a
+//  * temporary name and renamed into place, so readers never see partial
+//  * entries and no locking is needed.
+type FileTunnelRegistry struct {
+	dir string
+}
+
+/*NewFileTunnelRegistry *
+ * Creates a FileTunnelRegistry storing its entries in the given directory,
+ * which is created if necessary.
+ *
+ * @param dir The shared directory.
+ * @throws GuacamoleException If the directory cannot be created.
+ */
+func NewFileTunnelRegistry(dir string) (ret *FileTunnelRegistry, err exp.ExceptionInterface) {
+	if e := os.MkdirAll(dir, 0700); e != nil {
+		err = exp.GuacamoleServerException.Wrap(e, "Unable to create tunnel registry directory.")
+		return
+	}
+	ret = &FileTunnelRegistry{dir: dir}
+	return
+}
+
+func (opt *FileTunnelRegistry) path(uuid string) (string, exp.ExceptionInterface) {
+	if !uuidPattern.MatchString(uuid) {
+		return "", exp.GuacamoleClientException.Throw("Invalid tunnel UUID.")
+	}
+	return filepath.Join(opt.dir, uuid+".json"), nil
+}
+
+// Register override TunnelRegistry.Register
+func (opt *FileTunnelRegistry) Register(uuid string, node NodeInfo) (err exp.ExceptionInterface) {
+	path, err := opt.path(uuid)
+	if err != nil {
+		return
+	}
+	data, e := json.Marshal(node)
+	if e != nil {
+		return exp.GuacamoleServerException.Wrap(e)
+	}
+
+	temp, e := ioutil.TempFile(opt.dir, ".tunnel-")
+	if e != nil {
+		return exp.GuacamoleServerException.Wrap(e, "Unable to write tunnel registry entry.")
+	}
+	_, e = temp.Write(data)
+	if ce := temp.Close(); e == nil {
+		e = ce
+	}
+	if e == nil {
+		e = os.Rename(temp.Name(), path)
+	}
+	if e != nil {
+		os.Remove(temp.Name())
+		return exp.GuacamoleServerException.Wrap(e, "Unable to write tunnel registry entry.")
+	}
+	return
+}
+
+// Lookup override TunnelRegistry.Lookup
+func (opt *FileTunnelRegistry) Lookup(uuid string) (node NodeInfo, ok bool, err exp.ExceptionInterface) {
+	path, err := opt.path(uuid)
+	if err != nil {
+		return
+	}
+	data, e := ioutil.ReadFile(path)
+	if os.IsNotExist(e) {
+		return
+	}
+	if e != nil {
+		err = exp.GuacamoleServerException.Wrap(e, "Unable to read tunnel registry entry.")
+		return
+	}
+	if e = json.Unmarshal(data, &node); e != nil {
+		err = exp.GuacamoleServerException.Wrap(e, "Damaged tunnel registry entry.")
+		return
+	}
+	ok = true
+	return
+}
+
+// Unregister override TunnelRegistry.Unregister
+func (opt *FileTunnelRegistry) Unregister(uuid string) (err exp.ExceptionInterface) {
+	path, err := opt.path(uuid)
+	if err != nil {
+		return
+	}
+	if e := os.Remove(path); e != nil && !os.IsNotExist(e) {
+		err = exp.GuacamoleServerException.Wrap(e, "Unable to remove tunnel registry entry.")
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package gcluster
+
+import (
+	"sync"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+// NodeInfo *
+//  * A server node hosting tunnels.
+type NodeInfo struct {
+	/**
+	 * The unique identifier of the node.
+	 */
+	ID string `json:"id"`
+
+	/**
+	 * The URL of the node's HTTP tunnel endpoint, to which requests for
+	 * its tunnels are forwarded, such as "http://10.0.0.5:8080/tunnel".
+	 */
+	Address string `json:"address"`
+}
+
+// TunnelRegistry *
+//  * Records which node owns each tunnel, so that requests for a tunnel
+//  * reaching any node can be forwarded to its owner.
+type TunnelRegistry interface {
+	// Records that the tunnel having the given UUID is owned by the given node.
+	Register(uuid string, node NodeInfo) exp.ExceptionInterface
+
+	// Returns the owner of the tunnel having the given UUID, and whether it is known.
+	Lookup(uuid string) (NodeInfo, bool, exp.ExceptionInterface)
+
+	// Forgets the tunnel having the given UUID.
+	Unregister(uuid string) exp.ExceptionInterface
+}
+
+// MemoryTunnelRegistry ==> TunnelRegistry
+//  * TunnelRegistry held in memory, shared by the servlets of one process.
+type MemoryTunnelRegistry struct {
+	owners map[string]NodeInfo
+	lock   sync.RWMutex
+}
+
+// NewMemoryTunnelRegistry Construct function
+func NewMemoryTunnelRegistry() (ret *MemoryTunnelRegistry) {
+	ret = &MemoryTunnelRegistry{owners: make(map[string]NodeInfo)}
+	return
+}
+
+// Register override TunnelRegistry.Register
+func (opt *MemoryTunnelRegistry) Register(uuid string, node NodeInfo) (err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	opt.owners[uuid] = node
+	opt.lock.Unlock()
+	return
+}
+
+// Lookup override TunnelRegistry.Lookup
+func (opt *MemoryTunnelRegistry) Lookup(uuid string) (node NodeInfo, ok bool, err exp.ExceptionInterface) {
+	opt.lock.RLock()
+	node, ok = opt.owners[uuid]
+	opt.lock.RUnlock()
+	return
+}
+
+// Unregister override TunnelRegistry.Unregister
+func (opt *MemoryTunnelRegistry) Unregister(uuid string) (err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	delete(opt.owners, uuid)
+	opt.lock.Unlock()
+	return
+}
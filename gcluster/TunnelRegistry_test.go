@@ -0,0 +1,84 @@
+package gcluster
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const testUUID = "0f8fad5b-d9cb-469f-a165-70867728950e"
+
+/**
+ * Checks that the given registry records, returns and forgets owners.
+ */
+func testRegistry(t *testing.T, registry TunnelRegistry) {
+	node := NodeInfo{ID: "node-1", Address: "http://node-1:8080/tunnel"}
+	if _, ok, err := registry.Lookup(testUUID); ok || err != nil {
+		t.Fatalf("unknown tunnel found, err %v", err)
+	}
+	if err := registry.Register(testUUID, node); err != nil {
+		t.Fatal(err)
+	}
+	if found, ok, err := registry.Lookup(testUUID); !ok || err != nil || found != node {
+		t.Errorf("Lookup = %+v, %v, %v", found, ok, err)
+	}
+
+	// Registering again moves the tunnel
+	moved := NodeInfo{ID: "node-2", Address: "http://node-2:8080/tunnel"}
+	if err := registry.Register(testUUID, moved); err != nil {
+		t.Fatal(err)
+	}
+	if found, _, _ := registry.Lookup(testUUID); found != moved {
+		t.Errorf("Lookup after move = %+v", found)
+	}
+
+	if err := registry.Unregister(testUUID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := registry.Lookup(testUUID); ok {
+		t.Errorf("unregistered tunnel found")
+	}
+	if err := registry.Unregister(testUUID); err != nil {
+		t.Errorf("second Unregister failed: %v", err)
+	}
+}
+
+func Test_MemoryTunnelRegistry(t *testing.T) {
+	testRegistry(t, NewMemoryTunnelRegistry())
+}
+
+func Test_FileTunnelRegistry(t *testing.T) {
+	dir, e := ioutil.TempDir("", "registry")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	registry, err := NewFileTunnelRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRegistry(t, registry)
+
+	// Entries written by one node are seen by the others
+	if err = registry.Register(testUUID, NodeInfo{ID: "node-1"}); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewFileTunnelRegistry(dir)
+	if found, ok, _ := other.Lookup(testUUID); !ok || found.ID != "node-1" {
+		t.Errorf("Lookup from other node = %+v, %v", found, ok)
+	}
+
+	// Names which could escape the directory are refused
+	for _, uuid := range []string{"", "../" + testUUID[3:], testUUID + "/x"} {
+		if err = registry.Register(uuid, NodeInfo{}); err == nil {
+			t.Errorf("Register(%q) succeeded", uuid)
+		}
+	}
+
+	// Damaged entries are reported
+	ioutil.WriteFile(dir+"/"+testUUID+".json", []byte("{"), 0600)
+	if _, _, err = registry.Lookup(testUUID); err == nil {
+		t.Errorf("damaged entry read")
+	}
+}
@@ -0,0 +1,51 @@
+package gdirectory
+
+import (
+	"sync"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gnet"
+)
+
+// ActiveTunnel ==> IdentifiedGuacamoleTunnel
+//  * A tunnel established through a Directory. Closing the tunnel releases
+//  * the slot it holds on its connection and balancing group, if any.
+type ActiveTunnel struct {
+	*gnet.IdentifiedGuacamoleTunnel
+
+	directory            *Directory
+	connectionIdentifier string
+	groupIdentifier      string
+	releaseOnce          sync.Once
+}
+
+func newActiveTunnel(directory *Directory, tunnel gnet.GuacamoleTunnel, connection Connection,
+	groupIdentifier, username, remoteAddress string) (ret *ActiveTunnel) {
+	ret = &ActiveTunnel{
+		IdentifiedGuacamoleTunnel: gnet.NewIdentifiedGuacamoleTunnel(tunnel, username, connection.name, remoteAddress),
+		directory:                 directory,
+		connectionIdentifier:      connection.identifier,
+		groupIdentifier:           groupIdentifier,
+	}
+	return
+}
+
+// GetConnectionIdentifier Returns the identifier of the connection in use.
+func (opt *ActiveTunnel) GetConnectionIdentifier() string {
+	return opt.connectionIdentifier
+}
+
+// GetConnectionGroupIdentifier Returns the identifier of the balancing group
+// the tunnel was established through, if any.
+func (opt *ActiveTunnel) GetConnectionGroupIdentifier() string {
+	return opt.groupIdentifier
+}
+
+// Close override GuacamoleTunnel.Close
+func (opt *ActiveTunnel) Close() (err exp.ExceptionInterface) {
+	err = opt.IdentifiedGuacamoleTunnel.Close()
+	opt.releaseOnce.Do(func() {
+		opt.directory.release(opt.connectionIdentifier, opt.groupIdentifier, opt.GetUsername())
+	})
+	return
+}
@@ -0,0 +1,116 @@
+package gdirectory
+
+import (
+	"strings"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gcatalog"
+	logger "github.com/sirupsen/logrus"
+)
+
+// BALANCING_GROUP_TYPE The catalog group type denoting a balancing group.
+const BALANCING_GROUP_TYPE = "balancing"
+
+/*NewDirectoryFromCatalog *
+ * Creates a new Directory holding every group and connection of the given
+ * catalog. Group identifiers are the group names along the path from the
+ * root, joined by "/". Connection identifiers are connection names.
+ * Concurrency limits are read from the group and connection attributes
+ * themselves and are not inherited. The directory is updated each time
+ * the catalog is reloaded, as Update does.
+ *
+ * @param catalog The catalog to import.
+ * @param factory The factory which establishes tunnels from configurations.
+ * @throws GuacamoleException If the catalog cannot be imported.
+ */
+func NewDirectoryFromCatalog(catalog *gcatalog.ConnectionCatalog, factory TunnelFactoryInterface) (ret *Directory, err exp.ExceptionInterface) {
+	ret = NewDirectory(factory)
+	definition := catalog.GetDefinition()
+	err = ret.importGroup(catalog, definition.GroupDefinition, ROOT_IDENTIFIER, nil)
+	if err != nil {
+		ret = nil
+		return
+	}
+	catalog.AddReloadListener(ret.reloadCatalog)
+	return
+}
+
+/*Update *
+ * Replaces every group and connection of the directory with those of the
+ * given catalog, imported as NewDirectoryFromCatalog does. Active tunnels
+ * keep their slots, still counted against the connection and group having
+ * the same identifiers, if any. On failure the directory is unchanged.
+ *
+ * @param catalog The catalog to import.
+ * @throws GuacamoleException If the catalog cannot be imported.
+ */
+func (opt *Directory) Update(catalog *gcatalog.ConnectionCatalog) (err exp.ExceptionInterface) {
+	updated := NewDirectory(opt.factory)
+	definition := catalog.GetDefinition()
+	err = updated.importGroup(catalog, definition.GroupDefinition, ROOT_IDENTIFIER, nil)
+	if err != nil {
+		return
+	}
+
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+
+	// Counters in use are kept even if their connection or group is gone,
+	// so that releasing the slots of its tunnels stays balanced should it
+	// come back
+	for identifier, usage := range opt.connectionUsage {
+		if _, ok := updated.connections[identifier]; ok || usage.total > 0 {
+			updated.connectionUsage[identifier] = usage
+		}
+	}
+	for identifier, usage := range opt.groupUsage {
+		if _, ok := updated.groups[identifier]; ok || usage.total > 0 {
+			updated.groupUsage[identifier] = usage
+		}
+	}
+	opt.connections, opt.groups = updated.connections, updated.groups
+	opt.connectionUsage, opt.groupUsage = updated.connectionUsage, updated.groupUsage
+	return
+}
+
+func (opt *Directory) reloadCatalog(catalog *gcatalog.ConnectionCatalog) {
+	if err := opt.Update(catalog); err != nil {
+		logger.Warnf("Keeping previous connection directory, update from reloaded catalog failed: %v", err.GetMessage())
+	}
+}
+
+func (opt *Directory) importGroup(catalog *gcatalog.ConnectionCatalog, group gcatalog.GroupDefinition,
+	identifier string, path []string) (err exp.ExceptionInterface) {
+
+	for _, definition := range group.Connections {
+		resolved, ok := catalog.GetConnection(definition.Name)
+		if !ok {
+			return exp.GuacamoleResourceNotFoundException.Throw("No such connection.", definition.Name)
+		}
+		err = opt.AddConnection(NewConnection(definition.Name, definition.Name, identifier,
+			resolved.Config, definition.Attributes))
+		if err != nil {
+			return
+		}
+	}
+
+	for _, child := range group.Groups {
+		childPath := append(append([]string{}, path...), child.Name)
+		childIdentifier := strings.Join(childPath, "/")
+
+		gtype := ORGANIZATIONAL
+		if strings.EqualFold(child.Type, BALANCING_GROUP_TYPE) {
+			gtype = BALANCING
+		}
+
+		err = opt.AddConnectionGroup(NewConnectionGroup(childIdentifier, child.Name, identifier, gtype, child.Attributes))
+		if err != nil {
+			return
+		}
+		err = opt.importGroup(catalog, child, childIdentifier, childPath)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
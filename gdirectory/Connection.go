@@ -0,0 +1,116 @@
+package gdirectory
+
+import (
+	"strconv"
+
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+const (
+	/*ROOT_IDENTIFIER *
+	 * The identifier of the root connection group, which contains every
+	 * connection and group not placed elsewhere.
+	 */
+	ROOT_IDENTIFIER = "ROOT"
+
+	/*MAX_CONNECTIONS_ATTRIBUTE *
+	 * The attribute holding the maximum number of concurrent tunnels to a
+	 * connection or group. Zero or absent means unlimited.
+	 */
+	MAX_CONNECTIONS_ATTRIBUTE = "max-connections"
+
+	/*MAX_CONNECTIONS_PER_USER_ATTRIBUTE *
+	 * The attribute holding the maximum number of concurrent tunnels any one
+	 * user may have to a connection or group. Zero or absent means unlimited.
+	 */
+	MAX_CONNECTIONS_PER_USER_ATTRIBUTE = "max-connections-per-user"
+)
+
+// Connection *
+//  * A named, configured connection within a Directory.
+type Connection struct {
+	/**
+	 * The unique identifier of the connection.
+	 */
+	identifier string
+
+	/**
+	 * The human-readable name of the connection.
+	 */
+	name string
+
+	/**
+	 * The identifier of the group containing this connection.
+	 */
+	parentIdentifier string
+
+	/**
+	 * The configuration used when connecting.
+	 */
+	config gprotocol.GuacamoleConfiguration
+
+	/**
+	 * Arbitrary attributes, including concurrency limits.
+	 */
+	attributes map[string]string
+}
+
+// NewConnection Construct function
+func NewConnection(identifier, name, parentIdentifier string,
+	config gprotocol.GuacamoleConfiguration, attributes map[string]string) (ret Connection) {
+	ret.identifier = identifier
+	ret.name = name
+	ret.parentIdentifier = parentIdentifier
+	if len(ret.parentIdentifier) == 0 {
+		ret.parentIdentifier = ROOT_IDENTIFIER
+	}
+	ret.config = config
+	ret.attributes = make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		ret.attributes[k] = v
+	}
+	return
+}
+
+// GetIdentifier Returns the unique identifier of the connection.
+func (opt *Connection) GetIdentifier() string {
+	return opt.identifier
+}
+
+// GetName Returns the human-readable name of the connection.
+func (opt *Connection) GetName() string {
+	return opt.name
+}
+
+// GetParentIdentifier Returns the identifier of the containing group.
+func (opt *Connection) GetParentIdentifier() string {
+	return opt.parentIdentifier
+}
+
+// GetConfiguration Returns a copy of the configuration of the connection.
+func (opt *Connection) GetConfiguration() gprotocol.GuacamoleConfiguration {
+	return gprotocol.NewGuacamoleConfiguration2(opt.config)
+}
+
+// GetAttributes Returns the attributes of the connection.
+func (opt *Connection) GetAttributes() map[string]string {
+	return opt.attributes
+}
+
+// GetMaxConnections Returns the concurrency limit of the connection, or 0.
+func (opt *Connection) GetMaxConnections() int {
+	return intAttribute(opt.attributes, MAX_CONNECTIONS_ATTRIBUTE)
+}
+
+// GetMaxConnectionsPerUser Returns the per-user concurrency limit, or 0.
+func (opt *Connection) GetMaxConnectionsPerUser() int {
+	return intAttribute(opt.attributes, MAX_CONNECTIONS_PER_USER_ATTRIBUTE)
+}
+
+func intAttribute(attributes map[string]string, name string) int {
+	value, e := strconv.Atoi(attributes[name])
+	if e != nil || value < 0 {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,109 @@
+package gdirectory
+
+// ConnectionGroupType All possible types of a connection group.
+type ConnectionGroupType int
+
+const (
+	/*ORGANIZATIONAL *
+	 * A group which only organizes connections and cannot be connected to.
+	 */
+	ORGANIZATIONAL ConnectionGroupType = iota
+
+	/*BALANCING *
+	 * A group which, when connected to, chooses the member connection with
+	 * the fewest active tunnels, failing over to the next member if the
+	 * remote desktop reports an upstream error.
+	 */
+	BALANCING
+)
+
+func (gtype ConnectionGroupType) String() (ret string) {
+	switch gtype {
+	case ORGANIZATIONAL:
+		ret = "ORGANIZATIONAL"
+	case BALANCING:
+		ret = "BALANCING"
+	}
+	return
+}
+
+// ConnectionGroup *
+//  * A node of the connection tree within a Directory.
+type ConnectionGroup struct {
+	/**
+	 * The unique identifier of the group.
+	 */
+	identifier string
+
+	/**
+	 * The human-readable name of the group.
+	 */
+	name string
+
+	/**
+	 * The identifier of the group containing this group.
+	 */
+	parentIdentifier string
+
+	/**
+	 * Whether the group is organizational or balancing.
+	 */
+	gtype ConnectionGroupType
+
+	/**
+	 * Arbitrary attributes, including concurrency limits.
+	 */
+	attributes map[string]string
+}
+
+// NewConnectionGroup Construct function
+func NewConnectionGroup(identifier, name, parentIdentifier string,
+	gtype ConnectionGroupType, attributes map[string]string) (ret ConnectionGroup) {
+	ret.identifier = identifier
+	ret.name = name
+	ret.parentIdentifier = parentIdentifier
+	if len(ret.parentIdentifier) == 0 && identifier != ROOT_IDENTIFIER {
+		ret.parentIdentifier = ROOT_IDENTIFIER
+	}
+	ret.gtype = gtype
+	ret.attributes = make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		ret.attributes[k] = v
+	}
+	return
+}
+
+// GetIdentifier Returns the unique identifier of the group.
+func (opt *ConnectionGroup) GetIdentifier() string {
+	return opt.identifier
+}
+
+// GetName Returns the human-readable name of the group.
+func (opt *ConnectionGroup) GetName() string {
+	return opt.name
+}
+
+// GetParentIdentifier Returns the identifier of the containing group.
+func (opt *ConnectionGroup) GetParentIdentifier() string {
+	return opt.parentIdentifier
+}
+
+// GetType Returns whether the group is organizational or balancing.
+func (opt *ConnectionGroup) GetType() ConnectionGroupType {
+	return opt.gtype
+}
+
+// GetAttributes Returns the attributes of the group.
+func (opt *ConnectionGroup) GetAttributes() map[string]string {
+	return opt.attributes
+}
+
+// GetMaxConnections Returns the concurrency limit of the group, or 0.
+func (opt *ConnectionGroup) GetMaxConnections() int {
+	return intAttribute(opt.attributes, MAX_CONNECTIONS_ATTRIBUTE)
+}
+
+// GetMaxConnectionsPerUser Returns the per-user concurrency limit, or 0.
+func (opt *ConnectionGroup) GetMaxConnectionsPerUser() int {
+	return intAttribute(opt.attributes, MAX_CONNECTIONS_PER_USER_ATTRIBUTE)
+}
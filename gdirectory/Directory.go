@@ -0,0 +1,398 @@
+package gdirectory
+
+import (
+	"sort"
+	"sync"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	logger "github.com/sirupsen/logrus"
+)
+
+// TunnelFactoryInterface Tool interface for Directory
+//  * Establishes a tunnel using the given configuration. Upstream failures
+//  * should be reported as GuacamoleUpstream*Exception kinds so that
+//  * balancing groups can fail over.
+type TunnelFactoryInterface func(config gprotocol.GuacamoleConfiguration) (gnet.GuacamoleTunnel, exp.ExceptionInterface)
+
+/**
+ * The number of active tunnels of a connection or group, in total and per
+ * user.
+ */
+type usageCounter struct {
+	total int
+	users map[string]int
+}
+
+func (opt *usageCounter) acquire(username string) {
+	opt.total++
+	opt.users[username]++
+}
+
+func (opt *usageCounter) release(username string) {
+	opt.total--
+	opt.users[username]--
+	if opt.users[username] <= 0 {
+		delete(opt.users, username)
+	}
+}
+
+// Directory *
+//  * A tree of connections and connection groups which tracks every active
+//  * tunnel, enforcing the concurrency limits of each connection and group.
+type Directory struct {
+	connections map[string]*Connection
+	groups      map[string]*ConnectionGroup
+
+	/**
+	 * Active tunnel counts, indexed by connection or group identifier.
+	 */
+	connectionUsage map[string]*usageCounter
+	groupUsage      map[string]*usageCounter
+
+	factory TunnelFactoryInterface
+	lock    sync.RWMutex
+}
+
+/*NewDirectory *
+ * Creates a new Directory containing only the root group. Tunnels are
+ * established with the given factory.
+ *
+ * @param factory The factory which establishes tunnels from configurations.
+ */
+func NewDirectory(factory TunnelFactoryInterface) (ret *Directory) {
+	ret = &Directory{
+		connections:     make(map[string]*Connection),
+		groups:          make(map[string]*ConnectionGroup),
+		connectionUsage: make(map[string]*usageCounter),
+		groupUsage:      make(map[string]*usageCounter),
+		factory:         factory,
+	}
+	root := NewConnectionGroup(ROOT_IDENTIFIER, ROOT_IDENTIFIER, "", ORGANIZATIONAL, nil)
+	ret.groups[ROOT_IDENTIFIER] = &root
+	ret.groupUsage[ROOT_IDENTIFIER] = &usageCounter{users: make(map[string]int)}
+	return
+}
+
+/*AddConnectionGroup *
+ * Adds the given group to the directory. Its parent must already exist.
+ *
+ * @throws GuacamoleResourceConflictException If the identifier is taken.
+ * @throws GuacamoleResourceNotFoundException If the parent does not exist.
+ */
+func (opt *Directory) AddConnectionGroup(group ConnectionGroup) (err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if _, ok := opt.groups[group.identifier]; ok {
+		return exp.GuacamoleResourceConflictException.Throw("Connection group already exists.", group.identifier)
+	}
+	if _, ok := opt.groups[group.parentIdentifier]; !ok {
+		return exp.GuacamoleResourceNotFoundException.Throw("No such parent connection group.", group.parentIdentifier)
+	}
+	opt.groups[group.identifier] = &group
+	opt.groupUsage[group.identifier] = &usageCounter{users: make(map[string]int)}
+	return
+}
+
+/*AddConnection *
+ * Adds the given connection to the directory. Its parent group must
+ * already exist.
+ *
+ * @throws GuacamoleResourceConflictException If the identifier is taken.
+ * @throws GuacamoleResourceNotFoundException If the parent does not exist.
+ */
+func (opt *Directory) AddConnection(connection Connection) (err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if _, ok := opt.connections[connection.identifier]; ok {
+		return exp.GuacamoleResourceConflictException.Throw("Connection already exists.", connection.identifier)
+	}
+	if _, ok := opt.groups[connection.parentIdentifier]; !ok {
+		return exp.GuacamoleResourceNotFoundException.Throw("No such parent connection group.", connection.parentIdentifier)
+	}
+	opt.connections[connection.identifier] = &connection
+	opt.connectionUsage[connection.identifier] = &usageCounter{users: make(map[string]int)}
+	return
+}
+
+// GetConnection Returns the connection having the given identifier.
+func (opt *Directory) GetConnection(identifier string) (ret Connection, ok bool) {
+	opt.lock.RLock()
+	defer opt.lock.RUnlock()
+	one, ok := opt.connections[identifier]
+	if ok {
+		ret = *one
+	}
+	return
+}
+
+// GetConnectionGroup Returns the group having the given identifier.
+func (opt *Directory) GetConnectionGroup(identifier string) (ret ConnectionGroup, ok bool) {
+	opt.lock.RLock()
+	defer opt.lock.RUnlock()
+	one, ok := opt.groups[identifier]
+	if ok {
+		ret = *one
+	}
+	return
+}
+
+// GetChildConnections Returns the sorted identifiers of the connections in a group.
+func (opt *Directory) GetChildConnections(groupIdentifier string) (ret []string) {
+	opt.lock.RLock()
+	defer opt.lock.RUnlock()
+	ret = make([]string, 0)
+	for id, connection := range opt.connections {
+		if connection.parentIdentifier == groupIdentifier {
+			ret = append(ret, id)
+		}
+	}
+	sort.Strings(ret)
+	return
+}
+
+// GetChildGroups Returns the sorted identifiers of the groups in a group.
+func (opt *Directory) GetChildGroups(groupIdentifier string) (ret []string) {
+	opt.lock.RLock()
+	defer opt.lock.RUnlock()
+	ret = make([]string, 0)
+	for id, group := range opt.groups {
+		if id != ROOT_IDENTIFIER && group.parentIdentifier == groupIdentifier {
+			ret = append(ret, id)
+		}
+	}
+	sort.Strings(ret)
+	return
+}
+
+// GetActiveConnections Returns the number of active tunnels to a connection.
+func (opt *Directory) GetActiveConnections(identifier string) int {
+	opt.lock.RLock()
+	defer opt.lock.RUnlock()
+	if usage, ok := opt.connectionUsage[identifier]; ok {
+		return usage.total
+	}
+	return 0
+}
+
+// GetActiveGroupConnections Returns the number of active tunnels through a group.
+func (opt *Directory) GetActiveGroupConnections(identifier string) int {
+	opt.lock.RLock()
+	defer opt.lock.RUnlock()
+	if usage, ok := opt.groupUsage[identifier]; ok {
+		return usage.total
+	}
+	return 0
+}
+
+/**
+ * Checks the concurrency limits of the given connection for the given user.
+ * The caller must hold the lock.
+ */
+func (opt *Directory) checkConnectionLocked(connection *Connection, username string) (err exp.ExceptionInterface) {
+	usage := opt.connectionUsage[connection.identifier]
+	if max := connection.GetMaxConnections(); max > 0 && usage.total >= max {
+		return exp.GuacamoleResourceConflictException.Throw("Cannot connect. This connection is in use.")
+	}
+	if max := connection.GetMaxConnectionsPerUser(); max > 0 && usage.users[username] >= max {
+		return exp.GuacamoleClientTooManyException.Throw("Cannot connect. Connection already in use by this user.")
+	}
+	return
+}
+
+/**
+ * Checks the concurrency limits of the given group for the given user.
+ * The caller must hold the lock.
+ */
+func (opt *Directory) checkGroupLocked(group *ConnectionGroup, username string) (err exp.ExceptionInterface) {
+	usage := opt.groupUsage[group.identifier]
+	if max := group.GetMaxConnections(); max > 0 && usage.total >= max {
+		return exp.GuacamoleResourceConflictException.Throw("Cannot connect. This connection group is in use.")
+	}
+	if max := group.GetMaxConnectionsPerUser(); max > 0 && usage.users[username] >= max {
+		return exp.GuacamoleClientTooManyException.Throw("Cannot connect. Connection group already in use by this user.")
+	}
+	return
+}
+
+func (opt *Directory) release(connectionIdentifier, groupIdentifier, username string) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if usage, ok := opt.connectionUsage[connectionIdentifier]; ok {
+		usage.release(username)
+	}
+	if usage, ok := opt.groupUsage[groupIdentifier]; ok && len(groupIdentifier) > 0 {
+		usage.release(username)
+	}
+}
+
+/**
+ * Establishes a tunnel to a connection whose slot is already reserved,
+ * releasing the slot if the tunnel cannot be established.
+ */
+func (opt *Directory) connectReserved(connection Connection, groupIdentifier, username,
+	remoteAddress string) (ret gnet.GuacamoleTunnel, err exp.ExceptionInterface) {
+
+	tunnel, err := opt.factory(connection.GetConfiguration())
+	if err == nil && tunnel == nil {
+		err = exp.GuacamoleServerException.Throw("No tunnel created.")
+	}
+	if err != nil {
+		opt.release(connection.identifier, groupIdentifier, username)
+		return
+	}
+
+	ret = newActiveTunnel(opt, tunnel, connection, groupIdentifier, username, remoteAddress)
+	return
+}
+
+/*Connect *
+ * Establishes a tunnel to the connection having the given identifier on
+ * behalf of the given user.
+ *
+ * @param identifier The identifier of the connection.
+ * @param username The name of the connecting user.
+ * @param remoteAddress The address of the connecting client.
+ * @return A tunnel which releases its slot when closed, and which
+ *         implements gnet.TunnelIdentityInterface.
+ * @throws GuacamoleResourceNotFoundException If no such connection exists.
+ * @throws GuacamoleResourceConflictException If the connection is at its
+ *                                            concurrency limit.
+ * @throws GuacamoleClientTooManyException If the user is at the per-user
+ *                                         limit of the connection.
+ */
+func (opt *Directory) Connect(identifier, username, remoteAddress string) (ret gnet.GuacamoleTunnel, err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	connection, ok := opt.connections[identifier]
+	if !ok {
+		opt.lock.Unlock()
+		err = exp.GuacamoleResourceNotFoundException.Throw("No such connection.", identifier)
+		return
+	}
+	err = opt.checkConnectionLocked(connection, username)
+	if err == nil {
+		opt.connectionUsage[identifier].acquire(username)
+	}
+	one := *connection
+	opt.lock.Unlock()
+	if err != nil {
+		return
+	}
+	return opt.connectReserved(one, "", username, remoteAddress)
+}
+
+/*ConnectGroup *
+ * Establishes a tunnel through the balancing group having the given
+ * identifier on behalf of the given user. Member connections are tried in
+ * order of increasing active tunnels, moving on to the next member whenever
+ * the remote desktop reports an upstream error.
+ *
+ * @param identifier The identifier of the balancing group.
+ * @param username The name of the connecting user.
+ * @param remoteAddress The address of the connecting client.
+ * @return A tunnel which releases its slot when closed.
+ * @throws GuacamoleResourceNotFoundException If no such group exists.
+ * @throws GuacamoleSecurityException If the group is organizational.
+ * @throws GuacamoleResourceConflictException If every member is busy.
+ * @throws GuacamoleClientTooManyException If the user is at a per-user limit.
+ */
+func (opt *Directory) ConnectGroup(identifier, username, remoteAddress string) (ret gnet.GuacamoleTunnel, err exp.ExceptionInterface) {
+	opt.lock.RLock()
+	group, ok := opt.groups[identifier]
+	opt.lock.RUnlock()
+	if !ok {
+		err = exp.GuacamoleResourceNotFoundException.Throw("No such connection group.", identifier)
+		return
+	}
+	if group.gtype != BALANCING {
+		err = exp.GuacamoleSecurityException.Throw("Permission denied.")
+		return
+	}
+
+	tried := make(map[string]bool)
+	var lastErr exp.ExceptionInterface
+
+	for {
+		opt.lock.Lock()
+
+		// The group may have been removed by an update meanwhile
+		if group, ok = opt.groups[identifier]; !ok {
+			opt.lock.Unlock()
+			err = exp.GuacamoleResourceNotFoundException.Throw("No such connection group.", identifier)
+			return
+		}
+
+		// Group-wide limits apply to every member alike
+		err = opt.checkGroupLocked(group, username)
+		if err != nil {
+			opt.lock.Unlock()
+			return
+		}
+
+		// Pick the least-used member which has not been tried yet
+		candidates := make([]*Connection, 0)
+		for _, connection := range opt.connections {
+			if connection.parentIdentifier == identifier && !tried[connection.identifier] {
+				candidates = append(candidates, connection)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			a := opt.connectionUsage[candidates[i].identifier].total
+			b := opt.connectionUsage[candidates[j].identifier].total
+			if a != b {
+				return a < b
+			}
+			return candidates[i].identifier < candidates[j].identifier
+		})
+
+		var chosen *Connection
+		for _, candidate := range candidates {
+			tried[candidate.identifier] = true
+			e := opt.checkConnectionLocked(candidate, username)
+			if e == nil {
+				chosen = candidate
+				break
+			}
+			// A per-user refusal explains the failure better than "busy"
+			if lastErr == nil || e.Kind() == exp.GuacamoleClientTooManyException {
+				lastErr = e
+			}
+		}
+
+		var one Connection
+		if chosen != nil {
+			opt.connectionUsage[chosen.identifier].acquire(username)
+			opt.groupUsage[identifier].acquire(username)
+			one = *chosen
+		}
+		opt.lock.Unlock()
+
+		if chosen == nil {
+			// Report the last upstream or per-user failure, if any
+			err = lastErr
+			if err == nil || err.Kind() == exp.GuacamoleResourceConflictException {
+				err = exp.GuacamoleResourceConflictException.Throw("Cannot connect. All connections are in use.")
+			}
+			return
+		}
+
+		ret, err = opt.connectReserved(one, identifier, username, remoteAddress)
+		if err == nil {
+			return
+		}
+
+		// Fail over only if the remote desktop itself is at fault
+		switch err.Kind() {
+		case exp.GuacamoleUpstreamException,
+			exp.GuacamoleUpstreamNotFoundException,
+			exp.GuacamoleUpstreamTimeoutException,
+			exp.GuacamoleUpstreamUnavailableException:
+			logger.Infof("Upstream error connecting to \"%v\" of balancing group \"%v\", trying next connection: %v",
+				one.identifier, identifier, err.GetMessage())
+			lastErr = err
+		default:
+			return
+		}
+	}
+}
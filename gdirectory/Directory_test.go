@@ -0,0 +1,368 @@
+package gdirectory
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gcatalog"
+	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * A socket which is never read or written, only closed.
+ */
+type testSocket struct {
+	closed int32
+}
+
+func (opt *testSocket) GetReader() gio.GuacamoleReader { return nil }
+func (opt *testSocket) GetWriter() gio.GuacamoleWriter { return nil }
+func (opt *testSocket) IsOpen() bool                   { return atomic.LoadInt32(&opt.closed) == 0 }
+
+func (opt *testSocket) Close() exp.ExceptionInterface {
+	atomic.StoreInt32(&opt.closed, 1)
+	return nil
+}
+
+/**
+ * A tunnel factory failing with the error given for the "hostname"
+ * parameter of each configuration, if any, and recording the hostnames it
+ * was asked for.
+ */
+type testFactory struct {
+	failures map[string]exp.ExceptionInterface
+	tried    []string
+	lock     sync.Mutex
+}
+
+func (opt *testFactory) connect(config gprotocol.GuacamoleConfiguration) (gnet.GuacamoleTunnel, exp.ExceptionInterface) {
+	hostname := config.GetParameter("hostname")
+	opt.lock.Lock()
+	opt.tried = append(opt.tried, hostname)
+	err := opt.failures[hostname]
+	opt.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return gnet.NewSimpleGuacamoleTunnel(&testSocket{}, config), nil
+}
+
+func testConnection(identifier, parent string, attributes map[string]string) Connection {
+	config := gprotocol.NewGuacamoleConfiguration()
+	config.SetProtocol("vnc")
+	config.SetParameter("hostname", identifier)
+	return NewConnection(identifier, identifier, parent, config, attributes)
+}
+
+/**
+ * Returns a directory holding the balancing group "pool" with members "a",
+ * "b" and "c", limited to one tunnel each.
+ */
+func newTestPool(t *testing.T, factory *testFactory, attributes map[string]string) *Directory {
+	t.Helper()
+	directory := NewDirectory(factory.connect)
+	if err := directory.AddConnectionGroup(NewConnectionGroup("pool", "pool", "", BALANCING, attributes)); err != nil {
+		t.Fatal(err)
+	}
+	for _, identifier := range []string{"a", "b", "c"} {
+		err := directory.AddConnection(testConnection(identifier, "pool", map[string]string{MAX_CONNECTIONS_ATTRIBUTE: "1"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+func Test_DirectoryFailover(t *testing.T) {
+	factory := &testFactory{failures: map[string]exp.ExceptionInterface{
+		"a": exp.GuacamoleUpstreamNotFoundException.Throw("No such host."),
+	}}
+	directory := newTestPool(t, factory, nil)
+
+	// Upstream errors move on to the next member, releasing the failed one
+	tunnel, err := directory.ConnectGroup("pool", "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := tunnel.(*ActiveTunnel)
+	if active.GetConnectionIdentifier() != "b" || active.GetConnectionGroupIdentifier() != "pool" {
+		t.Errorf("connected to %q of %q", active.GetConnectionIdentifier(), active.GetConnectionGroupIdentifier())
+	}
+	if directory.GetActiveConnections("a") != 0 || directory.GetActiveConnections("b") != 1 ||
+		directory.GetActiveGroupConnections("pool") != 1 {
+		t.Errorf("usage a=%v b=%v pool=%v", directory.GetActiveConnections("a"),
+			directory.GetActiveConnections("b"), directory.GetActiveGroupConnections("pool"))
+	}
+
+	// Other errors are reported as they are, without failing over
+	factory.failures["a"] = exp.GuacamoleSecurityException.Throw("Permission denied.")
+	factory.tried = nil
+	if _, err = directory.ConnectGroup("pool", "alice", "127.0.0.1"); !errors.Is(err, exp.GuacamoleSecurityException) {
+		t.Errorf("err = %v", err)
+	}
+	if len(factory.tried) != 1 || directory.GetActiveConnections("a") != 0 || directory.GetActiveGroupConnections("pool") != 1 {
+		t.Errorf("tried %v, usage a=%v pool=%v", factory.tried, directory.GetActiveConnections("a"),
+			directory.GetActiveGroupConnections("pool"))
+	}
+
+	// Once every member failed or is busy, the last upstream error is
+	// reported
+	factory.failures["a"] = exp.GuacamoleUpstreamNotFoundException.Throw("No such host.")
+	factory.failures["c"] = exp.GuacamoleUpstreamNotFoundException.Throw("No such host.")
+	if _, err = directory.ConnectGroup("pool", "alice", "127.0.0.1"); !errors.Is(err, exp.GuacamoleUpstreamNotFoundException) {
+		t.Errorf("err = %v", err)
+	}
+
+	tunnel.Close()
+	tunnel.Close()
+	if directory.GetActiveConnections("b") != 0 || directory.GetActiveGroupConnections("pool") != 0 {
+		t.Errorf("usage after close b=%v pool=%v", directory.GetActiveConnections("b"),
+			directory.GetActiveGroupConnections("pool"))
+	}
+}
+
+func Test_DirectoryConcurrentConnect(t *testing.T) {
+	directory := NewDirectory((&testFactory{}).connect)
+	err := directory.AddConnection(testConnection("desktop", "", map[string]string{
+		MAX_CONNECTIONS_ATTRIBUTE:          "5",
+		MAX_CONNECTIONS_PER_USER_ATTRIBUTE: "2",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Of many concurrent users, exactly as many as allowed connect
+	const users = 20
+	tunnels := make(chan gnet.GuacamoleTunnel, users)
+	refusals := make(chan exp.ExceptionInterface, users)
+	var wait sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wait.Add(1)
+		go func(username string) {
+			defer wait.Done()
+			if tunnel, err := directory.Connect("desktop", username, "127.0.0.1"); err != nil {
+				refusals <- err
+			} else {
+				tunnels <- tunnel
+			}
+		}(string(rune('a' + i)))
+	}
+	wait.Wait()
+	close(tunnels)
+	close(refusals)
+	if len(tunnels) != 5 || directory.GetActiveConnections("desktop") != 5 {
+		t.Errorf("%v tunnels, %v active", len(tunnels), directory.GetActiveConnections("desktop"))
+	}
+	for err := range refusals {
+		if !errors.Is(err, exp.GuacamoleResourceConflictException) {
+			t.Errorf("err = %v", err)
+		}
+	}
+	for tunnel := range tunnels {
+		tunnel.Close()
+	}
+	if active := directory.GetActiveConnections("desktop"); active != 0 {
+		t.Errorf("%v active after close", active)
+	}
+
+	// Each user is held to their own limit first
+	for i := 0; i < 2; i++ {
+		tunnel, err := directory.Connect("desktop", "alice", "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tunnel.Close()
+	}
+	if _, err = directory.Connect("desktop", "alice", "127.0.0.1"); !errors.Is(err, exp.GuacamoleClientTooManyException) {
+		t.Errorf("err = %v", err)
+	}
+	if _, err = directory.Connect("missing", "alice", "127.0.0.1"); !errors.Is(err, exp.GuacamoleResourceNotFoundException) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func Test_DirectoryConcurrentConnectGroup(t *testing.T) {
+	directory := newTestPool(t, &testFactory{}, map[string]string{
+		MAX_CONNECTIONS_ATTRIBUTE:          "2",
+		MAX_CONNECTIONS_PER_USER_ATTRIBUTE: "1",
+	})
+
+	// The group limit applies before those of its members
+	const users = 10
+	var connected int32
+	var wait sync.WaitGroup
+	opened := make([]gnet.GuacamoleTunnel, users)
+	for i := 0; i < users; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			tunnel, err := directory.ConnectGroup("pool", string(rune('a'+i)), "127.0.0.1")
+			if err == nil {
+				atomic.AddInt32(&connected, 1)
+				opened[i] = tunnel
+			} else if !errors.Is(err, exp.GuacamoleResourceConflictException) {
+				t.Errorf("err = %v", err)
+			}
+		}(i)
+	}
+	wait.Wait()
+	if connected != 2 || directory.GetActiveGroupConnections("pool") != 2 {
+		t.Fatalf("%v connected, %v active", connected, directory.GetActiveGroupConnections("pool"))
+	}
+	owners := make([]string, 0, 2)
+	for i, tunnel := range opened {
+		if tunnel != nil {
+			owners = append(owners, string(rune('a'+i)))
+			defer tunnel.Close()
+		}
+	}
+
+	// Members are balanced
+	if directory.GetActiveConnections("a") != 1 || directory.GetActiveConnections("b") != 1 {
+		t.Errorf("usage a=%v b=%v", directory.GetActiveConnections("a"), directory.GetActiveConnections("b"))
+	}
+
+	// Below the group limit, the per-user group limit still applies
+	for i, tunnel := range opened {
+		if tunnel != nil && string(rune('a'+i)) == owners[1] {
+			tunnel.Close()
+		}
+	}
+	if _, err := directory.ConnectGroup("pool", owners[0], "127.0.0.1"); !errors.Is(err, exp.GuacamoleClientTooManyException) {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := directory.ConnectGroup(ROOT_IDENTIFIER, "alice", "127.0.0.1"); !errors.Is(err, exp.GuacamoleSecurityException) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+const testCatalog = `
+protocol: vnc
+groups:
+  - name: pool
+    type: balancing
+    attributes:
+      max-connections: "4"
+    connections:
+      - name: a
+        parameters:
+          hostname: a
+      - name: b
+        parameters:
+          hostname: b
+connections:
+  - name: desktop
+    parameters:
+      hostname: desktop
+    attributes:
+      max-connections: "1"
+`
+
+const testReloadedCatalog = `
+protocol: vnc
+groups:
+  - name: pool
+    type: balancing
+    connections:
+      - name: b
+        parameters:
+          hostname: b
+      - name: c
+        parameters:
+          hostname: c
+connections:
+  - name: desktop
+    parameters:
+      hostname: desktop
+    attributes:
+      max-connections: "2"
+`
+
+func Test_DirectoryCatalogReload(t *testing.T) {
+	dir, e := ioutil.TempDir("", "gdirectory")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "catalog.yaml")
+	if e = ioutil.WriteFile(path, []byte(testCatalog), 0600); e != nil {
+		t.Fatal(e)
+	}
+	catalog, err := gcatalog.LoadConnectionCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	directory, err := NewDirectoryFromCatalog(catalog, (&testFactory{}).connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desktop, err := directory.Connect("desktop", "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := directory.ConnectGroup("pool", "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identifier := member.(*ActiveTunnel).GetConnectionIdentifier(); identifier != "a" {
+		t.Fatalf("connected to %q", identifier)
+	}
+
+	// Reloading replaces the connections while active tunnels keep their
+	// slots
+	if e = ioutil.WriteFile(path, []byte(testReloadedCatalog), 0600); e != nil {
+		t.Fatal(e)
+	}
+	if err = catalog.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := directory.GetConnection("a"); ok {
+		t.Errorf("removed connection still present")
+	}
+	if connection, ok := directory.GetConnection("c"); !ok || connection.GetParentIdentifier() != "pool" {
+		t.Errorf("added connection = %v, %v", connection, ok)
+	}
+	if connection, _ := directory.GetConnection("desktop"); connection.GetMaxConnections() != 2 {
+		t.Errorf("max connections = %v", connection.GetMaxConnections())
+	}
+	if directory.GetActiveConnections("desktop") != 1 || directory.GetActiveGroupConnections("pool") != 1 {
+		t.Errorf("usage desktop=%v pool=%v", directory.GetActiveConnections("desktop"),
+			directory.GetActiveGroupConnections("pool"))
+	}
+	second, err := directory.Connect("desktop", "bob", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = directory.Connect("desktop", "carol", "127.0.0.1"); !errors.Is(err, exp.GuacamoleResourceConflictException) {
+		t.Errorf("err = %v", err)
+	}
+
+	// Tunnels to removed connections still release their slots
+	member.Close()
+	desktop.Close()
+	second.Close()
+	if directory.GetActiveConnections("a") != 0 || directory.GetActiveConnections("desktop") != 0 ||
+		directory.GetActiveGroupConnections("pool") != 0 {
+		t.Errorf("usage after close a=%v desktop=%v pool=%v", directory.GetActiveConnections("a"),
+			directory.GetActiveConnections("desktop"), directory.GetActiveGroupConnections("pool"))
+	}
+
+	// A catalog which no longer imports leaves the directory unchanged
+	if e = ioutil.WriteFile(path, []byte("connections: [{name: x}, {name: x}]"), 0600); e != nil {
+		t.Fatal(e)
+	}
+	catalog.Reload()
+	if _, ok := directory.GetConnection("c"); !ok {
+		t.Errorf("directory changed by a failed reload")
+	}
+}
@@ -0,0 +1,41 @@
+package gdirectory
+
+import (
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/*NewGuacdTunnelFactory *
+ * Returns a TunnelFactoryInterface which connects to the guacd instance
+ * listening at the given hostname and port, completes the handshake with
+ * the given client information, and watches the start of the session for
+ * upstream errors so that balancing groups can fail over.
+ *
+ * @param hostname The hostname of guacd.
+ * @param port The port of guacd.
+ * @param info The client information used during the handshake.
+ */
+func NewGuacdTunnelFactory(hostname string, port int, info gprotocol.GuacamoleClientInformation) TunnelFactoryInterface {
+	return func(config gprotocol.GuacamoleConfiguration) (ret gnet.GuacamoleTunnel, err exp.ExceptionInterface) {
+		inet, err := gnet.NewInetGuacamoleSocket(hostname, port)
+		if err != nil {
+			return
+		}
+
+		configured, err := gnet.NewConfiguredGuacamoleSocket3(&inet, config, info)
+		if err != nil {
+			inet.Close()
+			return
+		}
+
+		failover, err := gnet.NewFailoverGuacamoleSocket(&configured)
+		if err != nil {
+			configured.Close()
+			return
+		}
+
+		ret = gnet.NewSimpleGuacamoleTunnel(&failover, config)
+		return
+	}
+}
@@ -0,0 +1,177 @@
+package gdisplay
+
+import (
+	"image"
+)
+
+/**
+ * The channel masks of the Guacamole protocol, as Porter-Duff operators.
+ * Each bit selects a region: 0x8 source outside destination, 0x4 source
+ * inside destination, 0x2 destination outside source, 0x1 destination
+ * inside source.
+ */
+const (
+	ROUT  = 0x2
+	ATOP  = 0x6
+	XOR   = 0xA
+	ROVER = 0xB
+	OVER  = 0xE
+	PLUS  = 0xF
+	RIN   = 0x1
+	IN    = 0x4
+	OUT   = 0x8
+	RATOP = 0x9
+	SRC   = 0xC
+)
+
+/**
+ * The source of a drawing operation, returning the premultiplied color at
+ * the given destination coordinates.
+ */
+type source func(x, y int) (r, g, b, a uint32)
+
+/**
+ * Returns a source of the given straight color.
+ */
+func colorSource(r, g, b, a uint32) source {
+	r, g, b = r*a/255, g*a/255, b*a/255
+	return func(x, y int) (uint32, uint32, uint32, uint32) {
+		return r, g, b, a
+	}
+}
+
+/**
+ * Returns a source reading the given image, such that the destination
+ * point dp maps to the source point sp.
+ */
+func imageSource(img *image.RGBA, dp, sp image.Point) source {
+	dx, dy := sp.X-dp.X, sp.Y-dp.Y
+	return func(x, y int) (uint32, uint32, uint32, uint32) {
+		i := img.PixOffset(x+dx, y+dy)
+		p := img.Pix[i : i+4 : i+4]
+		return uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+	}
+}
+
+/**
+ * Returns a source repeating the given image from the origin of the
+ * destination, as used by "lfill" and "lstroke".
+ */
+func patternSource(img *image.RGBA) source {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if width == 0 || height == 0 {
+		return colorSource(0, 0, 0, 0)
+	}
+	return func(x, y int) (uint32, uint32, uint32, uint32) {
+		i := img.PixOffset(x%width, y%height)
+		p := img.Pix[i : i+4 : i+4]
+		return uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+	}
+}
+
+/**
+ * Draws the given source into the rectangle r of dst, which must lie
+ * within dst, using the given channel mask. If coverage is not nil, only
+ * the pixels it covers, in destination coordinates, are drawn.
+ */
+func composite(dst *image.RGBA, r image.Rectangle, src source, coverage *image.Alpha, mask int) {
+	fastCopy := mask == SRC || mask == PLUS || mask == OVER
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := uint32(255)
+			if coverage != nil {
+				if c = uint32(coverage.AlphaAt(x, y).A); c == 0 {
+					continue
+				}
+			}
+
+			sr, sg, sb, sa := src(x, y)
+			i := dst.PixOffset(x, y)
+			d := dst.Pix[i : i+4 : i+4]
+
+			var rr, rg, rb, ra uint32
+			if fastCopy && (sa == 255 || mask == SRC) {
+				rr, rg, rb, ra = sr, sg, sb, sa
+			} else {
+				da := uint32(d[3])
+
+				// Factors of source and destination, out of 255
+				fs, fd := uint32(0), uint32(0)
+				if mask&IN != 0 {
+					fs += da
+				}
+				if mask&OUT != 0 {
+					fs += 255 - da
+				}
+				if mask&RIN != 0 && mask&IN == 0 {
+					fd += sa
+				}
+				if mask&ROUT != 0 {
+					fd += 255 - sa
+				}
+				rr = clamp((sr*fs + uint32(d[0])*fd) / 255)
+				rg = clamp((sg*fs + uint32(d[1])*fd) / 255)
+				rb = clamp((sb*fs + uint32(d[2])*fd) / 255)
+				ra = clamp((sa*fs + da*fd) / 255)
+			}
+
+			if c < 255 {
+				rr = (rr*c + uint32(d[0])*(255-c)) / 255
+				rg = (rg*c + uint32(d[1])*(255-c)) / 255
+				rb = (rb*c + uint32(d[2])*(255-c)) / 255
+				ra = (ra*c + uint32(d[3])*(255-c)) / 255
+			}
+			d[0], d[1], d[2], d[3] = uint8(rr), uint8(rg), uint8(rb), uint8(ra)
+		}
+	}
+}
+
+func clamp(value uint32) uint32 {
+	if value > 255 {
+		return 255
+	}
+	return value
+}
+
+/**
+ * Applies the given binary transfer function of the "transfer"
+ * instruction to each channel of the rectangle r of dst. Bit 0x1 of the
+ * function selects source and destination both set, 0x2 source only, 0x4
+ * destination only and 0x8 neither.
+ */
+func transfer(dst *image.RGBA, r image.Rectangle, src source, function int) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sr, sg, sb, sa := src(x, y)
+			i := dst.PixOffset(x, y)
+			d := dst.Pix[i : i+4 : i+4]
+			d[0] = transferByte(function, uint8(sr), d[0])
+			d[1] = transferByte(function, uint8(sg), d[1])
+			d[2] = transferByte(function, uint8(sb), d[2])
+			d[3] = transferByte(function, uint8(sa), d[3])
+
+			// Keep the pixel premultiplied
+			for n := 0; n < 3; n++ {
+				if d[n] > d[3] {
+					d[n] = d[3]
+				}
+			}
+		}
+	}
+}
+
+func transferByte(function int, s, d uint8) (ret uint8) {
+	if function&0x1 != 0 {
+		ret |= s & d
+	}
+	if function&0x2 != 0 {
+		ret |= s &^ d
+	}
+	if function&0x4 != 0 {
+		ret |= ^s & d
+	}
+	if function&0x8 != 0 {
+		ret |= ^s &^ d
+	}
+	return
+}
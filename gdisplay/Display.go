@@ -0,0 +1,463 @@
+package gdisplay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg" // decoders of "img" streams
+	_ "image/png"
+	"sort"
+	"strconv"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * An image stream opened by "img", whose data arrives in "blob"
+ * instructions and is drawn on "end".
+ */
+type imageStream struct {
+	mask     int
+	layer    int
+	mimetype string
+	x, y     int
+	data     bytes.Buffer
+}
+
+// Display *
+//  * A pure Go model of the Guacamole display, updated by the drawing
+//  * instructions sent by guacd, as the JavaScript client would be. It is
+//  * meant for offline processing of recordings: drawing is not
+//  * antialiased, and transforms and clipping are ignored.
+type Display struct {
+	/**
+	 * All layers and buffers, by index. Layer 0 always exists.
+	 */
+	layers map[int]*Layer
+
+	/**
+	 * The image streams in progress, by stream index.
+	 */
+	streams map[int]*imageStream
+
+	/**
+	 * The cursor image, its hotspot, and the last known mouse position.
+	 */
+	cursor             *image.RGBA
+	hotspotX, hotspotY int
+	mouseX, mouseY     int
+
+	/**
+	 * The number of images which could not be decoded, by mimetype.
+	 */
+	unsupportedTypes map[string]int
+}
+
+// NewDisplay Construct function
+func NewDisplay() (ret *Display) {
+	ret = &Display{
+		layers:           map[int]*Layer{0: newLayer(0)},
+		streams:          make(map[int]*imageStream),
+		unsupportedTypes: make(map[string]int),
+	}
+	return
+}
+
+/*GetLayer *
+ * Returns the layer or buffer of the given index, creating it if needed.
+ */
+func (opt *Display) GetLayer(index int) *Layer {
+	layer, ok := opt.layers[index]
+	if !ok {
+		layer = newLayer(index)
+		opt.layers[index] = layer
+	}
+	return layer
+}
+
+/*GetLayers *
+ * Returns all layers and buffers, by increasing index.
+ */
+func (opt *Display) GetLayers() (ret []*Layer) {
+	for _, layer := range opt.layers {
+		ret = append(ret, layer)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].index < ret[j].index })
+	return
+}
+
+// GetWidth Returns the width of the display, that of layer 0.
+func (opt *Display) GetWidth() int {
+	return opt.layers[0].GetWidth()
+}
+
+// GetHeight Returns the height of the display, that of layer 0.
+func (opt *Display) GetHeight() int {
+	return opt.layers[0].GetHeight()
+}
+
+/*GetCursor *
+ * Returns the cursor image, nil if none was set, its hotspot, and the
+ * last known mouse position.
+ */
+func (opt *Display) GetCursor() (cursor *image.RGBA, hotspotX, hotspotY, mouseX, mouseY int) {
+	return opt.cursor, opt.hotspotX, opt.hotspotY, opt.mouseX, opt.mouseY
+}
+
+/*GetUnsupportedTypes *
+ * Returns the number of images skipped by mimetype, for the formats which
+ * cannot be decoded, such as WebP.
+ */
+func (opt *Display) GetUnsupportedTypes() map[string]int {
+	return opt.unsupportedTypes
+}
+
+/*Handle *
+ * Updates the display with the given instruction. Instructions which do
+ * not affect the display are ignored.
+ *
+ * @param instruction The instruction received from guacd.
+ * @throws GuacamoleServerException If the arguments of the instruction are
+ *                                  invalid.
+ */
+func (opt *Display) Handle(instruction gprotocol.GuacamoleInstruction) exp.ExceptionInterface {
+	args := instruction.GetArgs()
+	switch instruction.GetOpcode() {
+	case "size":
+		v, err := ints(instruction, 3)
+		if err != nil {
+			return err
+		}
+		opt.GetLayer(v[0]).resize(v[1], v[2])
+
+	case "move":
+		v, err := ints(instruction, 5)
+		if err != nil {
+			return err
+		}
+		layer := opt.GetLayer(v[0])
+		layer.parent, layer.x, layer.y, layer.z = v[1], v[2], v[3], v[4]
+
+	case "shade":
+		v, err := ints(instruction, 2)
+		if err != nil {
+			return err
+		}
+		opt.GetLayer(v[0]).opacity = int(clamp(uint32(v[1])))
+
+	case "dispose":
+		v, err := ints(instruction, 1)
+		if err != nil {
+			return err
+		}
+		if v[0] != 0 {
+			delete(opt.layers, v[0])
+		}
+
+	case "rect":
+		v, err := floats(instruction, 5)
+		if err != nil {
+			return err
+		}
+		opt.GetLayer(int(v[0])).path.rect(v[1], v[2], v[3], v[4])
+
+	case "start":
+		v, err := floats(instruction, 3)
+		if err != nil {
+			return err
+		}
+		opt.GetLayer(int(v[0])).path.start(v[1], v[2])
+
+	case "line":
+		v, err := floats(instruction, 3)
+		if err != nil {
+			return err
+		}
+		opt.GetLayer(int(v[0])).path.line(v[1], v[2])
+
+	case "curve":
+		v, err := floats(instruction, 7)
+		if err != nil {
+			return err
+		}
+		opt.GetLayer(int(v[0])).path.curve(v[1], v[2], v[3], v[4], v[5], v[6])
+
+	case "arc":
+		v, err := floats(instruction, 7)
+		if err != nil {
+			return err
+		}
+		opt.GetLayer(int(v[0])).path.arc(v[1], v[2], v[3], v[4], v[5], v[6] != 0)
+
+	case "close":
+		v, err := ints(instruction, 1)
+		if err != nil {
+			return err
+		}
+		opt.GetLayer(v[0]).path.close()
+
+	case "cfill":
+		v, err := ints(instruction, 6)
+		if err != nil {
+			return err
+		}
+		src := colorSource(clamp(uint32(v[2])), clamp(uint32(v[3])), clamp(uint32(v[4])), clamp(uint32(v[5])))
+		opt.fill(opt.GetLayer(v[1]), v[0], src)
+
+	case "lfill":
+		v, err := ints(instruction, 3)
+		if err != nil {
+			return err
+		}
+		opt.fill(opt.GetLayer(v[1]), v[0], patternSource(opt.GetLayer(v[2]).image))
+
+	case "cstroke":
+		v, err := ints(instruction, 9)
+		if err != nil {
+			return err
+		}
+		src := colorSource(clamp(uint32(v[5])), clamp(uint32(v[6])), clamp(uint32(v[7])), clamp(uint32(v[8])))
+		opt.stroke(opt.GetLayer(v[1]), v[0], float64(v[4]), src)
+
+	case "lstroke":
+		v, err := ints(instruction, 6)
+		if err != nil {
+			return err
+		}
+		opt.stroke(opt.GetLayer(v[1]), v[0], float64(v[4]), patternSource(opt.GetLayer(v[5]).image))
+
+	case "copy", "transfer":
+		v, err := ints(instruction, 9)
+		if err != nil {
+			return err
+		}
+		opt.copy(instruction.GetOpcode() == "transfer", v[0], image.Rect(v[1], v[2], v[1]+v[3], v[2]+v[4]), v[5], v[6], image.Pt(v[7], v[8]))
+
+	case "cursor":
+		v, err := ints(instruction, 7)
+		if err != nil {
+			return err
+		}
+		src := opt.GetLayer(v[2]).image
+		r := image.Rect(v[3], v[4], v[3]+v[5], v[4]+v[6]).Intersect(src.Rect)
+		opt.cursor = image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(opt.cursor, opt.cursor.Rect, src, r.Min, draw.Src)
+		opt.hotspotX, opt.hotspotY = v[0], v[1]
+
+	case "mouse":
+		v, err := ints(instruction, 2)
+		if err != nil {
+			return err
+		}
+		opt.mouseX, opt.mouseY = v[0], v[1]
+
+	case "img":
+		if len(args) < 6 {
+			return invalid(instruction)
+		}
+		v, err := parseInts(instruction, []string{args[0], args[1], args[2], args[4], args[5]})
+		if err != nil {
+			return err
+		}
+		opt.streams[v[0]] = &imageStream{mask: v[1], layer: v[2], mimetype: args[3], x: v[3], y: v[4]}
+
+	case "blob":
+		if len(args) < 2 {
+			return invalid(instruction)
+		}
+		index, e := strconv.Atoi(args[0])
+		if e != nil {
+			return invalid(instruction)
+		}
+		if stream, ok := opt.streams[index]; ok {
+			data, e := base64.StdEncoding.DecodeString(args[1])
+			if e != nil {
+				return invalid(instruction)
+			}
+			stream.data.Write(data)
+		}
+
+	case "end":
+		v, err := ints(instruction, 1)
+		if err != nil {
+			return err
+		}
+		if stream, ok := opt.streams[v[0]]; ok {
+			delete(opt.streams, v[0])
+			opt.drawImage(stream.layer, stream.mask, stream.x, stream.y, stream.mimetype, stream.data.Bytes())
+		}
+
+	case "png", "jpeg":
+		// Images of protocol versions before 0.9.0
+		v, err := ints(instruction, 4)
+		if err != nil {
+			return err
+		}
+		if len(args) < 5 {
+			return invalid(instruction)
+		}
+		data, e := base64.StdEncoding.DecodeString(args[4])
+		if e != nil {
+			return invalid(instruction)
+		}
+		opt.drawImage(v[1], v[0], v[2], v[3], "image/"+instruction.GetOpcode(), data)
+	}
+	return nil
+}
+
+func (opt *Display) fill(layer *Layer, mask int, src source) {
+	layer.fit(pathBounds(layer.path.subpaths))
+	coverage := layer.path.fill(layer.image.Rect)
+	composite(layer.image, coverage.Rect, src, coverage, mask)
+	layer.path.drawn = true
+}
+
+func (opt *Display) stroke(layer *Layer, mask int, thickness float64, src source) {
+	coverage := layer.path.stroke(layer.image.Rect, thickness)
+	composite(layer.image, coverage.Rect, src, coverage, mask)
+	layer.path.drawn = true
+}
+
+/**
+ * Copies the rectangle r of the source layer to the destination layer at
+ * the given point, with the given channel mask or transfer function.
+ */
+func (opt *Display) copy(isTransfer bool, srcIndex int, r image.Rectangle, function int, dstIndex int, dp image.Point) {
+	src := opt.GetLayer(srcIndex).image
+	r = r.Intersect(src.Rect)
+	if r.Empty() {
+		return
+	}
+	dst := opt.GetLayer(dstIndex)
+	target := dst.clip(r.Add(dp.Sub(r.Min)))
+	if target.Empty() {
+		return
+	}
+	sp := r.Min.Add(target.Min.Sub(dp))
+
+	// Copy within the same layer may overlap
+	if srcIndex == dstIndex {
+		copied := image.NewRGBA(image.Rect(0, 0, target.Dx(), target.Dy()))
+		draw.Draw(copied, copied.Rect, src, sp, draw.Src)
+		src, sp = copied, image.Point{}
+	}
+
+	if isTransfer {
+		transfer(dst.image, target, imageSource(src, target.Min, sp), function)
+		return
+	}
+	composite(dst.image, target, imageSource(src, target.Min, sp), nil, function)
+}
+
+/**
+ * Decodes the given image data and draws it to the given layer.
+ */
+func (opt *Display) drawImage(index, mask, x, y int, mimetype string, data []byte) {
+	decoded, _, e := image.Decode(bytes.NewReader(data))
+	if e != nil {
+		opt.unsupportedTypes[mimetype]++
+		return
+	}
+	img := image.NewRGBA(image.Rect(0, 0, decoded.Bounds().Dx(), decoded.Bounds().Dy()))
+	draw.Draw(img, img.Rect, decoded, decoded.Bounds().Min, draw.Src)
+
+	layer := opt.GetLayer(index)
+	dp := image.Pt(x, y)
+	target := layer.clip(img.Rect.Add(dp))
+	if target.Empty() {
+		return
+	}
+	composite(layer.image, target, imageSource(img, target.Min, target.Min.Sub(dp)), nil, mask)
+}
+
+/*Flatten *
+ * Returns the display as seen by the user: layer 0 over black, with all
+ * visible layers composited over it, and the cursor if requested.
+ */
+func (opt *Display) Flatten(withCursor bool) *image.RGBA {
+	root := opt.layers[0]
+	ret := image.NewRGBA(root.image.Rect)
+	draw.Draw(ret, ret.Rect, image.Black, image.Point{}, draw.Src)
+	opt.flattenLayer(ret, root, image.Point{}, ret.Rect, 255)
+
+	if withCursor && opt.cursor != nil {
+		at := image.Pt(opt.mouseX-opt.hotspotX, opt.mouseY-opt.hotspotY)
+		draw.Draw(ret, opt.cursor.Rect.Add(at), opt.cursor, image.Point{}, draw.Over)
+	}
+	return ret
+}
+
+func (opt *Display) flattenLayer(dst *image.RGBA, layer *Layer, at image.Point, clip image.Rectangle, opacity int) {
+	bounds := layer.image.Rect.Add(at).Intersect(clip)
+	if layer.index == 0 {
+		draw.Draw(dst, bounds, layer.image, image.Point{}, draw.Over)
+	} else {
+		alpha := image.NewUniform(color.Alpha{A: uint8(opacity)})
+		draw.DrawMask(dst, bounds, layer.image, bounds.Min.Sub(at), alpha, image.Point{}, draw.Over)
+	}
+
+	var children []*Layer
+	for _, child := range opt.layers {
+		if child.index > 0 && child.parent == layer.index && child != layer {
+			children = append(children, child)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].z != children[j].z {
+			return children[i].z < children[j].z
+		}
+		return children[i].index < children[j].index
+	})
+	for _, child := range children {
+		opt.flattenLayer(dst, child, at.Add(image.Pt(child.x, child.y)), bounds, opacity*child.opacity/255)
+	}
+}
+
+/**
+ * Returns the first n arguments of the given instruction as integers.
+ */
+func ints(instruction gprotocol.GuacamoleInstruction, n int) ([]int, exp.ExceptionInterface) {
+	args := instruction.GetArgs()
+	if len(args) < n {
+		return nil, invalid(instruction)
+	}
+	return parseInts(instruction, args[:n])
+}
+
+func parseInts(instruction gprotocol.GuacamoleInstruction, args []string) ([]int, exp.ExceptionInterface) {
+	ret := make([]int, len(args))
+	for i := range ret {
+		value, e := strconv.Atoi(args[i])
+		if e != nil {
+			return nil, invalid(instruction)
+		}
+		ret[i] = value
+	}
+	return ret, nil
+}
+
+/**
+ * Returns the first n arguments of the given instruction as numbers.
+ */
+func floats(instruction gprotocol.GuacamoleInstruction, n int) ([]float64, exp.ExceptionInterface) {
+	args := instruction.GetArgs()
+	if len(args) < n {
+		return nil, invalid(instruction)
+	}
+	ret := make([]float64, n)
+	for i := range ret {
+		value, e := strconv.ParseFloat(args[i], 64)
+		if e != nil {
+			return nil, invalid(instruction)
+		}
+		ret[i] = value
+	}
+	return ret, nil
+}
+
+func invalid(instruction gprotocol.GuacamoleInstruction) exp.ExceptionInterface {
+	return exp.GuacamoleServerException.Throw("Invalid \"" + instruction.GetOpcode() + "\" instruction.")
+}
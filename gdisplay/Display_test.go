@@ -0,0 +1,263 @@
+package gdisplay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * Passes the given instructions, written as opcode followed by arguments,
+ * to the display.
+ */
+func handle(t *testing.T, display *Display, instructions ...[]string) {
+	t.Helper()
+	for _, one := range instructions {
+		if e := display.Handle(gprotocol.NewGuacamoleInstruction(one[0], one[1:]...)); e != nil {
+			t.Fatalf("%v: %v", one, e.GetMessage())
+		}
+	}
+}
+
+func checkPixel(t *testing.T, img *image.RGBA, x, y int, expected color.RGBA) {
+	t.Helper()
+	actual := img.RGBAAt(x, y)
+	near := func(a, b uint8) bool { return int(a)-int(b) <= 2 && int(b)-int(a) <= 2 }
+	if !near(actual.R, expected.R) || !near(actual.G, expected.G) || !near(actual.B, expected.B) ||
+		!near(actual.A, expected.A) {
+		t.Errorf("pixel (%v, %v) is %v, expected %v", x, y, actual, expected)
+	}
+}
+
+var (
+	red         = color.RGBA{R: 255, A: 255}
+	green       = color.RGBA{G: 255, A: 255}
+	blue        = color.RGBA{B: 255, A: 255}
+	yellow      = color.RGBA{R: 255, G: 255, A: 255}
+	white       = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	black       = color.RGBA{A: 255}
+	transparent = color.RGBA{}
+)
+
+func Test_DisplayComposite(t *testing.T) {
+	display := NewDisplay()
+	handle(t, display,
+		[]string{"size", "0", "8", "8"},
+		[]string{"rect", "0", "0", "0", "8", "8"},
+		[]string{"cfill", "12", "0", "255", "0", "0", "255"},
+		[]string{"rect", "0", "0", "0", "4", "4"},
+		[]string{"cfill", "14", "0", "0", "0", "255", "128"},
+	)
+	layer := display.GetLayer(0).GetImage()
+	if display.GetWidth() != 8 || display.GetHeight() != 8 {
+		t.Fatalf("size %vx%v", display.GetWidth(), display.GetHeight())
+	}
+
+	// Translucent colors are blended over, premultiplied
+	checkPixel(t, layer, 1, 1, color.RGBA{R: 127, B: 128, A: 255})
+	checkPixel(t, layer, 3, 3, color.RGBA{R: 127, B: 128, A: 255})
+	checkPixel(t, layer, 4, 4, red)
+	checkPixel(t, layer, 7, 7, red)
+
+	// Buffers grow to fit what is drawn, and IN only draws where the
+	// destination is opaque
+	handle(t, display,
+		[]string{"rect", "-1", "0", "0", "2", "2"},
+		[]string{"cfill", "4", "-1", "0", "255", "0", "255"},
+	)
+	buffer := display.GetLayer(-1)
+	if !buffer.IsBuffer() || buffer.GetWidth() != 2 || buffer.GetHeight() != 2 {
+		t.Fatalf("buffer %vx%v", buffer.GetWidth(), buffer.GetHeight())
+	}
+	checkPixel(t, buffer.GetImage(), 1, 1, transparent)
+	handle(t, display,
+		[]string{"cfill", "12", "-1", "0", "255", "0", "255"},
+		[]string{"cfill", "4", "-1", "0", "0", "255", "255"},
+	)
+	checkPixel(t, buffer.GetImage(), 1, 1, blue)
+
+	// Copies within a layer may overlap
+	handle(t, display,
+		[]string{"rect", "0", "5", "7", "1", "1"},
+		[]string{"cfill", "12", "0", "0", "255", "0", "255"},
+		[]string{"copy", "0", "5", "7", "2", "1", "12", "0", "6", "7"},
+	)
+	checkPixel(t, layer, 5, 7, green)
+	checkPixel(t, layer, 6, 7, green)
+	checkPixel(t, layer, 7, 7, red)
+	handle(t, display, []string{"copy", "-1", "0", "0", "2", "2", "12", "0", "-1", "-1"})
+	checkPixel(t, layer, 0, 0, blue)
+	checkPixel(t, layer, 1, 1, color.RGBA{R: 127, B: 128, A: 255})
+}
+
+func Test_DisplayTransfer(t *testing.T) {
+	display := NewDisplay()
+	handle(t, display,
+		[]string{"size", "0", "4", "1"},
+		[]string{"rect", "0", "0", "0", "1", "1"},
+		[]string{"cfill", "12", "0", "255", "255", "0", "255"},
+		[]string{"rect", "0", "1", "0", "1", "1"},
+		[]string{"cfill", "12", "0", "255", "0", "0", "255"},
+		[]string{"rect", "0", "2", "0", "1", "1"},
+		[]string{"cfill", "12", "0", "0", "0", "255", "255"},
+		[]string{"rect", "0", "3", "0", "1", "1"},
+		[]string{"cfill", "12", "0", "0", "0", "255", "255"},
+	)
+
+	// AND, OR, then XOR, which leaves no alpha and so no color
+	handle(t, display,
+		[]string{"transfer", "0", "0", "0", "1", "1", "1", "0", "1", "0"},
+		[]string{"transfer", "0", "0", "0", "1", "1", "7", "0", "2", "0"},
+		[]string{"transfer", "0", "3", "0", "1", "1", "6", "0", "3", "0"},
+	)
+	layer := display.GetLayer(0).GetImage()
+	checkPixel(t, layer, 0, 0, yellow)
+	checkPixel(t, layer, 1, 0, red)
+	checkPixel(t, layer, 2, 0, white)
+	checkPixel(t, layer, 3, 0, transparent)
+}
+
+func Test_DisplayFlatten(t *testing.T) {
+	display := NewDisplay()
+	handle(t, display,
+		[]string{"size", "0", "8", "8"},
+		[]string{"rect", "0", "0", "0", "4", "8"},
+		[]string{"cfill", "12", "0", "255", "0", "0", "255"},
+
+		// A half transparent green layer over the right half
+		[]string{"size", "1", "4", "8"},
+		[]string{"move", "1", "0", "4", "0", "0"},
+		[]string{"rect", "1", "0", "0", "4", "8"},
+		[]string{"cfill", "12", "1", "0", "255", "0", "255"},
+		[]string{"shade", "1", "128"},
+
+		// A blue layer within it, clipped to its bounds
+		[]string{"size", "2", "4", "4"},
+		[]string{"move", "2", "1", "2", "6", "1"},
+		[]string{"rect", "2", "0", "0", "4", "4"},
+		[]string{"cfill", "12", "2", "0", "0", "255", "255"},
+
+		// Below a yellow sibling of higher z
+		[]string{"size", "3", "2", "2"},
+		[]string{"move", "3", "1", "1", "5", "2"},
+		[]string{"rect", "3", "0", "0", "2", "2"},
+		[]string{"cfill", "12", "3", "255", "255", "0", "255"},
+	)
+	flat := display.Flatten(false)
+	if flat.Rect != image.Rect(0, 0, 8, 8) {
+		t.Fatalf("bounds %v", flat.Rect)
+	}
+	checkPixel(t, flat, 1, 1, red)
+	checkPixel(t, flat, 5, 1, color.RGBA{G: 128, A: 255})
+	checkPixel(t, flat, 4, 7, color.RGBA{G: 128, A: 255})
+	checkPixel(t, flat, 7, 7, color.RGBA{G: 64, B: 128, A: 255})
+	checkPixel(t, flat, 5, 5, color.RGBA{R: 128, G: 192, A: 255})
+	checkPixel(t, flat, 6, 6, color.RGBA{R: 128, G: 160, B: 64, A: 255})
+
+	// Disposed layers are no longer drawn, but layer 0 always stays
+	handle(t, display, []string{"dispose", "1"}, []string{"dispose", "0"})
+	flat = display.Flatten(false)
+	checkPixel(t, flat, 1, 1, red)
+	checkPixel(t, flat, 5, 1, black)
+	if len(display.GetLayers()) != 3 {
+		t.Errorf("%v layers left", len(display.GetLayers()))
+	}
+}
+
+func Test_DisplayImages(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	var data bytes.Buffer
+	if e := png.Encode(&data, img); e != nil {
+		t.Fatal(e)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data.Bytes())
+
+	// Image data arrives in blobs, and is drawn at the end of the stream
+	display := NewDisplay()
+	handle(t, display,
+		[]string{"size", "0", "4", "4"},
+		[]string{"img", "1", "14", "0", "image/png", "1", "1"},
+		[]string{"blob", "1", encoded[:8]},
+		[]string{"blob", "1", encoded[8:]},
+		[]string{"blob", "9", "AAAA"},
+	)
+	layer := display.GetLayer(0).GetImage()
+	checkPixel(t, layer, 1, 1, transparent)
+	handle(t, display, []string{"end", "1"})
+	checkPixel(t, layer, 0, 0, transparent)
+	checkPixel(t, layer, 1, 1, white)
+	checkPixel(t, layer, 2, 2, white)
+	checkPixel(t, layer, 3, 3, transparent)
+
+	// Images of protocol versions before 0.9.0 come in one instruction
+	handle(t, display, []string{"png", "12", "0", "-1", "2", encoded})
+	checkPixel(t, layer, 0, 1, transparent)
+	checkPixel(t, layer, 0, 2, white)
+	checkPixel(t, layer, 0, 3, white)
+
+	// Formats which cannot be decoded are counted
+	handle(t, display,
+		[]string{"img", "2", "14", "0", "image/webp", "0", "0"},
+		[]string{"blob", "2", "AAAA"},
+		[]string{"end", "2"},
+	)
+	if unsupported := display.GetUnsupportedTypes(); len(unsupported) != 1 || unsupported["image/webp"] != 1 {
+		t.Errorf("unsupported %v", unsupported)
+	}
+}
+
+func Test_DisplayCursor(t *testing.T) {
+	display := NewDisplay()
+	handle(t, display,
+		[]string{"size", "0", "8", "8"},
+		[]string{"rect", "-1", "0", "0", "2", "2"},
+		[]string{"cfill", "12", "-1", "255", "255", "255", "255"},
+		[]string{"cursor", "1", "1", "-1", "0", "0", "2", "2"},
+		[]string{"mouse", "5", "5"},
+	)
+	cursor, hotspotX, hotspotY, mouseX, mouseY := display.GetCursor()
+	if cursor == nil || cursor.Rect != image.Rect(0, 0, 2, 2) || hotspotX != 1 || hotspotY != 1 || mouseX != 5 || mouseY != 5 {
+		t.Fatalf("cursor %v at %v,%v, mouse %v,%v", cursor, hotspotX, hotspotY, mouseX, mouseY)
+	}
+
+	// The cursor is only drawn when requested, at its hotspot
+	checkPixel(t, display.Flatten(false), 4, 4, black)
+	flat := display.Flatten(true)
+	checkPixel(t, flat, 4, 4, white)
+	checkPixel(t, flat, 5, 5, white)
+	checkPixel(t, flat, 6, 6, black)
+	checkPixel(t, flat, 3, 3, black)
+}
+
+func Test_DisplayInvalid(t *testing.T) {
+	display := NewDisplay()
+	for _, one := range [][]string{
+		{"size", "0", "8"},
+		{"size", "0", "8", "high"},
+		{"rect", "0", "0", "0", "1", "wide"},
+		{"cfill", "12", "0", "255"},
+		{"copy", "0", "0", "0", "1", "1", "12", "0", "0"},
+		{"img", "1", "14", "0", "image/png", "0"},
+		{"blob", "1"},
+		{"png", "12", "0", "0", "0", "%%%"},
+	} {
+		if e := display.Handle(gprotocol.NewGuacamoleInstruction(one[0], one[1:]...)); e == nil {
+			t.Errorf("%v accepted", one)
+		}
+	}
+	handle(t, display, []string{"img", "1", "14", "0", "image/png", "0", "0"})
+	if e := display.Handle(gprotocol.NewGuacamoleInstruction("blob", "1", "%%%")); e == nil {
+		t.Errorf("invalid base64 accepted")
+	}
+
+	// Other instructions are ignored
+	handle(t, display, []string{"sync", "1000"}, []string{"key", "65307", "1"})
+}
@@ -0,0 +1,143 @@
+package gdisplay
+
+import (
+	"image"
+	"image/draw"
+)
+
+/*MAX_SIZE *
+ * The maximum width and height of a layer, beyond which the content is
+ * cut, so that a corrupt recording cannot exhaust memory.
+ */
+const MAX_SIZE = 16384
+
+// Layer *
+//  * A layer or buffer of a Display. Layers, of index 0 or more, are
+//  * visible and positioned relative to their parent. Buffers, of negative
+//  * index, are never visible and grow automatically to fit whatever is
+//  * drawn to them, as in the JavaScript client.
+type Layer struct {
+	/**
+	 * The index of the layer, as used by the Guacamole protocol.
+	 */
+	index int
+
+	/**
+	 * The content of the layer, premultiplied.
+	 */
+	image *image.RGBA
+
+	/**
+	 * The parent layer and position, as set by "move".
+	 */
+	parent  int
+	x, y, z int
+
+	/**
+	 * The opacity of the layer, from 0 to 255, as set by "shade".
+	 */
+	opacity int
+
+	/**
+	 * The path being built by path instructions.
+	 */
+	path path
+}
+
+func newLayer(index int) (ret *Layer) {
+	ret = &Layer{index: index, opacity: 255}
+	ret.image = image.NewRGBA(image.Rect(0, 0, 0, 0))
+	return
+}
+
+// GetIndex Returns the index of the layer.
+func (opt *Layer) GetIndex() int {
+	return opt.index
+}
+
+// GetImage Returns the current content of the layer.
+func (opt *Layer) GetImage() *image.RGBA {
+	return opt.image
+}
+
+// GetWidth Returns the width of the layer, in pixels.
+func (opt *Layer) GetWidth() int {
+	return opt.image.Rect.Dx()
+}
+
+// GetHeight Returns the height of the layer, in pixels.
+func (opt *Layer) GetHeight() int {
+	return opt.image.Rect.Dy()
+}
+
+// GetParent Returns the index of the parent of the layer.
+func (opt *Layer) GetParent() int {
+	return opt.parent
+}
+
+// GetPosition Returns the position of the layer within its parent, and its
+// stacking order among its siblings.
+func (opt *Layer) GetPosition() (x, y, z int) {
+	return opt.x, opt.y, opt.z
+}
+
+// GetOpacity Returns the opacity of the layer, from 0 to 255.
+func (opt *Layer) GetOpacity() int {
+	return opt.opacity
+}
+
+// IsBuffer Returns whether the layer is an offscreen buffer.
+func (opt *Layer) IsBuffer() bool {
+	return opt.index < 0
+}
+
+/**
+ * Resizes the layer, keeping the part of its content which still fits.
+ */
+func (opt *Layer) resize(width, height int) {
+	if width < 0 {
+		width = 0
+	}
+	if height < 0 {
+		height = 0
+	}
+	if width > MAX_SIZE {
+		width = MAX_SIZE
+	}
+	if height > MAX_SIZE {
+		height = MAX_SIZE
+	}
+	if width == opt.GetWidth() && height == opt.GetHeight() {
+		return
+	}
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(resized, resized.Rect, opt.image, image.Point{}, draw.Src)
+	opt.image = resized
+}
+
+/**
+ * Grows a buffer so that it contains the given rectangle. Visible layers
+ * keep their size.
+ */
+func (opt *Layer) fit(r image.Rectangle) {
+	if !opt.IsBuffer() || r.Empty() {
+		return
+	}
+	width, height := opt.GetWidth(), opt.GetHeight()
+	if r.Max.X > width {
+		width = r.Max.X
+	}
+	if r.Max.Y > height {
+		height = r.Max.Y
+	}
+	opt.resize(width, height)
+}
+
+/**
+ * Returns the given rectangle clipped to the bounds of the layer, growing
+ * buffers first.
+ */
+func (opt *Layer) clip(r image.Rectangle) image.Rectangle {
+	opt.fit(r)
+	return r.Intersect(opt.image.Rect)
+}
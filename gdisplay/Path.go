@@ -0,0 +1,247 @@
+package gdisplay
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+type point struct {
+	x, y float64
+}
+
+type subpath struct {
+	points []point
+	closed bool
+}
+
+/**
+ * The path of a layer, built by "rect", "start", "line", "curve", "arc"
+ * and "close", and drawn by the fill and stroke instructions. As in the
+ * JavaScript client, the path is kept after being drawn, so that it may be
+ * both filled and stroked, and a new path begins with the next path
+ * instruction.
+ */
+type path struct {
+	subpaths []subpath
+	drawn    bool
+}
+
+/**
+ * Discards the path if it was already drawn.
+ */
+func (opt *path) begin() {
+	if opt.drawn {
+		opt.subpaths = nil
+		opt.drawn = false
+	}
+}
+
+func (opt *path) current() *subpath {
+	if len(opt.subpaths) == 0 || opt.subpaths[len(opt.subpaths)-1].closed {
+		return nil
+	}
+	return &opt.subpaths[len(opt.subpaths)-1]
+}
+
+func (opt *path) rect(x, y, width, height float64) {
+	opt.begin()
+	opt.subpaths = append(opt.subpaths, subpath{
+		points: []point{{x, y}, {x + width, y}, {x + width, y + height}, {x, y + height}},
+		closed: true,
+	})
+}
+
+func (opt *path) start(x, y float64) {
+	opt.begin()
+	opt.subpaths = append(opt.subpaths, subpath{points: []point{{x, y}}})
+}
+
+func (opt *path) line(x, y float64) {
+	opt.begin()
+	current := opt.current()
+	if current == nil {
+		opt.subpaths = append(opt.subpaths, subpath{points: []point{{x, y}}})
+		return
+	}
+	current.points = append(current.points, point{x, y})
+}
+
+func (opt *path) curve(cp1x, cp1y, cp2x, cp2y, x, y float64) {
+	opt.begin()
+	current := opt.current()
+	if current == nil {
+		opt.start(cp1x, cp1y)
+		current = opt.current()
+	}
+	p0 := current.points[len(current.points)-1]
+	const steps = 16
+	for n := 1; n <= steps; n++ {
+		t := float64(n) / steps
+		u := 1 - t
+		current.points = append(current.points, point{
+			u*u*u*p0.x + 3*u*u*t*cp1x + 3*u*t*t*cp2x + t*t*t*x,
+			u*u*u*p0.y + 3*u*u*t*cp1y + 3*u*t*t*cp2y + t*t*t*y,
+		})
+	}
+}
+
+func (opt *path) arc(x, y, radius, startAngle, endAngle float64, negative bool) {
+	opt.begin()
+	sweep := endAngle - startAngle
+	if negative {
+		for sweep > 0 {
+			sweep -= 2 * math.Pi
+		}
+	} else {
+		for sweep < 0 {
+			sweep += 2 * math.Pi
+		}
+	}
+	if math.Abs(sweep) > 2*math.Pi {
+		sweep = math.Copysign(2*math.Pi, sweep)
+	}
+
+	steps := int(math.Ceil(math.Abs(sweep) * radius / 2))
+	if steps < 8 {
+		steps = 8
+	}
+	for n := 0; n <= steps; n++ {
+		angle := startAngle + sweep*float64(n)/float64(steps)
+		opt.line(x+radius*math.Cos(angle), y+radius*math.Sin(angle))
+	}
+}
+
+func (opt *path) close() {
+	opt.begin()
+	if current := opt.current(); current != nil {
+		current.closed = true
+	}
+}
+
+/**
+ * Returns the pixels covered by the path, filled with the nonzero winding
+ * rule, within the given bounds. The returned bounds of the coverage are
+ * empty if nothing is covered.
+ */
+func (opt *path) fill(bounds image.Rectangle) *image.Alpha {
+	return rasterize(opt.subpaths, bounds)
+}
+
+/**
+ * Returns the pixels covered by the outline of the path, drawn with the
+ * given thickness and square joins, within the given bounds.
+ */
+func (opt *path) stroke(bounds image.Rectangle, thickness float64) *image.Alpha {
+	half := thickness / 2
+	if half < 0.5 {
+		half = 0.5
+	}
+
+	var outline []subpath
+	square := func(p point) {
+		outline = append(outline, subpath{points: []point{
+			{p.x - half, p.y + half}, {p.x + half, p.y + half},
+			{p.x + half, p.y - half}, {p.x - half, p.y - half},
+		}})
+	}
+	for _, sub := range opt.subpaths {
+		points := sub.points
+		if sub.closed && len(points) > 1 {
+			points = append(points[:len(points):len(points)], points[0])
+		}
+		for n, p := range points {
+			square(p)
+			if n == 0 {
+				continue
+			}
+			q := points[n-1]
+			length := math.Hypot(p.x-q.x, p.y-q.y)
+			if length == 0 {
+				continue
+			}
+
+			// Normal of the segment, always on the same side so that every
+			// quad winds the same way
+			nx, ny := -(p.y-q.y)/length*half, (p.x-q.x)/length*half
+			outline = append(outline, subpath{points: []point{
+				{q.x + nx, q.y + ny}, {p.x + nx, p.y + ny},
+				{p.x - nx, p.y - ny}, {q.x - nx, q.y - ny},
+			}})
+		}
+	}
+	return rasterize(outline, bounds)
+}
+
+/**
+ * Fills the given subpaths, all considered closed, with the nonzero
+ * winding rule, sampling each pixel at its center.
+ */
+func rasterize(subpaths []subpath, bounds image.Rectangle) *image.Alpha {
+	box := pathBounds(subpaths).Intersect(bounds)
+	coverage := image.NewAlpha(box)
+	if box.Empty() {
+		return coverage
+	}
+
+	type crossing struct {
+		x   float64
+		dir int
+	}
+	crossings := make([]crossing, 0, 16)
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+		center := float64(y) + 0.5
+		crossings = crossings[:0]
+		for _, sub := range subpaths {
+			for n := range sub.points {
+				a, b := sub.points[n], sub.points[(n+1)%len(sub.points)]
+				switch {
+				case a.y <= center && b.y > center:
+					crossings = append(crossings, crossing{a.x + (center-a.y)*(b.x-a.x)/(b.y-a.y), 1})
+				case b.y <= center && a.y > center:
+					crossings = append(crossings, crossing{a.x + (center-a.y)*(b.x-a.x)/(b.y-a.y), -1})
+				}
+			}
+		}
+		sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+
+		winding := 0
+		for n := 0; n+1 < len(crossings); n++ {
+			winding += crossings[n].dir
+			if winding == 0 {
+				continue
+			}
+			from := int(math.Ceil(crossings[n].x - 0.5))
+			to := int(math.Ceil(crossings[n+1].x - 0.5))
+			if from < box.Min.X {
+				from = box.Min.X
+			}
+			if to > box.Max.X {
+				to = box.Max.X
+			}
+			for x := from; x < to; x++ {
+				coverage.Pix[coverage.PixOffset(x, y)] = 255
+			}
+		}
+	}
+	return coverage
+}
+
+/**
+ * Returns the smallest rectangle of whole pixels containing the given
+ * subpaths.
+ */
+func pathBounds(subpaths []subpath) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, sub := range subpaths {
+		for _, p := range sub.points {
+			minX, minY = math.Min(minX, p.x), math.Min(minY, p.y)
+			maxX, maxY = math.Max(maxX, p.x), math.Max(maxY, p.y)
+		}
+	}
+	if minX > maxX {
+		return image.Rectangle{}
+	}
+	return image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+}
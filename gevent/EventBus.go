@@ -0,0 +1,213 @@
+package gevent
+
+import (
+	"sync"
+
+	"github.com/hsfish/guacamole_client_go/gnet"
+	logger "github.com/sirupsen/logrus"
+)
+
+// EventListenerInterface Tool interface for EventBus
+//  * Receives the events published on an EventBus.
+type EventListenerInterface func(event TunnelEvent)
+
+// DEFAULT_QUEUE_SIZE The default number of events queued for an asynchronous listener.
+const DEFAULT_QUEUE_SIZE = 256
+
+/**
+ * One subscription to an EventBus.
+ */
+type subscriber struct {
+	id       int
+	listener EventListenerInterface
+	types    map[TunnelEventType]bool
+
+	/**
+	 * The queue of an asynchronous subscriber, or nil.
+	 */
+	queue chan TunnelEvent
+	done  chan struct{}
+}
+
+func (opt *subscriber) accepts(eventType TunnelEventType) bool {
+	return len(opt.types) == 0 || opt.types[eventType]
+}
+
+func (opt *subscriber) deliver(event TunnelEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Tunnel event listener panicked on \"%v\" event: %v", event.Type, r)
+		}
+	}()
+	opt.listener(event)
+}
+
+func (opt *subscriber) run() {
+	defer close(opt.done)
+	for event := range opt.queue {
+		opt.deliver(event)
+	}
+}
+
+// EventBus *
+//  * Delivers tunnel lifecycle events to any number of listeners. Synchronous
+//  * listeners run on the publishing goroutine, in subscription order, before
+//  * Publish returns. Asynchronous listeners each have their own goroutine
+//  * and queue; events are dropped, with a warning, if the queue is full.
+type EventBus struct {
+	subscribers []*subscriber
+	nextID      int
+	lock        sync.RWMutex
+}
+
+// NewEventBus Construct function
+func NewEventBus() (ret *EventBus) {
+	ret = &EventBus{subscribers: make([]*subscriber, 0, 4)}
+	return
+}
+
+func (opt *EventBus) add(one *subscriber) int {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	opt.nextID++
+	one.id = opt.nextID
+	opt.subscribers = append(opt.subscribers, one)
+	return one.id
+}
+
+func newTypeSet(types []TunnelEventType) map[TunnelEventType]bool {
+	set := make(map[TunnelEventType]bool, len(types))
+	for _, one := range types {
+		set[one] = true
+	}
+	return set
+}
+
+/*Subscribe *
+ * Adds a synchronous listener.
+ *
+ * @param listener The listener to add.
+ * @param types The event types to receive, or none to receive all.
+ * @return The ID of the subscription, for use with Unsubscribe.
+ */
+func (opt *EventBus) Subscribe(listener EventListenerInterface, types ...TunnelEventType) int {
+	return opt.add(&subscriber{listener: listener, types: newTypeSet(types)})
+}
+
+/*SubscribeAsync *
+ * Adds an asynchronous listener with a queue of DEFAULT_QUEUE_SIZE events.
+ *
+ * @param listener The listener to add.
+ * @param types The event types to receive, or none to receive all.
+ * @return The ID of the subscription, for use with Unsubscribe.
+ */
+func (opt *EventBus) SubscribeAsync(listener EventListenerInterface, types ...TunnelEventType) int {
+	return opt.SubscribeAsync2(listener, DEFAULT_QUEUE_SIZE, types...)
+}
+
+/*SubscribeAsync2 *
+ * Adds an asynchronous listener with a queue of the given size.
+ */
+func (opt *EventBus) SubscribeAsync2(listener EventListenerInterface, queueSize int, types ...TunnelEventType) int {
+	one := &subscriber{
+		listener: listener,
+		types:    newTypeSet(types),
+		queue:    make(chan TunnelEvent, queueSize),
+		done:     make(chan struct{}),
+	}
+	go one.run()
+	return opt.add(one)
+}
+
+/*Unsubscribe *
+ * Removes the subscription having the given ID. Events already queued for
+ * an asynchronous listener are still delivered.
+ */
+func (opt *EventBus) Unsubscribe(id int) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	for i, one := range opt.subscribers {
+		if one.id == id {
+			opt.subscribers = append(opt.subscribers[:i], opt.subscribers[i+1:]...)
+			if one.queue != nil {
+				close(one.queue)
+			}
+			return
+		}
+	}
+}
+
+/*Publish *
+ * Delivers the given event to every subscribed listener accepting its type.
+ */
+func (opt *EventBus) Publish(event TunnelEvent) {
+	if opt == nil {
+		return
+	}
+	// Queue for asynchronous listeners while subscribed, then deliver to
+	// synchronous listeners without holding the lock, so that they may
+	// subscribe or unsubscribe
+	opt.lock.RLock()
+	listeners := make([]*subscriber, 0, len(opt.subscribers))
+	for _, one := range opt.subscribers {
+		if !one.accepts(event.Type) {
+			continue
+		}
+		if one.queue == nil {
+			listeners = append(listeners, one)
+			continue
+		}
+		select {
+		case one.queue <- event:
+		default:
+			logger.Warnf("Dropped \"%v\" event for tunnel \"%v\": listener queue is full.", event.Type, event.TunnelUUID)
+		}
+	}
+	opt.lock.RUnlock()
+
+	for _, one := range listeners {
+		one.deliver(event)
+	}
+}
+
+/*Close *
+ * Removes every subscription, waiting for asynchronous listeners to finish
+ * the events already queued.
+ */
+func (opt *EventBus) Close() {
+	opt.lock.Lock()
+	subscribers := opt.subscribers
+	opt.subscribers = make([]*subscriber, 0, 4)
+	opt.lock.Unlock()
+
+	for _, one := range subscribers {
+		if one.queue != nil {
+			close(one.queue)
+			<-one.done
+		}
+	}
+}
+
+/*ConnectSuccAdapter *
+ * Returns a listener calling the given function, which matches
+ * gservlet.DoConnectSuccInterface, with the tunnel of every READY event.
+ */
+func ConnectSuccAdapter(doConnectSucc func(tunnel gnet.GuacamoleTunnel)) EventListenerInterface {
+	return func(event TunnelEvent) {
+		if event.Type == READY && event.Tunnel != nil {
+			doConnectSucc(event.Tunnel)
+		}
+	}
+}
+
+/*ConnectStopAdapter *
+ * Returns a listener calling the given function, which matches
+ * gservlet.DoConnectStopInterface, with the tunnel of every CLOSED event.
+ */
+func ConnectStopAdapter(doConnectStop func(tunnel gnet.GuacamoleTunnel)) EventListenerInterface {
+	return func(event TunnelEvent) {
+		if event.Type == CLOSED && event.Tunnel != nil {
+			doConnectStop(event.Tunnel)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package gevent
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * A socket which is never read or written.
+ */
+type testSocket struct{}
+
+func (testSocket) GetReader() gio.GuacamoleReader { return nil }
+func (testSocket) GetWriter() gio.GuacamoleWriter { return nil }
+func (testSocket) IsOpen() bool                   { return true }
+func (testSocket) Close() exp.ExceptionInterface  { return nil }
+
+/**
+ * Records the types of the events it receives.
+ */
+type testListener struct {
+	types []TunnelEventType
+	lock  sync.Mutex
+}
+
+func (opt *testListener) listen(event TunnelEvent) {
+	opt.lock.Lock()
+	opt.types = append(opt.types, event.Type)
+	opt.lock.Unlock()
+}
+
+func (opt *testListener) received() []TunnelEventType {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	return append([]TunnelEventType{}, opt.types...)
+}
+
+func checkTypes(t *testing.T, name string, actual []TunnelEventType, expected ...TunnelEventType) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Errorf("%v received %v, expected %v", name, actual, expected)
+		return
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("%v received %v, expected %v", name, actual, expected)
+			return
+		}
+	}
+}
+
+func Test_EventBusSync(t *testing.T) {
+	bus := NewEventBus()
+	defer bus.Close()
+	all, closed := &testListener{}, &testListener{}
+	order := make([]string, 0, 4)
+	bus.Subscribe(func(event TunnelEvent) { order = append(order, "first") })
+	bus.Subscribe(func(event TunnelEvent) { panic("listener failure") })
+	bus.Subscribe(all.listen)
+	bus.Subscribe(closed.listen, CLOSED, TIMED_OUT)
+	bus.Subscribe(func(event TunnelEvent) { order = append(order, "last") })
+
+	// Listeners run in order before Publish returns, despite others
+	// panicking
+	bus.Publish(NewTunnelEvent(READY, nil))
+	checkTypes(t, "all", all.received(), READY)
+	checkTypes(t, "closed", closed.received())
+	if len(order) != 2 || order[0] != "first" || order[1] != "last" {
+		t.Errorf("order %v", order)
+	}
+	bus.Publish(NewTunnelEvent(CLOSED, nil))
+	checkTypes(t, "all", all.received(), READY, CLOSED)
+	checkTypes(t, "closed", closed.received(), CLOSED)
+
+	// Listeners may unsubscribe themselves while being called
+	once := &testListener{}
+	var id int
+	id = bus.Subscribe(func(event TunnelEvent) {
+		once.listen(event)
+		bus.Unsubscribe(id)
+	})
+	bus.Publish(NewTunnelEvent(IDLE, nil))
+	bus.Publish(NewTunnelEvent(IDLE, nil))
+	checkTypes(t, "once", once.received(), IDLE)
+	bus.Unsubscribe(id)
+	bus.Unsubscribe(-1)
+
+	var missing *EventBus
+	missing.Publish(NewTunnelEvent(READY, nil))
+}
+
+func Test_EventBusAsync(t *testing.T) {
+	bus := NewEventBus()
+	async := &testListener{}
+	started, release := make(chan struct{}), make(chan struct{})
+	bus.SubscribeAsync2(func(event TunnelEvent) {
+		if event.Type == CONNECTING {
+			close(started)
+			<-release
+		}
+		async.listen(event)
+	}, 2)
+	filtered := &testListener{}
+	bus.SubscribeAsync(filtered.listen, CLOSED)
+
+	// Publishing does not wait for asynchronous listeners, whose full
+	// queues drop events
+	bus.Publish(NewTunnelEvent(CONNECTING, nil))
+	<-started
+	done := make(chan struct{})
+	go func() {
+		bus.Publish(NewTunnelEvent(HANDSHAKE_COMPLETE, nil))
+		bus.Publish(NewTunnelEvent(READY, nil))
+		bus.Publish(NewTunnelEvent(UPSTREAM_ERROR, nil))
+		bus.Publish(NewTunnelEvent(CLOSED, nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a busy listener")
+	}
+	close(release)
+
+	// Closing waits for the queued events
+	bus.Close()
+	checkTypes(t, "async", async.received(), CONNECTING, HANDSHAKE_COMPLETE, READY)
+	checkTypes(t, "filtered", filtered.received(), CLOSED)
+	bus.Publish(NewTunnelEvent(CLOSED, nil))
+	checkTypes(t, "filtered", filtered.received(), CLOSED)
+}
+
+func Test_EventBusUnsubscribeAsync(t *testing.T) {
+	bus := NewEventBus()
+	defer bus.Close()
+	async := &testListener{}
+	delivered := make(chan struct{}, 4)
+	release := make(chan struct{})
+	id := bus.SubscribeAsync(func(event TunnelEvent) {
+		<-release
+		async.listen(event)
+		delivered <- struct{}{}
+	})
+	bus.Publish(NewTunnelEvent(READY, nil))
+	bus.Publish(NewTunnelEvent(IDLE, nil))
+
+	// Events queued before unsubscribing are still delivered, later ones
+	// are not
+	bus.Unsubscribe(id)
+	bus.Publish(NewTunnelEvent(CLOSED, nil))
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("queued event not delivered")
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	checkTypes(t, "async", async.received(), READY, IDLE)
+}
+
+func Test_EventBusAdapters(t *testing.T) {
+	bus := NewEventBus()
+	defer bus.Close()
+	tunnel := gnet.NewSimpleGuacamoleTunnel(testSocket{}, gprotocol.NewGuacamoleConfiguration())
+	succeeded, stopped := make([]gnet.GuacamoleTunnel, 0, 1), make([]gnet.GuacamoleTunnel, 0, 1)
+	bus.Subscribe(ConnectSuccAdapter(func(one gnet.GuacamoleTunnel) { succeeded = append(succeeded, one) }))
+	bus.Subscribe(ConnectStopAdapter(func(one gnet.GuacamoleTunnel) { stopped = append(stopped, one) }))
+
+	// Only READY and CLOSED events concerning a tunnel are passed on
+	bus.Publish(NewTunnelEvent(READY, nil))
+	bus.Publish(NewTunnelEvent(CLOSED, nil))
+	bus.Publish(NewTunnelEvent(IDLE, tunnel))
+	bus.Publish(NewTunnelEvent(READY, tunnel))
+	bus.Publish(NewTunnelEvent(CLOSED, tunnel))
+	if len(succeeded) != 1 || succeeded[0] != tunnel || len(stopped) != 1 || stopped[0] != tunnel {
+		t.Errorf("succeeded %v, stopped %v", succeeded, stopped)
+	}
+
+	event := NewTunnelEvent(CLOSED, tunnel).WithError(exp.GuacamoleClientTimeoutException.Throw("Timed out."))
+	if event.TunnelUUID != tunnel.GetUUID().String() || event.Status != exp.CLIENT_TIMEOUT || event.Message != "Timed out." {
+		t.Errorf("event %+v", event)
+	}
+}
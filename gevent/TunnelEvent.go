@@ -0,0 +1,192 @@
+package gevent
+
+import (
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gnet"
+)
+
+// TunnelEventType All possible types of TunnelEvent.
+type TunnelEventType int
+
+const (
+	/*CONNECTING *
+	 * A connect request was received and a tunnel is being created.
+	 */
+	CONNECTING TunnelEventType = iota
+
+	/*HANDSHAKE_COMPLETE *
+	 * The tunnel was created and the Guacamole protocol handshake with guacd
+	 * completed.
+	 */
+	HANDSHAKE_COMPLETE
+
+	/*READY *
+	 * The tunnel was registered and may be read and written. ConnectionID
+	 * holds the ID sent by guacd in its "ready" instruction.
+	 */
+	READY
+
+	/*UPSTREAM_ERROR *
+	 * The tunnel could not be created, or guacd sent an "error"
+	 * instruction.
+	 */
+	UPSTREAM_ERROR
+
+	/*IDLE *
+	 * The tunnel has not been accessed for a while, but has not yet timed
+	 * out.
+	 */
+	IDLE
+
+	/*TIMED_OUT *
+	 * The tunnel was closed because it was not accessed in time, or because
+	 * it reached a limit of its session policy. Always followed by CLOSED.
+	 */
+	TIMED_OUT
+
+	/*CLOSED *
+	 * The tunnel was deregistered. Published exactly once per tunnel, with
+	 * Reason and Status describing why.
+	 */
+	CLOSED
+
+	/*READER_ERROR *
+	 * Reading from the tunnel and sending to the client failed.
+	 */
+	READER_ERROR
+
+	/*WRITER_ERROR *
+	 * Receiving from the client and writing to the tunnel failed.
+	 */
+	WRITER_ERROR
+)
+
+func (eventType TunnelEventType) String() (ret string) {
+	switch eventType {
+	case CONNECTING:
+		ret = "connecting"
+	case HANDSHAKE_COMPLETE:
+		ret = "handshake_complete"
+	case READY:
+		ret = "ready"
+	case UPSTREAM_ERROR:
+		ret = "upstream_error"
+	case IDLE:
+		ret = "idle"
+	case TIMED_OUT:
+		ret = "timed_out"
+	case CLOSED:
+		ret = "closed"
+	case READER_ERROR:
+		ret = "reader_error"
+	case WRITER_ERROR:
+		ret = "writer_error"
+	}
+	return
+}
+
+// Reasons of CLOSED events
+const (
+	/*CLOSE_REASON_CLIENT *
+	 * The client ended the session, or the tunnel was deregistered by the
+	 * application.
+	 */
+	CLOSE_REASON_CLIENT = "client"
+
+	/*CLOSE_REASON_UPSTREAM *
+	 * guacd ended the session, with or without an "error" instruction.
+	 */
+	CLOSE_REASON_UPSTREAM = "upstream"
+
+	/*CLOSE_REASON_TIMEOUT *
+	 * The tunnel was not accessed in time.
+	 */
+	CLOSE_REASON_TIMEOUT = "timeout"
+
+	/*CLOSE_REASON_IDLE *
+	 * The user sent no keyboard or mouse input for too long.
+	 */
+	CLOSE_REASON_IDLE = "idle"
+
+	/*CLOSE_REASON_MAX_DURATION *
+	 * The session reached its maximum duration.
+	 */
+	CLOSE_REASON_MAX_DURATION = "max_duration"
+
+	/*CLOSE_REASON_SHUTDOWN *
+	 * The server shut down, or finished draining, while the tunnel was
+	 * open.
+	 */
+	CLOSE_REASON_SHUTDOWN = "shutdown"
+
+	/*CLOSE_REASON_IO_ERROR *
+	 * Communication with the client or guacd failed.
+	 */
+	CLOSE_REASON_IO_ERROR = "io_error"
+)
+
+// TunnelEvent *
+//  * A change in the lifecycle of a tunnel. Fields which do not apply to the
+//  * event type are left empty.
+type TunnelEvent struct {
+	Type TunnelEventType
+	Time time.Time
+
+	/**
+	 * The tunnel concerned, or nil for CONNECTING and for UPSTREAM_ERROR
+	 * events published when no tunnel could be created.
+	 */
+	Tunnel     gnet.GuacamoleTunnel
+	TunnelUUID string
+
+	/**
+	 * The ID of the guacd connection, available from READY onwards.
+	 */
+	ConnectionID string
+
+	/**
+	 * One of the CLOSE_REASON_* values, for CLOSED events.
+	 */
+	Reason string
+
+	/**
+	 * The status of the session, for CLOSED and error events.
+	 */
+	Status  exp.GuacamoleStatus
+	Message string
+
+	/**
+	 * The error which caused the event, if any.
+	 */
+	Err exp.ExceptionInterface
+}
+
+/*NewTunnelEvent *
+ * Creates an event of the given type concerning the given tunnel, which may
+ * be nil, with Status SUCCESS.
+ */
+func NewTunnelEvent(eventType TunnelEventType, tunnel gnet.GuacamoleTunnel) (ret TunnelEvent) {
+	ret.Type = eventType
+	ret.Time = time.Now()
+	ret.Status = exp.SUCCESS
+	if tunnel != nil {
+		ret.Tunnel = tunnel
+		ret.TunnelUUID = tunnel.GetUUID().String()
+		ret.ConnectionID = gnet.GetSocketConnectionID(tunnel.GetSocket())
+	}
+	return
+}
+
+/*WithError *
+ * Returns a copy of this event carrying the given error and its status.
+ */
+func (opt TunnelEvent) WithError(err exp.ExceptionInterface) TunnelEvent {
+	if err != nil {
+		opt.Err = err
+		opt.Status = err.GetStatus()
+		opt.Message = err.GetMessage()
+	}
+	return opt
+}
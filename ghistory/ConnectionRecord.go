@@ -0,0 +1,177 @@
+package ghistory
+
+import (
+	"sort"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+// ConnectionRecord *
+//  * A single use of a connection: who connected to what, from where, when,
+//  * for how long, and how the session ended.
+type ConnectionRecord struct {
+	/**
+	 * The unique identifier of this record.
+	 */
+	ID string `json:"id"`
+
+	/**
+	 * The UUID of the tunnel which carried the session.
+	 */
+	TunnelUUID string `json:"tunnelUUID"`
+
+	/**
+	 * The name of the user who connected.
+	 */
+	Username string `json:"username"`
+
+	/**
+	 * The name of the connection used.
+	 */
+	ConnectionName string `json:"connectionName"`
+
+	/**
+	 * The protocol of the connection used.
+	 */
+	Protocol string `json:"protocol"`
+
+	/**
+	 * The ID assigned to the session by guacd within "ready".
+	 */
+	ConnectionID string `json:"connectionID"`
+
+	/**
+	 * The address of the client.
+	 */
+	RemoteAddress string `json:"remoteAddress"`
+
+	/**
+	 * The time the session started.
+	 */
+	StartDate time.Time `json:"startDate"`
+
+	/**
+	 * The time the session ended. Zero while the session is active.
+	 */
+	EndDate time.Time `json:"endDate,omitempty"`
+
+	/**
+	 * The status the session ended with, as reported by any "error"
+	 * instruction. SUCCESS if the session ended normally.
+	 */
+	Status exp.GuacamoleStatus `json:"status"`
+
+	/**
+	 * The message of the "error" instruction which ended the session, if any.
+	 */
+	Message string `json:"message,omitempty"`
+}
+
+// IsActive Returns whether the session is still in progress.
+func (opt *ConnectionRecord) IsActive() bool {
+	return opt.EndDate.IsZero()
+}
+
+// GetDuration Returns how long the session lasted, or has lasted so far.
+func (opt *ConnectionRecord) GetDuration() time.Duration {
+	if opt.IsActive() {
+		return time.Since(opt.StartDate)
+	}
+	return opt.EndDate.Sub(opt.StartDate)
+}
+
+// HistoryQuery *
+//  * Filters and paging for HistoryStore.Search. Empty fields do not filter.
+type HistoryQuery struct {
+	Username       string
+	ConnectionName string
+	Protocol       string
+	RemoteAddress  string
+
+	/**
+	 * Only records which started at or after this time.
+	 */
+	StartedAfter time.Time
+
+	/**
+	 * Only records which started before this time.
+	 */
+	StartedBefore time.Time
+
+	/**
+	 * Only records of sessions still in progress.
+	 */
+	ActiveOnly bool
+
+	/**
+	 * The number of matching records to skip, newest first.
+	 */
+	Offset int
+
+	/**
+	 * The maximum number of records to return. Zero means no limit.
+	 */
+	Limit int
+}
+
+// Matches Returns whether the given record passes the filters of this query.
+func (opt *HistoryQuery) Matches(record ConnectionRecord) bool {
+	if len(opt.Username) > 0 && record.Username != opt.Username {
+		return false
+	}
+	if len(opt.ConnectionName) > 0 && record.ConnectionName != opt.ConnectionName {
+		return false
+	}
+	if len(opt.Protocol) > 0 && record.Protocol != opt.Protocol {
+		return false
+	}
+	if len(opt.RemoteAddress) > 0 && record.RemoteAddress != opt.RemoteAddress {
+		return false
+	}
+	if !opt.StartedAfter.IsZero() && record.StartDate.Before(opt.StartedAfter) {
+		return false
+	}
+	if !opt.StartedBefore.IsZero() && !record.StartDate.Before(opt.StartedBefore) {
+		return false
+	}
+	if opt.ActiveOnly && !record.IsActive() {
+		return false
+	}
+	return true
+}
+
+/*Apply *
+ * Filters, sorts (newest first) and pages the given records according to
+ * this query.
+ *
+ * @param records The records to search.
+ * @return The requested page of matching records, and the total number of
+ *         matching records.
+ */
+func (opt *HistoryQuery) Apply(records []ConnectionRecord) (ret []ConnectionRecord, total int) {
+	matched := make([]ConnectionRecord, 0)
+	for _, record := range records {
+		if opt.Matches(record) {
+			matched = append(matched, record)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].StartDate.After(matched[j].StartDate)
+	})
+
+	total = len(matched)
+	start := opt.Offset
+	if start < 0 {
+		start = 0
+	}
+	if start > total {
+		start = total
+	}
+	end := total
+	if opt.Limit > 0 && start+opt.Limit < end {
+		end = start + opt.Limit
+	}
+	ret = matched[start:end]
+	return
+}
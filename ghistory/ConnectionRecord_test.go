@@ -0,0 +1,98 @@
+package ghistory
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/**
+ * Returns records started one minute apart, oldest first, alternating
+ * between two users and connections. Every third record is still active.
+ */
+func testRecords(count int) []ConnectionRecord {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ret := make([]ConnectionRecord, count)
+	for i := range ret {
+		ret[i] = ConnectionRecord{
+			ID:             strconv.Itoa(i),
+			Username:       []string{"alice", "bob"}[i%2],
+			ConnectionName: []string{"rdp-1", "ssh-1"}[i%2],
+			Protocol:       []string{"rdp", "ssh"}[i%2],
+			StartDate:      base.Add(time.Duration(i) * time.Minute),
+		}
+		if i%3 != 0 {
+			ret[i].EndDate = ret[i].StartDate.Add(30 * time.Second)
+		}
+	}
+	return ret
+}
+
+/**
+ * Returns the IDs of the given records, as "[9 8 7]".
+ */
+func fmtIDs(records []ConnectionRecord) string {
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	return fmt.Sprint(ids)
+}
+
+func Test_HistoryQueryFilters(t *testing.T) {
+	records := testRecords(10)
+	base := records[0].StartDate
+	cases := map[string]struct {
+		query    HistoryQuery
+		expected string
+	}{
+		"all":            {HistoryQuery{}, "[9 8 7 6 5 4 3 2 1 0]"},
+		"user":           {HistoryQuery{Username: "alice"}, "[8 6 4 2 0]"},
+		"connection":     {HistoryQuery{ConnectionName: "ssh-1"}, "[9 7 5 3 1]"},
+		"protocol":       {HistoryQuery{Protocol: "rdp", Username: "bob"}, "[]"},
+		"started after":  {HistoryQuery{StartedAfter: base.Add(7 * time.Minute)}, "[9 8 7]"},
+		"started before": {HistoryQuery{StartedBefore: base.Add(2 * time.Minute)}, "[1 0]"},
+		"active":         {HistoryQuery{ActiveOnly: true}, "[9 6 3 0]"},
+	}
+	for name, one := range cases {
+		matched, total := one.query.Apply(records)
+		if got := fmtIDs(matched); got != one.expected || total != len(matched) {
+			t.Errorf("%v: %v of %v, expected %v", name, got, total, one.expected)
+		}
+	}
+}
+
+func Test_HistoryQueryPaging(t *testing.T) {
+	records := testRecords(10)
+	cases := []struct {
+		offset, limit int
+		expected      string
+	}{
+		{0, 3, "[9 8 7]"},
+		{3, 3, "[6 5 4]"},
+		{9, 3, "[0]"},
+		{10, 3, "[]"},
+		{20, 0, "[]"},
+		{-5, 2, "[9 8]"},
+		{8, 0, "[1 0]"},
+	}
+	for _, one := range cases {
+		query := HistoryQuery{Offset: one.offset, Limit: one.limit}
+		page, total := query.Apply(records)
+		if got := fmtIDs(page); got != one.expected || total != 10 {
+			t.Errorf("offset %v, limit %v: %v of %v, expected %v", one.offset, one.limit, got, total, one.expected)
+		}
+	}
+}
+
+func Test_HistoryQueryOrderStable(t *testing.T) {
+	// Records started at the same time keep their order
+	records := testRecords(3)
+	for i := range records {
+		records[i].StartDate = records[0].StartDate
+	}
+	if page, _ := (&HistoryQuery{}).Apply(records); fmtIDs(page) != "[0 1 2]" {
+		t.Errorf("order = %v", fmtIDs(page))
+	}
+}
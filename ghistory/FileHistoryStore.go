@@ -0,0 +1,144 @@
+package ghistory
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	logger "github.com/sirupsen/logrus"
+)
+
+/**
+ * A single line of the history file. Each session produces a "start" entry
+ * holding the full record, followed by an "end" entry when it ends.
+ */
+type historyFileEntry struct {
+	Type    string              `json:"type"`
+	Record  *ConnectionRecord   `json:"record,omitempty"`
+	ID      string              `json:"id,omitempty"`
+	EndDate time.Time           `json:"endDate,omitempty"`
+	Status  exp.GuacamoleStatus `json:"status,omitempty"`
+	Message string              `json:"message,omitempty"`
+}
+
+const (
+	historyEntryStart = "start"
+	historyEntryEnd   = "end"
+)
+
+// FileHistoryStore ==> HistoryStore
+//  * HistoryStore which appends every change, as one JSON object per line,
+//  * to a file which is never rewritten. The file is replayed when opened,
+//  * and searches are served from memory.
+type FileHistoryStore struct {
+	memory *MemoryHistoryStore
+	file   *os.File
+	lock   sync.Mutex
+}
+
+/*NewFileHistoryStore *
+ * Opens, or creates, the history file at the given path, replaying any
+ * records it already holds.
+ *
+ * @param path The path of the history file.
+ * @throws GuacamoleException If the file cannot be opened.
+ */
+func NewFileHistoryStore(path string) (ret *FileHistoryStore, err exp.ExceptionInterface) {
+	file, e := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if e != nil {
+		err = exp.GuacamoleServerException.Wrap(e, "Unable to open history file.")
+		return
+	}
+
+	one := &FileHistoryStore{
+		memory: NewMemoryHistoryStore(),
+		file:   file,
+	}
+
+	// Replay existing entries, skipping any which are damaged
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry historyFileEntry
+		if e := json.Unmarshal(scanner.Bytes(), &entry); e != nil {
+			logger.Warnf("Skipping damaged line %v of history file \"%v\": %v", line, path, e)
+			continue
+		}
+		switch entry.Type {
+		case historyEntryStart:
+			if entry.Record != nil {
+				one.memory.Insert(*entry.Record)
+			}
+		case historyEntryEnd:
+			one.memory.Finish(entry.ID, entry.EndDate, entry.Status, entry.Message)
+		}
+	}
+	if e := scanner.Err(); e != nil {
+		file.Close()
+		err = exp.GuacamoleServerException.Wrap(e, "Unable to read history file.")
+		return
+	}
+
+	ret = one
+	return
+}
+
+func (opt *FileHistoryStore) append(entry historyFileEntry) (err exp.ExceptionInterface) {
+	data, e := json.Marshal(entry)
+	if e != nil {
+		return exp.GuacamoleServerException.Wrap(e)
+	}
+	data = append(data, '\n')
+	if _, e = opt.file.Write(data); e != nil {
+		return exp.GuacamoleServerException.Wrap(e, "Unable to write history file.")
+	}
+	return
+}
+
+// Insert override HistoryStore.Insert
+func (opt *FileHistoryStore) Insert(record ConnectionRecord) (err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	err = opt.memory.Insert(record)
+	if err != nil {
+		return
+	}
+	return opt.append(historyFileEntry{Type: historyEntryStart, Record: &record})
+}
+
+// Finish override HistoryStore.Finish
+func (opt *FileHistoryStore) Finish(id string, endDate time.Time, status exp.GuacamoleStatus, message string) (err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	err = opt.memory.Finish(id, endDate, status, message)
+	if err != nil {
+		return
+	}
+	return opt.append(historyFileEntry{
+		Type:    historyEntryEnd,
+		ID:      id,
+		EndDate: endDate,
+		Status:  status,
+		Message: message,
+	})
+}
+
+// Search override HistoryStore.Search
+func (opt *FileHistoryStore) Search(query HistoryQuery) ([]ConnectionRecord, int, exp.ExceptionInterface) {
+	return opt.memory.Search(query)
+}
+
+// Close Closes the history file.
+func (opt *FileHistoryStore) Close() (err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if e := opt.file.Close(); e != nil {
+		err = exp.GuacamoleServerException.Wrap(e)
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package ghistory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+func Test_FileHistoryStoreReplay(t *testing.T) {
+	dir, e := ioutil.TempDir("", "history")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.jsonl")
+
+	store, err := NewFileHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := testRecords(3)
+	for _, record := range records {
+		if err = store.Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Finish(records[0].ID, records[0].StartDate.Add(time.Minute), exp.UPSTREAM_TIMEOUT, "Timed out.")
+	store.Close()
+
+	// A line damaged by a crash is skipped
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString("{\"type\":\"sta")
+	file.Close()
+
+	store, err = NewFileHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	found, total, _ := store.Search(HistoryQuery{Username: "alice"})
+	if total != 2 || fmtIDs(found) != "[2 0]" {
+		t.Fatalf("replayed records = %v", fmtIDs(found))
+	}
+	if found[1].Status != exp.UPSTREAM_TIMEOUT || found[1].Message != "Timed out." || found[1].IsActive() {
+		t.Errorf("replayed end = %+v", found[1])
+	}
+}
@@ -0,0 +1,140 @@
+package ghistory
+
+import (
+	"sync"
+	"time"
+
+	guid "github.com/gofrs/uuid"
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gevent"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	logger "github.com/sirupsen/logrus"
+)
+
+// IdentityResolverInterface Tool interface for HistoryRecorder
+//  * Returns the user, connection name and client address of a tunnel which
+//  * does not implement gnet.TunnelIdentityInterface itself.
+type IdentityResolverInterface func(tunnel gnet.GuacamoleTunnel) (username, connectionName, remoteAddress string)
+
+// HistoryRecorder *
+//  * Records a ConnectionRecord for every tunnel passed to OnConnectSucc,
+//  * completing it when the same tunnel is passed to OnConnectStop. Both
+//  * functions match the servlet callbacks DoConnectSuccInterface and
+//  * DoConnectStopInterface.
+type HistoryRecorder struct {
+	store    HistoryStore
+	resolver IdentityResolverInterface
+
+	/**
+	 * The IDs of the records of active sessions, indexed by tunnel UUID.
+	 */
+	active     map[string]string
+	activeLock sync.Mutex
+}
+
+/*NewHistoryRecorder *
+ * Creates a new HistoryRecorder which stores records in the given store.
+ *
+ * @param store The store which receives all records.
+ * @param resolver Resolves the identity of tunnels which do not carry one,
+ *                 or nil.
+ */
+func NewHistoryRecorder(store HistoryStore, resolver IdentityResolverInterface) (ret *HistoryRecorder) {
+	ret = &HistoryRecorder{
+		store:    store,
+		resolver: resolver,
+		active:   make(map[string]string),
+	}
+	return
+}
+
+// GetStore Returns the store receiving all records.
+func (opt *HistoryRecorder) GetStore() HistoryStore {
+	return opt.store
+}
+
+/*OnConnectSucc *
+ * Records the start of the session carried by the given tunnel.
+ *
+ * @param tunnel The newly registered tunnel.
+ */
+func (opt *HistoryRecorder) OnConnectSucc(tunnel gnet.GuacamoleTunnel) {
+	id, _ := guid.NewV4()
+	record := ConnectionRecord{
+		ID:         id.String(),
+		TunnelUUID: tunnel.GetUUID().String(),
+		StartDate:  time.Now(),
+		Status:     exp.SUCCESS,
+	}
+
+	if identity, ok := gnet.GetTunnelIdentity(tunnel); ok {
+		record.Username = identity.GetUsername()
+		record.ConnectionName = identity.GetConnectionName()
+		record.RemoteAddress = identity.GetRemoteAddress()
+	} else if opt.resolver != nil {
+		record.Username, record.ConnectionName, record.RemoteAddress = opt.resolver(tunnel)
+	}
+	if config := tunnel.GetConfiguration(); config != nil {
+		record.Protocol = config.GetProtocol()
+	}
+	record.ConnectionID = gnet.GetSocketConnectionID(tunnel.GetSocket())
+
+	if err := opt.store.Insert(record); err != nil {
+		logger.Warn("Unable to record start of connection: ", err.GetMessage())
+		return
+	}
+
+	opt.activeLock.Lock()
+	opt.active[record.TunnelUUID] = record.ID
+	opt.activeLock.Unlock()
+}
+
+/*OnConnectStop *
+ * Records the end of the session carried by the given tunnel, as ended
+ * successfully since the callback does not tell how it ended. Subscribe
+ * OnEvent instead to record the status of the CLOSED event. Tunnels which
+ * were never recorded, or were already stopped, are ignored.
+ *
+ * @param tunnel The tunnel being deregistered.
+ */
+func (opt *HistoryRecorder) OnConnectStop(tunnel gnet.GuacamoleTunnel) {
+	opt.finish(tunnel.GetUUID().String(), exp.SUCCESS, "")
+}
+
+/*OnEvent *
+ * Records the start of a session on READY events and its end, with the
+ * status and message of the event, such as those of the "error"
+ * instruction which ended it, on CLOSED events. Subscribe this function to an
+ * EventBus instead of passing OnConnectSucc and OnConnectStop as servlet
+ * callbacks.
+ *
+ * @param event The tunnel event.
+ */
+func (opt *HistoryRecorder) OnEvent(event gevent.TunnelEvent) {
+	switch event.Type {
+	case gevent.READY:
+		if event.Tunnel != nil {
+			opt.OnConnectSucc(event.Tunnel)
+		}
+	case gevent.CLOSED:
+		opt.finish(event.TunnelUUID, event.Status, event.Message)
+	}
+}
+
+/**
+ * Completes the record of the session of the tunnel having the given UUID,
+ * if it was recorded and not yet completed.
+ */
+func (opt *HistoryRecorder) finish(uuid string, status exp.GuacamoleStatus, message string) {
+	opt.activeLock.Lock()
+	id, ok := opt.active[uuid]
+	delete(opt.active, uuid)
+	opt.activeLock.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := opt.store.Finish(id, time.Now(), status, message); err != nil {
+		logger.Warn("Unable to record end of connection: ", err.GetMessage())
+	}
+}
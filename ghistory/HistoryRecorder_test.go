@@ -0,0 +1,41 @@
+package ghistory
+
+import (
+	"testing"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gevent"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+func Test_HistoryRecorderEvents(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	recorder := NewHistoryRecorder(store, func(tunnel gnet.GuacamoleTunnel) (string, string, string) {
+		return "alice", "rdp-1", "192.0.2.1"
+	})
+	config := gprotocol.NewGuacamoleConfiguration()
+	config.SetProtocol("rdp")
+	tunnel := gnet.NewSimpleGuacamoleTunnel(nil, config)
+
+	recorder.OnEvent(gevent.NewTunnelEvent(gevent.READY, tunnel))
+	records, total, _ := store.Search(HistoryQuery{ActiveOnly: true})
+	if total != 1 || records[0].Username != "alice" || records[0].Protocol != "rdp" {
+		t.Fatalf("active records = %+v", records)
+	}
+
+	// The status of the "error" instruction is carried by the CLOSED event
+	upstream := gprotocol.ParseErrorInstructionException("5.error,11.Logged off.,3.523;")
+	recorder.OnEvent(gevent.NewTunnelEvent(gevent.CLOSED, tunnel).WithError(upstream))
+	records, total, _ = store.Search(HistoryQuery{})
+	if total != 1 || records[0].IsActive() || records[0].Status != exp.SESSION_CLOSED ||
+		records[0].Message != "Logged off." {
+		t.Errorf("finished records = %+v", records)
+	}
+
+	// Later events of the same tunnel are ignored
+	recorder.OnEvent(gevent.NewTunnelEvent(gevent.CLOSED, tunnel))
+	if records, _, _ = store.Search(HistoryQuery{}); records[0].Status != exp.SESSION_CLOSED {
+		t.Errorf("record finished twice: %+v", records[0])
+	}
+}
@@ -0,0 +1,93 @@
+package ghistory
+
+import (
+	"sync"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+// HistoryStore *
+//  * Storage for connection records.
+type HistoryStore interface {
+
+	/**
+	 * Stores the given record of a session which has just started.
+	 *
+	 * @param record The record to store. Its ID must be unique.
+	 * @throws GuacamoleException If the record cannot be stored.
+	 */
+	Insert(record ConnectionRecord) exp.ExceptionInterface
+
+	/**
+	 * Marks the session having the given record ID as ended.
+	 *
+	 * @param id The ID of the record.
+	 * @param endDate The time the session ended.
+	 * @param status The status the session ended with.
+	 * @param message The message of the "error" instruction, if any.
+	 * @throws GuacamoleResourceNotFoundException If no such record exists.
+	 */
+	Finish(id string, endDate time.Time, status exp.GuacamoleStatus, message string) exp.ExceptionInterface
+
+	/**
+	 * Returns the page of records matching the given query, newest first,
+	 * along with the total number of matching records.
+	 *
+	 * @param query The filters and paging to apply.
+	 * @throws GuacamoleException If the records cannot be read.
+	 */
+	Search(query HistoryQuery) ([]ConnectionRecord, int, exp.ExceptionInterface)
+}
+
+// MemoryHistoryStore ==> HistoryStore
+//  * HistoryStore which keeps all records in memory. Records are lost when
+//  * the process exits.
+type MemoryHistoryStore struct {
+	records []ConnectionRecord
+	index   map[string]int
+	lock    sync.RWMutex
+}
+
+// NewMemoryHistoryStore Construct function
+func NewMemoryHistoryStore() (ret *MemoryHistoryStore) {
+	ret = &MemoryHistoryStore{
+		records: make([]ConnectionRecord, 0),
+		index:   make(map[string]int),
+	}
+	return
+}
+
+// Insert override HistoryStore.Insert
+func (opt *MemoryHistoryStore) Insert(record ConnectionRecord) (err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if _, ok := opt.index[record.ID]; ok {
+		return exp.GuacamoleResourceConflictException.Throw("Duplicate connection record.", record.ID)
+	}
+	opt.index[record.ID] = len(opt.records)
+	opt.records = append(opt.records, record)
+	return
+}
+
+// Finish override HistoryStore.Finish
+func (opt *MemoryHistoryStore) Finish(id string, endDate time.Time, status exp.GuacamoleStatus, message string) (err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	i, ok := opt.index[id]
+	if !ok {
+		return exp.GuacamoleResourceNotFoundException.Throw("No such connection record.", id)
+	}
+	opt.records[i].EndDate = endDate
+	opt.records[i].Status = status
+	opt.records[i].Message = message
+	return
+}
+
+// Search override HistoryStore.Search
+func (opt *MemoryHistoryStore) Search(query HistoryQuery) (ret []ConnectionRecord, total int, err exp.ExceptionInterface) {
+	opt.lock.RLock()
+	defer opt.lock.RUnlock()
+	ret, total = query.Apply(opt.records)
+	return
+}
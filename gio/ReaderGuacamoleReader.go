@@ -35,7 +35,7 @@ func (opt *ReaderGuacamoleReader) Available() (ok bool, err exp.ExceptionInterfa
 	}
 	ok, e := opt.input.Available()
 	if e != nil {
-		err = exp.GuacamoleServerException.Throw(e.Error())
+		err = exp.GuacamoleServerException.Wrap(e)
 		return
 	}
 	return
@@ -128,12 +128,12 @@ mainLoop:
 			case net.Error:
 				ex := e.(net.Error)
 				if ex.Timeout() {
-					err = exp.GuacamoleUpstreamTimeoutException.Throw("Connection to guacd timed out.", e.Error())
+					err = exp.GuacamoleUpstreamTimeoutException.Wrap(e, "Connection to guacd timed out.")
 				} else {
-					err = exp.GuacamoleConnectionClosedException.Throw("Connection to guacd is closed.", e.Error())
+					err = exp.GuacamoleConnectionClosedException.Wrap(e, "Connection to guacd is closed.")
 				}
 			default:
-				err = exp.GuacamoleServerException.Throw(e.Error())
+				err = exp.GuacamoleServerException.Wrap(e)
 			}
 			break mainLoop
 		}
@@ -177,7 +177,7 @@ func (opt *ReaderGuacamoleReader) ReadInstruction() (instruction gprotocol.Guaca
 		// Parse length
 		length, e := strconv.Atoi(string(instructionBuffer[elementStart:lengthEnd]))
 		if e != nil {
-			err = exp.GuacamoleServerException.Throw("Read returned wrong pattern instruction.", e.Error())
+			err = exp.GuacamoleServerException.Wrap(e, "Read returned wrong pattern instruction.")
 			return
 		}
 
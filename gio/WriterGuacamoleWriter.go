@@ -33,7 +33,7 @@ func (opt *WriterGuacamoleWriter) Write(chunk []byte, off, l int) (err exp.Excep
 	e := opt.WriteAll(chunk[off : off+l])
 	if e != nil {
 		// Socket timeout will close so ...
-		err = exp.GuacamoleConnectionClosedException.Throw("Connection to guacd is closed.", e.Error())
+		err = exp.GuacamoleConnectionClosedException.Wrap(e, "Connection to guacd is closed.")
 	}
 	return
 }
@@ -48,12 +48,12 @@ func (opt *WriterGuacamoleWriter) WriteAll(chunk []byte) (err exp.ExceptionInter
 	case net.Error:
 		ex := e.(net.Error)
 		if ex.Timeout() {
-			err = exp.GuacamoleUpstreamTimeoutException.Throw("Connection to guacd timed out.", e.Error())
+			err = exp.GuacamoleUpstreamTimeoutException.Wrap(e, "Connection to guacd timed out.")
 		} else {
-			err = exp.GuacamoleConnectionClosedException.Throw("Connection to guacd is closed.", e.Error())
+			err = exp.GuacamoleConnectionClosedException.Wrap(e, "Connection to guacd is closed.")
 		}
 	default:
-		err = exp.GuacamoleServerException.Throw(e.Error())
+		err = exp.GuacamoleServerException.Wrap(e)
 	}
 	return
 }
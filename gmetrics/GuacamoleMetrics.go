@@ -0,0 +1,72 @@
+package gmetrics
+
+// Label values of the direction of tunnel traffic
+const (
+	/*SERVER_TO_CLIENT *
+	 * Traffic read from guacd and sent to the client.
+	 */
+	SERVER_TO_CLIENT = "server_to_client"
+
+	/*CLIENT_TO_SERVER *
+	 * Traffic received from the client and written to guacd.
+	 */
+	CLIENT_TO_SERVER = "client_to_server"
+)
+
+// Metrics of this library. All are nil, and all updates are ignored, until
+// Enable() is called.
+var (
+	// TunnelsActive Gauge of registered tunnels, labelled by protocol
+	TunnelsActive *MetricFamily
+
+	// HandshakeDuration Histogram of handshake latency, labelled by protocol
+	HandshakeDuration *MetricFamily
+
+	// HandshakeFailures Counter of failed handshakes, labelled by GuacamoleStatus
+	HandshakeFailures *MetricFamily
+
+	// TunnelBytes Counter of tunnel bytes, labelled by direction
+	TunnelBytes *MetricFamily
+
+	// TunnelInstructions Counter of tunnel instructions, labelled by direction
+	TunnelInstructions *MetricFamily
+
+	// TunnelsExpired Counter of HTTP tunnels closed by the timeout task
+	TunnelsExpired *MetricFamily
+
+	// HTTPRequestDuration Histogram of HTTP tunnel requests, labelled by operation
+	HTTPRequestDuration *MetricFamily
+
+	// BackendUp Gauge of guacd reachability (1 or 0), labelled by backend address
+	BackendUp *MetricFamily
+
+	// BackendDialFailures Counter of failed guacd dials, labelled by backend address
+	BackendDialFailures *MetricFamily
+)
+
+/*Enable *
+ * Registers the metrics of this library with the given registry and starts
+ * collecting them. Must be called before any tunnel is created.
+ *
+ * @param registry The registry receiving the metrics.
+ */
+func Enable(registry *Registry) {
+	TunnelsActive = registry.NewGauge("guacamole_tunnels_active",
+		"Number of registered tunnels.", "protocol")
+	HandshakeDuration = registry.NewHistogram("guacamole_handshake_duration_seconds",
+		"Time taken by the Guacamole protocol handshake with guacd.", nil, "protocol")
+	HandshakeFailures = registry.NewCounter("guacamole_handshake_failures_total",
+		"Number of failed handshakes with guacd.", "status")
+	TunnelBytes = registry.NewCounter("guacamole_tunnel_bytes_total",
+		"Bytes of instruction data carried by tunnels.", "direction")
+	TunnelInstructions = registry.NewCounter("guacamole_tunnel_instructions_total",
+		"Instructions carried by tunnels.", "direction")
+	TunnelsExpired = registry.NewCounter("guacamole_http_tunnels_expired_total",
+		"Number of HTTP tunnels closed because they were no longer accessed.")
+	HTTPRequestDuration = registry.NewHistogram("guacamole_http_request_duration_seconds",
+		"Duration of HTTP tunnel requests.", nil, "operation")
+	BackendUp = registry.NewGauge("guacamole_guacd_up",
+		"Whether the last connection attempt to guacd succeeded.", "backend")
+	BackendDialFailures = registry.NewCounter("guacamole_guacd_dial_failures_total",
+		"Number of failed connection attempts to guacd.", "backend")
+}
@@ -0,0 +1,289 @@
+package gmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricType All possible types of a metric family.
+type MetricType int
+
+const (
+	/*COUNTER *
+	 * A value which only ever increases.
+	 */
+	COUNTER MetricType = iota
+
+	/*GAUGE *
+	 * A value which may increase and decrease.
+	 */
+	GAUGE
+
+	/*HISTOGRAM *
+	 * A distribution of observed values over fixed buckets.
+	 */
+	HISTOGRAM
+)
+
+func (mtype MetricType) String() (ret string) {
+	switch mtype {
+	case COUNTER:
+		ret = "counter"
+	case GAUGE:
+		ret = "gauge"
+	case HISTOGRAM:
+		ret = "histogram"
+	}
+	return
+}
+
+// DefaultBuckets Histogram buckets, in seconds, suited to network latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+/**
+ * The value of one labelled series of a family.
+ */
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// MetricFamily *
+//  * A named metric with a fixed set of label names, holding one series per
+//  * distinct combination of label values.
+type MetricFamily struct {
+	name       string
+	help       string
+	mtype      MetricType
+	labelNames []string
+	buckets    []float64
+	series     map[string]*series
+	lock       sync.Mutex
+}
+
+func (opt *MetricFamily) get(labelValues []string) *series {
+	if len(labelValues) != len(opt.labelNames) {
+		panic(fmt.Sprintf("metric %v expects %v label values, got %v", opt.name, len(opt.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	one, ok := opt.series[key]
+	if !ok {
+		one = &series{labelValues: append([]string{}, labelValues...)}
+		if opt.mtype == HISTOGRAM {
+			one.counts = make([]uint64, len(opt.buckets))
+		}
+		opt.series[key] = one
+	}
+	return one
+}
+
+/*Add *
+ * Adds the given amount to the series with the given label values. Only
+ * valid for counters and gauges; counters ignore negative amounts.
+ */
+func (opt *MetricFamily) Add(delta float64, labelValues ...string) {
+	if opt == nil || (opt.mtype == COUNTER && delta < 0) {
+		return
+	}
+	opt.lock.Lock()
+	opt.get(labelValues).value += delta
+	opt.lock.Unlock()
+}
+
+// Inc Adds one to the series with the given label values.
+func (opt *MetricFamily) Inc(labelValues ...string) {
+	opt.Add(1, labelValues...)
+}
+
+// Dec Subtracts one from the gauge series with the given label values.
+func (opt *MetricFamily) Dec(labelValues ...string) {
+	if opt == nil || opt.mtype != GAUGE {
+		return
+	}
+	opt.Add(-1, labelValues...)
+}
+
+// Set Sets the gauge series with the given label values.
+func (opt *MetricFamily) Set(value float64, labelValues ...string) {
+	if opt == nil || opt.mtype != GAUGE {
+		return
+	}
+	opt.lock.Lock()
+	opt.get(labelValues).value = value
+	opt.lock.Unlock()
+}
+
+// Observe Records a value in the histogram series with the given label values.
+func (opt *MetricFamily) Observe(value float64, labelValues ...string) {
+	if opt == nil || opt.mtype != HISTOGRAM {
+		return
+	}
+	opt.lock.Lock()
+	one := opt.get(labelValues)
+	for i, bound := range opt.buckets {
+		if value <= bound {
+			one.counts[i]++
+		}
+	}
+	one.sum += value
+	one.count++
+	opt.lock.Unlock()
+}
+
+// Value Returns the current value of a counter or gauge series.
+func (opt *MetricFamily) Value(labelValues ...string) float64 {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	return opt.get(labelValues).value
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func (opt *MetricFamily) labels(labelValues []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(labelValues)+1)
+	for i, name := range opt.labelNames {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, name, escapeLabelValue(labelValues[i])))
+	}
+	if len(extraName) > 0 {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (opt *MetricFamily) write(w *bufio.Writer) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n", opt.name, strings.Replace(opt.help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %v %v\n", opt.name, opt.mtype)
+
+	keys := make([]string, 0, len(opt.series))
+	for k := range opt.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		one := opt.series[k]
+		if opt.mtype != HISTOGRAM {
+			fmt.Fprintf(w, "%v%v %v\n", opt.name, opt.labels(one.labelValues, "", ""), formatValue(one.value))
+			continue
+		}
+		for i, bound := range opt.buckets {
+			fmt.Fprintf(w, "%v_bucket%v %v\n", opt.name, opt.labels(one.labelValues, "le", formatValue(bound)), one.counts[i])
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", opt.name, opt.labels(one.labelValues, "le", "+Inf"), one.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", opt.name, opt.labels(one.labelValues, "", ""), formatValue(one.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", opt.name, opt.labels(one.labelValues, "", ""), one.count)
+	}
+}
+
+// Registry *
+//  * A set of metric families which can be exposed in the Prometheus text
+//  * exposition format.
+type Registry struct {
+	families map[string]*MetricFamily
+	lock     sync.RWMutex
+}
+
+// NewRegistry Construct function
+func NewRegistry() (ret *Registry) {
+	ret = &Registry{families: make(map[string]*MetricFamily)}
+	return
+}
+
+func (opt *Registry) register(name, help string, mtype MetricType, buckets []float64, labelNames []string) (ret *MetricFamily) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if one, ok := opt.families[name]; ok {
+		return one
+	}
+	ret = &MetricFamily{
+		name:       name,
+		help:       help,
+		mtype:      mtype,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	opt.families[name] = ret
+	return
+}
+
+// NewCounter Registers, or returns the existing, counter family of the given name.
+func (opt *Registry) NewCounter(name, help string, labelNames ...string) *MetricFamily {
+	return opt.register(name, help, COUNTER, nil, labelNames)
+}
+
+// NewGauge Registers, or returns the existing, gauge family of the given name.
+func (opt *Registry) NewGauge(name, help string, labelNames ...string) *MetricFamily {
+	return opt.register(name, help, GAUGE, nil, labelNames)
+}
+
+// NewHistogram Registers, or returns the existing, histogram family of the given name.
+func (opt *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *MetricFamily {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return opt.register(name, help, HISTOGRAM, buckets, labelNames)
+}
+
+/*WriteText *
+ * Writes every metric family, sorted by name, in the Prometheus text
+ * exposition format.
+ *
+ * @param output The writer receiving the metrics.
+ */
+func (opt *Registry) WriteText(output io.Writer) error {
+	opt.lock.RLock()
+	names := make([]string, 0, len(opt.families))
+	for name := range opt.families {
+		names = append(names, name)
+	}
+	opt.lock.RUnlock()
+	sort.Strings(names)
+
+	w := bufio.NewWriter(output)
+	for _, name := range names {
+		opt.lock.RLock()
+		family := opt.families[name]
+		opt.lock.RUnlock()
+		family.write(w)
+	}
+	return w.Flush()
+}
+
+// ServeHTTP Serves the metrics in the Prometheus text exposition format.
+func (opt *Registry) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	opt.WriteText(response)
+}
@@ -0,0 +1,94 @@
+package gmetrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_RegistryWriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("test_requests_total", "Requests served,\nby method.", "method", "path")
+	sessions := registry.NewGauge("test_sessions", "Open sessions.")
+	latency := registry.NewHistogram("test_latency_seconds", "Request latency.", []float64{0.1, 1}, "method")
+
+	requests.Inc("GET", "/")
+	requests.Add(2, "GET", "/")
+	requests.Add(-5, "GET", "/")
+	requests.Inc("POST", `/a "quoted" \path`+"\n")
+	sessions.Set(3)
+	sessions.Dec()
+	sessions.Add(math.Inf(1))
+	requests.Dec("GET", "/")
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.5, "GET")
+	latency.Observe(5, "GET")
+
+	// Families are sorted by name and series by label values
+	expected := `# HELP test_latency_seconds Request latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{method="GET",le="0.1"} 1
+test_latency_seconds_bucket{method="GET",le="1"} 2
+test_latency_seconds_bucket{method="GET",le="+Inf"} 3
+test_latency_seconds_sum{method="GET"} 5.55
+test_latency_seconds_count{method="GET"} 3
+# HELP test_requests_total Requests served, by method.
+# TYPE test_requests_total counter
+test_requests_total{method="GET",path="/"} 3
+test_requests_total{method="POST",path="/a \"quoted\" \\path\n"} 1
+# HELP test_sessions Open sessions.
+# TYPE test_sessions gauge
+test_sessions +Inf
+`
+	var output bytes.Buffer
+	if e := registry.WriteText(&output); e != nil {
+		t.Fatal(e)
+	}
+	if output.String() != expected {
+		t.Errorf("output:\n%v\nexpected:\n%v", output.String(), expected)
+	}
+
+	response := httptest.NewRecorder()
+	registry.ServeHTTP(response, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := response.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("content type %q", contentType)
+	}
+	if response.Body.String() != expected {
+		t.Errorf("served:\n%v", response.Body.String())
+	}
+}
+
+func Test_RegistryFamilies(t *testing.T) {
+	registry := NewRegistry()
+
+	// Families are registered once, and nil families ignore updates
+	counter := registry.NewCounter("test_total", "Test.")
+	if registry.NewCounter("test_total", "Other.") != counter {
+		t.Errorf("family registered twice")
+	}
+	var missing *MetricFamily
+	missing.Inc()
+	missing.Set(1)
+	missing.Observe(1)
+
+	// Each type accepts only its own updates
+	gauge := registry.NewGauge("test_gauge", "Test.")
+	gauge.Observe(1)
+	gauge.Inc()
+	counter.Set(5)
+	if counter.Value() != 0 || gauge.Value() != 1 {
+		t.Errorf("counter = %v, gauge = %v", counter.Value(), gauge.Value())
+	}
+	histogram := registry.NewHistogram("test_histogram", "Test.", nil)
+	if len(histogram.buckets) != len(DefaultBuckets) {
+		t.Errorf("buckets = %v", histogram.buckets)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("wrong label count accepted")
+		}
+	}()
+	counter.Inc("extra")
+}
@@ -4,11 +4,17 @@ package gnet
 // Avoid cross depends
 
 import (
+	"context"
+
 	exp "github.com/hsfish/guacamole_client_go"
 	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gmetrics"
 	"github.com/hsfish/guacamole_client_go/gprotocol"
+	"github.com/hsfish/guacamole_client_go/gtrace"
+	logger "github.com/sirupsen/logrus"
 
 	"fmt"
+	"time"
 )
 
 // ConfiguredGuacamoleSocket ==> GuacamoleSocket
@@ -99,10 +105,64 @@ func NewConfiguredGuacamoleSocket3(socket GuacamoleSocket,
 	config gprotocol.GuacamoleConfiguration,
 	info gprotocol.GuacamoleClientInformation) (one ConfiguredGuacamoleSocket,
 	err exp.ExceptionInterface) {
+	return NewConfiguredGuacamoleSocketContext(context.Background(), socket, config, info)
+}
+
+/*NewConfiguredGuacamoleSocketContext *
+* Creates a new ConfiguredGuacamoleSocket as NewConfiguredGuacamoleSocket3
+* does, recording the handshake as a "guacd.handshake" span, with one child
+* span per handshake step, beneath the span carried by the given context.
+*
+* @param ctx The context of the handshake.
+* @param socket The GuacamoleSocket to wrap.
+* @param config The GuacamoleConfiguration to use to complete the initial
+*               protocol handshake.
+* @param info The GuacamoleClientInformation to use to complete the initial
+*             protocol handshake.
+* @throws GuacamoleException If an error occurs while completing the
+*                            initial protocol handshake.
+ */
+func NewConfiguredGuacamoleSocketContext(ctx context.Context, socket GuacamoleSocket,
+	config gprotocol.GuacamoleConfiguration,
+	info gprotocol.GuacamoleClientInformation) (one ConfiguredGuacamoleSocket,
+	err exp.ExceptionInterface) {
 
 	one.socket = socket
 	one.config = config
 
+	ctx, span := gtrace.Start(ctx, "guacd.handshake")
+	span.SetAttribute("guacamole.protocol", config.GetProtocol())
+
+	// Record handshake latency and failures
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			gmetrics.HandshakeFailures.Inc(err.GetStatus().String())
+			span.SetError(err.GetMessage())
+		} else {
+			gmetrics.HandshakeDuration.Observe(time.Since(start).Seconds(), config.GetProtocol())
+			span.SetAttribute("guacamole.connection_id", one.id)
+		}
+		span.End()
+	}()
+
+	// Run one handshake step within its own span
+	step := func(name string, fn func() exp.ExceptionInterface) (e exp.ExceptionInterface) {
+		_, stepSpan := gtrace.Start(ctx, "handshake."+name)
+		defer stepSpan.End()
+		e = fn()
+		if e != nil {
+			stepSpan.SetError(e.GetMessage())
+		}
+		return
+	}
+
+	// Reject malformed parameters before talking to guacd
+	err = gprotocol.ValidateConfiguration(config)
+	if err != nil {
+		return
+	}
+
 	// Get reader and writer
 	reader := socket.GetReader()
 	writer := socket.GetWriter()
@@ -114,13 +174,19 @@ func NewConfiguredGuacamoleSocket3(socket GuacamoleSocket,
 	}
 
 	// Send requested protocol or connection ID
-	err = writer.WriteInstruction(gprotocol.NewGuacamoleInstruction("select", selectArg))
+	err = step("select", func() exp.ExceptionInterface {
+		return writer.WriteInstruction(gprotocol.NewGuacamoleInstruction("select", selectArg))
+	})
 	if err != nil {
 		return
 	}
 
 	// Wait for server args
-	args, err := one.expect(reader, "args")
+	var args gprotocol.GuacamoleInstruction
+	err = step("args", func() (e exp.ExceptionInterface) {
+		args, e = one.expect(reader, "args")
+		return
+	})
 	if err != nil {
 		return
 	}
@@ -142,68 +208,88 @@ func NewConfiguredGuacamoleSocket3(socket GuacamoleSocket,
 		argValueS = append(argValueS, value)
 	}
 
-	// Send size
-	err = writer.WriteInstruction(
-		gprotocol.NewGuacamoleInstruction(
-			"size",
-			fmt.Sprintf("%v", info.GetOptimalScreenWidth()),
-			fmt.Sprintf("%v", info.GetOptimalScreenHeight()),
-			fmt.Sprintf("%v", info.GetOptimalResolution())),
-	)
+	// Warn about parameters guacd did not ask for, as they will be ignored
+	requested := make(map[string]bool, len(argNameS))
+	for _, argName := range argNameS {
+		requested[argName] = true
+	}
+	for _, name := range config.GetParameterNames() {
+		if !requested[name] {
+			logger.Warnf("Parameter \"%v\" is not accepted by guacd for \"%v\" and will be ignored.", name, selectArg)
+		}
+	}
 
+	// Send size
+	err = step("size", func() exp.ExceptionInterface {
+		return writer.WriteInstruction(
+			gprotocol.NewGuacamoleInstruction(
+				"size",
+				fmt.Sprintf("%v", info.GetOptimalScreenWidth()),
+				fmt.Sprintf("%v", info.GetOptimalScreenHeight()),
+				fmt.Sprintf("%v", info.GetOptimalResolution())),
+		)
+	})
 	if err != nil {
 		return
 	}
 
 	// Send supported audio formats
-	err = writer.WriteInstruction(
-		gprotocol.NewGuacamoleInstruction(
-			"audio",
-			info.GetAudioMimetypes()...,
-		))
+	err = step("audio", func() exp.ExceptionInterface {
+		return writer.WriteInstruction(
+			gprotocol.NewGuacamoleInstruction(
+				"audio",
+				info.GetAudioMimetypes()...,
+			))
+	})
 	if err != nil {
 		return
 	}
 
 	// Send supported video formats
-	err = writer.WriteInstruction(
-		gprotocol.NewGuacamoleInstruction(
-			"video",
-			info.GetVideoMimetypes()...,
-		))
+	err = step("video", func() exp.ExceptionInterface {
+		return writer.WriteInstruction(
+			gprotocol.NewGuacamoleInstruction(
+				"video",
+				info.GetVideoMimetypes()...,
+			))
+	})
 	if err != nil {
 		return
 	}
 
 	// Send supported image formats
-	err = writer.WriteInstruction(
-		gprotocol.NewGuacamoleInstruction(
-			"image",
-			info.GetImageMimetypes()...,
-		))
+	err = step("image", func() exp.ExceptionInterface {
+		return writer.WriteInstruction(
+			gprotocol.NewGuacamoleInstruction(
+				"image",
+				info.GetImageMimetypes()...,
+			))
+	})
 	if err != nil {
 		return
 	}
 
 	// Send args
-	err = writer.WriteInstruction(gprotocol.NewGuacamoleInstruction("connect", argValueS...))
+	err = step("connect", func() exp.ExceptionInterface {
+		return writer.WriteInstruction(gprotocol.NewGuacamoleInstruction("connect", argValueS...))
+	})
 	if err != nil {
 		return
 	}
 
 	// Wait for ready, store ID
-	ready, err := one.expect(reader, "ready")
-	if err != nil {
-		return
-	}
-
-	readyArgs := ready.GetArgs()
-	if len(readyArgs) == 0 {
-		err = exp.GuacamoleServerException.Throw("No connection ID received")
-		return
-	}
-
-	one.id = readyArgs[0]
+	err = step("ready", func() exp.ExceptionInterface {
+		ready, e := one.expect(reader, "ready")
+		if e != nil {
+			return e
+		}
+		readyArgs := ready.GetArgs()
+		if len(readyArgs) == 0 {
+			return exp.GuacamoleServerException.Throw("No connection ID received")
+		}
+		one.id = readyArgs[0]
+		return nil
+	})
 
 	return
 
@@ -20,6 +20,11 @@ func NewDelegatingGuacamoleTunnel(tunnel GuacamoleTunnel) (ret DelegatingGuacamo
 	return
 }
 
+// Unwrap Returns the underlying GuacamoleTunnel
+func (opt *DelegatingGuacamoleTunnel) Unwrap() GuacamoleTunnel {
+	return opt.tunnel
+}
+
 // GetUUID override GuacamoleTunnel.GetUUID
 func (opt *DelegatingGuacamoleTunnel) GetUUID() guid.UUID {
 	return opt.tunnel.GetUUID()
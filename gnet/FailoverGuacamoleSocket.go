@@ -7,7 +7,6 @@ import (
 	exp "github.com/hsfish/guacamole_client_go"
 	"github.com/hsfish/guacamole_client_go/gio"
 	"github.com/hsfish/guacamole_client_go/gprotocol"
-	"strconv"
 )
 
 // log instread of LoggerFactory
@@ -48,61 +47,11 @@ type FailoverGuacamoleSocket struct {
 	queuedReader gio.GuacamoleReader
 }
 
-/**
-* Parses the given "error" instruction, throwing an exception if the
-* instruction represents an error from the upstream remote desktop.
-*
-* @param instruction
-*     The "error" instruction to parse.
-*
-* @throws GuacamoleUpstreamException
-*     If the "error" instruction represents an error from the upstream
-*     remote desktop.
- */
-func handleUpstreamErrors(instruction gprotocol.GuacamoleInstruction) (err exp.ExceptionInterface) {
-	// Ignore error instructions which are missing the status code
-	args := instruction.GetArgs()
-	if len(args) < 2 {
-		// logger.debug("Received \"error\" instruction without status code.");
-		return
-	}
-
-	// Parse the status code from the received error instruction
-	var statusCode int
-	statusCode, e := strconv.Atoi(args[1])
-	if e != nil {
-		// logger.debug("Received \"error\" instruction with non-numeric status code.", e);
-		return
-	}
-
-	status := exp.FromGuacamoleStatusCode(statusCode)
-	if status == exp.Undifined {
-		// logger.debug("Received \"error\" instruction with unknown/invalid status code: {}", statusCode);
-		return
-	}
-
-	switch status {
-	case exp.UPSTREAM_ERROR:
-		err = exp.GuacamoleUpstreamException.Throw(args[0])
-
-	case exp.UPSTREAM_NOT_FOUND:
-		err = exp.GuacamoleUpstreamNotFoundException.Throw(args[0])
-
-	// Upstream did not respond
-	case exp.UPSTREAM_TIMEOUT:
-		err = exp.GuacamoleUpstreamTimeoutException.Throw(args[0])
-
-	// Upstream is refusing the connection
-	case exp.UPSTREAM_UNAVAILABLE:
-		err = exp.GuacamoleUpstreamUnavailableException.Throw(args[0])
-	}
-	return
-}
-
 /*NewFailoverGuacamoleSocket *
 * Creates a new FailoverGuacamoleSocket which reads Guacamole instructions
 * from the given socket, searching for errors from the upstream remote
-* desktop. If an upstream error is encountered, it is thrown as a
+* desktop. If an error is encountered, it is thrown as the exception of
+* the kind corresponding to its status, such as a
 * GuacamoleUpstreamException. This constructor will block until an error
 * is encountered, or until the connection appears to have been successful.
 * Once the FailoverGuacamoleSocket has been created, all reads, writes,
@@ -120,6 +69,7 @@ func handleUpstreamErrors(instruction gprotocol.GuacamoleInstruction) (err exp.E
 *     connecting to the remote desktop.
  */
 func NewFailoverGuacamoleSocket(socket GuacamoleSocket) (ret FailoverGuacamoleSocket, err exp.ExceptionInterface) {
+	ret.socket = socket
 	ret.instructionQueue = make([]gprotocol.GuacamoleInstruction, 0, 1)
 
 	var totalQueueSize int
@@ -138,10 +88,10 @@ func NewFailoverGuacamoleSocket(socket GuacamoleSocket) (ret FailoverGuacamoleSo
 			break
 		}
 
-		// If instruction is an "error" instruction, parse its contents and
-		// stop reading
+		// If instruction is an "error" instruction, throw the exception
+		// it reports and stop reading
 		if opcode == "error" {
-			err = handleUpstreamErrors(instruction)
+			err = gprotocol.ErrorInstructionException(instruction)
 			return
 		}
 
@@ -157,8 +107,6 @@ func NewFailoverGuacamoleSocket(socket GuacamoleSocket) (ret FailoverGuacamoleSo
 		return
 	}
 
-	ret.socket = socket
-
 	/**
 	 * GuacamoleReader which reads instructions from the queue populated when
 	 * the FailoverGuacamoleSocket was constructed. Once the queue has been
@@ -169,6 +117,11 @@ func NewFailoverGuacamoleSocket(socket GuacamoleSocket) (ret FailoverGuacamoleSo
 	return
 }
 
+// Unwrap Returns the wrapped GuacamoleSocket
+func (opt *FailoverGuacamoleSocket) Unwrap() GuacamoleSocket {
+	return opt.socket
+}
+
 // GetReader override GuacamoleSocket.GetReader
 func (opt *FailoverGuacamoleSocket) GetReader() gio.GuacamoleReader {
 	return opt.queuedReader
@@ -211,7 +164,7 @@ func (opt *lambdaQueuedReader) Available() (ok bool, err exp.ExceptionInterface)
 	if ok {
 		return
 	}
-	return opt.core.GetReader().Available()
+	return opt.core.socket.GetReader().Available()
 }
 
 // Read override GuacamoleReader.Read
@@ -242,5 +195,5 @@ func (opt *lambdaQueuedReader) ReadInstruction() (ret gprotocol.GuacamoleInstruc
 		opt.core.instructionQueue = opt.core.instructionQueue[1:]
 		return
 	}
-	return opt.core.GetReader().ReadInstruction()
+	return opt.core.socket.GetReader().ReadInstruction()
 }
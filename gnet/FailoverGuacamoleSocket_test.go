@@ -0,0 +1,85 @@
+package gnet
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gio"
+)
+
+/**
+ * A socket to a fake guacd, played by the other end of a pipe.
+ */
+type testSocket struct {
+	conn   net.Conn
+	reader gio.GuacamoleReader
+	writer gio.GuacamoleWriter
+	closed int32
+}
+
+func (opt *testSocket) GetReader() gio.GuacamoleReader { return opt.reader }
+func (opt *testSocket) GetWriter() gio.GuacamoleWriter { return opt.writer }
+func (opt *testSocket) IsOpen() bool                   { return atomic.LoadInt32(&opt.closed) == 0 }
+
+func (opt *testSocket) Close() exp.ExceptionInterface {
+	atomic.StoreInt32(&opt.closed, 1)
+	opt.conn.Close()
+	return nil
+}
+
+/**
+ * Returns a socket reading the given data, sent by a fake guacd, and the
+ * connection of that guacd.
+ */
+func newTestSocket(data string) (*testSocket, net.Conn) {
+	client, guacd := net.Pipe()
+	stream := gio.NewStream(client, 5*time.Second)
+	go guacd.Write([]byte(data))
+	return &testSocket{
+		conn:   client,
+		reader: gio.NewReaderGuacamoleReader(stream),
+		writer: gio.NewWriterGuacamoleWriter(stream),
+	}, guacd
+}
+
+func Test_FailoverGuacamoleSocket(t *testing.T) {
+	socket, guacd := newTestSocket("4.size,1.0,1.1;4.sync,1.5;4.name,3.rdp;")
+	defer guacd.Close()
+
+	failover, err := NewFailoverGuacamoleSocket(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Instructions read while searching for errors come first, then those
+	// of the wrapped socket
+	reader := failover.GetReader()
+	for _, opcode := range []string{"size", "sync", "name"} {
+		instruction, err := reader.ReadInstruction()
+		if err != nil || instruction.GetOpcode() != opcode {
+			t.Fatalf("read %v, err %v, expected %v", instruction.GetOpcode(), err, opcode)
+		}
+	}
+	if failover.Close() != nil || socket.IsOpen() {
+		t.Errorf("wrapped socket not closed")
+	}
+}
+
+func Test_FailoverGuacamoleSocketError(t *testing.T) {
+	socket, guacd := newTestSocket("4.size,1.0,1.1;5.error,13.No such host.,3.519;")
+	defer guacd.Close()
+
+	failover, err := NewFailoverGuacamoleSocket(socket)
+	if err == nil || err.GetStatus() != exp.UPSTREAM_NOT_FOUND {
+		t.Fatalf("err %v", err)
+	}
+
+	// The socket returned may still be closed
+	failover.Close()
+	if socket.IsOpen() {
+		t.Errorf("wrapped socket not closed")
+	}
+}
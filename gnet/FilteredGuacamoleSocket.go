@@ -68,6 +68,11 @@ func NewFilteredGuacamoleSocket(
 	return
 }
 
+// Unwrap Returns the wrapped GuacamoleSocket
+func (opt *FilteredGuacamoleSocket) Unwrap() GuacamoleSocket {
+	return opt.socket
+}
+
 // GetReader override GuacamoleSocket.GetReader
 func (opt *FilteredGuacamoleSocket) GetReader() gio.GuacamoleReader {
 	return opt.reader
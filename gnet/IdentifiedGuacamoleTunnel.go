@@ -0,0 +1,52 @@
+package gnet
+
+// IdentifiedGuacamoleTunnel ==> DelegatingGuacamoleTunnel
+//  * GuacamoleTunnel implementation which delegates to an underlying tunnel
+//  * while recording the user, connection and client address it serves.
+type IdentifiedGuacamoleTunnel struct {
+	DelegatingGuacamoleTunnel
+
+	/**
+	 * The name of the user on whose behalf the tunnel was established.
+	 */
+	username string
+
+	/**
+	 * The name of the connection the tunnel is connected to.
+	 */
+	connectionName string
+
+	/**
+	 * The address of the client using the tunnel.
+	 */
+	remoteAddress string
+}
+
+// NewIdentifiedGuacamoleTunnel Construct function
+//  * Wraps the given tunnel, associating it with the given user, connection
+//  * name and client address.
+func NewIdentifiedGuacamoleTunnel(tunnel GuacamoleTunnel,
+	username, connectionName, remoteAddress string) (ret *IdentifiedGuacamoleTunnel) {
+	ret = &IdentifiedGuacamoleTunnel{
+		DelegatingGuacamoleTunnel: NewDelegatingGuacamoleTunnel(tunnel),
+		username:                  username,
+		connectionName:            connectionName,
+		remoteAddress:             remoteAddress,
+	}
+	return
+}
+
+// GetUsername override TunnelIdentityInterface.GetUsername
+func (opt *IdentifiedGuacamoleTunnel) GetUsername() string {
+	return opt.username
+}
+
+// GetConnectionName override TunnelIdentityInterface.GetConnectionName
+func (opt *IdentifiedGuacamoleTunnel) GetConnectionName() string {
+	return opt.connectionName
+}
+
+// GetRemoteAddress override TunnelIdentityInterface.GetRemoteAddress
+func (opt *IdentifiedGuacamoleTunnel) GetRemoteAddress() string {
+	return opt.remoteAddress
+}
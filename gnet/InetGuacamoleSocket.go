@@ -1,9 +1,12 @@
 package gnet
 
 import (
+	"context"
 	"fmt"
 	exp "github.com/hsfish/guacamole_client_go"
 	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gmetrics"
+	"github.com/hsfish/guacamole_client_go/gtrace"
 	"net"
 	"time"
 )
@@ -32,20 +35,44 @@ type InetGuacamoleSocket struct {
 //  * @throws GuacamoleException If an error occurs while connecting to the
 //  *                            Guacamole proxy server.
 func NewInetGuacamoleSocket(hostname string, port int) (ret InetGuacamoleSocket, err exp.ExceptionInterface) {
+	return NewInetGuacamoleSocketContext(context.Background(), hostname, port)
+}
+
+// NewInetGuacamoleSocketContext Construct & connect
+//  * Creates a new InetGuacamoleSocket as NewInetGuacamoleSocket does,
+//  * recording the connection attempt as a "guacd.dial" span which is a
+//  * child of the span carried by the given context. Cancelling the context
+//  * aborts the connection attempt.
+//  *
+//  * @param ctx The context of the connection attempt.
+//  * @param hostname The hostname of the Guacamole proxy server to connect to.
+//  * @param port The port of the Guacamole proxy server to connect to.
+//  * @throws GuacamoleException If an error occurs while connecting to the
+//  *                            Guacamole proxy server.
+func NewInetGuacamoleSocketContext(ctx context.Context, hostname string, port int) (ret InetGuacamoleSocket, err exp.ExceptionInterface) {
 	// log.DebugF("Try connect %v:%v", hostname, port)
 
+	_, span := gtrace.Start(ctx, "guacd.dial")
+	span.SetAttribute("net.peer.name", hostname)
+	span.SetAttribute("net.peer.port", port)
+	defer span.End()
+
 	// Get address
 	address := fmt.Sprintf("%s:%d", hostname, port)
-	addr, e := net.ResolveTCPAddr("tcp", address)
 
 	// Connect with timeout
 	// sock, e := net.DialTimeout("tcp", address, SocketTimeout)
 
-	sock, e := net.DialTCP("tcp", nil, addr)
+	var dialer net.Dialer
+	sock, e := dialer.DialContext(ctx, "tcp", address)
 	if e != nil {
-		err = exp.GuacamoleUpstreamTimeoutException.Throw("Connection timed out.", e.Error())
+		gmetrics.BackendUp.Set(0, address)
+		gmetrics.BackendDialFailures.Inc(address)
+		span.SetError(e.Error())
+		err = exp.GuacamoleUpstreamTimeoutException.Wrap(e, "Connection timed out.")
 		return
 	}
+	gmetrics.BackendUp.Set(1, address)
 
 	// Set read timeout
 	// On successful connect, retrieve I/O streams
@@ -61,7 +88,7 @@ func (opt *InetGuacamoleSocket) Close() (err exp.ExceptionInterface) {
 	// logger.debug("Closing socket to guacd.");
 	e := opt.sock.Close()
 	if e != nil {
-		err = exp.GuacamoleServerException.Throw(e.Error())
+		err = exp.GuacamoleServerException.Wrap(e)
 	}
 	return
 }
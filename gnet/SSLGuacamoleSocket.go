@@ -1,10 +1,13 @@
 package gnet
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	exp "github.com/hsfish/guacamole_client_go"
 	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gmetrics"
+	"github.com/hsfish/guacamole_client_go/gtrace"
 	"net"
 )
 
@@ -27,8 +30,26 @@ type SSLGuacamoleSocket struct {
 //  * @throws GuacamoleException If an error occurs while connecting to the
 //  *                            Guacamole proxy server.
 func NewSSLGuacamoleSocket(hostname string, port int) (ret SSLGuacamoleSocket, err error) {
+	return NewSSLGuacamoleSocketContext(context.Background(), hostname, port)
+}
+
+// NewSSLGuacamoleSocketContext Construct & connect
+//  * Creates a new SSLGuacamoleSocket as NewSSLGuacamoleSocket does,
+//  * recording the connection attempt as a "guacd.dial" span which is a
+//  * child of the span carried by the given context.
+//  *
+//  * @param ctx The context of the connection attempt.
+//  * @param hostname The hostname of the Guacamole proxy server to connect to.
+//  * @param port The port of the Guacamole proxy server to connect to.
+func NewSSLGuacamoleSocketContext(ctx context.Context, hostname string, port int) (ret SSLGuacamoleSocket, err error) {
 	// log.DebugF("Connecting to guacd at {}:{} via SSL/TLS.", hostname, port)
 
+	_, span := gtrace.Start(ctx, "guacd.dial")
+	span.SetAttribute("net.peer.name", hostname)
+	span.SetAttribute("net.peer.port", port)
+	span.SetAttribute("tls", true)
+	defer span.End()
+
 	// Get address
 	address := fmt.Sprintf("%s:%d", hostname, port)
 
@@ -40,8 +61,12 @@ func NewSSLGuacamoleSocket(hostname string, port int) (ret SSLGuacamoleSocket, e
 		})
 	if err != nil {
 		// throw new GuacamoleUpstreamTimeoutException("Connection timed out.", e);
+		gmetrics.BackendUp.Set(0, address)
+		gmetrics.BackendDialFailures.Inc(address)
+		span.SetError(err.Error())
 		return
 	}
+	gmetrics.BackendUp.Set(1, address)
 
 	// Set read timeout
 	// On successful connect, retrieve I/O streams
@@ -57,7 +82,7 @@ func (opt *SSLGuacamoleSocket) Close() (err exp.ExceptionInterface) {
 	// logger.debug("Closing socket to guacd.");
 	e := opt.sock.Close()
 	if e != nil {
-		err = exp.GuacamoleServerException.Throw(e.Error())
+		err = exp.GuacamoleServerException.Wrap(e)
 	}
 	return
 }
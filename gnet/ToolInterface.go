@@ -4,3 +4,70 @@ package gnet
 type GetSocketInterface interface {
 	GetSocket() GuacamoleSocket
 }
+
+// TunnelIdentityInterface Tool interface for tunnels bound to a user
+//  * Implemented by tunnels which know on whose behalf, and to which named
+//  * connection, they were established.
+type TunnelIdentityInterface interface {
+	GetUsername() string
+	GetConnectionName() string
+	GetRemoteAddress() string
+}
+
+// UnwrapTunnelInterface Tool interface for tunnels wrapping another tunnel
+type UnwrapTunnelInterface interface {
+	Unwrap() GuacamoleTunnel
+}
+
+/*GetTunnelIdentity *
+ * Returns the identity of the given tunnel, searching through any wrapping
+ * tunnels until a TunnelIdentityInterface is found.
+ *
+ * @param tunnel The tunnel to inspect.
+ * @return The identity of the tunnel, and whether one was found.
+ */
+func GetTunnelIdentity(tunnel GuacamoleTunnel) (ret TunnelIdentityInterface, ok bool) {
+	for tunnel != nil {
+		if ret, ok = tunnel.(TunnelIdentityInterface); ok {
+			return
+		}
+		wrapper, isWrapper := tunnel.(UnwrapTunnelInterface)
+		if !isWrapper {
+			return
+		}
+		tunnel = wrapper.Unwrap()
+	}
+	return
+}
+
+// GetConnectionIDInterface Tool interface for sockets which completed the handshake
+type GetConnectionIDInterface interface {
+	GetConnectionID() string
+}
+
+// UnwrapSocketInterface Tool interface for sockets wrapping another socket
+type UnwrapSocketInterface interface {
+	Unwrap() GuacamoleSocket
+}
+
+/*GetSocketConnectionID *
+ * Returns the ID of the Guacamole connection negotiated over the given
+ * socket, as received within "ready", searching through any wrapping
+ * sockets. An empty string is returned if no such ID is known.
+ *
+ * @param socket The socket to inspect.
+ * @return The connection ID, or an empty string.
+ */
+func GetSocketConnectionID(socket GuacamoleSocket) string {
+	for socket != nil {
+		if one, ok := socket.(GetConnectionIDInterface); ok {
+			return one.GetConnectionID()
+		}
+		wrapper, ok := socket.(UnwrapSocketInterface)
+		if !ok {
+			break
+		}
+		socket = wrapper.Unwrap()
+	}
+	return ""
+}
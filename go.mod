@@ -1,8 +1,10 @@
 module github.com/hsfish/guacamole_client_go
 
-go 1.12
+go 1.13
 
 require (
 	github.com/gofrs/uuid v3.2.0+incompatible
+	github.com/gorilla/websocket v1.4.2
 	github.com/sirupsen/logrus v1.4.2
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -120,3 +120,27 @@ func (opt *GuacamoleClientInformation) GetVideoMimetypes() []string {
 func (opt *GuacamoleClientInformation) GetImageMimetypes() []string {
 	return opt.imageMimetypes
 }
+
+// SetAudioMimetypes *
+//  * Sets the list of audio mimetypes supported by the client.
+//  *
+//  * @param mimetypes The audio mimetypes supported by the client.
+func (opt *GuacamoleClientInformation) SetAudioMimetypes(mimetypes []string) {
+	opt.audioMimetypes = append(make([]string, 0, len(mimetypes)), mimetypes...)
+}
+
+// SetVideoMimetypes *
+//  * Sets the list of video mimetypes supported by the client.
+//  *
+//  * @param mimetypes The video mimetypes supported by the client.
+func (opt *GuacamoleClientInformation) SetVideoMimetypes(mimetypes []string) {
+	opt.videoMimetypes = append(make([]string, 0, len(mimetypes)), mimetypes...)
+}
+
+// SetImageMimetypes *
+//  * Sets the list of image mimetypes supported by the client.
+//  *
+//  * @param mimetypes The image mimetypes supported by the client.
+func (opt *GuacamoleClientInformation) SetImageMimetypes(mimetypes []string) {
+	opt.imageMimetypes = append(make([]string, 0, len(mimetypes)), mimetypes...)
+}
@@ -29,6 +29,19 @@ func NewGuacamoleConfiguration() (ret GuacamoleConfiguration) {
 	return
 }
 
+// NewGuacamoleConfiguration2 Copy construct function
+//  * Copies the given GuacamoleConfiguration, creating a new, independent
+//  * GuacamoleConfiguration containing the same connection ID, protocol,
+//  * and parameter values, if any.
+//  *
+//  * @param config The GuacamoleConfiguration to copy.
+func NewGuacamoleConfiguration2(config GuacamoleConfiguration) (ret GuacamoleConfiguration) {
+	ret.connectionID = config.connectionID
+	ret.protocol = config.protocol
+	ret.SetParameters(config.parameters)
+	return
+}
+
 /*GetConnectionID *
  * Returns the ID of the connection being joined, if any. If no connection
  * is being joined, this returns null, and the protocol must be set.
@@ -139,3 +152,20 @@ func (opt *GuacamoleConfiguration) SetParameters(parameters map[string]string) {
 		opt.SetParameter(k, v)
 	}
 }
+
+/*Resolve *
+ * Returns a copy of this configuration in which all parameter values have
+ * been passed through the given TokenFilter. This
+ * configuration, which typically acts as a template, is left untouched.
+ *
+ * @param filter
+ *     The TokenFilter to apply to the parameter values.
+ *
+ * @return
+ *     A new GuacamoleConfiguration with all tokens substituted.
+ */
+func (opt *GuacamoleConfiguration) Resolve(filter *TokenFilter) (ret GuacamoleConfiguration) {
+	ret = NewGuacamoleConfiguration2(*opt)
+	filter.FilterValues(ret.parameters)
+	return
+}
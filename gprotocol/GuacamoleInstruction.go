@@ -1,7 +1,12 @@
 package gprotocol
 
 import (
+	"bytes"
 	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	exp "github.com/hsfish/guacamole_client_go"
 )
 
 // GuacamoleInstruction instruction container
@@ -42,11 +47,96 @@ func (opt *GuacamoleInstruction) String() string {
 		return opt.protocolForm
 	}
 
-	opt.protocolForm = fmt.Sprintf("%d.%s", len(opt.opcode), opt.opcode)
+	// Element lengths are counted in characters, not bytes
+	opt.protocolForm = fmt.Sprintf("%d.%s", utf8.RuneCountInString(opt.opcode), opt.opcode)
 	for _, value := range opt.args {
-		opt.protocolForm += fmt.Sprintf(",%d.%s", len(value), value)
+		opt.protocolForm += fmt.Sprintf(",%d.%s", utf8.RuneCountInString(value), value)
 	}
 	opt.protocolForm += ";"
 
 	return opt.protocolForm
 }
+
+/*NewErrorInstruction *
+ * Returns the "error" instruction reporting the message and status of the
+ * given error, which ParseErrorInstructionException parses back.
+ *
+ * @param err The error to report.
+ * @return The "error" instruction.
+ */
+func NewErrorInstruction(err exp.ExceptionInterface) GuacamoleInstruction {
+	return NewGuacamoleInstruction("error", err.GetMessage(), strconv.Itoa(err.GetStatus().GetGuacamoleStatusCode()))
+}
+
+/*ParseErrorInstruction *
+ * Extracts the status and message of the first "error" instruction within
+ * the given instruction data, in protocol form. If the text contains no
+ * valid "error" instruction, SERVER_ERROR is returned along with the raw
+ * text.
+ *
+ * @param text Instruction data such as "5.error,9.Timed out,3.520;".
+ * @return The status and human-readable message of the error.
+ */
+func ParseErrorInstruction(text string) (status exp.GuacamoleStatus, message string) {
+	status, message = exp.SERVER_ERROR, text
+
+	parser := NewGuacamoleStreamParser()
+	instructions, _ := parser.Append([]byte(text))
+	var args []string
+	found := false
+	for _, instruction := range instructions {
+		if instruction.GetOpcode() == "error" {
+			args, found = instruction.GetArgs(), true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	if len(args) > 0 {
+		message = args[0]
+	}
+	if len(args) > 1 {
+		if code, e := strconv.Atoi(args[1]); e == nil {
+			if one := exp.FromGuacamoleStatusCode(code); one != exp.Undifined {
+				status = one
+			}
+		}
+	}
+	return
+}
+
+/*IsErrorInstruction *
+ * Returns whether the given instruction data, in protocol form, begins
+ * with an "error" instruction.
+ *
+ * @param data Instruction data, such as one instruction read from guacd.
+ */
+func IsErrorInstruction(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("5.error,"))
+}
+
+/*ErrorInstructionException *
+ * Returns the exception reported by the given "error" instruction, of the
+ * kind corresponding to its status code. Instructions lacking a valid
+ * status code report SERVER_ERROR.
+ *
+ * @param instruction The "error" instruction.
+ * @return The exception, whose message is that of the instruction.
+ */
+func ErrorInstructionException(instruction GuacamoleInstruction) exp.ExceptionInterface {
+	return ParseErrorInstructionException(instruction.String())
+}
+
+/*ParseErrorInstructionException *
+ * Returns the exception reported by the first "error" instruction within
+ * the given instruction data, as ParseErrorInstruction parses it.
+ *
+ * @param text Instruction data such as "5.error,9.Timed out,3.520;".
+ * @return The exception, of the kind corresponding to the status.
+ */
+func ParseErrorInstructionException(text string) exp.ExceptionInterface {
+	status, message := ParseErrorInstruction(text)
+	return exp.ExceptionKindFromStatus(status).Throw(message)
+}
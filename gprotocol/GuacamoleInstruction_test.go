@@ -0,0 +1,50 @@
+package gprotocol
+
+import (
+	"errors"
+	"testing"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+func Test_ErrorInstructionStatus(t *testing.T) {
+	// Every status survives being sent in an "error" instruction, found
+	// through its code. SUCCESS is no error, reported as SERVER_ERROR.
+	found := 0
+	for code := 0; code < 0x1000; code++ {
+		status := exp.FromGuacamoleStatusCode(code)
+		if status == exp.Undifined {
+			continue
+		}
+		found++
+		expected := status
+		if status == exp.SUCCESS {
+			expected = exp.SERVER_ERROR
+		}
+		kind := exp.ExceptionKindFromStatus(status)
+		instruction := NewErrorInstruction(kind.Throw("Failed."))
+		err := ParseErrorInstructionException(instruction.String())
+		if err.GetStatus() != expected || err.Kind() != kind || !errors.Is(err, kind) || err.GetMessage() != "Failed." {
+			t.Errorf("%v sent as %q, parsed as %v of kind %v: %v", status, instruction.String(), err.GetStatus(),
+				err.Kind(), err.GetMessage())
+		}
+	}
+	if found < 20 {
+		t.Errorf("only %v statuses found", found)
+	}
+
+	// Instructions lacking a valid code report SERVER_ERROR
+	cases := map[string]string{
+		"5.error,7.Failed.;":             "Failed.",
+		"5.error,7.Failed.,3.abc;":       "Failed.",
+		"5.error,7.Failed.,4.1234;":      "Failed.",
+		"4.sync,4.1000;":                 "4.sync,4.1000;",
+		"4.sync,4.1000;5.error,2.No,0.;": "No",
+	}
+	for text, message := range cases {
+		err := ParseErrorInstructionException(text)
+		if err.GetStatus() != exp.SERVER_ERROR || !errors.Is(err, exp.GuacamoleServerException) || err.GetMessage() != message {
+			t.Errorf("%q parsed as %v: %v", text, err.GetStatus(), err.GetMessage())
+		}
+	}
+}
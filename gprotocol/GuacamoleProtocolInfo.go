@@ -0,0 +1,443 @@
+package gprotocol
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+// GuacamoleParameterType All possible types of a protocol parameter.
+type GuacamoleParameterType int
+
+const (
+	/*TEXT_PARAMETER *
+	 * A parameter which accepts arbitrary text.
+	 */
+	TEXT_PARAMETER GuacamoleParameterType = iota
+
+	/*NUMERIC_PARAMETER *
+	 * A parameter which accepts only integer values.
+	 */
+	NUMERIC_PARAMETER
+
+	/*BOOLEAN_PARAMETER *
+	 * A parameter which is either "true" or "false". Guacamole treats any
+	 * value other than "true" as false.
+	 */
+	BOOLEAN_PARAMETER
+
+	/*ENUM_PARAMETER *
+	 * A parameter which accepts only one of a fixed set of values.
+	 */
+	ENUM_PARAMETER
+)
+
+func (ptype GuacamoleParameterType) String() (ret string) {
+	switch ptype {
+	case TEXT_PARAMETER:
+		ret = "TEXT"
+	case NUMERIC_PARAMETER:
+		ret = "NUMERIC"
+	case BOOLEAN_PARAMETER:
+		ret = "BOOLEAN"
+	case ENUM_PARAMETER:
+		ret = "ENUM"
+	}
+	return
+}
+
+// GuacamoleParameterInfo *
+//  * Describes a single connection parameter accepted by a protocol.
+type GuacamoleParameterInfo struct {
+	/**
+	 * The name of the parameter, as requested by guacd within "args".
+	 */
+	name string
+
+	/**
+	 * The type of value accepted by the parameter.
+	 */
+	ptype GuacamoleParameterType
+
+	/**
+	 * The values allowed for ENUM_PARAMETER parameters.
+	 */
+	options []string
+
+	/**
+	 * Whether the value of the parameter must never be logged or displayed.
+	 */
+	sensitive bool
+}
+
+// NewGuacamoleParameterInfo Construct function
+func NewGuacamoleParameterInfo(name string, ptype GuacamoleParameterType, sensitive bool, options ...string) (ret GuacamoleParameterInfo) {
+	ret.name = name
+	ret.ptype = ptype
+	ret.sensitive = sensitive
+	ret.options = options
+	return
+}
+
+// GetName Returns the name of the parameter.
+func (opt *GuacamoleParameterInfo) GetName() string {
+	return opt.name
+}
+
+// GetType Returns the type of value accepted by the parameter.
+func (opt *GuacamoleParameterInfo) GetType() GuacamoleParameterType {
+	return opt.ptype
+}
+
+// GetOptions Returns the values allowed for an ENUM_PARAMETER parameter.
+func (opt *GuacamoleParameterInfo) GetOptions() []string {
+	return opt.options
+}
+
+// IsSensitive Returns whether the value of the parameter must not be disclosed.
+func (opt *GuacamoleParameterInfo) IsSensitive() bool {
+	return opt.sensitive
+}
+
+/*Validate *
+ * Checks the given value against the type of this parameter. Empty values
+ * are always valid, as guacd treats them as "use the default".
+ *
+ * @param value The value to check.
+ * @return An error describing why the value is invalid, or nil.
+ */
+func (opt *GuacamoleParameterInfo) Validate(value string) (err exp.ExceptionInterface) {
+	if len(value) == 0 {
+		return
+	}
+	switch opt.ptype {
+	case NUMERIC_PARAMETER:
+		if _, e := strconv.Atoi(value); e != nil {
+			err = exp.GuacamoleClientException.Throw(fmt.Sprintf("Parameter \"%v\" must be numeric.", opt.name))
+		}
+	case BOOLEAN_PARAMETER:
+		if value != "true" && value != "false" {
+			err = exp.GuacamoleClientException.Throw(fmt.Sprintf("Parameter \"%v\" must be \"true\" or \"false\".", opt.name))
+		}
+	case ENUM_PARAMETER:
+		for _, option := range opt.options {
+			if option == value {
+				return
+			}
+		}
+		err = exp.GuacamoleClientException.Throw(fmt.Sprintf("Parameter \"%v\" must be one of %v.", opt.name, opt.options))
+	}
+	return
+}
+
+// GuacamoleProtocolInfo *
+//  * Describes a protocol supported by guacd, listing all parameters it
+//  * accepts.
+type GuacamoleProtocolInfo struct {
+	/**
+	 * The name of the protocol, as given to "select".
+	 */
+	name string
+
+	/**
+	 * All parameters accepted by the protocol, indexed by name.
+	 */
+	parameters map[string]GuacamoleParameterInfo
+}
+
+// NewGuacamoleProtocolInfo Construct function
+func NewGuacamoleProtocolInfo(name string, parameters ...GuacamoleParameterInfo) (ret GuacamoleProtocolInfo) {
+	ret.name = name
+	ret.parameters = make(map[string]GuacamoleParameterInfo, len(parameters))
+	for _, parameter := range parameters {
+		ret.parameters[parameter.name] = parameter
+	}
+	return
+}
+
+// GetName Returns the name of the protocol.
+func (opt *GuacamoleProtocolInfo) GetName() string {
+	return opt.name
+}
+
+/*GetParameter *
+ * Returns the definition of the parameter having the given name.
+ *
+ * @param name The name of the parameter.
+ * @return The parameter definition, and whether such a parameter exists.
+ */
+func (opt *GuacamoleProtocolInfo) GetParameter(name string) (ret GuacamoleParameterInfo, ok bool) {
+	ret, ok = opt.parameters[name]
+	return
+}
+
+// GetParameterNames Returns the sorted names of all parameters of the protocol.
+func (opt *GuacamoleProtocolInfo) GetParameterNames() (ret []string) {
+	ret = make([]string, 0, len(opt.parameters))
+	for k := range opt.parameters {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return
+}
+
+/*IsSensitive *
+ * Returns whether the parameter having the given name holds a secret, such
+ * as a password or private key. Unknown parameters are not sensitive.
+ */
+func (opt *GuacamoleProtocolInfo) IsSensitive(name string) bool {
+	v, ok := opt.parameters[name]
+	return ok && v.sensitive
+}
+
+/*Validate *
+ * Validates the parameters of the given configuration against this
+ * protocol, rejecting values of the wrong type. Unknown parameter names
+ * are accepted, as guacd may accept parameters this schema does not list
+ * yet, and the handshake warns about those guacd does not request.
+ *
+ * @param config The configuration to validate.
+ * @return A GuacamoleClientException listing every problem found, or nil
+ *         if the configuration is valid.
+ */
+func (opt *GuacamoleProtocolInfo) Validate(config GuacamoleConfiguration) (err exp.ExceptionInterface) {
+	names := config.GetParameterNames()
+	sort.Strings(names)
+
+	problems := make([]string, 0)
+	for _, name := range names {
+		parameter, ok := opt.parameters[name]
+		if !ok {
+			continue
+		}
+		if e := parameter.Validate(config.GetParameter(name)); e != nil {
+			problems = append(problems, e.GetMessage())
+		}
+	}
+
+	if len(problems) > 0 {
+		err = exp.GuacamoleClientException.Throw(problems...)
+	}
+	return
+}
+
+var (
+	protocolInfoMap     = initProtocolInfoMap()
+	protocolInfoMapLock sync.RWMutex
+)
+
+/*GetProtocolInfo *
+ * Returns the schema of the protocol having the given name.
+ *
+ * @param name The name of the protocol, such as "rdp".
+ * @return The protocol schema, and whether such a protocol is known.
+ */
+func GetProtocolInfo(name string) (ret GuacamoleProtocolInfo, ok bool) {
+	protocolInfoMapLock.RLock()
+	ret, ok = protocolInfoMap[name]
+	protocolInfoMapLock.RUnlock()
+	return
+}
+
+/*RegisterProtocolInfo *
+ * Registers the schema of a protocol, replacing any built-in schema of the
+ * same name. This allows custom guacd builds to be validated.
+ *
+ * @param info The protocol schema to register.
+ */
+func RegisterProtocolInfo(info GuacamoleProtocolInfo) {
+	protocolInfoMapLock.Lock()
+	protocolInfoMap[info.name] = info
+	protocolInfoMapLock.Unlock()
+}
+
+/*ValidateConfiguration *
+ * Validates the given configuration against the schema of its protocol.
+ * Configurations joining an existing connection, or using a protocol
+ * without a known schema, are not validated.
+ *
+ * @param config The configuration to validate.
+ * @return A GuacamoleClientException describing all invalid parameters, or
+ *         nil if the configuration is valid.
+ */
+func ValidateConfiguration(config GuacamoleConfiguration) (err exp.ExceptionInterface) {
+	if len(config.GetConnectionID()) > 0 {
+		return
+	}
+	info, ok := GetProtocolInfo(config.GetProtocol())
+	if !ok {
+		return
+	}
+	return info.Validate(config)
+}
+
+///////////////////////////////////////////////////////////////////
+// Built-in protocol schemas
+///////////////////////////////////////////////////////////////////
+
+func textParameter(names ...string) (ret []GuacamoleParameterInfo) {
+	for _, name := range names {
+		ret = append(ret, NewGuacamoleParameterInfo(name, TEXT_PARAMETER, false))
+	}
+	return
+}
+
+func numericParameter(names ...string) (ret []GuacamoleParameterInfo) {
+	for _, name := range names {
+		ret = append(ret, NewGuacamoleParameterInfo(name, NUMERIC_PARAMETER, false))
+	}
+	return
+}
+
+func booleanParameter(names ...string) (ret []GuacamoleParameterInfo) {
+	for _, name := range names {
+		ret = append(ret, NewGuacamoleParameterInfo(name, BOOLEAN_PARAMETER, false))
+	}
+	return
+}
+
+func sensitiveParameter(names ...string) (ret []GuacamoleParameterInfo) {
+	for _, name := range names {
+		ret = append(ret, NewGuacamoleParameterInfo(name, TEXT_PARAMETER, true))
+	}
+	return
+}
+
+func enumParameter(name string, options ...string) []GuacamoleParameterInfo {
+	return []GuacamoleParameterInfo{NewGuacamoleParameterInfo(name, ENUM_PARAMETER, false, options...)}
+}
+
+func joinParameters(groups ...[]GuacamoleParameterInfo) (ret []GuacamoleParameterInfo) {
+	for _, group := range groups {
+		ret = append(ret, group...)
+	}
+	return
+}
+
+// Parameters shared by several protocols
+var (
+	recordingParameters = joinParameters(
+		textParameter("recording-path", "recording-name"),
+		booleanParameter("recording-exclude-output", "recording-exclude-mouse",
+			"recording-exclude-touch", "recording-include-keys",
+			"create-recording-path", "recording-write-existing"),
+	)
+
+	sftpParameters = joinParameters(
+		booleanParameter("enable-sftp", "sftp-disable-download", "sftp-disable-upload"),
+		textParameter("sftp-hostname", "sftp-host-key", "sftp-username",
+			"sftp-public-key", "sftp-directory", "sftp-root-directory"),
+		numericParameter("sftp-port", "sftp-timeout", "sftp-server-alive-interval"),
+		sensitiveParameter("sftp-password", "sftp-private-key", "sftp-passphrase"),
+	)
+
+	wolParameters = joinParameters(
+		booleanParameter("wol-send-packet"),
+		textParameter("wol-mac-addr", "wol-broadcast-addr"),
+		numericParameter("wol-udp-port", "wol-wait-time"),
+	)
+
+	clipboardParameters = booleanParameter("disable-copy", "disable-paste")
+
+	terminalParameters = joinParameters(
+		textParameter("font-name", "color-scheme", "terminal-type",
+			"typescript-path", "typescript-name"),
+		numericParameter("font-size", "scrollback"),
+		enumParameter("backspace", "127", "8"),
+		booleanParameter("read-only", "create-typescript-path", "typescript-write-existing"),
+	)
+)
+
+func initProtocolInfoMap() (ret map[string]GuacamoleProtocolInfo) {
+	ret = make(map[string]GuacamoleProtocolInfo)
+
+	ret["rdp"] = NewGuacamoleProtocolInfo("rdp", joinParameters(
+		textParameter("hostname", "domain", "username", "initial-program",
+			"printer-name", "drive-name", "drive-path", "client-name",
+			"remote-app", "remote-app-dir", "remote-app-args",
+			"static-channels", "preconnection-blob", "timezone",
+			"cert-fingerprints", "gateway-hostname", "gateway-domain",
+			"gateway-username", "load-balance-info"),
+		sensitiveParameter("password", "gateway-password"),
+		numericParameter("port", "width", "height", "dpi", "preconnection-id",
+			"gateway-port"),
+		enumParameter("color-depth", "8", "16", "24", "32"),
+		enumParameter("security", "any", "nla", "nla-ext", "tls", "vmconnect", "rdp"),
+		enumParameter("server-layout", "en-us-qwerty", "en-gb-qwerty",
+			"de-ch-qwertz", "de-de-qwertz", "fr-be-azerty", "fr-fr-azerty",
+			"fr-ch-qwertz", "hu-hu-qwertz", "it-it-qwerty", "ja-jp-qwerty",
+			"no-no-qwerty", "pt-br-qwerty", "es-es-qwerty", "es-latam-qwerty",
+			"sv-se-qwerty", "tr-tr-qwerty", "da-dk-qwerty", "failsafe"),
+		enumParameter("resize-method", "display-update", "reconnect"),
+		enumParameter("normalize-clipboard", "preserve", "unix", "windows"),
+		booleanParameter("disable-audio", "enable-audio-input", "enable-touch",
+			"enable-printing", "enable-drive", "create-drive-path",
+			"disable-download", "disable-upload", "console", "console-audio",
+			"ignore-cert", "cert-tofu", "disable-auth", "enable-wallpaper",
+			"enable-theming", "enable-font-smoothing",
+			"enable-full-window-drag", "enable-desktop-composition",
+			"enable-menu-animations", "disable-bitmap-caching",
+			"disable-offscreen-caching", "disable-glyph-caching",
+			"disable-gfx", "read-only", "force-lossless"),
+		sftpParameters,
+		recordingParameters,
+		wolParameters,
+		clipboardParameters,
+	)...)
+
+	ret["vnc"] = NewGuacamoleProtocolInfo("vnc", joinParameters(
+		textParameter("hostname", "username", "encodings", "dest-host",
+			"audio-servername"),
+		sensitiveParameter("password"),
+		numericParameter("port", "autoretry", "dest-port", "listen-timeout",
+			"compress-level", "quality-level"),
+		enumParameter("color-depth", "8", "16", "24", "32"),
+		enumParameter("cursor", "local", "remote"),
+		enumParameter("clipboard-encoding", "ISO8859-1", "UTF-8", "UTF-16", "CP1252"),
+		booleanParameter("swap-red-blue", "read-only", "force-lossless",
+			"reverse-connect", "enable-audio", "disable-display-resize"),
+		sftpParameters,
+		recordingParameters,
+		wolParameters,
+		clipboardParameters,
+	)...)
+
+	ret["ssh"] = NewGuacamoleProtocolInfo("ssh", joinParameters(
+		textParameter("hostname", "host-key", "username", "public-key",
+			"command", "locale", "timezone", "sftp-root-directory"),
+		sensitiveParameter("password", "private-key", "passphrase"),
+		numericParameter("port", "server-alive-interval"),
+		booleanParameter("enable-sftp", "sftp-disable-download", "sftp-disable-upload"),
+		terminalParameters,
+		recordingParameters,
+		wolParameters,
+		clipboardParameters,
+	)...)
+
+	ret["telnet"] = NewGuacamoleProtocolInfo("telnet", joinParameters(
+		textParameter("hostname", "username", "username-regex",
+			"password-regex", "login-success-regex", "login-failure-regex"),
+		sensitiveParameter("password"),
+		numericParameter("port"),
+		terminalParameters,
+		recordingParameters,
+		wolParameters,
+		clipboardParameters,
+	)...)
+
+	ret["kubernetes"] = NewGuacamoleProtocolInfo("kubernetes", joinParameters(
+		textParameter("hostname", "namespace", "pod", "container",
+			"exec-command", "ca-cert"),
+		sensitiveParameter("client-cert", "client-key"),
+		numericParameter("port"),
+		booleanParameter("use-ssl", "ignore-cert"),
+		terminalParameters,
+		recordingParameters,
+		clipboardParameters,
+	)...)
+
+	return
+}
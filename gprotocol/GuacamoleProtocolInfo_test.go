@@ -0,0 +1,109 @@
+package gprotocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+func Test_ParameterInfoValidate(t *testing.T) {
+	numeric := NewGuacamoleParameterInfo("port", NUMERIC_PARAMETER, false)
+	boolean := NewGuacamoleParameterInfo("read-only", BOOLEAN_PARAMETER, false)
+	enum := NewGuacamoleParameterInfo("security", ENUM_PARAMETER, false, "nla", "tls")
+	text := NewGuacamoleParameterInfo("hostname", TEXT_PARAMETER, false)
+
+	cases := []struct {
+		parameter GuacamoleParameterInfo
+		value     string
+		valid     bool
+	}{
+		{numeric, "3389", true},
+		{numeric, "-1", true},
+		{numeric, "3389a", false},
+		{numeric, "", true},
+		{boolean, "true", true},
+		{boolean, "false", true},
+		{boolean, "yes", false},
+		{enum, "tls", true},
+		{enum, "TLS", false},
+		{enum, "", true},
+		{text, "any value; at all", true},
+	}
+	for _, one := range cases {
+		err := one.parameter.Validate(one.value)
+		if (err == nil) != one.valid {
+			t.Errorf("%v %q: err %v", one.parameter.GetName(), one.value, err)
+		}
+		if err != nil && (!errors.Is(err, exp.GuacamoleClientException) || !strings.Contains(err.GetMessage(), one.parameter.GetName())) {
+			t.Errorf("%v %q: err %v", one.parameter.GetName(), one.value, err)
+		}
+	}
+}
+
+func Test_BuiltinProtocolInfo(t *testing.T) {
+	for _, name := range []string{"rdp", "vnc", "ssh", "telnet", "kubernetes"} {
+		info, ok := GetProtocolInfo(name)
+		if !ok || info.GetName() != name {
+			t.Errorf("no schema for %v", name)
+			continue
+		}
+		if _, ok = info.GetParameter("hostname"); !ok && name != "kubernetes" {
+			t.Errorf("%v has no hostname", name)
+		}
+		names := info.GetParameterNames()
+		for i := 1; i < len(names); i++ {
+			if names[i-1] >= names[i] {
+				t.Errorf("%v parameter names not sorted: %v", name, names)
+				break
+			}
+		}
+	}
+	rdp, _ := GetProtocolInfo("rdp")
+	if !rdp.IsSensitive("password") || rdp.IsSensitive("hostname") || rdp.IsSensitive("no-such-parameter") {
+		t.Errorf("sensitive rdp parameters misreported")
+	}
+	if _, ok := GetProtocolInfo("no-such-protocol"); ok {
+		t.Errorf("schema found for unknown protocol")
+	}
+}
+
+func Test_ValidateConfiguration(t *testing.T) {
+	config := NewGuacamoleConfiguration()
+	config.SetProtocol("rdp")
+	config.SetParameter("hostname", "desktop")
+	config.SetParameter("port", "3389")
+
+	// Parameters newer than the schema are left to the handshake
+	config.SetParameter("some-future-parameter", "value")
+	if err := ValidateConfiguration(config); err != nil {
+		t.Errorf("valid configuration refused: %v", err)
+	}
+
+	// Every malformed value of a known parameter is reported
+	config.SetParameter("port", "rdp")
+	config.SetParameter("security", "none")
+	err := ValidateConfiguration(config)
+	if !errors.Is(err, exp.GuacamoleClientException) || !strings.Contains(err.GetMessage(), "\"port\"") ||
+		!strings.Contains(err.GetMessage(), "\"security\"") {
+		t.Errorf("malformed configuration: err %v", err)
+	}
+
+	// Joining connections and unknown protocols are not validated
+	join := NewGuacamoleConfiguration2(config)
+	join.SetConnectionID("$260d01da-779b-4ee9-afc1-c16bae885cc7")
+	if err = ValidateConfiguration(join); err != nil {
+		t.Errorf("join refused: %v", err)
+	}
+	config.SetProtocol("custom")
+	if err = ValidateConfiguration(config); err != nil {
+		t.Errorf("unknown protocol refused: %v", err)
+	}
+
+	// Registered schemas replace the built-in ones
+	RegisterProtocolInfo(NewGuacamoleProtocolInfo("custom", NewGuacamoleParameterInfo("port", NUMERIC_PARAMETER, false)))
+	if err = ValidateConfiguration(config); err == nil {
+		t.Errorf("registered schema not applied")
+	}
+}
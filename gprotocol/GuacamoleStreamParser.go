@@ -0,0 +1,184 @@
+package gprotocol
+
+import (
+	"unicode/utf8"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+// GuacamoleStreamParser *
+//  * Parser for an unbounded stream of Guacamole instructions which may be
+//  * split at arbitrary points, such as the body of an HTTP tunnel "write"
+//  * request. Incomplete trailing data is kept until the next append.
+//  * Element lengths are counted in characters, not bytes, as required by
+//  * the Guacamole protocol.
+type GuacamoleStreamParser struct {
+	/**
+	 * Data received but not yet part of a complete instruction.
+	 */
+	pending []byte
+
+	/**
+	 * The maximum length of each instruction, in characters, and the
+	 * maximum number of elements of each instruction, including its
+	 * opcode.
+	 */
+	maxLength   int
+	maxElements int
+
+	/**
+	 * The kind of exception thrown for instructions exceeding the limits.
+	 */
+	limitKind exp.ExceptionKind
+}
+
+// NewGuacamoleStreamParser Construct function
+func NewGuacamoleStreamParser() (ret GuacamoleStreamParser) {
+	return NewGuacamoleStreamParser2(INSTRUCTION_MAX_LENGTH, INSTRUCTION_MAX_ELEMENTS, exp.GuacamoleServerException)
+}
+
+/*NewGuacamoleStreamParser2 *
+ * Creates a parser enforcing the given limits, which are capped at
+ * INSTRUCTION_MAX_LENGTH and INSTRUCTION_MAX_ELEMENTS, the limits of
+ * NewGuacamoleStreamParser.
+ *
+ * @param maxLength The maximum length of each instruction, in characters,
+ *                  or 0 for INSTRUCTION_MAX_LENGTH.
+ * @param maxElements The maximum number of elements of each instruction,
+ *                    or 0 for INSTRUCTION_MAX_ELEMENTS.
+ * @param limitKind The kind of exception thrown for instructions exceeding
+ *                  the limits, such as GuacamoleClientOverrunException for
+ *                  client input. Malformed data is always reported as
+ *                  GuacamoleServerException.
+ */
+func NewGuacamoleStreamParser2(maxLength, maxElements int, limitKind exp.ExceptionKind) (ret GuacamoleStreamParser) {
+	if maxLength <= 0 || maxLength > INSTRUCTION_MAX_LENGTH {
+		maxLength = INSTRUCTION_MAX_LENGTH
+	}
+	if maxElements <= 0 || maxElements > INSTRUCTION_MAX_ELEMENTS {
+		maxElements = INSTRUCTION_MAX_ELEMENTS
+	}
+	ret.pending = make([]byte, 0, 256)
+	ret.maxLength = maxLength
+	ret.maxElements = maxElements
+	ret.limitKind = limitKind
+	return
+}
+
+/*Append *
+ * Appends the given data to the stream, returning every instruction which
+ * is now complete.
+ *
+ * @param chunk The data to append.
+ * @return All complete instructions, in order.
+ * @throws GuacamoleException If the stream is not valid Guacamole protocol
+ *                            data, or an instruction exceeds the limits
+ *                            of the parser. The parser must not be used
+ *                            after an error.
+ */
+func (opt *GuacamoleStreamParser) Append(chunk []byte) (ret []GuacamoleInstruction, err exp.ExceptionInterface) {
+	opt.pending = append(opt.pending, chunk...)
+
+	start := 0
+	for start < len(opt.pending) {
+		instruction, consumed, complete, e := opt.parseOneInstruction(opt.pending[start:])
+		if e != nil {
+			err = e
+			return
+		}
+		if !complete {
+			break
+		}
+		ret = append(ret, instruction)
+		start += consumed
+	}
+
+	// Keep only the incomplete tail
+	opt.pending = append(opt.pending[:0], opt.pending[start:]...)
+	return
+}
+
+// Pending Returns the number of bytes of incomplete instruction data held.
+func (opt *GuacamoleStreamParser) Pending() int {
+	return len(opt.pending)
+}
+
+/**
+ * Parses the instruction at the start of the given data, returning the
+ * instruction, the number of bytes it occupies, and whether it is complete.
+ */
+func (opt *GuacamoleStreamParser) parseOneInstruction(data []byte) (ret GuacamoleInstruction, consumed int,
+	complete bool, err exp.ExceptionInterface) {
+
+	elements := make([]string, 0, 4)
+	characters := 0
+	i := 0
+
+	for {
+		// Parse element length
+		length, digits := 0, 0
+		for {
+			if i >= len(data) {
+				return
+			}
+			c := data[i]
+			i++
+			characters++
+			if c == '.' {
+				break
+			}
+			if c < '0' || c > '9' {
+				err = exp.GuacamoleServerException.Throw("Non-numeric character in element length.")
+				return
+			}
+			digits++
+			if digits > INSTRUCTION_MAX_DIGITS {
+				err = opt.limitKind.Throw("Element length has too many digits.")
+				return
+			}
+			length = length*10 + int(c-'0')
+		}
+
+		if characters+length+1 > opt.maxLength {
+			err = opt.limitKind.Throw("Instruction exceeds maximum length.")
+			return
+		}
+
+		// Parse element content, counted in characters
+		contentStart := i
+		for n := 0; n < length; n++ {
+			if i >= len(data) || !utf8.FullRune(data[i:]) {
+				return
+			}
+			_, size := utf8.DecodeRune(data[i:])
+			i += size
+		}
+		characters += length
+
+		// Read terminator
+		if i >= len(data) {
+			return
+		}
+		terminator := data[i]
+		i++
+		characters++
+
+		elements = append(elements, string(data[contentStart:i-1]))
+		if len(elements) > opt.maxElements {
+			err = opt.limitKind.Throw("Instruction contains too many elements.")
+			return
+		}
+
+		switch terminator {
+		case ';':
+			ret = NewGuacamoleInstruction(elements[0], elements[1:]...)
+			consumed = i
+			complete = true
+			return
+		case ',':
+		default:
+			err = exp.GuacamoleServerException.Throw("Element terminator of instruction was not ';' nor ','")
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package gprotocol
+
+import (
+	"time"
+)
+
+const (
+	/*USERNAME_TOKEN *
+	 * The name of the username token added via AddStandardTokens().
+	 */
+	USERNAME_TOKEN = "GUAC_USERNAME"
+
+	/*PASSWORD_TOKEN *
+	 * The name of the password token added via AddStandardTokens().
+	 */
+	PASSWORD_TOKEN = "GUAC_PASSWORD"
+
+	/*CLIENT_HOSTNAME_TOKEN *
+	 * The name of the client hostname token added via AddStandardTokens().
+	 */
+	CLIENT_HOSTNAME_TOKEN = "GUAC_CLIENT_HOSTNAME"
+
+	/*CLIENT_ADDRESS_TOKEN *
+	 * The name of the client address token added via AddStandardTokens().
+	 */
+	CLIENT_ADDRESS_TOKEN = "GUAC_CLIENT_ADDRESS"
+
+	/*DATE_TOKEN *
+	 * The name of the date token (server-local time) added via
+	 * AddStandardTokens().
+	 */
+	DATE_TOKEN = "GUAC_DATE"
+
+	/*TIME_TOKEN *
+	 * The name of the time token (server-local time) added via
+	 * AddStandardTokens().
+	 */
+	TIME_TOKEN = "GUAC_TIME"
+
+	/*DATE_FORMAT *
+	 * The date format that should be used for the date token. This format must
+	 * be compatible with time.Format. Equivalent to Java's "yyyyMMdd".
+	 */
+	DATE_FORMAT = "20060102"
+
+	/*TIME_FORMAT *
+	 * The format that should be used for the time token. This format must
+	 * be compatible with time.Format. Equivalent to Java's "HHmmss".
+	 */
+	TIME_FORMAT = "150405"
+)
+
+// StandardCredentials *
+//  * The information about the connecting user from which the standard
+//  * tokens are derived. Empty fields produce no token.
+type StandardCredentials struct {
+	Username       string
+	Password       string
+	RemoteHostname string
+	RemoteAddress  string
+}
+
+/*AddStandardTokens *
+ * Adds tokens which are standardized by guacamole-ext to the given
+ * TokenFilter using the values from the given credentials and the current
+ * time. Tokens whose values are unknown are not set.
+ *
+ * @param filter The TokenFilter to add standard tokens to.
+ * @param credentials The credentials to use when populating the
+ *                    GUAC_USERNAME, GUAC_PASSWORD, GUAC_CLIENT_HOSTNAME and
+ *                    GUAC_CLIENT_ADDRESS tokens.
+ */
+func AddStandardTokens(filter *TokenFilter, credentials StandardCredentials) {
+	AddStandardTokens2(filter, credentials, time.Now())
+}
+
+/*AddStandardTokens2 *
+ * Adds tokens which are standardized by guacamole-ext to the given
+ * TokenFilter, using the given time for GUAC_DATE and GUAC_TIME.
+ *
+ * @param filter The TokenFilter to add standard tokens to.
+ * @param credentials The credentials of the connecting user.
+ * @param now The time to use when populating the date and time tokens.
+ */
+func AddStandardTokens2(filter *TokenFilter, credentials StandardCredentials, now time.Time) {
+
+	// Add date/time tokens (server-local time)
+	filter.SetToken(DATE_TOKEN, now.Format(DATE_FORMAT))
+	filter.SetToken(TIME_TOKEN, now.Format(TIME_FORMAT))
+
+	// Add username token
+	if len(credentials.Username) > 0 {
+		filter.SetToken(USERNAME_TOKEN, credentials.Username)
+	}
+
+	// Add password token
+	if len(credentials.Password) > 0 {
+		filter.SetToken(PASSWORD_TOKEN, credentials.Password)
+	}
+
+	// Add client hostname token
+	if len(credentials.RemoteHostname) > 0 {
+		filter.SetToken(CLIENT_HOSTNAME_TOKEN, credentials.RemoteHostname)
+	}
+
+	// Add client address token
+	if len(credentials.RemoteAddress) > 0 {
+		filter.SetToken(CLIENT_ADDRESS_TOKEN, credentials.RemoteAddress)
+	}
+}
@@ -0,0 +1,233 @@
+package gprotocol
+
+import (
+	"strings"
+)
+
+const (
+	/*TOKEN_MODIFIER_LOWER *
+	 * The modifier which converts the value of a token to lowercase, as in
+	 * ${GUAC_USERNAME:LOWER}.
+	 */
+	TOKEN_MODIFIER_LOWER = "LOWER"
+
+	/*TOKEN_MODIFIER_UPPER *
+	 * The modifier which converts the value of a token to uppercase, as in
+	 * ${GUAC_USERNAME:UPPER}.
+	 */
+	TOKEN_MODIFIER_UPPER = "UPPER"
+)
+
+// TokenProviderInterface Tool interface for TokenFilter
+//  * Supplies the value of tokens which are not explicitly set on a
+//  * TokenFilter. The returned flag must be false if the provider does not
+//  * know the given token.
+type TokenProviderInterface func(name string) (value string, ok bool)
+
+// TokenFilter *
+//  * Filtering object which replaces tokens of the form "${TOKEN_NAME}" with
+//  * their corresponding values. Tokens are case-sensitive and may contain
+//  * only letters, numbers, and underscores. A token may be followed by a
+//  * modifier, as in "${TOKEN_NAME:LOWER}". Any token which is not defined
+//  * is left untouched, and the sequence "$${" escapes a literal "${".
+type TokenFilter struct {
+	/**
+	 * The values of all known tokens, indexed by token name.
+	 */
+	tokenValues map[string]string
+
+	/**
+	 * Providers consulted, in order, for tokens without an explicit value.
+	 */
+	providers []TokenProviderInterface
+}
+
+// NewTokenFilter Construct function
+//  * Creates a new TokenFilter which has no associated tokens. Tokens must
+//  * later be given using SetToken() or SetTokens().
+func NewTokenFilter() (ret TokenFilter) {
+	ret.tokenValues = make(map[string]string)
+	ret.providers = make([]TokenProviderInterface, 0, 1)
+	return
+}
+
+/*SetToken *
+ * Sets the token having the given name to the given value. Any existing
+ * value for that token is replaced.
+ *
+ * @param name The name of the token to set.
+ * @param value The value to set the token to.
+ */
+func (opt *TokenFilter) SetToken(name, value string) {
+	opt.tokenValues[name] = value
+}
+
+/*GetToken *
+ * Returns the value of the token with the given name, consulting the
+ * registered token providers if no explicit value has been set.
+ *
+ * @param name The name of the token to return.
+ * @return The value of the token with the given name, and whether such a
+ *         token is defined.
+ */
+func (opt *TokenFilter) GetToken(name string) (value string, ok bool) {
+	if value, ok = opt.tokenValues[name]; ok {
+		return
+	}
+	for _, provider := range opt.providers {
+		if value, ok = provider(name); ok {
+			return
+		}
+	}
+	return
+}
+
+/*UnsetToken *
+ * Removes the value of the token with the given name.
+ *
+ * @param name The name of the token to remove.
+ */
+func (opt *TokenFilter) UnsetToken(name string) {
+	delete(opt.tokenValues, name)
+}
+
+/*SetTokens *
+ * Replaces all current token values with the contents of the given map,
+ * where each map key represents a token name, and each map value
+ * represents a token value.
+ *
+ * @param tokens A map containing the token names and corresponding values
+ *               to assign.
+ */
+func (opt *TokenFilter) SetTokens(tokens map[string]string) {
+	opt.tokenValues = make(map[string]string)
+	for k, v := range tokens {
+		opt.SetToken(k, v)
+	}
+}
+
+/*GetTokens *
+ * Returns a map of all explicitly set tokens, where each key is a token
+ * name, and each value is the corresponding token value. Changes to this
+ * map will affect the tokens stored within this filter.
+ *
+ * @return A map of all explicitly set token names and their values.
+ */
+func (opt *TokenFilter) GetTokens() map[string]string {
+	return opt.tokenValues
+}
+
+/*AddTokenProvider *
+ * Registers a provider which will be consulted for any token which has no
+ * explicitly set value. Providers are consulted in registration order.
+ *
+ * @param provider The provider to register.
+ */
+func (opt *TokenFilter) AddTokenProvider(provider TokenProviderInterface) {
+	if provider != nil {
+		opt.providers = append(opt.providers, provider)
+	}
+}
+
+func isTokenNameChar(c byte) bool {
+	return c == '_' ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= '0' && c <= '9')
+}
+
+/**
+ * Applies the given modifier to the given token value. Unknown modifiers
+ * leave the value untouched.
+ */
+func applyTokenModifier(value, modifier string) string {
+	switch modifier {
+	case TOKEN_MODIFIER_LOWER:
+		return strings.ToLower(value)
+	case TOKEN_MODIFIER_UPPER:
+		return strings.ToUpper(value)
+	}
+	return value
+}
+
+/*Filter *
+ * Filters the given string, replacing any tokens with their corresponding
+ * values.
+ *
+ * @param input The string to filter.
+ * @return A copy of the input string, with any tokens replaced with their
+ *         corresponding values.
+ */
+func (opt *TokenFilter) Filter(input string) string {
+	var output strings.Builder
+
+	for i := 0; i < len(input); {
+
+		// Copy everything which cannot start a token
+		if input[i] != '$' {
+			output.WriteByte(input[i])
+			i++
+			continue
+		}
+
+		// "$${" is an escaped "${"
+		if strings.HasPrefix(input[i:], "$${") {
+			output.WriteString("${")
+			i += 3
+			continue
+		}
+
+		// Anything other than "${" is literal
+		if !strings.HasPrefix(input[i:], "${") {
+			output.WriteByte(input[i])
+			i++
+			continue
+		}
+
+		// Locate end of token, leaving unterminated tokens untouched
+		end := strings.IndexByte(input[i:], '}')
+		if end == -1 {
+			output.WriteString(input[i:])
+			break
+		}
+		token := input[i : i+end+1]
+		body := token[2 : len(token)-1]
+		i += end + 1
+
+		// Split off modifier, if any
+		name, modifier := body, ""
+		if colon := strings.IndexByte(body, ':'); colon != -1 {
+			name, modifier = body[:colon], body[colon+1:]
+		}
+
+		// Validate token name
+		valid := len(name) > 0
+		for j := 0; j < len(name) && valid; j++ {
+			valid = isTokenNameChar(name[j])
+		}
+
+		// Replace known tokens, leaving anything else as-is
+		if value, ok := opt.GetToken(name); valid && ok {
+			output.WriteString(applyTokenModifier(value, modifier))
+		} else {
+			output.WriteString(token)
+		}
+	}
+
+	return output.String()
+}
+
+/*FilterValues *
+ * Given an arbitrary map containing string values, replace each non-empty
+ * value with the result of filtering that value through this TokenFilter.
+ * The map is modified in place.
+ *
+ * @param values The map whose values should be filtered.
+ */
+func (opt *TokenFilter) FilterValues(values map[string]string) {
+	for k, v := range values {
+		if len(v) > 0 {
+			values[k] = opt.Filter(v)
+		}
+	}
+}
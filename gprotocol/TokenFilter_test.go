@@ -0,0 +1,64 @@
+package gprotocol
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_TokenFilter(t *testing.T) {
+	filter := NewTokenFilter()
+	filter.SetToken("HOST", "Example.ORG")
+	filter.AddTokenProvider(func(name string) (string, bool) {
+		if name == "CUSTOM" {
+			return "custom", true
+		}
+		return "", false
+	})
+
+	cases := map[string]string{
+		"${HOST}":             "Example.ORG",
+		"${HOST:LOWER}":       "example.org",
+		"${HOST:UPPER}":       "EXAMPLE.ORG",
+		"${HOST:OTHER}":       "Example.ORG",
+		"$${HOST}":            "${HOST}",
+		"${MISSING}":          "${MISSING}",
+		"${CUSTOM}-${HOST}":   "custom-Example.ORG",
+		"$HOST ${HOST":        "$HOST ${HOST",
+		"${BAD-NAME}":         "${BAD-NAME}",
+		"cost: $5, ${CUSTOM}": "cost: $5, custom",
+	}
+	for input, expected := range cases {
+		if output := filter.Filter(input); output != expected {
+			t.Errorf("Filter(%q) = %q, expected %q", input, output, expected)
+		}
+	}
+}
+
+func Test_ConfigurationResolve(t *testing.T) {
+	filter := NewTokenFilter()
+	AddStandardTokens2(&filter, StandardCredentials{
+		Username:      "Alice",
+		RemoteAddress: "10.0.0.1",
+	}, time.Date(2019, 5, 6, 7, 8, 9, 0, time.Local))
+
+	template := NewGuacamoleConfiguration()
+	template.SetProtocol("rdp")
+	template.SetParameter("username", "${GUAC_USERNAME:LOWER}")
+	template.SetParameter("recording-name", "${GUAC_DATE}-${GUAC_TIME}-${GUAC_CLIENT_ADDRESS}")
+	template.SetParameter("password", "${GUAC_PASSWORD}")
+
+	resolved := template.Resolve(&filter)
+
+	if v := resolved.GetParameter("username"); v != "alice" {
+		t.Errorf("username = %q", v)
+	}
+	if v := resolved.GetParameter("recording-name"); v != "20190506-070809-10.0.0.1" {
+		t.Errorf("recording-name = %q", v)
+	}
+	if v := resolved.GetParameter("password"); v != "${GUAC_PASSWORD}" {
+		t.Errorf("password = %q", v)
+	}
+	if v := template.GetParameter("username"); v != "${GUAC_USERNAME:LOWER}" {
+		t.Errorf("template was modified: %q", v)
+	}
+}
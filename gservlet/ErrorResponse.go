@@ -0,0 +1,102 @@
+package gservlet
+
+import (
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+
+	guid "github.com/gofrs/uuid"
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+const (
+	/*CORRELATION_ID_HEADER *
+	 * The header giving the correlation ID of a request, sent with every
+	 * error response. A correlation ID given by the client is kept if made
+	 * of at most 64 letters, digits and dashes, otherwise one is generated.
+	 */
+	CORRELATION_ID_HEADER = "X-Correlation-ID"
+
+	/*ERROR_MESSAGE_KEY_PREFIX *
+	 * The prefix of the translation key of every error, followed by its
+	 * Guacamole status code in hexadecimal, as the keys of tunnel errors
+	 * in the translations of the Guacamole web application.
+	 */
+	ERROR_MESSAGE_KEY_PREFIX = "CLIENT.ERROR_TUNNEL_"
+)
+
+// ErrorResponse *
+//  * The JSON body of an error response, sent to clients accepting
+//  * "application/json" in addition to the Guacamole-Status-Code and
+//  * Guacamole-Error-Message headers sent to every client.
+type ErrorResponse struct {
+	Status        string `json:"status"`
+	StatusCode    int    `json:"statusCode"`
+	HTTPCode      int    `json:"httpCode"`
+	WebSocketCode int    `json:"websocketCode"`
+	Message       string `json:"message"`
+	MessageKey    string `json:"messageKey"`
+	CorrelationID string `json:"correlationID"`
+}
+
+/*NewErrorResponse *
+ * Returns the error response reporting the given status and message.
+ *
+ * @param status The status of the error.
+ * @param message A human-readable message that can be presented to the user.
+ * @param correlationID The correlation ID of the failed request.
+ */
+func NewErrorResponse(status exp.GuacamoleStatus, message, correlationID string) ErrorResponse {
+	return ErrorResponse{
+		Status:        status.String(),
+		StatusCode:    status.GetGuacamoleStatusCode(),
+		HTTPCode:      status.GetHTTPStatusCode(),
+		WebSocketCode: status.GetWebSocketCode(),
+		Message:       message,
+		MessageKey:    ERROR_MESSAGE_KEY_PREFIX + strings.ToUpper(strconv.FormatInt(int64(status.GetGuacamoleStatusCode()), 16)),
+		CorrelationID: correlationID,
+	}
+}
+
+/**
+ * The correlation IDs accepted from clients, which are echoed in headers,
+ * bodies and logs.
+ */
+var correlationIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+/**
+ * Returns the correlation ID given by the client with the given request,
+ * or a new one if none or an invalid one was given.
+ */
+func correlationID(request HTTPServletRequestInterface) string {
+	if headers, ok := request.(HTTPServletRequestHeaderInterface); ok {
+		if id := headers.GetHeader(CORRELATION_ID_HEADER); correlationIDPattern.MatchString(id) {
+			return id
+		}
+	}
+	id, _ := guid.NewV4()
+	return id.String()
+}
+
+/**
+ * Returns whether the client explicitly accepts JSON responses to the
+ * given request. The JavaScript client, which accepts anything, does not.
+ */
+func acceptsJSON(request HTTPServletRequestInterface) bool {
+	headers, ok := request.(HTTPServletRequestHeaderInterface)
+	if !ok {
+		return false
+	}
+	for _, one := range strings.Split(headers.GetHeader("Accept"), ",") {
+		mediaType, params, e := mime.ParseMediaType(strings.TrimSpace(one))
+		if e != nil || mediaType != "application/json" {
+			continue
+		}
+		if q, e := strconv.ParseFloat(params["q"], 64); e == nil && q == 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
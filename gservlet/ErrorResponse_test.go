@@ -0,0 +1,110 @@
+package gservlet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gnet"
+)
+
+func Test_NewErrorResponse(t *testing.T) {
+	one := NewErrorResponse(exp.UPSTREAM_NOT_FOUND, "Not found.", "abc")
+	if one.Status != "UPSTREAM_NOT_FOUND" || one.StatusCode != 0x0207 || one.HTTPCode != 502 ||
+		one.WebSocketCode != 1011 || one.MessageKey != "CLIENT.ERROR_TUNNEL_207" || one.CorrelationID != "abc" {
+		t.Errorf("NewErrorResponse = %+v", one)
+	}
+}
+
+func Test_SendErrorNegotiation(t *testing.T) {
+	servlet := NewGuacamoleHTTPTunnelServlet(nil, nil, nil)
+	defer servlet.Destroy()
+
+	cases := map[string]bool{
+		"":                                  false,
+		"*/*":                               false,
+		"application/json":                  true,
+		"text/html, application/json;q=0.9": true,
+		"application/json;q=0":              false,
+	}
+	for accept, expected := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/tunnel?read:"+testUUID+":0", nil)
+		request.Header.Set("Accept", accept)
+		request.Header.Set(CORRELATION_ID_HEADER, "abc")
+		recorder := httptest.NewRecorder()
+		servlet.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusNotFound || recorder.Header().Get("Guacamole-Status-Code") != "516" ||
+			recorder.Header().Get(CORRELATION_ID_HEADER) != "abc" {
+			t.Errorf("Accept %q: code %v, headers %v", accept, recorder.Code, recorder.Header())
+		}
+
+		var body ErrorResponse
+		decoded := json.Unmarshal(recorder.Body.Bytes(), &body) == nil
+		if decoded != expected {
+			t.Errorf("Accept %q: JSON body %v, expected %v", accept, decoded, expected)
+		}
+		if decoded && (body.Status != "RESOURCE_NOT_FOUND" || body.CorrelationID != "abc") {
+			t.Errorf("Accept %q: body %+v", accept, body)
+		}
+	}
+}
+
+func Test_SendErrorMessages(t *testing.T) {
+	var failure error
+	servlet := NewGuacamoleHTTPTunnelServlet(func(request HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error) {
+		return nil, failure
+	}, nil, nil)
+	defer servlet.Destroy()
+
+	// Client errors of every kind are shown, server errors are not
+	cases := []struct {
+		query   string
+		failure error
+		code    int
+		message string
+	}{
+		{"read:" + testUUID + ":0", nil, http.StatusNotFound, "No such tunnel."},
+		{"connect", exp.GuacamoleUnauthorizedException.Throw("Token expired."), http.StatusForbidden, "Token expired."},
+		{"connect", exp.GuacamoleResourceConflictException.Throw("In use."), http.StatusConflict, "In use."},
+		{"connect", exp.GuacamoleSessionClosedException.Throw("Closed."), http.StatusNotFound, "Closed."},
+		{"connect", exp.GuacamoleServerException.Throw("Disk full."), http.StatusInternalServerError, "Internal server error."},
+	}
+	for _, one := range cases {
+		failure = one.failure
+		request := httptest.NewRequest(http.MethodPost, "/tunnel?"+one.query, nil)
+		request.Header.Set("Accept", "application/json")
+		recorder := httptest.NewRecorder()
+		servlet.ServeHTTP(recorder, request)
+
+		var body ErrorResponse
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		if recorder.Code != one.code || recorder.Header().Get("Guacamole-Error-Message") != one.message ||
+			body.Message != one.message {
+			t.Errorf("%v (%v): code %v, headers %v, body %+v", one.query, one.failure, recorder.Code, recorder.Header(), body)
+		}
+	}
+}
+
+func Test_CorrelationID(t *testing.T) {
+	cases := map[string]bool{
+		"abc":                                  true,
+		"0f8fad5b-d9cb-469f-a165-70867728950e": true,
+		strings.Repeat("a", 64):                true,
+		strings.Repeat("a", 65):                false,
+		"a b":                                  false,
+		"abc\r\nSet-Cookie: x=y":               false,
+		"<script>":                             false,
+	}
+	for given, kept := range cases {
+		request := NewHTTPRequestAdapter(httptest.NewRequest(http.MethodGet, "/tunnel", nil))
+		request.GetRequest().Header.Set(CORRELATION_ID_HEADER, given)
+		id := correlationID(request)
+		if (id == given) != kept || !correlationIDPattern.MatchString(id) {
+			t.Errorf("correlationID(%q) = %q", given, id)
+		}
+	}
+}
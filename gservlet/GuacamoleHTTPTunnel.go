@@ -1,9 +1,13 @@
 package gservlet
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	"github.com/hsfish/guacamole_client_go/gtrace"
 )
 
 /*GuacamoleHTTPTunnel ==> DelegatingGuacamoleTunnel
@@ -19,9 +23,68 @@ import (
 type GuacamoleHTTPTunnel struct {
 	gnet.DelegatingGuacamoleTunnel
 	/**
-	 * The last time this tunnel was accessed.
+	 * The last time this tunnel was accessed. Guarded by stateLock.
 	 */
 	lastAccessedTime time.Time
+
+	/**
+	 * The span covering the lifetime of this tunnel, ended when the tunnel
+	 * is removed from its GuacamoleHTTPTunnelMap.
+	 */
+	span gtrace.Span
+
+	/**
+	 * Whether an IDLE event was published since this tunnel was last
+	 * accessed. Guarded by stateLock.
+	 */
+	idle bool
+
+	/**
+	 * The time this tunnel was created, and the last time the user sent a
+	 * "key" or "mouse" instruction through it.
+	 */
+	createdTime   time.Time
+	lastInputTime time.Time
+
+	/**
+	 * Whether the user was warned of the idle timeout since their last
+	 * input, and whether they were warned of the maximum session duration.
+	 */
+	idleWarned     bool
+	durationWarned bool
+
+	/**
+	 * Instructions queued by the server for the client, sent ahead of the
+	 * next instructions read from the wrapped tunnel.
+	 */
+	pending [][]byte
+
+	/**
+	 * The record of instructions sent to the client, if the tunnel is
+	 * resumable, or nil.
+	 */
+	replay *ReplayBuffer
+
+	/**
+	 * The time the client's connection was lost, while it may still resume
+	 * the tunnel, or the zero time.
+	 */
+	detachedTime time.Time
+
+	/**
+	 * The principal which connected this tunnel, as returned by the
+	 * TunnelOwnerResolverInterface of the servlet, or "" if unbound.
+	 */
+	owner string
+
+	/**
+	 * The gate limiting the input written to this tunnel, created by its
+	 * first write if the servlet limits input. Only used while the writer
+	 * is acquired.
+	 */
+	input *InputGate
+
+	stateLock sync.Mutex
 }
 
 /*NewGuacamoleHTTPTunnel *
@@ -34,6 +97,9 @@ type GuacamoleHTTPTunnel struct {
  */
 func NewGuacamoleHTTPTunnel(wrappedTunnel gnet.GuacamoleTunnel) (ret GuacamoleHTTPTunnel) {
 	ret.DelegatingGuacamoleTunnel = gnet.NewDelegatingGuacamoleTunnel(wrappedTunnel)
+	ret.span = gtrace.SpanFromContext(context.Background())
+	ret.createdTime = time.Now()
+	ret.lastInputTime = ret.createdTime
 	ret.Access()
 	return
 }
@@ -42,7 +108,24 @@ func NewGuacamoleHTTPTunnel(wrappedTunnel gnet.GuacamoleTunnel) (ret GuacamoleHT
  * Updates this tunnel, marking it as recently accessed.
  */
 func (opt *GuacamoleHTTPTunnel) Access() {
+	opt.stateLock.Lock()
 	opt.lastAccessedTime = time.Now()
+	opt.idle = false
+	opt.stateLock.Unlock()
+}
+
+/**
+ * Marks this tunnel as idle, returning whether it was not already marked
+ * since it was last accessed.
+ */
+func (opt *GuacamoleHTTPTunnel) markIdle() bool {
+	opt.stateLock.Lock()
+	defer opt.stateLock.Unlock()
+	if opt.idle {
+		return false
+	}
+	opt.idle = true
+	return true
 }
 
 /*GetLastAccessedTime *
@@ -54,5 +137,124 @@ func (opt *GuacamoleHTTPTunnel) Access() {
  *     The time this tunnel was last accessed.
  */
 func (opt *GuacamoleHTTPTunnel) GetLastAccessedTime() time.Time {
+	opt.stateLock.Lock()
+	defer opt.stateLock.Unlock()
 	return opt.lastAccessedTime
 }
+
+/*Input *
+ * Updates this tunnel, marking that the user has just sent keyboard or
+ * mouse input.
+ */
+func (opt *GuacamoleHTTPTunnel) Input() {
+	opt.stateLock.Lock()
+	opt.lastInputTime = time.Now()
+	opt.idleWarned = false
+	opt.stateLock.Unlock()
+}
+
+/*GetCreatedTime *
+ * Returns the time this tunnel was created.
+ */
+func (opt *GuacamoleHTTPTunnel) GetCreatedTime() time.Time {
+	return opt.createdTime
+}
+
+/*GetLastInputTime *
+ * Returns the time the user last sent a "key" or "mouse" instruction
+ * through this tunnel, or the time it was created if they never did.
+ */
+func (opt *GuacamoleHTTPTunnel) GetLastInputTime() time.Time {
+	opt.stateLock.Lock()
+	defer opt.stateLock.Unlock()
+	return opt.lastInputTime
+}
+
+/**
+ * Queues the given instruction to be sent to the client ahead of the next
+ * instructions read from the wrapped tunnel.
+ */
+func (opt *GuacamoleHTTPTunnel) queue(instruction gprotocol.GuacamoleInstruction) {
+	opt.stateLock.Lock()
+	opt.pending = append(opt.pending, []byte(instruction.String()))
+	opt.stateLock.Unlock()
+}
+
+/**
+ * Removes and returns every queued instruction.
+ */
+func (opt *GuacamoleHTTPTunnel) takePending() (ret [][]byte) {
+	opt.stateLock.Lock()
+	ret, opt.pending = opt.pending, nil
+	opt.stateLock.Unlock()
+	return
+}
+
+/*IsResumable *
+ * Returns whether a client may resume this tunnel after losing its
+ * connection.
+ */
+func (opt *GuacamoleHTTPTunnel) IsResumable() bool {
+	return opt.replay != nil
+}
+
+/**
+ * Marks that the client's connection was lost, leaving the tunnel open for
+ * it to resume.
+ */
+func (opt *GuacamoleHTTPTunnel) detach() {
+	opt.stateLock.Lock()
+	if opt.detachedTime.IsZero() {
+		opt.detachedTime = time.Now()
+	}
+	opt.stateLock.Unlock()
+}
+
+/**
+ * Marks that the client has resumed the tunnel.
+ */
+func (opt *GuacamoleHTTPTunnel) attach() {
+	opt.stateLock.Lock()
+	opt.detachedTime = time.Time{}
+	opt.stateLock.Unlock()
+}
+
+/**
+ * Returns the time the client's connection was lost, and whether it is
+ * still awaited.
+ */
+func (opt *GuacamoleHTTPTunnel) getDetachedTime() (ret time.Time, detached bool) {
+	opt.stateLock.Lock()
+	defer opt.stateLock.Unlock()
+	return opt.detachedTime, !opt.detachedTime.IsZero()
+}
+
+// TunnelStatus *
+//  * The state of a GuacamoleHTTPTunnel, as answered to "status" requests.
+type TunnelStatus struct {
+	UUID         string    `json:"uuid"`
+	Protocol     string    `json:"protocol,omitempty"`
+	ConnectionID string    `json:"connectionID,omitempty"`
+	Open         bool      `json:"open"`
+	Resumable    bool      `json:"resumable"`
+	Created      time.Time `json:"created"`
+	LastAccessed time.Time `json:"lastAccessed"`
+	LastInput    time.Time `json:"lastInput"`
+}
+
+/*NewTunnelStatus *
+ * Returns the current state of the given tunnel.
+ *
+ * @param tunnel The tunnel.
+ */
+func NewTunnelStatus(tunnel *GuacamoleHTTPTunnel) (ret TunnelStatus) {
+	ret.UUID = tunnel.GetUUID().String()
+	ret.Protocol = tunnelProtocol(tunnel)
+	ret.ConnectionID = gnet.GetSocketConnectionID(tunnel.GetSocket())
+	ret.Open = tunnel.IsOpen()
+	ret.Resumable = tunnel.IsResumable()
+	ret.Created = tunnel.GetCreatedTime()
+	ret.LastAccessed = tunnel.GetLastAccessedTime()
+	ret.LastInput = tunnel.GetLastInputTime()
+	return
+}
@@ -1,11 +1,15 @@
 package gservlet
 
 import (
-	"fmt"
+	"context"
 	"sync"
 	"time"
 
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gevent"
+	"github.com/hsfish/guacamole_client_go/gmetrics"
 	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gtrace"
 	logger "github.com/sirupsen/logrus"
 )
 
@@ -27,9 +31,17 @@ const TunnelTimeout = 10 * time.Second
  */
 type GuacamoleHTTPTunnelMap struct {
 	/**
-	 * Executor service which runs the periodic tunnel timeout task.
+	 * The time between runs of the periodic tunnel timeout task.
 	 */
-	executor []*time.Ticker
+	sweepInterval time.Duration
+
+	/**
+	 * Closed to stop the periodic tunnel timeout task, which closes done
+	 * once it has stopped.
+	 */
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
 
 	/**
 	 * The maximum amount of time to allow between accesses to any one
@@ -41,80 +53,431 @@ type GuacamoleHTTPTunnelMap struct {
 	 * Map of all tunnels that are using HTTP, indexed by tunnel UUID.
 	 */
 	tunnelMap     map[string]*GuacamoleHTTPTunnel
-	doStopConnect DoConnectStopInterface
 	tunnelMapLock sync.RWMutex
+
+	/**
+	 * The bus receiving the IDLE, TIMED_OUT and CLOSED events of tunnels.
+	 */
+	events *gevent.EventBus
+
+	/**
+	 * The limits on the length of sessions.
+	 */
+	policy SessionPolicy
+
+	/**
+	 * The errors of tunnels recently closed by the session policy, indexed
+	 * by tunnel UUID.
+	 */
+	tombstones map[string]tombstone
+
+	/**
+	 * Whether new tunnels are refused because the map is draining or was
+	 * shut down. Tunnels left at drainDeadline are closed with drainErr.
+	 */
+	draining      bool
+	shutdown      bool
+	drainDeadline time.Time
+	drainErr      exp.ExceptionInterface
+
+	/**
+	 * How long tunnels may await a resuming client, and the size of their
+	 * replay buffers.
+	 */
+	resumeWindow     time.Duration
+	replayBufferSize int
+}
+
+/**
+ * The record of a tunnel closed by the session policy, by the server, or
+ * by an error reported by guacd.
+ */
+type tombstone struct {
+	err     exp.ExceptionInterface
+	expires time.Time
+}
+
+// GuacamoleHTTPTunnelMapOptions *
+//  * The settings of a GuacamoleHTTPTunnelMap. Zero values are replaced by
+//  * the defaults of DefaultGuacamoleHTTPTunnelMapOptions.
+type GuacamoleHTTPTunnelMapOptions struct {
+	/**
+	 * The maximum amount of time to allow between accesses to any one
+	 * HTTP tunnel.
+	 */
+	TunnelTimeout time.Duration
+
+	/**
+	 * The time between runs of the task which closes timed out tunnels and
+	 * enforces the session policy.
+	 */
+	SweepInterval time.Duration
+
+	/**
+	 * The limits on the length of sessions.
+	 */
+	SessionPolicy SessionPolicy
+
+	/**
+	 * The bus receiving the IDLE, TIMED_OUT and CLOSED events of tunnels.
+	 */
+	Events *gevent.EventBus
+
+	/**
+	 * How long a tunnel whose client lost its connection is kept open for
+	 * the client to resume it. Zero disables resuming. Clients must be
+	 * changed to resume: see RESUME_PREFIX.
+	 */
+	ResumeWindow time.Duration
+
+	/**
+	 * The number of bytes of instructions kept for resuming each tunnel,
+	 * or zero for DEFAULT_REPLAY_BUFFER_SIZE.
+	 */
+	ReplayBufferSize int
+}
+
+/*DefaultGuacamoleHTTPTunnelMapOptions *
+ * Returns the options matching the historical behaviour of the map: tunnels
+ * time out after TunnelTimeout, checked every TunnelTimeout, with no
+ * session policy.
+ */
+func DefaultGuacamoleHTTPTunnelMapOptions() GuacamoleHTTPTunnelMapOptions {
+	return GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout: TunnelTimeout,
+		SweepInterval: TunnelTimeout,
+	}
 }
 
 /*NewGuacamoleHTTPTunnelMap *
  * Creates a new GuacamoleHTTPTunnelMap which automatically closes and
  * removes HTTP tunnels which are no longer in use.
+ *
+ * @param doStopConnect
+ *     Called with every tunnel removed from the map.
  */
-func NewGuacamoleHTTPTunnelMap(doStopConnect DoConnectStopInterface) (ret GuacamoleHTTPTunnelMap) {
-
-	ret.executor = make([]*time.Ticker, 0, 1)
-	ret.tunnelMap = make(map[string]*GuacamoleHTTPTunnel)
+func NewGuacamoleHTTPTunnelMap(doStopConnect DoConnectStopInterface) (ret *GuacamoleHTTPTunnelMap) {
+	events := gevent.NewEventBus()
+	if doStopConnect != nil {
+		events.Subscribe(gevent.ConnectStopAdapter(doStopConnect), gevent.CLOSED)
+	}
+	return NewGuacamoleHTTPTunnelMap2(events)
+}
 
-	ret.tunnelTimeout = TunnelTimeout
-	ret.doStopConnect = doStopConnect
-	ret.startScheduled(1, TunnelTimeout)
-	return
+/*NewGuacamoleHTTPTunnelMap2 *
+ * Creates a new GuacamoleHTTPTunnelMap which automatically closes and
+ * removes HTTP tunnels which are no longer in use, publishing their IDLE,
+ * TIMED_OUT and CLOSED events on the given bus.
+ *
+ * @param events
+ *     The bus receiving tunnel events.
+ */
+func NewGuacamoleHTTPTunnelMap2(events *gevent.EventBus) (ret *GuacamoleHTTPTunnelMap) {
+	options := DefaultGuacamoleHTTPTunnelMapOptions()
+	options.Events = events
+	return NewGuacamoleHTTPTunnelMap3(options)
 }
 
-func (opt *GuacamoleHTTPTunnelMap) startScheduled(count int32, timeout time.Duration) {
-	for i := int32(len(opt.executor)); i < count; i++ {
+/*NewGuacamoleHTTPTunnelMap3 *
+ * Creates a new GuacamoleHTTPTunnelMap with the given options. The map's
+ * timeout task runs until Shutdown is called.
+ *
+ * @param options
+ *     The settings of the map.
+ */
+func NewGuacamoleHTTPTunnelMap3(options GuacamoleHTTPTunnelMapOptions) (ret *GuacamoleHTTPTunnelMap) {
+	defaults := DefaultGuacamoleHTTPTunnelMapOptions()
+	if options.TunnelTimeout <= 0 {
+		options.TunnelTimeout = defaults.TunnelTimeout
+	}
+	if options.SweepInterval <= 0 {
+		options.SweepInterval = options.TunnelTimeout
+	}
+	if options.Events == nil {
+		options.Events = gevent.NewEventBus()
+	}
 
-		tick := time.NewTicker(timeout)
-		go opt.tunnelTimeoutTask(tick.C)
+	ret = &GuacamoleHTTPTunnelMap{
+		sweepInterval: options.SweepInterval,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		tunnelTimeout: options.TunnelTimeout,
+		tunnelMap:     make(map[string]*GuacamoleHTTPTunnel),
+		events:        options.Events,
+		policy:        options.SessionPolicy,
+		tombstones:    make(map[string]tombstone),
 
-		opt.executor = append(opt.executor, tick)
+		resumeWindow:     options.ResumeWindow,
+		replayBufferSize: options.ReplayBufferSize,
 	}
+	go ret.tunnelTimeoutTask()
+	return
 }
 
-func (opt *GuacamoleHTTPTunnelMap) tunnelTimeoutTask(c <-chan time.Time) {
+/**
+ * Runs the tunnel timeout task every sweepInterval until the map is shut
+ * down.
+ */
+func (opt *GuacamoleHTTPTunnelMap) tunnelTimeoutTask() {
+	defer close(opt.done)
+	tick := time.NewTicker(opt.sweepInterval)
+	defer tick.Stop()
 	for {
-		_, ok := <-c
-		if !ok {
-			break
+		select {
+		case <-tick.C:
+			opt.tunnelTimeoutTaskRun()
+		case <-opt.stop:
+			return
 		}
-		opt.tunnelTimeoutTaskRun()
 	}
 }
 
 func (opt *GuacamoleHTTPTunnelMap) tunnelTimeoutTaskRun() {
 	// timeLine = Now() - tunnelTimeout
-	timeLine := time.Now().Add(0 - opt.tunnelTimeout)
+	now := time.Now()
+	timeLine := now.Add(0 - opt.tunnelTimeout)
+	idleLine := now.Add(0 - opt.tunnelTimeout/2)
 
 	type pair struct {
 		uuid   string
 		tunnel *GuacamoleHTTPTunnel
 	}
 	removeIDs := make([]pair, 0, 1)
+	idleIDs := make([]pair, 0, 1)
+
+	resumeLine := now.Add(0 - opt.resumeWindow)
+	abandonedIDs := make([]pair, 0, 1)
 
 	opt.tunnelMapLock.RLock()
 	for uuid, tunnel := range opt.tunnelMap {
-		if tunnel.GetLastAccessedTime().Before(timeLine) {
+		// Tunnels awaiting a resuming client time out only once the
+		// resume window has passed
+		if detachedTime, detached := tunnel.getDetachedTime(); detached {
+			if detachedTime.Before(resumeLine) {
+				abandonedIDs = append(abandonedIDs, pair{uuid: uuid, tunnel: tunnel})
+			}
+			continue
+		}
+
+		lastAccessed := tunnel.GetLastAccessedTime()
+		if lastAccessed.Before(timeLine) {
 			removeIDs = append(removeIDs, pair{uuid: uuid, tunnel: tunnel})
+		} else if lastAccessed.Before(idleLine) && tunnel.markIdle() {
+			idleIDs = append(idleIDs, pair{uuid: uuid, tunnel: tunnel})
 		}
 	}
 	opt.tunnelMapLock.RUnlock()
 
+	for _, double := range idleIDs {
+		opt.events.Publish(gevent.NewTunnelEvent(gevent.IDLE, double.tunnel))
+	}
+
+	for _, double := range abandonedIDs {
+		logger.Debugf("HTTP tunnel \"%v\" was not resumed in time.", double.uuid)
+		opt.terminate(double.uuid, double.tunnel, gevent.CLOSE_REASON_IO_ERROR,
+			exp.GuacamoleClientTimeoutException.Throw("Connection was not resumed in time."), true)
+	}
+
+	opt.enforcePolicy(now)
+	opt.enforceDrain(now)
+
 	for _, double := range removeIDs {
-		fmt.Println("timeout")
 		logger.Debugf("HTTP tunnel \"%v\" has timed out.", double.uuid)
-		opt.tunnelMapLock.Lock()
-		delete(opt.tunnelMap, double.uuid)
-		opt.tunnelMapLock.Unlock()
-		if double.tunnel != nil {
-			err := double.tunnel.Close()
-			if err != nil {
-				logger.Debug("Unable to close expired HTTP tunnel.", err)
-			}
-			opt.doStopConnect(double.tunnel)
+		if opt.terminate(double.uuid, double.tunnel, gevent.CLOSE_REASON_TIMEOUT,
+			exp.GuacamoleClientTimeoutException.Throw("Tunnel was not accessed in time."), true) {
+			gmetrics.TunnelsExpired.Inc()
 		}
 	}
 	return
 }
 
+/*SetSessionPolicy *
+ * Sets the limits on the length of sessions, enforced each time the timeout
+ * task runs.
+ */
+func (opt *GuacamoleHTTPTunnelMap) SetSessionPolicy(policy SessionPolicy) {
+	opt.tunnelMapLock.Lock()
+	opt.policy = policy
+	opt.tunnelMapLock.Unlock()
+}
+
+/*GetSessionPolicy *
+ * Returns the limits on the length of sessions.
+ */
+func (opt *GuacamoleHTTPTunnelMap) GetSessionPolicy() SessionPolicy {
+	opt.tunnelMapLock.RLock()
+	defer opt.tunnelMapLock.RUnlock()
+	return opt.policy
+}
+
+/**
+ * Warns the users of tunnels nearing a limit of the session policy and
+ * closes the tunnels which reached one, forgetting expired tombstones.
+ */
+func (opt *GuacamoleHTTPTunnelMap) enforcePolicy(now time.Time) {
+	policy := opt.GetSessionPolicy()
+
+	type expiry struct {
+		uuid    string
+		tunnel  *GuacamoleHTTPTunnel
+		reason  string
+		message string
+	}
+	expired := make([]expiry, 0, 1)
+
+	opt.tunnelMapLock.Lock()
+	for uuid, one := range opt.tombstones {
+		if now.After(one.expires) {
+			delete(opt.tombstones, uuid)
+		}
+	}
+	tunnels := make(map[string]*GuacamoleHTTPTunnel, len(opt.tunnelMap))
+	for uuid, tunnel := range opt.tunnelMap {
+		tunnels[uuid] = tunnel
+	}
+	opt.tunnelMapLock.Unlock()
+
+	for uuid, tunnel := range tunnels {
+		tunnel.stateLock.Lock()
+		var warnings []string
+		if policy.MaxDuration > 0 {
+			remaining := tunnel.createdTime.Add(policy.MaxDuration).Sub(now)
+			if remaining <= 0 {
+				expired = append(expired, expiry{uuid, tunnel, gevent.CLOSE_REASON_MAX_DURATION,
+					"Maximum session duration reached."})
+				tunnel.stateLock.Unlock()
+				continue
+			}
+			if policy.MaxDurationWarning > 0 && remaining <= policy.MaxDurationWarning && !tunnel.durationWarned {
+				tunnel.durationWarned = true
+				warnings = append(warnings, "Your session will end in "+formatRemaining(remaining)+".")
+			}
+		}
+		if policy.IdleTimeout > 0 {
+			remaining := tunnel.lastInputTime.Add(policy.IdleTimeout).Sub(now)
+			if remaining <= 0 {
+				expired = append(expired, expiry{uuid, tunnel, gevent.CLOSE_REASON_IDLE,
+					"Session closed due to inactivity."})
+				tunnel.stateLock.Unlock()
+				continue
+			}
+			if policy.IdleWarning > 0 && remaining <= policy.IdleWarning && !tunnel.idleWarned {
+				tunnel.idleWarned = true
+				warnings = append(warnings, "Your session will be closed due to inactivity in "+formatRemaining(remaining)+".")
+			}
+		}
+		tunnel.stateLock.Unlock()
+
+		for _, text := range warnings {
+			for _, instruction := range NewNoticeInstructions(text) {
+				tunnel.queue(instruction)
+			}
+		}
+	}
+
+	for _, one := range expired {
+		logger.Debugf("HTTP tunnel \"%v\" reached its session limit: %v", one.uuid, one.reason)
+		opt.expire(one.uuid, one.tunnel, one.reason, one.message)
+	}
+}
+
+/**
+ * Closes the given tunnel with SESSION_TIMEOUT, queueing an "error"
+ * instruction for any read in progress and leaving a tombstone for later
+ * requests.
+ */
+func (opt *GuacamoleHTTPTunnelMap) expire(uuid string, tunnel *GuacamoleHTTPTunnel, reason, message string) {
+	opt.terminate(uuid, tunnel, reason, exp.GuacamoleSessionTimeoutException.Throw(message), true)
+}
+
+/**
+ * Closes the given tunnel with the status of the given error, queueing an
+ * "error" instruction for any read in progress and leaving a tombstone so
+ * that later requests fail with the same error. A TIMED_OUT event precedes
+ * the CLOSED event if timedOut is set. Returns whether the tunnel was
+ * removed by this call rather than concurrently.
+ */
+func (opt *GuacamoleHTTPTunnelMap) terminate(uuid string, tunnel *GuacamoleHTTPTunnel,
+	reason string, err exp.ExceptionInterface, timedOut bool) bool {
+
+	tunnel.queue(NewErrorInstruction(err))
+
+	opt.tunnelMapLock.Lock()
+	if _, ok := opt.tunnelMap[uuid]; !ok {
+		// Removed concurrently
+		opt.tunnelMapLock.Unlock()
+		return false
+	}
+	opt.tombstones[uuid] = tombstone{err: err, expires: time.Now().Add(TOMBSTONE_LIFETIME)}
+	opt.tunnelMapLock.Unlock()
+
+	if timedOut {
+		opt.events.Publish(gevent.NewTunnelEvent(gevent.TIMED_OUT, tunnel).WithError(err))
+	}
+
+	closed := gevent.NewTunnelEvent(gevent.CLOSED, nil).WithError(err)
+	closed.Reason = reason
+	_, ok := opt.remove(uuid, closed)
+	if ok {
+		e := tunnel.Close()
+		if e != nil {
+			logger.Debug("Unable to close HTTP tunnel.", e)
+		}
+	}
+	return ok
+}
+
+/**
+ * Leaves a tombstone for the tunnel having the given UUID, closed by the
+ * given error, so that later requests fail with the same error.
+ */
+func (opt *GuacamoleHTTPTunnelMap) bury(uuid string, err exp.ExceptionInterface) {
+	opt.tunnelMapLock.Lock()
+	opt.tombstones[uuid] = tombstone{err: err, expires: time.Now().Add(TOMBSTONE_LIFETIME)}
+	opt.tunnelMapLock.Unlock()
+}
+
+/**
+ * Closes every registered tunnel with the status of the given error.
+ */
+func (opt *GuacamoleHTTPTunnelMap) terminateAll(reason string, err exp.ExceptionInterface) {
+	opt.tunnelMapLock.RLock()
+	tunnels := make(map[string]*GuacamoleHTTPTunnel, len(opt.tunnelMap))
+	for uuid, tunnel := range opt.tunnelMap {
+		tunnels[uuid] = tunnel
+	}
+	opt.tunnelMapLock.RUnlock()
+
+	for uuid, tunnel := range tunnels {
+		opt.terminate(uuid, tunnel, reason, err, false)
+	}
+}
+
+/**
+ * Closes the tunnels left once the deadline of a drain has passed.
+ */
+func (opt *GuacamoleHTTPTunnelMap) enforceDrain(now time.Time) {
+	opt.tunnelMapLock.RLock()
+	expired := opt.draining && !opt.drainDeadline.IsZero() && now.After(opt.drainDeadline)
+	err := opt.drainErr
+	opt.tunnelMapLock.RUnlock()
+
+	if expired {
+		opt.terminateAll(gevent.CLOSE_REASON_SHUTDOWN, err)
+	}
+}
+
+/**
+ * Returns the error of the tunnel having the given UUID if it was recently
+ * closed by the session policy, by the server, or by guacd.
+ */
+func (opt *GuacamoleHTTPTunnelMap) getTombstone(uuid string) (err exp.ExceptionInterface, ok bool) {
+	opt.tunnelMapLock.RLock()
+	one, ok := opt.tombstones[uuid]
+	opt.tunnelMapLock.RUnlock()
+	return one.err, ok
+}
+
 /*Get *
  * Returns the GuacamoleTunnel having the given UUID, wrapped within a
  * GuacamoleHTTPTunnel. If the no tunnel having the given UUID is
@@ -158,10 +521,44 @@ func (opt *GuacamoleHTTPTunnelMap) Get(uuid string) (tunnel *GuacamoleHTTPTunnel
  *     having just been established via HTTP.
  */
 func (opt *GuacamoleHTTPTunnelMap) Put(uuid string, tunnel gnet.GuacamoleTunnel) {
+	if !opt.put(context.Background(), uuid, tunnel, "") {
+		logger.Warnf("Tunnel \"%v\" not registered: HTTP tunnel map is not accepting tunnels.", uuid)
+	}
+}
+
+/**
+ * Registers the given tunnel as Put does, bound to the given owner,
+ * starting the span covering its lifetime beneath the span carried by the
+ * given context. Returns false, registering nothing, if the map is
+ * draining or shut down.
+ */
+func (opt *GuacamoleHTTPTunnelMap) put(ctx context.Context, uuid string, tunnel gnet.GuacamoleTunnel, owner string) bool {
 	one := NewGuacamoleHTTPTunnel(tunnel)
+	one.owner = owner
+	if opt.resumeWindow > 0 {
+		one.replay = NewReplayBuffer(opt.replayBufferSize)
+	}
+
+	// The span must be complete before the tunnel can be found, and so
+	// removed, by other requests or by the timeout task
+	_, one.span = gtrace.Start(ctx, "tunnel")
+	one.span.SetAttribute("guacamole.tunnel_uuid", uuid)
+	one.span.SetAttribute("guacamole.protocol", tunnelProtocol(tunnel))
+	if id := gnet.GetSocketConnectionID(tunnel.GetSocket()); len(id) > 0 {
+		one.span.SetAttribute("guacamole.connection_id", id)
+	}
+
 	opt.tunnelMapLock.Lock()
+	if opt.draining || opt.shutdown {
+		opt.tunnelMapLock.Unlock()
+		one.span.SetError("HTTP tunnel map is not accepting tunnels.")
+		one.span.End()
+		return false
+	}
+	gmetrics.TunnelsActive.Inc(tunnelProtocol(tunnel))
 	opt.tunnelMap[uuid] = &one
 	opt.tunnelMapLock.Unlock()
+	return true
 }
 
 /*Remove *
@@ -178,28 +575,166 @@ func (opt *GuacamoleHTTPTunnelMap) Put(uuid string, tunnel gnet.GuacamoleTunnel)
  *     exists and no removal was performed.
  */
 func (opt *GuacamoleHTTPTunnelMap) Remove(uuid string) (*GuacamoleHTTPTunnel, bool) {
+	closed := gevent.NewTunnelEvent(gevent.CLOSED, nil)
+	closed.Reason = gevent.CLOSE_REASON_CLIENT
+	return opt.remove(uuid, closed)
+}
 
-	opt.tunnelMapLock.RLock()
+/**
+ * Removes the tunnel having the given UUID as Remove does, publishing the
+ * given CLOSED event, completed with the tunnel removed. Nothing is
+ * published if no tunnel was removed, so that each tunnel is reported
+ * closed exactly once.
+ */
+func (opt *GuacamoleHTTPTunnelMap) remove(uuid string, closed gevent.TunnelEvent) (*GuacamoleHTTPTunnel, bool) {
+
+	opt.tunnelMapLock.Lock()
 	v, ok := opt.tunnelMap[uuid]
-	opt.tunnelMapLock.RUnlock()
+	delete(opt.tunnelMap, uuid)
+	opt.tunnelMapLock.Unlock()
 
 	if ok {
-		opt.tunnelMapLock.Lock()
-		delete(opt.tunnelMap, uuid)
-		opt.tunnelMapLock.Unlock()
-		opt.doStopConnect(v)
+		gmetrics.TunnelsActive.Dec(tunnelProtocol(v))
+		v.span.SetAttribute("guacamole.close_reason", closed.Reason)
+		if closed.Status != exp.SUCCESS {
+			v.span.SetError(closed.Message)
+		}
+		v.span.End()
+
+		one := gevent.NewTunnelEvent(gevent.CLOSED, v)
+		one.Reason, one.Status, one.Message, one.Err = closed.Reason, closed.Status, closed.Message, closed.Err
+		opt.events.Publish(one)
 	}
 	return v, ok
 }
 
 /*Shutdown *
  * Shuts down this tunnel map, disallowing future tunnels from being
- * registered and reclaiming any resources.
+ * registered and reclaiming any resources. Tunnels still registered are
+ * closed with SESSION_CLOSED.
  */
 func (opt *GuacamoleHTTPTunnelMap) Shutdown() {
-	fmt.Println("Shutdown")
-	for _, c := range opt.executor {
-		c.Stop()
+	opt.Shutdown2(exp.SESSION_CLOSED, "Server is shutting down.")
+}
+
+/*Shutdown2 *
+ * Shuts down this tunnel map as Shutdown does, closing the tunnels still
+ * registered with the given status. Their clients receive an "error"
+ * instruction carrying the status and message, and later requests for
+ * them fail with the same status.
+ *
+ * @param status
+ *     The status with which to close the remaining tunnels.
+ *
+ * @param message
+ *     A human-readable message that can be presented to the user.
+ */
+func (opt *GuacamoleHTTPTunnelMap) Shutdown2(status exp.GuacamoleStatus, message string) {
+	logger.Debug("Shutting down HTTP tunnel map.")
+
+	opt.tunnelMapLock.Lock()
+	opt.shutdown = true
+	opt.tunnelMapLock.Unlock()
+
+	opt.stopOnce.Do(func() { close(opt.stop) })
+	<-opt.done
+
+	opt.terminateAll(gevent.CLOSE_REASON_SHUTDOWN, newStatusException(status, message))
+}
+
+/*Drain *
+ * Puts this tunnel map in drain mode: new tunnels are refused, while those
+ * registered may continue until the given deadline. Tunnels still
+ * registered once the deadline has passed are closed with the given status
+ * by the timeout task, within one sweep interval.
+ *
+ * @param deadline
+ *     The time after which remaining tunnels are closed, or the zero time
+ *     to let them continue until Shutdown.
+ *
+ * @param status
+ *     The status with which to close the remaining tunnels.
+ *
+ * @param message
+ *     A human-readable message that can be presented to the user.
+ */
+func (opt *GuacamoleHTTPTunnelMap) Drain(deadline time.Time, status exp.GuacamoleStatus, message string) {
+	opt.tunnelMapLock.Lock()
+	opt.draining = true
+	opt.drainDeadline = deadline
+	opt.drainErr = newStatusException(status, message)
+	opt.tunnelMapLock.Unlock()
+}
+
+/**
+ * Queues the given notice for the user of every registered tunnel.
+ */
+func (opt *GuacamoleHTTPTunnelMap) notifyAll(text string) {
+	opt.tunnelMapLock.RLock()
+	defer opt.tunnelMapLock.RUnlock()
+	for _, tunnel := range opt.tunnelMap {
+		for _, instruction := range NewNoticeInstructions(text) {
+			tunnel.queue(instruction)
+		}
+	}
+}
+
+/*IsAccepting *
+ * Returns whether new tunnels may be registered, being false once the map
+ * is draining or shut down.
+ */
+func (opt *GuacamoleHTTPTunnelMap) IsAccepting() bool {
+	opt.tunnelMapLock.RLock()
+	defer opt.tunnelMapLock.RUnlock()
+	return !opt.draining && !opt.shutdown
+}
+
+/*IsDraining *
+ * Returns whether Drain was called.
+ */
+func (opt *GuacamoleHTTPTunnelMap) IsDraining() bool {
+	opt.tunnelMapLock.RLock()
+	defer opt.tunnelMapLock.RUnlock()
+	return opt.draining
+}
+
+/*Size *
+ * Returns the number of registered tunnels.
+ */
+func (opt *GuacamoleHTTPTunnelMap) Size() int {
+	opt.tunnelMapLock.RLock()
+	defer opt.tunnelMapLock.RUnlock()
+	return len(opt.tunnelMap)
+}
+
+/*WaitEmpty *
+ * Waits until no tunnel is registered, or until the given context is done.
+ *
+ * @return
+ *     Whether the map became empty.
+ */
+func (opt *GuacamoleHTTPTunnelMap) WaitEmpty(ctx context.Context) bool {
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for opt.Size() > 0 {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return opt.Size() == 0
+		}
+	}
+	return true
+}
+
+/**
+ * Returns the protocol of the given tunnel, for use as a metric label.
+ */
+func tunnelProtocol(tunnel gnet.GuacamoleTunnel) string {
+	if tunnel == nil {
+		return ""
+	}
+	if config := tunnel.GetConfiguration(); config != nil {
+		return config.GetProtocol()
 	}
-	opt.executor = make([]*time.Ticker, 0, 1)
+	return ""
 }
@@ -0,0 +1,335 @@
+package gservlet
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gevent"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * Records the events published on a bus.
+ */
+type testEvents struct {
+	events []gevent.TunnelEvent
+	lock   sync.Mutex
+}
+
+func newTestEvents(bus *gevent.EventBus) *testEvents {
+	ret := &testEvents{}
+	bus.Subscribe(func(event gevent.TunnelEvent) {
+		ret.lock.Lock()
+		ret.events = append(ret.events, event)
+		ret.lock.Unlock()
+	})
+	return ret
+}
+
+/**
+ * Waits for the CLOSED event of the tunnel having the given UUID, returning
+ * the events of that tunnel so far.
+ */
+func (opt *testEvents) waitClosed(t *testing.T, uuid string) (closed gevent.TunnelEvent, events []gevent.TunnelEventType) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		opt.lock.Lock()
+		events = events[:0]
+		found := false
+		for _, event := range opt.events {
+			if event.TunnelUUID != uuid {
+				continue
+			}
+			events = append(events, event.Type)
+			if event.Type == gevent.CLOSED {
+				closed, found = event, true
+			}
+		}
+		opt.lock.Unlock()
+		if found {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("tunnel %v not closed, events %v", uuid, events)
+	return
+}
+
+/**
+ * Registers a new test tunnel in the given map, returning it as registered
+ * and the connection of its guacd.
+ */
+func putTestTunnel(t *testing.T, tunnels *GuacamoleHTTPTunnelMap) (*GuacamoleHTTPTunnel, net.Conn) {
+	t.Helper()
+	tunnel, guacd := newTestTunnel()
+	uuid := tunnel.GetUUID().String()
+	if !tunnels.put(context.Background(), uuid, tunnel, "") {
+		t.Fatalf("tunnel %v refused", uuid)
+	}
+	one, ok := tunnels.Get(uuid)
+	if !ok {
+		t.Fatalf("tunnel %v not registered", uuid)
+	}
+	return one, guacd
+}
+
+/**
+ * Checks that the given tunnel was closed with the given status, leaving a
+ * tombstone and an "error" instruction for its client.
+ */
+func checkTerminated(t *testing.T, tunnels *GuacamoleHTTPTunnelMap, tunnel *GuacamoleHTTPTunnel,
+	closed gevent.TunnelEvent, reason string, status exp.GuacamoleStatus) {
+	t.Helper()
+	uuid := tunnel.GetUUID().String()
+	if closed.Reason != reason || closed.Status != status {
+		t.Errorf("closed with reason %q, status %v", closed.Reason, closed.Status)
+	}
+	if tunnel.IsOpen() {
+		t.Errorf("tunnel left open")
+	}
+	if _, ok := tunnels.Get(uuid); ok {
+		t.Errorf("tunnel left registered")
+	}
+	if err, ok := tunnels.getTombstone(uuid); !ok || err.GetStatus() != status {
+		t.Errorf("tombstone = %v, %v", err, ok)
+	}
+	pending := tunnel.takePending()
+	if len(pending) == 0 || !strings.HasPrefix(string(pending[len(pending)-1]), "5.error,") {
+		t.Errorf("pending instructions = %q", pending)
+	}
+}
+
+/**
+ * Returns the texts of the notices within the given instructions, failing
+ * unless each is sent as a whole over the notice stream, and the opcodes
+ * of the other instructions.
+ */
+func parseNotices(t *testing.T, data []byte) (notices []string, others []string) {
+	t.Helper()
+	parser := gprotocol.NewGuacamoleStreamParser()
+	instructions, err := parser.Append(data)
+	if err != nil {
+		t.Fatalf("instructions %q: %v", data, err.GetMessage())
+	}
+	index := strconv.Itoa(NOTICE_STREAM_INDEX)
+	var opcodes []string
+	for _, instruction := range instructions {
+		args := instruction.GetArgs()
+		if len(args) == 0 || args[0] != index {
+			others = append(others, instruction.GetOpcode())
+			continue
+		}
+		opcodes = append(opcodes, instruction.GetOpcode())
+		if instruction.GetOpcode() == "blob" && len(args) == 2 {
+			text, _ := base64.StdEncoding.DecodeString(args[1])
+			notices = append(notices, string(text))
+		}
+	}
+	if strings.Repeat("pipe,blob,end,", len(notices)) != strings.Join(append(opcodes, ""), ",") {
+		t.Errorf("notice instructions %v", opcodes)
+	}
+	return
+}
+
+/**
+ * Returns the texts of the notices queued for the client of the given
+ * tunnel, leaving them queued.
+ */
+func queuedNotices(t *testing.T, tunnel *GuacamoleHTTPTunnel) []string {
+	t.Helper()
+	tunnel.stateLock.Lock()
+	pending := bytes.Join(tunnel.pending, nil)
+	tunnel.stateLock.Unlock()
+	notices, _ := parseNotices(t, pending)
+	return notices
+}
+
+func Test_TunnelMapTimeout(t *testing.T) {
+	bus := gevent.NewEventBus()
+	events := newTestEvents(bus)
+	tunnels := NewGuacamoleHTTPTunnelMap3(GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout: 100 * time.Millisecond,
+		SweepInterval: 10 * time.Millisecond,
+		Events:        bus,
+	})
+	defer tunnels.Shutdown()
+
+	tunnel, guacd := putTestTunnel(t, tunnels)
+	defer guacd.Close()
+
+	closed, types := events.waitClosed(t, tunnel.GetUUID().String())
+	expected := []gevent.TunnelEventType{gevent.IDLE, gevent.TIMED_OUT, gevent.CLOSED}
+	if len(types) != len(expected) {
+		t.Fatalf("events = %v, expected %v", types, expected)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Errorf("events = %v, expected %v", types, expected)
+		}
+	}
+	checkTerminated(t, tunnels, tunnel, closed, gevent.CLOSE_REASON_TIMEOUT, exp.CLIENT_TIMEOUT)
+}
+
+func Test_TunnelMapDrain(t *testing.T) {
+	bus := gevent.NewEventBus()
+	events := newTestEvents(bus)
+	tunnels := NewGuacamoleHTTPTunnelMap3(GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout: time.Minute,
+		SweepInterval: 10 * time.Millisecond,
+		Events:        bus,
+	})
+	defer tunnels.Shutdown()
+
+	tunnel, guacd := putTestTunnel(t, tunnels)
+	defer guacd.Close()
+
+	// Tunnels continue until the deadline, while new ones are refused
+	deadline := time.Now().Add(100 * time.Millisecond)
+	tunnels.Drain(deadline, exp.SERVER_BUSY, "Server is restarting.")
+	refused, refusedGuacd := newTestTunnel()
+	defer refusedGuacd.Close()
+	if tunnels.IsAccepting() || tunnels.put(context.Background(), refused.GetUUID().String(), refused, "") {
+		t.Errorf("tunnel registered while draining")
+	}
+	if _, ok := tunnels.Get(tunnel.GetUUID().String()); !ok {
+		t.Errorf("tunnel closed before the deadline")
+	}
+
+	closed, _ := events.waitClosed(t, tunnel.GetUUID().String())
+	if closed.Time.Before(deadline) {
+		t.Errorf("tunnel closed at %v, before the deadline %v", closed.Time, deadline)
+	}
+	checkTerminated(t, tunnels, tunnel, closed, gevent.CLOSE_REASON_SHUTDOWN, exp.SERVER_BUSY)
+}
+
+func Test_TunnelMapShutdown(t *testing.T) {
+	bus := gevent.NewEventBus()
+	events := newTestEvents(bus)
+	tunnels := NewGuacamoleHTTPTunnelMap3(GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout: time.Minute,
+		Events:        bus,
+	})
+
+	tunnel, guacd := putTestTunnel(t, tunnels)
+	defer guacd.Close()
+
+	tunnels.Shutdown()
+	closed, _ := events.waitClosed(t, tunnel.GetUUID().String())
+	checkTerminated(t, tunnels, tunnel, closed, gevent.CLOSE_REASON_SHUTDOWN, exp.SESSION_CLOSED)
+
+	refused, refusedGuacd := newTestTunnel()
+	defer refusedGuacd.Close()
+	if tunnels.put(context.Background(), refused.GetUUID().String(), refused, "") || tunnels.Size() != 0 {
+		t.Errorf("tunnel registered after shutdown")
+	}
+}
+
+func Test_SessionPolicyIdle(t *testing.T) {
+	servlet := NewGuacamoleHTTPTunnelServlet2(nil, GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout: time.Minute,
+		SweepInterval: 10 * time.Millisecond,
+		SessionPolicy: SessionPolicy{IdleTimeout: 300 * time.Millisecond, IdleWarning: 150 * time.Millisecond},
+	})
+	defer servlet.Destroy()
+	events := newTestEvents(servlet.GetEventBus())
+
+	tunnel, guacd := putTestTunnel(t, servlet.tunnels)
+	defer guacd.Close()
+	go io.Copy(ioutil.Discard, guacd)
+	uuid := tunnel.GetUUID().String()
+	write := func(instructions ...gprotocol.GuacamoleInstruction) {
+		t.Helper()
+		var body strings.Builder
+		for _, one := range instructions {
+			body.WriteString(one.String())
+		}
+		recorder := httptest.NewRecorder()
+		servlet.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/tunnel?write:"+uuid,
+			strings.NewReader(body.String())))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("write: code %v", recorder.Code)
+		}
+	}
+
+	// Only "key" and "mouse" instructions written by the user count as
+	// activity
+	write(gprotocol.NewGuacamoleInstruction("sync", "1000"), gprotocol.NewGuacamoleInstruction("size", "1024", "768"))
+	if !tunnel.GetLastInputTime().Equal(tunnel.GetCreatedTime()) {
+		t.Errorf("idle clock reset by sync and size")
+	}
+	time.Sleep(20 * time.Millisecond)
+	before := time.Now()
+	write(gprotocol.NewGuacamoleInstruction("key", "65307", "1"))
+	input := tunnel.GetLastInputTime()
+	if input.Before(before) {
+		t.Errorf("idle clock not reset by key")
+	}
+	time.Sleep(20 * time.Millisecond)
+	before = time.Now()
+	write(gprotocol.NewGuacamoleInstruction("mouse", "10", "20", "1"))
+	if input = tunnel.GetLastInputTime(); input.Before(before) {
+		t.Errorf("idle clock not reset by mouse")
+	}
+
+	// The user is warned once, then the tunnel is closed
+	closed, _ := events.waitClosed(t, uuid)
+	if closed.Time.Before(input.Add(300 * time.Millisecond)) {
+		t.Errorf("closed at %v, less than the idle timeout after the last input at %v", closed.Time, input)
+	}
+	notices := queuedNotices(t, tunnel)
+	if len(notices) != 1 || !strings.HasPrefix(notices[0], "Your session will be closed due to inactivity in ") {
+		t.Errorf("notices %q", notices)
+	}
+	checkTerminated(t, servlet.tunnels, tunnel, closed, gevent.CLOSE_REASON_IDLE, exp.SESSION_TIMEOUT)
+}
+
+func Test_SessionPolicyMaxDuration(t *testing.T) {
+	bus := gevent.NewEventBus()
+	events := newTestEvents(bus)
+	tunnels := NewGuacamoleHTTPTunnelMap3(GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout: time.Minute,
+		SweepInterval: 10 * time.Millisecond,
+		SessionPolicy: SessionPolicy{MaxDuration: 300 * time.Millisecond, MaxDurationWarning: 150 * time.Millisecond},
+		Events:        bus,
+	})
+	defer tunnels.Shutdown()
+
+	tunnel, guacd := putTestTunnel(t, tunnels)
+	defer guacd.Close()
+
+	// Activity does not extend the session
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				tunnel.Input()
+			}
+		}
+	}()
+	closed, _ := events.waitClosed(t, tunnel.GetUUID().String())
+	close(done)
+	if closed.Time.Before(tunnel.GetCreatedTime().Add(300 * time.Millisecond)) {
+		t.Errorf("closed at %v, before the maximum duration", closed.Time)
+	}
+	notices := queuedNotices(t, tunnel)
+	if len(notices) != 1 || !strings.HasPrefix(notices[0], "Your session will end in ") {
+		t.Errorf("notices %q", notices)
+	}
+	checkTerminated(t, tunnels, tunnel, closed, gevent.CLOSE_REASON_MAX_DURATION, exp.SESSION_TIMEOUT)
+}
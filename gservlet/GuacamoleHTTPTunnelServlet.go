@@ -1,12 +1,22 @@
 package gservlet
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gcluster"
+	"github.com/hsfish/guacamole_client_go/gevent"
 	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gmetrics"
 	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	"github.com/hsfish/guacamole_client_go/gtrace"
 	logger "github.com/sirupsen/logrus"
 )
 
@@ -36,10 +46,40 @@ const (
 	 */
 	WRITE_PREFIX_LENGTH = len(WRITE_PREFIX)
 
+	/*RESUME_PREFIX *
+	 * The prefix of the query string which denotes a tunnel resume
+	 * operation, of the form "resume:UUID:TIMESTAMP", where TIMESTAMP is
+	 * that of the last "sync" instruction received by the client, or -1 if
+	 * it received none.
+	 *
+	 * The stock Guacamole.HTTPTunnel never resumes: it closes the tunnel
+	 * when a read request fails. To resume within the ResumeWindow of the
+	 * tunnel map, the client must remember the timestamp of the last
+	 * "sync" instruction it received and, when a read request fails
+	 * without a Guacamole-Status-Code header, as happens when the
+	 * connection is lost, send a GET request with this query string
+	 * instead of closing. Its response is read as that of a read request,
+	 * starting with the instructions the client missed, and later reads
+	 * use "read:UUID" as before. If the resume request fails with a
+	 * status, the client closes the tunnel as for a failed read.
+	 */
+	RESUME_PREFIX string = "resume:"
+
 	/*UUID_LENGTH *
 	 * The length of every tunnel UUID, in characters.
 	 */
 	UUID_LENGTH = 36
+
+	/*DRAIN_NOTICE *
+	 * The notice sent to every active session when a drain starts.
+	 */
+	DRAIN_NOTICE = "The server is restarting and you will be reconnected soon."
+
+	/*DRAIN_CLOSE_MESSAGE *
+	 * The message of the SERVER_BUSY error closing sessions left once a
+	 * drain ends.
+	 */
+	DRAIN_CLOSE_MESSAGE = "The server is restarting. Please reconnect."
 )
 
 /*GuacamoleHTTPTunnelServlet ==> HttpServlet*
@@ -50,7 +90,7 @@ type GuacamoleHTTPTunnelServlet struct {
 	/**
 	 * Map of absolutely all active tunnels using HTTP, indexed by tunnel UUID.
 	 */
-	tunnels GuacamoleHTTPTunnelMap
+	tunnels *GuacamoleHTTPTunnelMap
 
 	/**
 	 * Called whenever the JavaScript Guacamole client makes a connection
@@ -71,44 +111,232 @@ type GuacamoleHTTPTunnelServlet struct {
 	 *     If an error occurs while constructing the GuacamoleTunnel, or if the
 	 *     conditions required for connection are not met.
 	 */
-	doConnect     DoConnectInterface
-	doSuccConnect DoConnectSuccInterface
-	// doStopConnect DoConnectStopInterface
+	doConnect DoConnectInterface
+
+	/**
+	 * Replaces doConnect, if set, receiving the traced context of the
+	 * connect request.
+	 */
+	doConnectContext DoConnectContextInterface
+
+	/**
+	 * The bus receiving every lifecycle event of the tunnels of this
+	 * servlet.
+	 */
+	events *gevent.EventBus
+
+	/**
+	 * The registry recording the owner of every tunnel in the cluster, the
+	 * node of this servlet, the secret shared by the nodes, and the proxy
+	 * relaying requests for tunnels owned by other nodes. All are unset if
+	 * the servlet is standalone.
+	 */
+	registry  gcluster.TunnelRegistry
+	node      gcluster.NodeInfo
+	secret    []byte
+	forwarder *TunnelForwarder
+
+	/**
+	 * Resolves the principal of each request, to which tunnels are bound
+	 * at connect time, or nil if tunnels are not bound to an owner.
+	 */
+	ownerResolver TunnelOwnerResolverInterface
+
+	/**
+	 * The handlers of operations other than the built-in ones, by name.
+	 */
+	operations map[string]TunnelOperationInterface
+
+	/**
+	 * Limits the input written by clients, or nil if input is unlimited.
+	 */
+	inputLimiter *InputLimiter
+
+	/**
+	 * Counts the tunnels connected against their quotas, or nil if the
+	 * number of tunnels is unlimited, and the subscription through which
+	 * it releases them.
+	 */
+	quotas            *QuotaManager
+	quotaSubscription int
 }
 
 // NewGuacamoleHTTPTunnelServlet Construct funtion
+//  * doSuccConnect and doStopConnect, either of which may be nil, are
+//  * subscribed to the READY and CLOSED events of the servlet's EventBus.
 func NewGuacamoleHTTPTunnelServlet(doConnect DoConnectInterface, doSuccConnect DoConnectSuccInterface, doStopConnect DoConnectStopInterface) (ret GuacamoleHTTPTunnelServlet) {
-	ret.tunnels = NewGuacamoleHTTPTunnelMap(doStopConnect)
+	options := DefaultGuacamoleHTTPTunnelMapOptions()
+	options.Events = gevent.NewEventBus()
+	if doSuccConnect != nil {
+		options.Events.Subscribe(gevent.ConnectSuccAdapter(doSuccConnect), gevent.READY)
+	}
+	if doStopConnect != nil {
+		options.Events.Subscribe(gevent.ConnectStopAdapter(doStopConnect), gevent.CLOSED)
+	}
+	return NewGuacamoleHTTPTunnelServlet2(doConnect, options)
+}
+
+// NewGuacamoleHTTPTunnelServlet2 Construct funtion
+//  * Creates a servlet whose tunnel map uses the given options. The events
+//  * of its tunnels are published on options.Events, created if nil.
+func NewGuacamoleHTTPTunnelServlet2(doConnect DoConnectInterface, options GuacamoleHTTPTunnelMapOptions) (ret GuacamoleHTTPTunnelServlet) {
+	if options.Events == nil {
+		options.Events = gevent.NewEventBus()
+	}
+	ret.events = options.Events
+	ret.tunnels = NewGuacamoleHTTPTunnelMap3(options)
 	ret.doConnect = doConnect
-	ret.doSuccConnect = doSuccConnect
-	// ret.doStopConnect = doStopConnect
+	ret.operations = make(map[string]TunnelOperationInterface)
+	return
+}
+
+/*GetEventBus *
+ * Returns the bus receiving every lifecycle event of the tunnels of this
+ * servlet, to which further listeners may be subscribed.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) GetEventBus() *gevent.EventBus {
+	return opt.events
+}
+
+/*SetDoConnectContext *
+ * Sets the function called instead of doConnect for connect requests,
+ * receiving the context of the request. The context carries the
+ * "doConnect" span, itself a child of any "traceparent" header sent with
+ * the request, and should be passed to NewInetGuacamoleSocketContext and
+ * NewConfiguredGuacamoleSocketContext so that their spans join the trace.
+ *
+ * @param doConnectContext
+ *     The connect function, or nil to use doConnect again.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) SetDoConnectContext(doConnectContext DoConnectContextInterface) {
+	opt.doConnectContext = doConnectContext
+}
+
+/*SetOperation *
+ * Adds an operation of the form "NAME:UUID[:ARGUMENT...]" to this servlet,
+ * handled by the given function once the tunnel is found and its owner
+ * verified. Built-in operations cannot be replaced. Must be called before
+ * any request is handled.
+ *
+ * @param name
+ *     The name of the operation.
+ *
+ * @param operation
+ *     The function handling the operation, or nil to remove it.
+ *
+ * @throws GuacamoleException
+ *     If the name is that of a built-in operation or is not valid.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) SetOperation(name string, operation TunnelOperationInterface) (err exp.ExceptionInterface) {
+	if name == CONNECT_OPERATION || isBuiltinOperation(name) {
+		return exp.GuacamoleServerException.Throw("Operation \"" + name + "\" is built in.")
+	}
+	if len(name) == 0 || strings.ContainsAny(name, ":&=") {
+		return exp.GuacamoleServerException.Throw("Invalid operation name \"" + name + "\".")
+	}
+	if operation == nil {
+		delete(opt.operations, name)
+	} else {
+		opt.operations[name] = operation
+	}
 	return
 }
 
+/**
+ * Returns whether the given operation, applying to a tunnel, is handled by
+ * the servlet itself.
+ */
+func isBuiltinOperation(name string) bool {
+	switch name {
+	case READ_OPERATION, WRITE_OPERATION, RESUME_OPERATION, STATUS_OPERATION, CLOSE_OPERATION:
+		return true
+	}
+	return false
+}
+
+/*SetOwnerResolver *
+ * Binds each tunnel to the principal of its connect request, as returned
+ * by the given resolver. Read, write and resume requests whose principal
+ * differs are rejected with GuacamoleSecurityException before the tunnel
+ * is touched. Must be called before any request is handled.
+ *
+ * @param resolver
+ *     The resolver, such as CookieOwnerResolver, or nil to leave tunnels
+ *     unbound.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) SetOwnerResolver(resolver TunnelOwnerResolverInterface) {
+	opt.ownerResolver = resolver
+}
+
+/**
+ * Returns the principal of the given request, or "" if tunnels are not
+ * bound to an owner.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) resolveOwner(request HTTPServletRequestInterface) (string, exp.ExceptionInterface) {
+	if opt.ownerResolver == nil {
+		return "", nil
+	}
+	return opt.ownerResolver(request)
+}
+
 /**
  * Registers the given tunnel such that future read/write requests to that
  * tunnel will be properly directed.
  *
+ * @param ctx
+ *     The context of the connect request which created the tunnel.
+ *
  * @param tunnel
  *     The tunnel to register.
+ *
+ * @param owner
+ *     The principal of the connect request, or "" if unbound.
+ *
+ * @throws GuacamoleException
+ *     If the servlet is no longer accepting tunnels.
  */
-func (opt *GuacamoleHTTPTunnelServlet) registerTunnel(tunnel gnet.GuacamoleTunnel) {
-	opt.tunnels.Put(tunnel.GetUUID().String(), tunnel)
+func (opt *GuacamoleHTTPTunnelServlet) registerTunnel(ctx context.Context, tunnel gnet.GuacamoleTunnel,
+	owner string) (err exp.ExceptionInterface) {
+	if !opt.tunnels.put(ctx, tunnel.GetUUID().String(), tunnel, owner) {
+		return exp.GuacamoleServerBusyException.Throw("Server is not accepting new connections.")
+	}
 	logger.Debugf("Registered tunnel \"%v\".", tunnel.GetUUID())
-	opt.doSuccConnect(tunnel)
+	if opt.registry != nil {
+		if e := opt.registry.Register(tunnel.GetUUID().String(), opt.node); e != nil {
+			logger.Warnf("Unable to register tunnel \"%v\" in cluster: %v", tunnel.GetUUID(), e.GetMessage())
+		}
+	}
+	opt.events.Publish(gevent.NewTunnelEvent(gevent.READY, tunnel))
+	return
 }
 
 /**
  * Deregisters the given tunnel such that future read/write requests to
- * that tunnel will be rejected.
+ * that tunnel will be rejected. A CLOSED event is published with the given
+ * reason if the tunnel was still registered.
  *
  * @param tunnel
  *     The tunnel to deregister.
+ *
+ * @param reason
+ *     One of the gevent.CLOSE_REASON_* values.
+ *
+ * @param err
+ *     The error which ended the tunnel, or nil.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) deregisterTunnel(tunnel gnet.GuacamoleTunnel,
+	reason string, err exp.ExceptionInterface) {
+	closed := gevent.NewTunnelEvent(gevent.CLOSED, nil).WithError(err)
+	closed.Reason = reason
+	opt.deregisterTunnel2(tunnel, closed)
+}
+
+/**
+ * Deregisters the given tunnel, publishing the given CLOSED event.
  */
-func (opt *GuacamoleHTTPTunnelServlet) deregisterTunnel(tunnel gnet.GuacamoleTunnel) {
-	opt.tunnels.Remove(tunnel.GetUUID().String())
+func (opt *GuacamoleHTTPTunnelServlet) deregisterTunnel2(tunnel gnet.GuacamoleTunnel, closed gevent.TunnelEvent) {
+	opt.tunnels.remove(tunnel.GetUUID().String(), closed)
 	logger.Debugf("Deregistered tunnel \"%v\".", tunnel.GetUUID())
-	// opt.doStopConnect(tunnel)
 }
 
 /**
@@ -125,18 +353,171 @@ func (opt *GuacamoleHTTPTunnelServlet) deregisterTunnel(tunnel gnet.GuacamoleTun
  *     If the requested tunnel does not exist because it has not yet been
  *     registered or it has been deregistered.
  */
-func (opt *GuacamoleHTTPTunnelServlet) getTunnel(tunnelUUID string) (ret gnet.GuacamoleTunnel,
+func (opt *GuacamoleHTTPTunnelServlet) getTunnel(tunnelUUID string) (ret *GuacamoleHTTPTunnel,
 	err exp.ExceptionInterface) {
 
 	// Pull tunnel from map
 	ret, ok := opt.tunnels.Get(tunnelUUID)
 
 	if !ok {
+		// Report tunnels closed by the session policy as such
+		if err, ok = opt.tunnels.getTombstone(tunnelUUID); ok {
+			return
+		}
 		err = exp.GuacamoleResourceNotFoundException.Throw("No such tunnel.")
 	}
 	return
 }
 
+/**
+ * Returns the tunnel with the given UUID as getTunnel does, provided the
+ * given request is made by the owner of the tunnel.
+ *
+ * @throws GuacamoleException
+ *     If the tunnel does not exist, or is owned by another principal.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) getOwnedTunnel(request HTTPServletRequestInterface,
+	tunnelUUID string) (ret *GuacamoleHTTPTunnel, err exp.ExceptionInterface) {
+
+	principal, err := opt.resolveOwner(request)
+	if err != nil {
+		return
+	}
+	ret, err = opt.getTunnel(tunnelUUID)
+	if err != nil {
+		return
+	}
+	if !isOwner(ret.owner, principal) {
+		logger.Warnf("Request for tunnel \"%v\" denied: not made by the owner of the tunnel.", tunnelUUID)
+		return nil, exp.GuacamoleSecurityException.Throw("Permission denied.")
+	}
+	return
+}
+
+/*SetTunnelRegistry *
+ * Joins this servlet to a cluster of nodes sharing the given registry.
+ * Tunnels created here are registered as owned by the given node, and
+ * read, write and resume requests for tunnels owned by other nodes are
+ * forwarded to their owner. Forwarded requests are signed with the given
+ * secret, and the forwarding headers of requests not signed with it are
+ * ignored. Must be called before any request is handled.
+ *
+ * @param registry
+ *     The registry shared by every node.
+ *
+ * @param node
+ *     The node of this servlet, whose Address reaches this servlet.
+ *
+ * @param secret
+ *     The secret shared by every node, which clients must not know.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) SetTunnelRegistry(registry gcluster.TunnelRegistry, node gcluster.NodeInfo,
+	secret []byte) {
+	opt.registry = registry
+	opt.node = node
+	opt.secret = secret
+	opt.forwarder = NewTunnelForwarder(node, secret)
+	opt.events.Subscribe(func(event gevent.TunnelEvent) {
+		if err := registry.Unregister(event.TunnelUUID); err != nil {
+			logger.Warnf("Unable to unregister tunnel \"%v\": %v", event.TunnelUUID, err.GetMessage())
+		}
+	}, gevent.CLOSED)
+}
+
+/**
+ * Forwards the given request to the node owning the tunnel having the given
+ * UUID, if the servlet is part of a cluster and the tunnel is owned by
+ * another node. Requests already forwarded are never forwarded again.
+ *
+ * @return
+ *     Whether the request was forwarded, and the error preventing it from
+ *     being forwarded, if any.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) forward(tunnelUUID, query string, request HTTPServletRequestInterface,
+	response HTTPServletResponseInterface) (forwarded bool, err exp.ExceptionInterface) {
+
+	if opt.registry == nil || isForwarded(request) {
+		return
+	}
+
+	// Local tunnels, including recently closed ones, are handled here
+	if _, ok := opt.tunnels.Get(tunnelUUID); ok {
+		return
+	}
+	if _, ok := opt.tunnels.getTombstone(tunnelUUID); ok {
+		return
+	}
+
+	owner, ok, err := opt.registry.Lookup(tunnelUUID)
+	if err != nil || !ok || owner.ID == opt.node.ID {
+		return
+	}
+
+	logger.Debugf("Forwarding request for tunnel \"%v\" to node \"%v\".", tunnelUUID, owner.ID)
+	return true, opt.forwarder.Forward(owner, query, request, response)
+}
+
+/*SetSessionPolicy *
+ * Sets the limits on the length of the sessions of this servlet.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) SetSessionPolicy(policy SessionPolicy) {
+	opt.tunnels.SetSessionPolicy(policy)
+}
+
+/*SetInputLimits *
+ * Sets the limits on the input written by clients. Writes exceeding the
+ * rates are delayed, and close their tunnel with CLIENT_TOO_MANY if they
+ * would be delayed longer than INPUT_MAX_DELAY. Instructions exceeding the
+ * sizes close their tunnel with CLIENT_OVERRUN. Must be called before any
+ * request is handled.
+ *
+ * @param limits
+ *     The limits on client input.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) SetInputLimits(limits InputLimits) {
+	opt.SetInputLimiter(NewInputLimiter(limits))
+}
+
+/*SetInputLimiter *
+ * Limits the input written by clients as SetInputLimits does, with the
+ * given limiter, which may be shared with a WebSocket tunnel endpoint so
+ * that the limits of each user cover both. Must be called before any
+ * request is handled.
+ *
+ * @param limiter
+ *     The input limiter.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) SetInputLimiter(limiter *InputLimiter) {
+	opt.inputLimiter = limiter
+}
+
+/*SetQuotaManager *
+ * Counts the tunnels of this servlet against the quotas of the given
+ * manager, which may be shared with a WebSocket tunnel endpoint. Connect
+ * requests beyond the quotas fail with CLIENT_TOO_MANY or SERVER_BUSY.
+ * Must be called before any request is handled.
+ *
+ * @param quotas
+ *     The quota manager, replacing any set before, or nil for no quotas.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) SetQuotaManager(quotas *QuotaManager) {
+	if opt.quotas != nil {
+		opt.events.Unsubscribe(opt.quotaSubscription)
+	}
+	opt.quotas = quotas
+	if quotas != nil {
+		opt.quotaSubscription = quotas.Subscribe(opt.events)
+	}
+}
+
+/*GetQuotaManager *
+ * Returns the quota manager of this servlet, or nil if the number of
+ * tunnels is unlimited.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) GetQuotaManager() *QuotaManager {
+	return opt.quotas
+}
+
 //DoGet @Override
 func (opt *GuacamoleHTTPTunnelServlet) DoGet(request HTTPServletRequestInterface, response HTTPServletResponseInterface) error {
 	return opt.HandleTunnelRequest(request, response)
@@ -149,7 +530,11 @@ func (opt *GuacamoleHTTPTunnelServlet) DoPost(request HTTPServletRequestInterfac
 
 /**
  * Sends an error on the given HTTP response using the information within
- * the given GuacamoleStatus.
+ * the given GuacamoleStatus. Clients accepting "application/json" also
+ * receive the error as an ErrorResponse.
+ *
+ * @param request
+ *     The HTTP request which failed.
  *
  * @param response
  *     The HTTP response to use to send the error.
@@ -160,11 +545,14 @@ func (opt *GuacamoleHTTPTunnelServlet) DoPost(request HTTPServletRequestInterfac
  * @param message
  *     A human-readable message that can be presented to the user.
  *
+ * @param correlationID
+ *     The correlation ID of the request, sent along with the error.
+ *
  * @throws ServletException
  *     If an error prevents sending of the error code.
  */
-func (opt *GuacamoleHTTPTunnelServlet) sendError(response HTTPServletResponseInterface,
-	guacStatus exp.GuacamoleStatus, message string) (err error) {
+func (opt *GuacamoleHTTPTunnelServlet) sendError(request HTTPServletRequestInterface, response HTTPServletResponseInterface,
+	guacStatus exp.GuacamoleStatus, message, correlationID string) (err error) {
 
 	committed, err := response.IsCommitted()
 	if err != nil {
@@ -174,12 +562,26 @@ func (opt *GuacamoleHTTPTunnelServlet) sendError(response HTTPServletResponseInt
 	}
 
 	// If response not committed, send error code and message
-	if !committed {
-		response.AddHeader("Guacamole-Status-Code", fmt.Sprintf("%v", guacStatus.GetGuacamoleStatusCode()))
-		response.AddHeader("Guacamole-Error-Message", message)
-		err = response.SendError(guacStatus.GetHTTPStatusCode())
+	if committed {
+		return
 	}
-	return
+	response.AddHeader("Guacamole-Status-Code", fmt.Sprintf("%v", guacStatus.GetGuacamoleStatusCode()))
+	response.AddHeader("Guacamole-Error-Message", message)
+	response.SetHeader(CORRELATION_ID_HEADER, correlationID)
+	if !acceptsJSON(request) {
+		return response.SendError(guacStatus.GetHTTPStatusCode())
+	}
+
+	body, err := json.Marshal(NewErrorResponse(guacStatus, message, correlationID))
+	if err != nil {
+		return
+	}
+	response.SetHeader("Cache-Control", "no-cache")
+	response.SetContentType("application/json")
+	if err = response.SendError(guacStatus.GetHTTPStatusCode()); err != nil {
+		return
+	}
+	return response.Write(body)
 }
 
 /*HandleTunnelRequest put it into GET/POST handle *
@@ -200,45 +602,111 @@ func (opt *GuacamoleHTTPTunnelServlet) sendError(response HTTPServletResponseInt
 func (opt *GuacamoleHTTPTunnelServlet) HandleTunnelRequest(request HTTPServletRequestInterface,
 	response HTTPServletResponseInterface) (e error) {
 
+	start := time.Now()
+	defer func() {
+		gmetrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), tunnelOperation(request.GetQueryString()))
+	}()
+
+	// Only trust forwarding headers set by another node
+	request = authenticateForwarded(request, opt.secret)
+
 	err := opt.handleTunnelRequestCore(request, response)
 	if err == nil {
 		return
 	}
-	switch err.Kind() {
-	case exp.GuacamoleClientException:
-		logger.Warn("HTTP tunnel request rejected: ", err.GetMessage())
-		e = opt.sendError(response, err.GetStatus(), err.GetMessage())
-	default:
-		logger.Error("HTTP tunnel request failed: ", err.GetMessage())
+	id := correlationID(request)
+	if isRejection(err) {
+		logger.WithField("correlation_id", id).Warn("HTTP tunnel request rejected: ", err.GetMessage())
+		e = opt.sendError(request, response, err.GetStatus(), err.GetMessage(), id)
+	} else {
+		logger.WithField("correlation_id", id).Error("HTTP tunnel request failed: ", err.GetMessage())
 		logger.Debug("Internal error in HTTP tunnel.", err)
-		e = opt.sendError(response, err.GetStatus(), "Internal server error.")
+		e = opt.sendError(request, response, err.GetStatus(), "Internal server error.", id)
 	}
 	return
 }
 
+/**
+ * The kinds of errors, along with their subkinds such as every client
+ * error, which are expected in normal operation and whose message is shown
+ * to the client.
+ */
+var rejectionKinds = []exp.ExceptionKind{
+	exp.GuacamoleClientException,
+	exp.GuacamoleServerBusyException,
+	exp.GuacamoleSessionClosedException,
+	exp.GuacamoleSessionConflictException,
+	exp.GuacamoleSessionTimeoutException,
+}
+
+/**
+ * Returns whether the given error rejects the request rather than reporting
+ * a failure of the server.
+ */
+func isRejection(err exp.ExceptionInterface) bool {
+	for _, kind := range rejectionKinds {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
+
 func (opt *GuacamoleHTTPTunnelServlet) handleTunnelRequestCore(request HTTPServletRequestInterface,
 	response HTTPServletResponseInterface) (err exp.ExceptionInterface) {
 	query := request.GetQueryString()
-	if len(query) == 0 {
-		return exp.GuacamoleClientException.Throw("No query string provided.")
+	tunnelRequest, err := ParseTunnelRequest(query)
+	if err != nil {
+		return
 	}
+
 	// If connect operation, call doConnect() and return tunnel UUID
 	// in response.
-	if query == "connect" {
+	if tunnelRequest.Operation == CONNECT_OPERATION {
 
-		tunnel, e := opt.doConnect(request)
+		// Refuse new tunnels while draining or shut down
+		if !opt.tunnels.IsAccepting() {
+			return exp.GuacamoleServerBusyException.Throw("Server is not accepting new connections.")
+		}
+
+		// Identify the owner before connecting on their behalf
+		owner, ownerErr := opt.resolveOwner(request)
+		if ownerErr != nil {
+			return ownerErr
+		}
+
+		ctx := requestContext(request)
+
+		// Take a share of the quotas, completed by doConnect
+		var reservation *QuotaReservation
+		if opt.quotas != nil {
+			if reservation, err = opt.quotas.ReserveRequest(request); err != nil {
+				return
+			}
+			ctx = WithQuotaReservation(ctx, reservation)
+		}
+
+		tunnel, e := opt.connect(ctx, request)
 		// Failed to connect
 		if tunnel == nil || e != nil {
+			reservation.Release()
 			switch e.(type) {
 			case exp.ExceptionInterface:
 				err = e.(exp.ExceptionInterface)
 			default:
-				err = exp.GuacamoleResourceNotFoundException.Throw("No tunnel created.", e.Error())
+				err = exp.GuacamoleResourceNotFoundException.Wrap(e, "No tunnel created.")
 			}
 			return
 		}
-		// Register newly-created tunnel
-		opt.registerTunnel(tunnel)
+		// Register newly-created tunnel, whose CLOSED event releases its
+		// reservation
+		reservation.Bind(tunnel.GetUUID().String())
+		err = opt.registerTunnel(ctx, tunnel, owner)
+		if err != nil {
+			reservation.Release()
+			tunnel.Close()
+			return
+		}
 
 		// Ensure buggy browsers do not cache response
 		response.SetHeader("Cache-Control", "no-cache")
@@ -247,26 +715,102 @@ func (opt *GuacamoleHTTPTunnelServlet) handleTunnelRequestCore(request HTTPServl
 		e = response.WriteString(tunnel.GetUUID().String())
 
 		if e != nil {
-			err = exp.GuacamoleServerException.Throw(e.Error())
+			err = exp.GuacamoleServerException.Wrap(e)
 			return
 		}
+		return
+	}
+
+	// Refuse unknown operations before relaying them to another node
+	operation, custom := opt.operations[tunnelRequest.Operation]
+	if !custom && !isBuiltinOperation(tunnelRequest.Operation) {
+		return exp.GuacamoleClientException.Throw("Invalid tunnel operation: " + tunnelRequest.Operation)
+	}
+
+	// Every other operation applies to a tunnel, possibly owned by
+	// another node
+	tunnelUUID := tunnelRequest.TunnelUUID
+	if forwarded, e := opt.forward(tunnelUUID, query, request, response); forwarded {
+		return e
+	}
+
+	switch tunnelRequest.Operation {
+	case READ_OPERATION:
+		err = opt.doRead(request, response, tunnelUUID)
+	case RESUME_OPERATION:
+		// Resume from the timestamp of the last "sync" received by the
+		// client
+		err = opt.doResume(request, response, tunnelUUID, tunnelRequest.Timestamp)
+	case WRITE_OPERATION:
+		err = opt.doWrite(request, response, tunnelUUID)
+	case STATUS_OPERATION:
+		err = opt.doStatus(request, response, tunnelUUID)
+	case CLOSE_OPERATION:
+		err = opt.doClose(request, response, tunnelUUID)
+	default:
+		tunnel, e := opt.getOwnedTunnel(request, tunnelUUID)
+		if e != nil {
+			return e
+		}
+		err = operation(tunnel, tunnelRequest, request, response)
+	}
+	return
+}
+
+/**
+ * Calls doConnectContext, or doConnect if it is not set, within a
+ * "doConnect" span.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) connect(ctx context.Context,
+	request HTTPServletRequestInterface) (tunnel gnet.GuacamoleTunnel, e error) {
+
+	ctx, span := gtrace.Start(ctx, "doConnect")
+	defer span.End()
+
+	opt.events.Publish(gevent.NewTunnelEvent(gevent.CONNECTING, nil))
 
-	} else if strings.HasPrefix(query, READ_PREFIX) {
-		// If read operation, call doRead() with tunnel UUID, ignoring any
-		// characters following the tunnel UUID.
-		err = opt.doRead(request, response, query[READ_PREFIX_LENGTH:READ_PREFIX_LENGTH+UUID_LENGTH])
-	} else if strings.HasPrefix(query, (WRITE_PREFIX)) {
-		// If write operation, call doWrite() with tunnel UUID, ignoring any
-		// characters following the tunnel UUID.
-		err = opt.doWrite(request, response, query[WRITE_PREFIX_LENGTH:WRITE_PREFIX_LENGTH+UUID_LENGTH])
+	if opt.doConnectContext != nil {
+		tunnel, e = opt.doConnectContext(ctx, request)
 	} else {
-		// Otherwise, invalid operation
-		err = exp.GuacamoleClientException.Throw("Invalid tunnel operation: " + query)
+		tunnel, e = opt.doConnect(request)
 	}
 
+	switch {
+	case e != nil:
+		span.SetError(e.Error())
+		failed := gevent.NewTunnelEvent(gevent.UPSTREAM_ERROR, nil)
+		if err, ok := e.(exp.ExceptionInterface); ok {
+			failed = failed.WithError(err)
+		} else {
+			failed.Status, failed.Message = exp.SERVER_ERROR, e.Error()
+		}
+		opt.events.Publish(failed)
+	case tunnel == nil:
+		span.SetError("No tunnel created.")
+		failed := gevent.NewTunnelEvent(gevent.UPSTREAM_ERROR, nil)
+		failed.Status, failed.Message = exp.RESOURCE_NOT_FOUND, "No tunnel created."
+		opt.events.Publish(failed)
+	default:
+		span.SetAttribute("guacamole.tunnel_uuid", tunnel.GetUUID().String())
+		opt.events.Publish(gevent.NewTunnelEvent(gevent.HANDSHAKE_COMPLETE, tunnel))
+	}
 	return
 }
 
+/**
+ * Returns the context of the given request, carrying the remote parent
+ * span of its "traceparent" header, if any.
+ */
+func requestContext(request HTTPServletRequestInterface) context.Context {
+	ctx := context.Background()
+	if headers, ok := request.(HTTPServletRequestHeaderInterface); ok {
+		if parent, ok := gtrace.ParseTraceparent(headers.GetHeader(gtrace.TRACEPARENT_HEADER)); ok {
+			ctx = gtrace.ContextWithRemoteParent(ctx, parent)
+		}
+	}
+	return ctx
+}
+
 /**
  * Called whenever the JavaScript Guacamole client makes a read request.
  * This function should in general not be overridden, as it already
@@ -292,14 +836,14 @@ func (opt *GuacamoleHTTPTunnelServlet) doRead(request HTTPServletRequestInterfac
 	response HTTPServletResponseInterface, tunnelUUID string) (err exp.ExceptionInterface) {
 
 	// Get tunnel, ensure tunnel exists
-	tunnel, err := opt.getTunnel(tunnelUUID)
+	tunnel, err := opt.getOwnedTunnel(request, tunnelUUID)
 	if err != nil {
 		return
 	}
 
 	// Ensure tunnel is open
 	if !tunnel.IsOpen() {
-		opt.deregisterTunnel(tunnel)
+		opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_UPSTREAM, nil)
 		err = exp.GuacamoleResourceNotFoundException.Throw("Tunnel is closed.")
 		return
 	}
@@ -307,23 +851,96 @@ func (opt *GuacamoleHTTPTunnelServlet) doRead(request HTTPServletRequestInterfac
 	reader := tunnel.AcquireReader()
 	defer tunnel.ReleaseReader()
 
-	e := opt.doReadCore1(response, reader, tunnel)
+	opt.doReadCore0(response, reader, tunnel, nil)
+	return
+}
+
+/**
+ * Streams the given replayed data, then instructions read from the tunnel,
+ * to the response. If the client's connection is lost and the tunnel is
+ * resumable, the tunnel is left open for the client to resume; otherwise
+ * it is deregistered and closed.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) doReadCore0(response HTTPServletResponseInterface,
+	reader gio.GuacamoleReader, tunnel *GuacamoleHTTPTunnel, replayed []byte) {
+
+	tracked := &trackedResponse{HTTPServletResponseInterface: response}
+	e := opt.doReadCore1(tracked, reader, tunnel, replayed)
 
 	if e != nil {
 
 		// Log typically frequent I/O error if desired
 		logger.Debug("Error writing to servlet output stream", e)
+		opt.events.Publish(gevent.NewTunnelEvent(gevent.READER_ERROR, tunnel).WithError(e))
+
+		// Await the client if only its connection was lost
+		if tracked.failed && tunnel.IsResumable() && tunnel.IsOpen() {
+			logger.Debugf("Awaiting client of HTTP tunnel \"%v\" to resume.", tunnel.GetUUID())
+			tunnel.detach()
+			return
+		}
 
 		// Deregister and close
-		opt.deregisterTunnel(tunnel)
+		opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_IO_ERROR, e)
 		tunnel.Close()
 	}
+}
+
+/**
+ * Called whenever the JavaScript Guacamole client resumes a tunnel after
+ * losing its connection. Every instruction sent after the given "sync" is
+ * resent, followed by instructions read from the tunnel as for a read
+ * request.
+ *
+ * @param request
+ *     The HttpServletRequest associated with the resume request received.
+ *
+ * @param response
+ *     The HttpServletResponse associated with the resume request received.
+ *
+ * @param tunnelUUID
+ *     The UUID of the tunnel to resume.
+ *
+ * @param timestamp
+ *     The timestamp of the last "sync" instruction received by the client,
+ *     or -1 if it received none.
+ *
+ * @throws GuacamoleException
+ *     If the tunnel does not exist, is not resumable, or no longer holds
+ *     the instructions following the given "sync".
+ */
+func (opt *GuacamoleHTTPTunnelServlet) doResume(request HTTPServletRequestInterface,
+	response HTTPServletResponseInterface, tunnelUUID string, timestamp int64) (err exp.ExceptionInterface) {
+
+	tunnel, err := opt.getOwnedTunnel(request, tunnelUUID)
+	if err != nil {
+		return
+	}
+	if !tunnel.IsResumable() {
+		return exp.GuacamoleClientException.Throw("Tunnel is not resumable.")
+	}
+	if !tunnel.IsOpen() {
+		opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_UPSTREAM, nil)
+		return exp.GuacamoleResourceNotFoundException.Throw("Tunnel is closed.")
+	}
 
+	// Wait for any read still in progress on the lost connection
+	reader := tunnel.AcquireReader()
+	defer tunnel.ReleaseReader()
+
+	replayed, err := tunnel.replay.Since(timestamp)
+	if err != nil {
+		return
+	}
+	tunnel.attach()
+	logger.Debugf("Resuming HTTP tunnel \"%v\" after sync %v, replaying %v bytes.", tunnelUUID, timestamp, len(replayed))
+
+	opt.doReadCore0(response, reader, tunnel, replayed)
 	return
 }
 
 func (opt *GuacamoleHTTPTunnelServlet) doReadCore1(response HTTPServletResponseInterface,
-	reader gio.GuacamoleReader, tunnel gnet.GuacamoleTunnel) (e exp.ExceptionInterface) {
+	reader gio.GuacamoleReader, tunnel *GuacamoleHTTPTunnel, replayed []byte) (e exp.ExceptionInterface) {
 	// Note that although we are sending text, Webkit browsers will
 	// buffer 1024 bytes before starting a normal stream if we use
 	// anything but application/octet-stream.
@@ -336,6 +953,13 @@ func (opt *GuacamoleHTTPTunnelServlet) doReadCore1(response HTTPServletResponseI
 	// Get writer for response
 	// Writer out = new BufferedWriter(new OutputStreamWriter(response.getOutputStream(), "UTF-8"));
 
+	// Resend instructions the client missed while resuming
+	if len(replayed) > 0 {
+		if err := response.Write(replayed); err != nil {
+			return exp.GuacamoleException.Wrap(err)
+		}
+	}
+
 	// Stream data to response, ensuring output stream is closed
 	err := opt.doReadCore2(response, reader, tunnel)
 
@@ -348,9 +972,12 @@ func (opt *GuacamoleHTTPTunnelServlet) doReadCore1(response HTTPServletResponseI
 	// Send end-of-stream marker and close tunnel if connection is closed
 	case exp.GuacamoleConnectionClosedException:
 		// Deregister and close
-		opt.deregisterTunnel(tunnel)
+		opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_UPSTREAM, nil)
 		tunnel.Close()
 
+		// Deliver anything queued before the tunnel was closed
+		opt.writePending(response, tunnel)
+
 		// End-of-instructions marker
 		response.WriteString("0.;")
 		response.FlushBuffer()
@@ -362,25 +989,49 @@ func (opt *GuacamoleHTTPTunnelServlet) doReadCore1(response HTTPServletResponseI
 }
 
 func (opt *GuacamoleHTTPTunnelServlet) doReadCore2(response HTTPServletResponseInterface,
-	reader gio.GuacamoleReader, tunnel gnet.GuacamoleTunnel) (err exp.ExceptionInterface) {
+	reader gio.GuacamoleReader, tunnel *GuacamoleHTTPTunnel) (err exp.ExceptionInterface) {
 	var ok bool
 	var message []byte
+	// Whether guacd ended the session with an "error" instruction
+	ended := false
 	// Deregister tunnel and throw error if we reach EOF without
 	// having ever sent any data
 	message, err = reader.Read()
 	if err != nil {
+		// A tunnel closed by its session policy ends with the "error"
+		// instruction it queued
+		if _, expired := opt.tunnels.getTombstone(tunnel.GetUUID().String()); expired {
+			opt.writePending(response, tunnel)
+			response.WriteString("0.;")
+			response.FlushBuffer()
+			return nil
+		}
 		return
 	}
 	// start := time.Now().UnixNano()
 	// For all messages, until another stream is ready (we send at least one message)
 	for ; tunnel.IsOpen() && len(message) > 0 && err == nil; message, err = reader.Read() {
+		// Send instructions queued by the server first
+		e := opt.writePending(response, tunnel)
+		if e != nil {
+			err = exp.GuacamoleException.Wrap(e)
+			return
+		}
+
+		// Keep for resuming, before writing in case the write is lost
+		if tunnel.replay != nil {
+			tunnel.replay.Append(message)
+		}
+
 		// time.Sleep(2000)
 		// Get message output bytes
-		e := response.Write(message)
+		e = response.Write(message)
 		if e != nil {
-			err = exp.GuacamoleException.Throw(e.Error())
+			err = exp.GuacamoleException.Wrap(e)
 			return
 		}
+		gmetrics.TunnelBytes.Add(float64(len(message)), gmetrics.SERVER_TO_CLIENT)
+		gmetrics.TunnelInstructions.Inc(gmetrics.SERVER_TO_CLIENT)
 
 		// Flush if we expect to wait
 		ok, err = reader.Available()
@@ -391,16 +1042,24 @@ func (opt *GuacamoleHTTPTunnelServlet) doReadCore2(response HTTPServletResponseI
 		if !ok {
 			e = response.FlushBuffer()
 			if e != nil {
-				err = exp.GuacamoleException.Throw(e.Error())
+				err = exp.GuacamoleException.Wrap(e)
 				return
 			}
 		}
-		if strings.Contains(string(message), "error") {
-			response.Write([]byte("10.disconnect;"))
+		// guacd ended the session, its "error" instruction having been
+		// sent, later requests fail with the status it reported
+		if gprotocol.IsErrorInstruction(message) {
 			response.FlushBuffer()
-			tunnel.GetConfiguration().SetParameter("errMsg", string(message))
-			opt.deregisterTunnel(tunnel)
+
+			upstream := gprotocol.ParseErrorInstructionException(string(message))
+			opt.events.Publish(gevent.NewTunnelEvent(gevent.UPSTREAM_ERROR, tunnel).WithError(upstream))
+
+			uuid := tunnel.GetUUID().String()
+			opt.tunnels.bury(uuid, upstream)
+			opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_UPSTREAM, upstream)
 			tunnel.Close()
+			ended = true
+			break
 		}
 		// No more messages another stream can take over
 		if tunnel.HasQueuedReaderThreads() {
@@ -410,9 +1069,21 @@ func (opt *GuacamoleHTTPTunnelServlet) doReadCore2(response HTTPServletResponseI
 		// 	break
 		// }
 	}
+	// A tunnel closed by its session policy ends with the "error"
+	// instruction it queued
+	if _, expired := opt.tunnels.getTombstone(tunnel.GetUUID().String()); expired {
+		ended = true
+	}
+	opt.writePending(response, tunnel)
+
 	// Close tunnel immediately upon EOF
-	if err != nil {
-		opt.deregisterTunnel(tunnel)
+	if err != nil && !ended {
+		if err.Kind() == exp.GuacamoleConnectionClosedException {
+			opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_UPSTREAM, nil)
+		} else {
+			opt.events.Publish(gevent.NewTunnelEvent(gevent.READER_ERROR, tunnel).WithError(err))
+			opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_IO_ERROR, err)
+		}
 		tunnel.Close()
 	}
 
@@ -422,6 +1093,25 @@ func (opt *GuacamoleHTTPTunnelServlet) doReadCore2(response HTTPServletResponseI
 	return nil
 }
 
+/**
+ * Writes every instruction queued on the given tunnel by the server, such
+ * as session policy notices, to the given response.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) writePending(response HTTPServletResponseInterface,
+	tunnel *GuacamoleHTTPTunnel) error {
+	for _, instruction := range tunnel.takePending() {
+		if tunnel.replay != nil {
+			tunnel.replay.Append(instruction)
+		}
+		if e := response.Write(instruction); e != nil {
+			return e
+		}
+		gmetrics.TunnelBytes.Add(float64(len(instruction)), gmetrics.SERVER_TO_CLIENT)
+		gmetrics.TunnelInstructions.Inc(gmetrics.SERVER_TO_CLIENT)
+	}
+	return nil
+}
+
 /**
  * Called whenever the JavaScript Guacamole client makes a write request.
  * This function should in general not be overridden, as it already
@@ -445,7 +1135,7 @@ func (opt *GuacamoleHTTPTunnelServlet) doReadCore2(response HTTPServletResponseI
  */
 func (opt *GuacamoleHTTPTunnelServlet) doWrite(request HTTPServletRequestInterface,
 	response HTTPServletResponseInterface, tunnelUUID string) (err exp.ExceptionInterface) {
-	tunnel, err := opt.getTunnel(tunnelUUID)
+	tunnel, err := opt.getOwnedTunnel(request, tunnelUUID)
 	if err != nil {
 		return
 	}
@@ -461,6 +1151,15 @@ func (opt *GuacamoleHTTPTunnelServlet) doWrite(request HTTPServletRequestInterfa
 	writer := tunnel.AcquireWriter()
 	defer tunnel.ReleaseWriter()
 
+	// Input is checked by the gate of the tunnel if limited, otherwise
+	// parsed only to count the instructions written and to detect user
+	// input
+	if opt.inputLimiter != nil {
+		return opt.doLimitedWrite(request, tunnel, writer)
+	}
+	counter := gprotocol.NewGuacamoleStreamParser()
+	counting := true
+
 	var e error
 	length := 0
 	buffer := make([]byte, 8192, 8192)
@@ -469,6 +1168,13 @@ func (opt *GuacamoleHTTPTunnelServlet) doWrite(request HTTPServletRequestInterfa
 		if err != nil {
 			break
 		}
+		gmetrics.TunnelBytes.Add(float64(length), gmetrics.CLIENT_TO_SERVER)
+		if counting {
+			instructions, perr := counter.Append(buffer[:length])
+			gmetrics.TunnelInstructions.Add(float64(len(instructions)), gmetrics.CLIENT_TO_SERVER)
+			counting = perr == nil
+			markInput(tunnel, instructions)
+		}
 	}
 	if e != nil {
 		// EOF
@@ -477,12 +1183,172 @@ func (opt *GuacamoleHTTPTunnelServlet) doWrite(request HTTPServletRequestInterfa
 	}
 
 	if err != nil {
-		opt.deregisterTunnel(tunnel)
+		opt.events.Publish(gevent.NewTunnelEvent(gevent.WRITER_ERROR, tunnel).WithError(err))
+		opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_IO_ERROR, err)
 		tunnel.Close()
 	}
 	return
 }
 
+/**
+ * Writes the body of the given write request to the given tunnel as its
+ * input gate admits it, with the writer of the tunnel acquired. Input
+ * beyond the rate limits is delayed.
+ *
+ * @throws GuacamoleClientTooManyException
+ *     If the input would be delayed too long. The tunnel is closed.
+ *
+ * @throws GuacamoleClientOverrunException
+ *     If an instruction exceeds the size limits. The tunnel is closed.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) doLimitedWrite(request HTTPServletRequestInterface,
+	tunnel *GuacamoleHTTPTunnel, writer gio.GuacamoleWriter) (err exp.ExceptionInterface) {
+	if tunnel.input == nil {
+		tunnel.input = opt.inputLimiter.Open(tunnel.owner)
+	}
+
+	var data bytes.Buffer
+	buffer := make([]byte, 8192)
+	for tunnel.IsOpen() {
+		length, e := request.Read(buffer)
+		if length > 0 {
+			// Refused input closes the tunnel rather than being dropped,
+			// which could leave keys or buttons pressed
+			instructions, delay, refused := tunnel.input.Admit(buffer[:length])
+			if refused != nil {
+				opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_CLIENT, refused)
+				tunnel.Close()
+				return refused
+			}
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+
+			data.Reset()
+			for _, instruction := range instructions {
+				data.WriteString(instruction.String())
+			}
+			if data.Len() > 0 {
+				if err = writer.WriteAll(data.Bytes()); err != nil {
+					opt.events.Publish(gevent.NewTunnelEvent(gevent.WRITER_ERROR, tunnel).WithError(err))
+					opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_IO_ERROR, err)
+					tunnel.Close()
+					return
+				}
+				gmetrics.TunnelBytes.Add(float64(data.Len()), gmetrics.CLIENT_TO_SERVER)
+				gmetrics.TunnelInstructions.Add(float64(len(instructions)), gmetrics.CLIENT_TO_SERVER)
+				markInput(tunnel, instructions)
+			}
+		}
+		if e != nil || length == 0 {
+			return nil
+		}
+	}
+	return
+}
+
+/**
+ * Marks the given tunnel as having received user input if any of the
+ * given instructions written by its client is user input.
+ */
+func markInput(tunnel *GuacamoleHTTPTunnel, instructions []gprotocol.GuacamoleInstruction) {
+	for _, instruction := range instructions {
+		if isUserInput(instruction) {
+			tunnel.Input()
+			return
+		}
+	}
+}
+
+/**
+ * Called whenever a client requests the status of a tunnel, answering
+ * with its TunnelStatus as JSON.
+ *
+ * @throws GuacamoleException
+ *     If the tunnel does not exist or is owned by another principal.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) doStatus(request HTTPServletRequestInterface,
+	response HTTPServletResponseInterface, tunnelUUID string) (err exp.ExceptionInterface) {
+	tunnel, err := opt.getOwnedTunnel(request, tunnelUUID)
+	if err != nil {
+		return
+	}
+
+	body, e := json.Marshal(NewTunnelStatus(tunnel))
+	if e != nil {
+		return exp.GuacamoleServerException.Wrap(e)
+	}
+	response.SetHeader("Cache-Control", "no-cache")
+	response.SetContentType("application/json")
+	if e = response.Write(body); e != nil {
+		return exp.GuacamoleServerException.Wrap(e)
+	}
+	return
+}
+
+/**
+ * Called whenever a client requests that a tunnel be closed. Any read in
+ * progress ends as the tunnel closes.
+ *
+ * @throws GuacamoleException
+ *     If the tunnel does not exist or is owned by another principal.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) doClose(request HTTPServletRequestInterface,
+	response HTTPServletResponseInterface, tunnelUUID string) (err exp.ExceptionInterface) {
+	tunnel, err := opt.getOwnedTunnel(request, tunnelUUID)
+	if err != nil {
+		return
+	}
+
+	opt.deregisterTunnel(tunnel, gevent.CLOSE_REASON_CLIENT, nil)
+	if e := tunnel.Close(); e != nil {
+		logger.Debugf("Error closing tunnel \"%v\": %v", tunnelUUID, e.GetMessage())
+	}
+	response.SetHeader("Cache-Control", "no-cache")
+	return
+}
+
+/*Drain *
+ * Drains this servlet ahead of a restart. New "connect" requests are
+ * refused with SERVER_BUSY, and the user of each active session is sent a
+ * "reconnecting soon" notice over the notice stream. Sessions may then
+ * continue until they end or the given context is done, after which those
+ * remaining are closed with SERVER_BUSY, prompting the JavaScript client to
+ * reconnect elsewhere.
+ *
+ * @param ctx
+ *     The context whose deadline, or cancellation, ends the drain.
+ *
+ * @return
+ *     The number of sessions closed because they did not end in time.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) Drain(ctx context.Context) int {
+	deadline, _ := ctx.Deadline()
+	// The sessions left are closed here once ctx is done, so that they
+	// are counted, rather than by the map at the deadline
+	opt.tunnels.Drain(time.Time{}, exp.SERVER_BUSY, DRAIN_CLOSE_MESSAGE)
+	logger.Infof("Draining HTTP tunnels, %v active.", opt.tunnels.Size())
+
+	opt.tunnels.notifyAll(DrainNotice(deadline))
+
+	if opt.tunnels.WaitEmpty(ctx) {
+		return 0
+	}
+
+	remaining := opt.tunnels.Size()
+	logger.Infof("Drain deadline reached, closing %v remaining HTTP tunnels.", remaining)
+	opt.tunnels.terminateAll(gevent.CLOSE_REASON_SHUTDOWN,
+		exp.GuacamoleServerBusyException.Throw(DRAIN_CLOSE_MESSAGE))
+	return remaining
+}
+
+/*Size *
+ * Returns the number of HTTP tunnels currently open.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) Size() int {
+	return opt.tunnels.Size()
+}
+
 // Destroy release
 func (opt *GuacamoleHTTPTunnelServlet) Destroy() {
 	opt.tunnels.Shutdown()
@@ -498,3 +1364,15 @@ func (opt *GuacamoleHTTPTunnelServlet) GetTunnel(tunnelUUID string) (ret gnet.Gu
 	}
 	return
 }
+
+/**
+ * Returns the name of the tunnel operation requested by the given query
+ * string, for use as a metric label.
+ */
+func tunnelOperation(query string) string {
+	operation := strings.SplitN(query, ":", 2)[0]
+	if operation == CONNECT_OPERATION || isBuiltinOperation(operation) {
+		return operation
+	}
+	return "other"
+}
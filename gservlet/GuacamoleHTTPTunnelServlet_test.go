@@ -0,0 +1,328 @@
+package gservlet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gevent"
+	"github.com/hsfish/guacamole_client_go/gnet"
+)
+
+/**
+ * Sends the request of the given query and body, made by the client at
+ * the given address, to the servlet.
+ */
+func serveTest(servlet *GuacamoleHTTPTunnelServlet, remoteAddr, query, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/tunnel?"+query, strings.NewReader(body))
+	request.RemoteAddr = remoteAddr
+	recorder := httptest.NewRecorder()
+	servlet.ServeHTTP(recorder, request)
+	return recorder
+}
+
+/**
+ * Checks that the given response reports an error of the given status.
+ */
+func checkStatus(t *testing.T, recorder *httptest.ResponseRecorder, status exp.GuacamoleStatus) {
+	t.Helper()
+	if code := recorder.Header().Get("Guacamole-Status-Code"); code != strconv.Itoa(status.GetGuacamoleStatusCode()) {
+		t.Errorf("status code %v, expected %v: %v", code, status.GetGuacamoleStatusCode(),
+			recorder.Header().Get("Guacamole-Error-Message"))
+	}
+}
+
+/**
+ * Waits for the given number of instructions to be queued for the client
+ * of the given tunnel.
+ */
+func waitQueued(t *testing.T, tunnel *GuacamoleHTTPTunnel, count int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		tunnel.stateLock.Lock()
+		queued := len(tunnel.pending)
+		tunnel.stateLock.Unlock()
+		if queued >= count {
+			return
+		}
+	}
+	t.Fatalf("%v instructions not queued", count)
+}
+
+func Test_ServletDrain(t *testing.T) {
+	servlet := NewGuacamoleHTTPTunnelServlet2(func(request HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error) {
+		t.Errorf("connected while draining")
+		return nil, exp.GuacamoleServerException.Throw("Unexpected connection.")
+	}, GuacamoleHTTPTunnelMapOptions{TunnelTimeout: time.Minute, SweepInterval: 10 * time.Millisecond})
+	defer servlet.Destroy()
+
+	read, readGuacd := putTestTunnel(t, servlet.tunnels)
+	defer readGuacd.Close()
+	closed, closedGuacd := putTestTunnel(t, servlet.tunnels)
+	defer closedGuacd.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	drained := make(chan int, 1)
+	go func() { drained <- servlet.Drain(ctx) }()
+	waitQueued(t, read, 3)
+	waitQueued(t, closed, 3)
+
+	// New tunnels are refused
+	checkStatus(t, serveTest(&servlet, "192.0.2.1:1234", "connect", ""), exp.SERVER_BUSY)
+
+	// Every user is told when their session will end, ahead of what guacd
+	// sends
+	go func() {
+		readGuacd.Write([]byte("4.sync,4.1000;"))
+		readGuacd.Close()
+	}()
+	body := serveTest(&servlet, "192.0.2.1:1234", "read:"+read.GetUUID().String(), "").Body.String()
+	if !strings.HasSuffix(body, "0.;") {
+		t.Fatalf("read %q", body)
+	}
+	notices, others := parseNotices(t, []byte(strings.TrimSuffix(body, "0.;")))
+	if len(notices) != 1 || !strings.HasPrefix(notices[0], DRAIN_NOTICE+" Your session will end in ") ||
+		strings.Join(others, ",") != "sync" {
+		t.Errorf("read notices %q, then %v", notices, others)
+	}
+	notices = queuedNotices(t, closed)
+	if len(notices) != 1 || !strings.HasPrefix(notices[0], DRAIN_NOTICE+" Your session will end in ") {
+		t.Errorf("queued notices %q", notices)
+	}
+
+	// The drain ends with the last session, none having to be closed
+	if recorder := serveTest(&servlet, "192.0.2.1:1234", "close:"+closed.GetUUID().String(), ""); recorder.Code != http.StatusOK {
+		t.Errorf("close: code %v", recorder.Code)
+	}
+	select {
+	case remaining := <-drained:
+		if remaining != 0 {
+			t.Errorf("drain closed %v sessions", remaining)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("drain continued after the last session ended")
+	}
+}
+
+func Test_ServletDrainDeadline(t *testing.T) {
+	servlet := NewGuacamoleHTTPTunnelServlet2(nil, GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout: time.Minute,
+		SweepInterval: 10 * time.Millisecond,
+	})
+	defer servlet.Destroy()
+	events := newTestEvents(servlet.GetEventBus())
+
+	var tunnels []*GuacamoleHTTPTunnel
+	for i := 0; i < 2; i++ {
+		tunnel, guacd := putTestTunnel(t, servlet.tunnels)
+		defer guacd.Close()
+		tunnels = append(tunnels, tunnel)
+	}
+
+	// Sessions left when the context is done are closed and counted
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	if remaining := servlet.Drain(ctx); remaining != 2 {
+		t.Errorf("drain closed %v sessions", remaining)
+	}
+	for _, tunnel := range tunnels {
+		uuid := tunnel.GetUUID().String()
+		closed, _ := events.waitClosed(t, uuid)
+		if closed.Time.Before(deadline) {
+			t.Errorf("closed at %v, before the deadline %v", closed.Time, deadline)
+		}
+		if notices := queuedNotices(t, tunnel); len(notices) != 1 || !strings.HasPrefix(notices[0], DRAIN_NOTICE) {
+			t.Errorf("notices %q", notices)
+		}
+		checkTerminated(t, servlet.tunnels, tunnel, closed, gevent.CLOSE_REASON_SHUTDOWN, exp.SERVER_BUSY)
+		checkStatus(t, serveTest(&servlet, "192.0.2.1:1234", "read:"+uuid, ""), exp.SERVER_BUSY)
+	}
+}
+
+/**
+ * A response whose connection is lost once an instruction containing the
+ * given text is written, as when the client goes away.
+ */
+type lostResponse struct {
+	header http.Header
+	body   bytes.Buffer
+	lostAt string
+	lost   bool
+}
+
+func (opt *lostResponse) Header() http.Header  { return opt.header }
+func (opt *lostResponse) WriteHeader(code int) {}
+
+func (opt *lostResponse) Write(data []byte) (int, error) {
+	if opt.lost || bytes.Contains(data, []byte(opt.lostAt)) {
+		opt.lost = true
+		return 0, errors.New("connection reset by peer")
+	}
+	return opt.body.Write(data)
+}
+
+/**
+ * Instructions sent by guacd, the connection to the client being lost
+ * while sending the last.
+ */
+const (
+	testFrame1 = "4.sync,4.1000;"
+	testFrame2 = "3.img,1.1,2.14,1.0,9.image/png,1.0,1.0;3.end,1.1;4.sync,4.2000;"
+	testFrame3 = "4.blob,1.2,4.AAAA;"
+)
+
+/**
+ * Starts a servlet whose tunnels may be resumed within the given window,
+ * connects through it as the client at the given address, and sends the
+ * test frames to that client until its connection is lost. Returns the
+ * tunnel as registered and its guacd.
+ */
+func newLostTunnel(t *testing.T, remoteAddr string, options GuacamoleHTTPTunnelMapOptions) (*GuacamoleHTTPTunnelServlet,
+	*GuacamoleHTTPTunnel, net.Conn) {
+	t.Helper()
+	tunnel, guacd := newTestTunnel()
+	servlet := NewGuacamoleHTTPTunnelServlet2(func(request HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error) {
+		return tunnel, nil
+	}, options)
+	servlet.SetOwnerResolver(RemoteAddressOwnerResolver(32, 128))
+	uuid := tunnel.GetUUID().String()
+	if recorder := serveTest(&servlet, remoteAddr, "connect", ""); recorder.Body.String() != uuid {
+		t.Fatalf("connect: code %v, body %q", recorder.Code, recorder.Body.String())
+	}
+	registered, _ := servlet.tunnels.Get(uuid)
+
+	go guacd.Write([]byte(testFrame1 + testFrame2 + testFrame3))
+	lost := &lostResponse{header: make(http.Header), lostAt: "4.blob,"}
+	request := httptest.NewRequest(http.MethodGet, "/tunnel?read:"+uuid, nil)
+	request.RemoteAddr = remoteAddr
+	servlet.ServeHTTP(lost, request)
+	if lost.body.String() != testFrame1+testFrame2 {
+		t.Fatalf("read %q before the connection was lost", lost.body.String())
+	}
+	return &servlet, registered, guacd
+}
+
+func Test_ServletResume(t *testing.T) {
+	const owner = "198.51.100.7:5000"
+	servlet, tunnel, guacd := newLostTunnel(t, owner, GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout: time.Minute,
+		SweepInterval: 10 * time.Millisecond,
+		ResumeWindow:  time.Minute,
+	})
+	defer servlet.Destroy()
+	defer guacd.Close()
+	events := newTestEvents(servlet.GetEventBus())
+	uuid := tunnel.GetUUID().String()
+
+	// The lost read leaves the tunnel, and guacd, awaiting the client
+	if _, detached := tunnel.getDetachedTime(); !detached || !tunnel.IsOpen() {
+		t.Fatalf("tunnel detached %v, open %v", detached, tunnel.IsOpen())
+	}
+	if _, ok := servlet.tunnels.Get(uuid); !ok {
+		t.Fatalf("tunnel deregistered")
+	}
+
+	// Only the owner may resume, from a sync still known
+	checkStatus(t, serveTest(servlet, "203.0.113.9:6000", "resume:"+uuid+":1000", ""), exp.CLIENT_FORBIDDEN)
+	checkStatus(t, serveTest(servlet, owner, "resume:"+uuid+":1500", ""), exp.RESOURCE_CLOSED)
+	if _, detached := tunnel.getDetachedTime(); !detached || !tunnel.IsOpen() {
+		t.Fatalf("tunnel detached %v, open %v after refused resumes", detached, tunnel.IsOpen())
+	}
+
+	// Resuming resends what followed the last sync received, then reads on
+	go func() {
+		guacd.Write([]byte("4.sync,4.3000;"))
+		guacd.Close()
+	}()
+	recorder := serveTest(servlet, owner, "resume:"+uuid+":1000", "")
+	if expected := testFrame2 + testFrame3 + "4.sync,4.3000;0.;"; recorder.Body.String() != expected {
+		t.Errorf("resumed with %q, expected %q", recorder.Body.String(), expected)
+	}
+	if _, detached := tunnel.getDetachedTime(); detached {
+		t.Errorf("tunnel still detached after resuming")
+	}
+	events.waitClosed(t, uuid)
+}
+
+func Test_ServletResumeWindow(t *testing.T) {
+	const owner = "198.51.100.7:5000"
+	servlet, tunnel, guacd := newLostTunnel(t, owner, GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout:    time.Minute,
+		SweepInterval:    10 * time.Millisecond,
+		ResumeWindow:     100 * time.Millisecond,
+		ReplayBufferSize: len(testFrame2),
+	})
+	defer servlet.Destroy()
+	defer guacd.Close()
+	events := newTestEvents(servlet.GetEventBus())
+	uuid := tunnel.GetUUID().String()
+	detachedTime, _ := tunnel.getDetachedTime()
+
+	// Instructions evicted from the buffer cannot be resent
+	checkStatus(t, serveTest(servlet, owner, "resume:"+uuid+":-1", ""), exp.RESOURCE_CLOSED)
+
+	// The tunnel is closed once the client failed to resume in time
+	closed, _ := events.waitClosed(t, uuid)
+	if closed.Time.Before(detachedTime.Add(100 * time.Millisecond)) {
+		t.Errorf("closed at %v, within the resume window from %v", closed.Time, detachedTime)
+	}
+	checkTerminated(t, servlet.tunnels, tunnel, closed, gevent.CLOSE_REASON_IO_ERROR, exp.CLIENT_TIMEOUT)
+	guacd.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, e := guacd.Read(make([]byte, 1)); e == nil {
+		t.Errorf("guacd left open")
+	}
+	checkStatus(t, serveTest(servlet, owner, "resume:"+uuid+":1000", ""), exp.CLIENT_TIMEOUT)
+}
+
+func Test_ServletReadLostWithoutResume(t *testing.T) {
+	servlet, tunnel, guacd := newLostTunnel(t, "198.51.100.7:5000", GuacamoleHTTPTunnelMapOptions{
+		TunnelTimeout: time.Minute,
+	})
+	defer servlet.Destroy()
+	defer guacd.Close()
+
+	// Tunnels which may not be resumed end with their client's connection
+	if _, ok := servlet.tunnels.Get(tunnel.GetUUID().String()); ok || tunnel.IsOpen() {
+		t.Errorf("tunnel left open")
+	}
+}
+
+func Test_ServletUpstreamError(t *testing.T) {
+	servlet := NewGuacamoleHTTPTunnelServlet2(nil, GuacamoleHTTPTunnelMapOptions{TunnelTimeout: time.Minute})
+	defer servlet.Destroy()
+	events := newTestEvents(servlet.GetEventBus())
+	tunnel, guacd := putTestTunnel(t, servlet.tunnels)
+	defer guacd.Close()
+	uuid := tunnel.GetUUID().String()
+
+	// The "error" of guacd reaches the client, ending the read
+	const upstream = "5.error,14.Upstream gone.,3.519;"
+	go guacd.Write([]byte("4.sync,4.1000;" + upstream))
+	if body := serveTest(&servlet, "192.0.2.1:1234", "read:"+uuid, "").Body.String(); body != "4.sync,4.1000;"+upstream+"0.;" {
+		t.Errorf("read %q", body)
+	}
+	closed, types := events.waitClosed(t, uuid)
+	if closed.Reason != gevent.CLOSE_REASON_UPSTREAM || closed.Status != exp.UPSTREAM_NOT_FOUND ||
+		closed.Message != "Upstream gone." {
+		t.Errorf("closed with reason %q, status %v: %v", closed.Reason, closed.Status, closed.Message)
+	}
+	if len(types) < 2 || types[len(types)-2] != gevent.UPSTREAM_ERROR {
+		t.Errorf("events %v", types)
+	}
+
+	// Later requests fail with the status reported by guacd
+	if err, ok := servlet.tunnels.getTombstone(uuid); !ok || err.GetStatus() != exp.UPSTREAM_NOT_FOUND {
+		t.Errorf("tombstone = %v, %v", err, ok)
+	}
+	checkStatus(t, serveTest(&servlet, "192.0.2.1:1234", "read:"+uuid, ""), exp.UPSTREAM_NOT_FOUND)
+}
@@ -0,0 +1,157 @@
+package gservlet
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// HTTPRequestAdapter *
+//  * Adapts a net/http request to HTTPServletRequestInterface, along with
+//  * its optional header, cookie and remote address interfaces.
+type HTTPRequestAdapter struct {
+	request *http.Request
+}
+
+/*NewHTTPRequestAdapter *
+ * Creates a new HTTPRequestAdapter wrapping the given request.
+ *
+ * @param request The request received.
+ */
+func NewHTTPRequestAdapter(request *http.Request) (ret *HTTPRequestAdapter) {
+	ret = &HTTPRequestAdapter{request: request}
+	return
+}
+
+// GetRequest Returns the wrapped request.
+func (opt *HTTPRequestAdapter) GetRequest() *http.Request {
+	return opt.request
+}
+
+// GetQueryString Override HTTPServletRequestInterface.GetQueryString
+func (opt *HTTPRequestAdapter) GetQueryString() string {
+	return opt.request.URL.RawQuery
+}
+
+// Read Override HTTPServletRequestInterface.Read
+func (opt *HTTPRequestAdapter) Read(p []byte) (int, error) {
+	if opt.request.Body == nil {
+		return 0, io.EOF
+	}
+	return opt.request.Body.Read(p)
+}
+
+// GetHeader Override HTTPServletRequestHeaderInterface.GetHeader
+func (opt *HTTPRequestAdapter) GetHeader(name string) string {
+	return opt.request.Header.Get(name)
+}
+
+// GetCookie Override HTTPServletRequestCookieInterface.GetCookie
+func (opt *HTTPRequestAdapter) GetCookie(name string) (string, bool) {
+	cookie, err := opt.request.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// GetRemoteAddr Override HTTPServletRequestRemoteAddrInterface.GetRemoteAddr
+func (opt *HTTPRequestAdapter) GetRemoteAddr() string {
+	host, _, err := net.SplitHostPort(opt.request.RemoteAddr)
+	if err != nil {
+		return opt.request.RemoteAddr
+	}
+	return host
+}
+
+// HTTPResponseAdapter *
+//  * Adapts a net/http response writer to HTTPServletResponseInterface. The
+//  * response is committed by the first write or error sent.
+type HTTPResponseAdapter struct {
+	writer    http.ResponseWriter
+	committed bool
+}
+
+/*NewHTTPResponseAdapter *
+ * Creates a new HTTPResponseAdapter wrapping the given response writer.
+ *
+ * @param writer The writer of the response.
+ */
+func NewHTTPResponseAdapter(writer http.ResponseWriter) (ret *HTTPResponseAdapter) {
+	ret = &HTTPResponseAdapter{writer: writer}
+	return
+}
+
+// IsCommitted Override HTTPServletResponseInterface.IsCommitted
+func (opt *HTTPResponseAdapter) IsCommitted() (bool, error) {
+	return opt.committed, nil
+}
+
+// AddHeader Override HTTPServletResponseInterface.AddHeader
+func (opt *HTTPResponseAdapter) AddHeader(key, value string) {
+	opt.writer.Header().Add(key, value)
+}
+
+// SetHeader Override HTTPServletResponseInterface.SetHeader
+func (opt *HTTPResponseAdapter) SetHeader(key, value string) {
+	opt.writer.Header().Set(key, value)
+}
+
+// SetContentType Override HTTPServletResponseInterface.SetContentType
+func (opt *HTTPResponseAdapter) SetContentType(value string) {
+	opt.writer.Header().Set("Content-Type", value)
+}
+
+// SetContentLength Override HTTPServletResponseInterface.SetContentLength
+func (opt *HTTPResponseAdapter) SetContentLength(length int) {
+	opt.writer.Header().Set("Content-Length", strconv.Itoa(length))
+}
+
+// SendError Override HTTPServletResponseInterface.SendError
+func (opt *HTTPResponseAdapter) SendError(sc int) error {
+	opt.committed = true
+	opt.writer.WriteHeader(sc)
+	return nil
+}
+
+// WriteString Override HTTPServletResponseInterface.WriteString
+func (opt *HTTPResponseAdapter) WriteString(data string) error {
+	return opt.Write([]byte(data))
+}
+
+// Write Override HTTPServletResponseInterface.Write
+func (opt *HTTPResponseAdapter) Write(data []byte) error {
+	opt.committed = true
+	_, err := opt.writer.Write(data)
+	return err
+}
+
+// FlushBuffer Override HTTPServletResponseInterface.FlushBuffer
+func (opt *HTTPResponseAdapter) FlushBuffer() error {
+	opt.committed = true
+	if flusher, ok := opt.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Close Override HTTPServletResponseInterface.Close
+func (opt *HTTPResponseAdapter) Close() error {
+	return nil
+}
+
+/*ServeHTTP *
+ * Handles a tunnel request received by a net/http server, so that the
+ * servlet may be registered directly as an http.Handler.
+ */
+func (opt *GuacamoleHTTPTunnelServlet) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet, http.MethodPost:
+	default:
+		writer.Header().Set("Allow", "GET, POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	opt.HandleTunnelRequest(NewHTTPRequestAdapter(request), NewHTTPResponseAdapter(writer))
+}
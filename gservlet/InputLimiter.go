@@ -0,0 +1,294 @@
+package gservlet
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/*INPUT_MAX_DELAY *
+ * The longest input may be delayed to respect the rates of InputLimits.
+ * Input which would be delayed longer is refused with CLIENT_TOO_MANY.
+ */
+const INPUT_MAX_DELAY = 5 * time.Second
+
+// InputLimits *
+//  * The limits on the input written by clients to their tunnels. Rates of
+//  * zero are unlimited, and each rate may be exceeded by a burst of up to
+//  * one second of input. Input beyond the rates is delayed rather than
+//  * dropped, up to INPUT_MAX_DELAY. Instruction sizes are capped at
+//  * gprotocol.INSTRUCTION_MAX_LENGTH and INSTRUCTION_MAX_ELEMENTS, which
+//  * zero selects.
+type InputLimits struct {
+	/**
+	 * The bytes and instructions each tunnel may write per second.
+	 */
+	TunnelBytesPerSecond        int
+	TunnelInstructionsPerSecond int
+
+	/**
+	 * The bytes and instructions all tunnels of each user, as identified
+	 * by the TunnelOwnerResolverInterface of the servlet, may write per
+	 * second. Tunnels without an owner are limited per tunnel only.
+	 */
+	UserBytesPerSecond        int
+	UserInstructionsPerSecond int
+
+	/**
+	 * The maximum length of each instruction, in characters, and the
+	 * maximum number of elements of each instruction, including its
+	 * opcode.
+	 */
+	MaxInstructionLength   int
+	MaxInstructionElements int
+}
+
+/**
+ * A token bucket admitting up to rate units per second, with a burst of
+ * rate units.
+ */
+type rateBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+/**
+ * Returns a full bucket admitting the given rate, or nil if the rate is
+ * unlimited.
+ */
+func newRateBucket(rate int, now time.Time) *rateBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateBucket{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+func (opt *rateBucket) refill(now time.Time) {
+	if opt == nil {
+		return
+	}
+	opt.tokens += now.Sub(opt.last).Seconds() * opt.rate
+	if opt.tokens > opt.rate {
+		opt.tokens = opt.rate
+	}
+	opt.last = now
+}
+
+/**
+ * Returns how long to wait before the given amount may be taken. An amount
+ * larger than the burst may be taken from a full bucket, leaving it in
+ * debt.
+ */
+func (opt *rateBucket) delay(amount float64) time.Duration {
+	if opt == nil {
+		return 0
+	}
+	if amount > opt.rate {
+		amount = opt.rate
+	}
+	if opt.tokens >= amount {
+		return 0
+	}
+	return time.Duration((amount - opt.tokens) / opt.rate * float64(time.Second))
+}
+
+func (opt *rateBucket) take(amount float64) {
+	if opt != nil {
+		opt.tokens -= amount
+	}
+}
+
+func (opt *rateBucket) full() bool {
+	return opt == nil || opt.tokens >= opt.rate
+}
+
+/**
+ * The buckets limiting the input of one tunnel or user.
+ */
+type inputBuckets struct {
+	bytes        *rateBucket
+	instructions *rateBucket
+}
+
+func (opt *inputBuckets) refill(now time.Time) {
+	opt.bytes.refill(now)
+	opt.instructions.refill(now)
+}
+
+/**
+ * Returns how long to wait before the given numbers of bytes and
+ * instructions may be taken.
+ */
+func (opt *inputBuckets) delay(size, count int) time.Duration {
+	ret := opt.bytes.delay(float64(size))
+	if delay := opt.instructions.delay(float64(count)); delay > ret {
+		ret = delay
+	}
+	return ret
+}
+
+func (opt *inputBuckets) take(size, count int) {
+	opt.bytes.take(float64(size))
+	opt.instructions.take(float64(count))
+}
+
+func (opt *inputBuckets) full() bool {
+	return opt.bytes.full() && opt.instructions.full()
+}
+
+// InputLimiter *
+//  * Enforces InputLimits on the input written by the clients of a
+//  * GuacamoleHTTPTunnelServlet or of a WebSocket tunnel endpoint. See
+//  * GuacamoleHTTPTunnelServlet.SetInputLimits.
+type InputLimiter struct {
+	limits InputLimits
+
+	/**
+	 * The buckets of each user having written recently, by owner.
+	 */
+	users map[string]*inputBuckets
+	lock  sync.Mutex
+}
+
+/*NewInputLimiter *
+ * Creates a new InputLimiter enforcing the given limits.
+ *
+ * @param limits The limits on client input.
+ */
+func NewInputLimiter(limits InputLimits) (ret *InputLimiter) {
+	return &InputLimiter{
+		limits: limits,
+		users:  make(map[string]*inputBuckets),
+	}
+}
+
+/*Open *
+ * Returns the gate limiting the input of one tunnel of the given owner,
+ * forgetting the users whose buckets have refilled.
+ *
+ * @param owner
+ *     The principal owning the tunnel, or "" to limit the tunnel alone.
+ */
+func (opt *InputLimiter) Open(owner string) *InputGate {
+	now := time.Now()
+	opt.lock.Lock()
+	for user, buckets := range opt.users {
+		buckets.refill(now)
+		if buckets.full() {
+			delete(opt.users, user)
+		}
+	}
+	opt.lock.Unlock()
+
+	return &InputGate{
+		limiter: opt,
+		owner:   owner,
+		parser: gprotocol.NewGuacamoleStreamParser2(opt.limits.MaxInstructionLength,
+			opt.limits.MaxInstructionElements, exp.GuacamoleClientOverrunException),
+		tunnel: inputBuckets{
+			bytes:        newRateBucket(opt.limits.TunnelBytesPerSecond, now),
+			instructions: newRateBucket(opt.limits.TunnelInstructionsPerSecond, now),
+		},
+	}
+}
+
+/**
+ * Returns the buckets of the given user, creating them if necessary, or
+ * nil if users are not limited. The lock must be held.
+ */
+func (opt *InputLimiter) userBucketsLocked(owner string, now time.Time) *inputBuckets {
+	if len(owner) == 0 || (opt.limits.UserBytesPerSecond <= 0 && opt.limits.UserInstructionsPerSecond <= 0) {
+		return nil
+	}
+	buckets, ok := opt.users[owner]
+	if !ok {
+		buckets = &inputBuckets{
+			bytes:        newRateBucket(opt.limits.UserBytesPerSecond, now),
+			instructions: newRateBucket(opt.limits.UserInstructionsPerSecond, now),
+		}
+		opt.users[owner] = buckets
+	}
+	return buckets
+}
+
+// InputGate *
+//  * Limits the input written to one tunnel, as returned by
+//  * InputLimiter.Open. Must only be used by the writer of the tunnel, so
+//  * that its parser sees the input in order.
+type InputGate struct {
+	limiter *InputLimiter
+	owner   string
+	parser  gprotocol.GuacamoleStreamParser
+	tunnel  inputBuckets
+
+	/**
+	 * Set once the input was refused, after which the parser cannot be
+	 * used.
+	 */
+	failed exp.ExceptionInterface
+}
+
+/*Admit *
+ * Parses the given input, returning its complete instructions and how long
+ * to wait before writing them so as to respect the rates. An incomplete
+ * trailing instruction is kept until the rest of it is given. Input is
+ * admitted or refused as a whole, and all later input is refused once
+ * some is.
+ *
+ * @throws GuacamoleClientOverrunException If an instruction exceeds the
+ *                                         size limits.
+ * @throws GuacamoleClientTooManyException If the input would be delayed
+ *                                         longer than INPUT_MAX_DELAY.
+ * @throws GuacamoleClientException If the input is not valid Guacamole
+ *                                  protocol data.
+ */
+func (opt *InputGate) Admit(chunk []byte) (ret []gprotocol.GuacamoleInstruction, delay time.Duration,
+	err exp.ExceptionInterface) {
+	if opt.failed != nil {
+		return nil, 0, opt.failed
+	}
+	instructions, err := opt.parser.Append(chunk)
+	if err != nil {
+		if !errors.Is(err, exp.GuacamoleClientOverrunException) {
+			err = exp.GuacamoleClientException.Wrap(err, "Invalid instruction data.")
+		}
+		opt.failed = err
+		return nil, 0, err
+	}
+	if len(instructions) == 0 {
+		return
+	}
+	size := 0
+	for _, instruction := range instructions {
+		size += len(instruction.String())
+	}
+
+	// Take the input from the buckets now, leaving them in debt until it
+	// is written, so that the input of other tunnels of the user waits
+	// behind it
+	now := time.Now()
+	opt.limiter.lock.Lock()
+	defer opt.limiter.lock.Unlock()
+	user := opt.limiter.userBucketsLocked(opt.owner, now)
+	opt.tunnel.refill(now)
+	delay = opt.tunnel.delay(size, len(instructions))
+	if user != nil {
+		user.refill(now)
+		if one := user.delay(size, len(instructions)); one > delay {
+			delay = one
+		}
+	}
+	if delay > INPUT_MAX_DELAY {
+		opt.failed = exp.GuacamoleClientTooManyException.Throw("Input rate limit exceeded.")
+		return nil, 0, opt.failed
+	}
+	opt.tunnel.take(size, len(instructions))
+	if user != nil {
+		user.take(size, len(instructions))
+	}
+	return instructions, delay, nil
+}
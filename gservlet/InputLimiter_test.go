@@ -0,0 +1,97 @@
+package gservlet
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+const testKey = "3.key,5.65307,1.1;"
+
+func Test_InputLimiterRate(t *testing.T) {
+	limiter := NewInputLimiter(InputLimits{TunnelInstructionsPerSecond: 2})
+	gate := limiter.Open("")
+
+	// Input within the burst is not delayed
+	admitted, delay, err := gate.Admit([]byte(testKey + testKey))
+	if len(admitted) != 2 || delay != 0 || err != nil {
+		t.Fatalf("admitted %v, delay %v, err %v", len(admitted), delay, err)
+	}
+
+	// Input beyond it is delayed, as a whole, until refused
+	previous := time.Duration(0)
+	for i := 0; ; i++ {
+		admitted, delay, err = gate.Admit([]byte(testKey))
+		if err != nil {
+			break
+		}
+		if len(admitted) != 1 || delay <= previous || delay > INPUT_MAX_DELAY {
+			t.Fatalf("admitted %v, delay %v after %v", len(admitted), delay, previous)
+		}
+		previous = delay
+		if i > 20 {
+			t.Fatalf("input never refused, delay %v", delay)
+		}
+	}
+	if len(admitted) != 0 || !errors.Is(err, exp.GuacamoleClientTooManyException) || err.GetStatus() != exp.CLIENT_TOO_MANY {
+		t.Errorf("refusal admitted %v, err %v", len(admitted), err)
+	}
+	if previous < INPUT_MAX_DELAY-time.Second {
+		t.Errorf("refused after a delay of %v", previous)
+	}
+	if _, _, again := gate.Admit([]byte(testKey)); again != err {
+		t.Errorf("input admitted after refusal: %v", again)
+	}
+
+	// Other tunnels are not affected
+	if admitted, delay, err = limiter.Open("").Admit([]byte(testKey)); len(admitted) != 1 || delay != 0 || err != nil {
+		t.Errorf("other tunnel admitted %v, delay %v, err %v", len(admitted), delay, err)
+	}
+}
+
+func Test_InputLimiterUser(t *testing.T) {
+	limiter := NewInputLimiter(InputLimits{UserBytesPerSecond: 2 * len(testKey)})
+	first, second := limiter.Open("alice"), limiter.Open("alice")
+
+	if admitted, delay, err := first.Admit([]byte(testKey + testKey)); len(admitted) != 2 || delay != 0 || err != nil {
+		t.Fatalf("admitted %v, delay %v, err %v", len(admitted), delay, err)
+	}
+	admitted, delay, err := second.Admit([]byte(testKey))
+	if len(admitted) != 1 || delay < 400*time.Millisecond || delay > 500*time.Millisecond || err != nil {
+		t.Errorf("second tunnel of the user admitted %v, delay %v, err %v", len(admitted), delay, err)
+	}
+	if admitted, delay, err := limiter.Open("bob").Admit([]byte(testKey)); len(admitted) != 1 || delay != 0 || err != nil {
+		t.Errorf("other user admitted %v, delay %v, err %v", len(admitted), delay, err)
+	}
+}
+
+func Test_InputLimiterSize(t *testing.T) {
+	limiter := NewInputLimiter(InputLimits{MaxInstructionLength: 32, MaxInstructionElements: 3})
+
+	gate := limiter.Open("")
+	text := strings.Repeat("a", 40)
+	_, _, err := gate.Admit([]byte("9.clipboard,2.10,"))
+	if err != nil {
+		t.Fatalf("incomplete instruction refused: %v", err)
+	}
+	if _, _, err = gate.Admit([]byte("2.40,")); err != nil {
+		t.Fatalf("incomplete instruction refused: %v", err)
+	}
+	_, _, err = gate.Admit([]byte("40." + text + ";"))
+	if !errors.Is(err, exp.GuacamoleClientOverrunException) || err.GetStatus() != exp.CLIENT_OVERRUN {
+		t.Errorf("long instruction: err %v", err)
+	}
+	if _, _, again := gate.Admit([]byte(testKey)); again != err {
+		t.Errorf("input admitted after overrun: %v", again)
+	}
+
+	if _, _, err = limiter.Open("").Admit([]byte("4.size,1.0,1.1,1.2;")); !errors.Is(err, exp.GuacamoleClientOverrunException) {
+		t.Errorf("instruction with too many elements: err %v", err)
+	}
+	if _, _, err = limiter.Open("").Admit([]byte("x.size;")); err == nil || err.Kind() != exp.GuacamoleClientException {
+		t.Errorf("malformed instruction: err %v", err)
+	}
+}
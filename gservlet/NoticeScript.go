@@ -0,0 +1,90 @@
+package gservlet
+
+import (
+	"net/http"
+)
+
+/*NOTICE_SCRIPT *
+ * The script displaying the notices sent by NewNoticeInstructions in the
+ * JavaScript Guacamole client. guacamole-common-js gives "pipe" streams to
+ * the onpipe handler of Guacamole.Client and has none by default, so the
+ * stock client shows nothing, refusing the stream with an "ack" which
+ * guacd ignores. Once the script is loaded, after guacamole-common-js,
+ * applications install the handler on each client:
+ *
+ *     var client = new Guacamole.Client(tunnel);
+ *     GuacamoleNotice.install(client);
+ *
+ * Each notice is then shown for GuacamoleNotice.DURATION milliseconds by
+ * GuacamoleNotice.show, or given to the function passed as second
+ * argument of install instead. Other pipes are passed to any onpipe
+ * handler set before install is called. See ServeNoticeScript.
+ */
+const NOTICE_SCRIPT = `/*
+ * Displays the notices sent by Guacamole tunnels over the "` + NOTICE_STREAM_NAME + `" pipe.
+ */
+var GuacamoleNotice = GuacamoleNotice || {};
+
+/*
+ * How long each notice is shown by GuacamoleNotice.show, in milliseconds.
+ */
+GuacamoleNotice.DURATION = 10000;
+
+/*
+ * Installs the notice handler on the given Guacamole.Client. Other pipes
+ * are passed to the onpipe handler already set, if any.
+ *
+ * @param {Guacamole.Client} client
+ * @param {function(string)} [onnotice]
+ *     Called with the text of each notice, GuacamoleNotice.show by default.
+ */
+GuacamoleNotice.install = function install(client, onnotice) {
+    var next = client.onpipe;
+    onnotice = onnotice || GuacamoleNotice.show;
+    client.onpipe = function onpipe(stream, mimetype, name) {
+        if (name !== "` + NOTICE_STREAM_NAME + `") {
+            if (next)
+                next.apply(this, arguments);
+            return;
+        }
+        var reader = new Guacamole.StringReader(stream);
+        var text = "";
+        reader.ontext = function ontext(chunk) {
+            text += chunk;
+        };
+        reader.onend = function onend() {
+            onnotice(text);
+        };
+    };
+};
+
+/*
+ * Shows the given text at the top of the page.
+ *
+ * @param {string} text
+ */
+GuacamoleNotice.show = function show(text) {
+    var notice = document.createElement("div");
+    notice.className = "guacamole-notice";
+    notice.setAttribute("role", "alert");
+    notice.textContent = text;
+    notice.style.cssText = "position: fixed; top: 1em; left: 50%; transform: translateX(-50%);"
+        + " z-index: 10000; padding: 0.75em 1.5em; border-radius: 4px;"
+        + " background: rgba(0, 0, 0, 0.8); color: #fff; font: 14px sans-serif;";
+    document.body.appendChild(notice);
+    window.setTimeout(function hide() {
+        if (notice.parentNode)
+            notice.parentNode.removeChild(notice);
+    }, GuacamoleNotice.DURATION);
+};
+`
+
+/*ServeNoticeScript *
+ * Answers the given request with NOTICE_SCRIPT, for use as the handler of
+ * the path from which the JavaScript client loads it.
+ */
+func ServeNoticeScript(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	writer.Header().Set("Cache-Control", "public, max-age=3600")
+	writer.Write([]byte(NOTICE_SCRIPT))
+}
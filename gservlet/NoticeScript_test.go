@@ -0,0 +1,47 @@
+package gservlet
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_NoticeInstructions(t *testing.T) {
+	instructions := NewNoticeInstructions("Your session will end in 1m0s.")
+	if len(instructions) != 3 {
+		t.Fatalf("instructions = %v", instructions)
+	}
+	index := strconv.Itoa(NOTICE_STREAM_INDEX)
+
+	// The pipe is named as the script expects
+	pipe := instructions[0]
+	if args := pipe.GetArgs(); pipe.GetOpcode() != "pipe" || len(args) != 3 ||
+		args[0] != index || args[1] != "text/plain" || args[2] != NOTICE_STREAM_NAME {
+		t.Errorf("pipe = %v %v", pipe.GetOpcode(), args)
+	}
+	blob := instructions[1]
+	if args := blob.GetArgs(); blob.GetOpcode() != "blob" || len(args) != 2 || args[0] != index {
+		t.Errorf("blob = %v %v", blob.GetOpcode(), args)
+	} else if text, e := base64.StdEncoding.DecodeString(args[1]); e != nil || string(text) != "Your session will end in 1m0s." {
+		t.Errorf("text = %q, err %v", text, e)
+	}
+	end := instructions[2]
+	if args := end.GetArgs(); end.GetOpcode() != "end" || len(args) != 1 || args[0] != index {
+		t.Errorf("end = %v %v", end.GetOpcode(), args)
+	}
+}
+
+func Test_ServeNoticeScript(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	ServeNoticeScript(recorder, httptest.NewRequest(http.MethodGet, "/guacamole-notice.js", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/javascript") {
+		t.Errorf("content type = %q", recorder.Header().Get("Content-Type"))
+	}
+	body := recorder.Body.String()
+	if body != NOTICE_SCRIPT || !strings.Contains(body, `name !== "`+NOTICE_STREAM_NAME+`"`) {
+		t.Errorf("script = %q", body)
+	}
+}
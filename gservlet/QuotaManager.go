@@ -0,0 +1,383 @@
+package gservlet
+
+import (
+	"context"
+	"sync"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gevent"
+)
+
+// QuotaLimits *
+//  * The maximum numbers of active tunnels enforced by a QuotaManager when
+//  * tunnels are connected. Zero values are unlimited.
+type QuotaLimits struct {
+	/**
+	 * The maximum number of active tunnels in total.
+	 */
+	Total int `json:"total"`
+
+	/**
+	 * The maximum number of active tunnels of each user.
+	 */
+	PerUser int `json:"perUser"`
+
+	/**
+	 * The maximum number of active tunnels to each connection.
+	 */
+	PerConnection int `json:"perConnection"`
+
+	/**
+	 * The maximum number of active tunnels through each guacd backend.
+	 */
+	PerBackend int `json:"perBackend"`
+}
+
+// QuotaUsage *
+//  * The active tunnels counted by a QuotaManager, as returned by
+//  * QuotaManager.Usage. Tunnels whose user, connection or backend is not
+//  * known are only counted in Total.
+type QuotaUsage struct {
+	Limits      QuotaLimits    `json:"limits"`
+	Total       int            `json:"total"`
+	Users       map[string]int `json:"users"`
+	Connections map[string]int `json:"connections"`
+	Backends    map[string]int `json:"backends"`
+}
+
+// QuotaManager *
+//  * Counts the active tunnels of a GuacamoleHTTPTunnelServlet and of a
+//  * GuacamoleWebSocketTunnelEndpoint, which may share one manager, by
+//  * user, connection and guacd backend, refusing connect requests beyond
+//  * its QuotaLimits.
+//  *
+//  * A QuotaReservation is taken for each connect request before doConnect
+//  * is called, refused with SERVER_BUSY beyond the total limit or with
+//  * CLIENT_TOO_MANY beyond the limit of the user, if the manager has a
+//  * user resolver. doConnect finds the reservation in its context with
+//  * QuotaReservationFromContext and completes it with the user, connection
+//  * and backend it chooses, each refused beyond its limit. The reservation
+//  * is released when the tunnel is closed, as reported by the CLOSED event
+//  * of the tunnel, or when the connect request fails.
+type QuotaManager struct {
+	limits QuotaLimits
+
+	/**
+	 * Resolves the user of each connect request before doConnect is
+	 * called, or nil.
+	 */
+	userResolver TunnelOwnerResolverInterface
+
+	/**
+	 * The numbers of active reservations in total, and by user, connection
+	 * and backend.
+	 */
+	total       int
+	users       map[string]int
+	connections map[string]int
+	backends    map[string]int
+
+	/**
+	 * The reservations of the tunnels connected, by tunnel UUID.
+	 */
+	tunnels map[string]*QuotaReservation
+
+	lock sync.Mutex
+}
+
+/*NewQuotaManager *
+ * Creates a new QuotaManager enforcing the given limits.
+ *
+ * @param limits The maximum numbers of active tunnels.
+ */
+func NewQuotaManager(limits QuotaLimits) (ret *QuotaManager) {
+	return &QuotaManager{
+		limits:      limits,
+		users:       make(map[string]int),
+		connections: make(map[string]int),
+		backends:    make(map[string]int),
+		tunnels:     make(map[string]*QuotaReservation),
+	}
+}
+
+/*SetUserResolver *
+ * Sets the function resolving the user of each connect request, so that
+ * users beyond their limit are refused before doConnect is called. Must be
+ * called before any request is handled.
+ *
+ * @param resolver
+ *     The user resolver, such as the owner resolver of the servlet, or nil
+ *     to leave the user to doConnect.
+ */
+func (opt *QuotaManager) SetUserResolver(resolver TunnelOwnerResolverInterface) {
+	opt.userResolver = resolver
+}
+
+/*Reserve *
+ * Takes a reservation for a tunnel of the given user, which must be
+ * released once the tunnel is closed.
+ *
+ * @param user
+ *     The user, or "" if not known yet.
+ *
+ * @throws GuacamoleServerBusyException
+ *     If the total limit is reached.
+ *
+ * @throws GuacamoleClientTooManyException
+ *     If the limit of the user is reached.
+ */
+func (opt *QuotaManager) Reserve(user string) (ret *QuotaReservation, err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if opt.limits.Total > 0 && opt.total >= opt.limits.Total {
+		return nil, exp.GuacamoleServerBusyException.Throw("Too many active connections.")
+	}
+	if err = opt.checkLocked(opt.users, opt.limits.PerUser, user, exp.GuacamoleClientTooManyException,
+		"Too many active connections for this user."); err != nil {
+		return
+	}
+	opt.total++
+	increment(opt.users, user)
+	return &QuotaReservation{manager: opt, user: user}, nil
+}
+
+/*ReserveRequest *
+ * Takes a reservation for the given connect request, for the user given
+ * by the user resolver of the manager, if any.
+ *
+ * @throws GuacamoleServerBusyException
+ *     If the total limit is reached.
+ *
+ * @throws GuacamoleClientTooManyException
+ *     If the limit of the user is reached.
+ */
+func (opt *QuotaManager) ReserveRequest(request HTTPServletRequestInterface) (*QuotaReservation, exp.ExceptionInterface) {
+	user := ""
+	if opt.userResolver != nil {
+		var err exp.ExceptionInterface
+		if user, err = opt.userResolver(request); err != nil {
+			return nil, err
+		}
+	}
+	return opt.Reserve(user)
+}
+
+/**
+ * Returns an error of the given kind if the count of the given key has
+ * reached the given limit. The lock must be held.
+ */
+func (opt *QuotaManager) checkLocked(counts map[string]int, limit int, key string,
+	kind exp.ExceptionKind, message string) exp.ExceptionInterface {
+	if limit > 0 && len(key) > 0 && counts[key] >= limit {
+		return kind.Throw(message)
+	}
+	return nil
+}
+
+func increment(counts map[string]int, key string) {
+	if len(key) > 0 {
+		counts[key]++
+	}
+}
+
+func decrement(counts map[string]int, key string) {
+	if len(key) == 0 {
+		return
+	}
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
+
+/*Subscribe *
+ * Releases the reservation of each tunnel whose CLOSED event is published
+ * on the given bus. Subscribing twice to the same bus is harmless.
+ *
+ * @return The ID of the subscription, for use with Unsubscribe.
+ */
+func (opt *QuotaManager) Subscribe(events *gevent.EventBus) int {
+	return events.Subscribe(func(event gevent.TunnelEvent) {
+		opt.lock.Lock()
+		reservation, ok := opt.tunnels[event.TunnelUUID]
+		opt.lock.Unlock()
+		if ok {
+			reservation.Release()
+		}
+	}, gevent.CLOSED)
+}
+
+/*Usage *
+ * Returns the active tunnels currently counted, with the limits.
+ */
+func (opt *QuotaManager) Usage() (ret QuotaUsage) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	ret.Limits = opt.limits
+	ret.Total = opt.total
+	ret.Users = copyCounts(opt.users)
+	ret.Connections = copyCounts(opt.connections)
+	ret.Backends = copyCounts(opt.backends)
+	return
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	ret := make(map[string]int, len(counts))
+	for key, count := range counts {
+		ret[key] = count
+	}
+	return ret
+}
+
+// QuotaReservation *
+//  * The share of the quotas of a QuotaManager held by one tunnel, from its
+//  * connect request until it is closed. The functions of a nil reservation
+//  * do nothing, so that doConnect need not check whether quotas are
+//  * enforced.
+type QuotaReservation struct {
+	manager *QuotaManager
+
+	/**
+	 * The user, connection and backend counted, each "" if not known.
+	 */
+	user       string
+	connection string
+	backend    string
+
+	/**
+	 * The UUID of the tunnel holding the reservation, once connected.
+	 */
+	uuid     string
+	released bool
+}
+
+/**
+ * Counts the reservation under the given key of the given counts instead
+ * of the current one, unless the limit of the new key is reached.
+ */
+func (opt *QuotaReservation) move(counts map[string]int, limit int, current *string, key string,
+	kind exp.ExceptionKind, message string) exp.ExceptionInterface {
+	if opt == nil || *current == key {
+		return nil
+	}
+	manager := opt.manager
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if opt.released {
+		return nil
+	}
+	if err := manager.checkLocked(counts, limit, key, kind, message); err != nil {
+		return err
+	}
+	decrement(counts, *current)
+	increment(counts, key)
+	*current = key
+	return nil
+}
+
+/*SetUser *
+ * Counts the reservation for the given user.
+ *
+ * @throws GuacamoleClientTooManyException
+ *     If the limit of the user is reached.
+ */
+func (opt *QuotaReservation) SetUser(user string) exp.ExceptionInterface {
+	if opt == nil {
+		return nil
+	}
+	return opt.move(opt.manager.users, opt.manager.limits.PerUser, &opt.user, user,
+		exp.GuacamoleClientTooManyException, "Too many active connections for this user.")
+}
+
+/*SetConnection *
+ * Counts the reservation for the connection having the given identifier.
+ *
+ * @throws GuacamoleServerBusyException
+ *     If the limit of the connection is reached.
+ */
+func (opt *QuotaReservation) SetConnection(connection string) exp.ExceptionInterface {
+	if opt == nil {
+		return nil
+	}
+	return opt.move(opt.manager.connections, opt.manager.limits.PerConnection, &opt.connection, connection,
+		exp.GuacamoleServerBusyException, "Too many active connections to \""+connection+"\".")
+}
+
+/*SetBackend *
+ * Counts the reservation for the given guacd backend, such as
+ * "guacd-1:4822", instead of any previous one. Should be called before
+ * connecting to the backend.
+ *
+ * @throws GuacamoleServerBusyException
+ *     If the limit of the backend is reached.
+ */
+func (opt *QuotaReservation) SetBackend(backend string) exp.ExceptionInterface {
+	if opt == nil {
+		return nil
+	}
+	return opt.move(opt.manager.backends, opt.manager.limits.PerBackend, &opt.backend, backend,
+		exp.GuacamoleServerBusyException, "Too many active connections through \""+backend+"\".")
+}
+
+/*Bind *
+ * Records that the reservation is held by the tunnel having the given
+ * UUID, so that it is released by the CLOSED event of the tunnel. Must be
+ * called before the tunnel is registered.
+ */
+func (opt *QuotaReservation) Bind(uuid string) {
+	if opt == nil {
+		return
+	}
+	manager := opt.manager
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if !opt.released {
+		opt.uuid = uuid
+		manager.tunnels[uuid] = opt
+	}
+}
+
+/*Release *
+ * Releases the reservation. Later calls do nothing.
+ */
+func (opt *QuotaReservation) Release() {
+	if opt == nil {
+		return
+	}
+	manager := opt.manager
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if opt.released {
+		return
+	}
+	opt.released = true
+	manager.total--
+	decrement(manager.users, opt.user)
+	decrement(manager.connections, opt.connection)
+	decrement(manager.backends, opt.backend)
+	if len(opt.uuid) > 0 {
+		delete(manager.tunnels, opt.uuid)
+	}
+}
+
+/**
+ * The key of the QuotaReservation within the context of doConnect.
+ */
+type quotaReservationKey struct{}
+
+/*WithQuotaReservation *
+ * Returns a copy of the given context carrying the given reservation.
+ */
+func WithQuotaReservation(ctx context.Context, reservation *QuotaReservation) context.Context {
+	return context.WithValue(ctx, quotaReservationKey{}, reservation)
+}
+
+/*QuotaReservationFromContext *
+ * Returns the reservation of the connect request whose context is given,
+ * or nil if quotas are not enforced.
+ */
+func QuotaReservationFromContext(ctx context.Context) *QuotaReservation {
+	reservation, _ := ctx.Value(quotaReservationKey{}).(*QuotaReservation)
+	return reservation
+}
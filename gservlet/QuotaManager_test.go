@@ -0,0 +1,153 @@
+package gservlet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gevent"
+)
+
+func Test_QuotaManagerLimits(t *testing.T) {
+	quotas := NewQuotaManager(QuotaLimits{Total: 3, PerUser: 1, PerConnection: 1, PerBackend: 1})
+
+	first, err := quotas.Reserve("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = quotas.Reserve("alice"); !errors.Is(err, exp.GuacamoleClientTooManyException) {
+		t.Errorf("second tunnel of the user reserved, err %v", err)
+	}
+
+	second, err := quotas.Reserve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = second.SetUser("alice"); !errors.Is(err, exp.GuacamoleClientTooManyException) {
+		t.Errorf("second tunnel of the user counted, err %v", err)
+	}
+	if err = first.SetConnection("rdp"); err != nil {
+		t.Fatal(err)
+	}
+	if err = second.SetConnection("rdp"); !errors.Is(err, exp.GuacamoleServerBusyException) {
+		t.Errorf("second tunnel to the connection counted, err %v", err)
+	}
+	if err = first.SetBackend("guacd-1:4822"); err != nil {
+		t.Fatal(err)
+	}
+	if err = second.SetBackend("guacd-1:4822"); !errors.Is(err, exp.GuacamoleServerBusyException) {
+		t.Errorf("second tunnel through the backend counted, err %v", err)
+	}
+	if err = second.SetBackend("guacd-2:4822"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = quotas.Reserve("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = quotas.Reserve("carol"); err.GetStatus() != exp.SERVER_BUSY {
+		t.Errorf("tunnel beyond the total reserved, err %v", err)
+	}
+
+	usage := quotas.Usage()
+	if usage.Total != 3 || usage.Users["alice"] != 1 || usage.Connections["rdp"] != 1 || len(usage.Backends) != 2 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+func Test_QuotaManagerRelease(t *testing.T) {
+	quotas := NewQuotaManager(QuotaLimits{PerUser: 1})
+	events := gevent.NewEventBus()
+	quotas.Subscribe(events)
+
+	reservation, err := quotas.Reserve("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reservation.Bind("tunnel-1")
+
+	// Other tunnels closing do not release the reservation
+	closed := gevent.NewTunnelEvent(gevent.CLOSED, nil)
+	closed.TunnelUUID = "tunnel-2"
+	events.Publish(closed)
+	if quotas.Usage().Total != 1 {
+		t.Fatalf("usage = %+v", quotas.Usage())
+	}
+
+	closed.TunnelUUID = "tunnel-1"
+	events.Publish(closed)
+	reservation.Release()
+	if usage := quotas.Usage(); usage.Total != 0 || len(usage.Users) != 0 {
+		t.Errorf("usage = %+v", usage)
+	}
+	if _, err = quotas.Reserve("alice"); err != nil {
+		t.Errorf("user not released, err %v", err)
+	}
+}
+
+func Test_QuotaReservationContext(t *testing.T) {
+	// Quotas are not enforced without a reservation
+	reservation := QuotaReservationFromContext(context.Background())
+	if reservation != nil || reservation.SetUser("alice") != nil || reservation.SetBackend("guacd") != nil {
+		t.Errorf("reservation = %v", reservation)
+	}
+	reservation.Release()
+
+	quotas := NewQuotaManager(QuotaLimits{})
+	reservation, _ = quotas.Reserve("")
+	ctx := WithQuotaReservation(context.Background(), reservation)
+	if QuotaReservationFromContext(ctx) != reservation {
+		t.Errorf("reservation not found in context")
+	}
+}
+
+/**
+ * Reserves a share of the given quotas for the tunnel having the given
+ * UUID, then publishes the CLOSED event of that tunnel on the given bus.
+ * Returns whether the reservation was released.
+ */
+func releasedOnClose(t *testing.T, quotas *QuotaManager, events *gevent.EventBus, uuid string) bool {
+	t.Helper()
+	reservation, err := quotas.Reserve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reservation.Bind(uuid)
+	total := quotas.Usage().Total
+	closed := gevent.NewTunnelEvent(gevent.CLOSED, nil)
+	closed.TunnelUUID = uuid
+	events.Publish(closed)
+	released := quotas.Usage().Total < total
+	reservation.Release()
+	return released
+}
+
+func Test_ServletSetQuotaManager(t *testing.T) {
+	servlet := NewGuacamoleHTTPTunnelServlet(nil, nil, nil)
+	defer servlet.Destroy()
+	events := servlet.GetEventBus()
+
+	// Nil means no quotas
+	servlet.SetQuotaManager(nil)
+	if servlet.GetQuotaManager() != nil {
+		t.Errorf("quota manager set")
+	}
+
+	// A manager replaced no longer releases the tunnels of the servlet
+	replaced, quotas := NewQuotaManager(QuotaLimits{}), NewQuotaManager(QuotaLimits{})
+	servlet.SetQuotaManager(replaced)
+	servlet.SetQuotaManager(quotas)
+	if servlet.GetQuotaManager() != quotas {
+		t.Errorf("quota manager not replaced")
+	}
+	if releasedOnClose(t, replaced, events, "tunnel-1") || !releasedOnClose(t, quotas, events, "tunnel-2") {
+		t.Errorf("released by the replaced manager, or not by the current one")
+	}
+
+	// Nor does a manager removed
+	servlet.SetQuotaManager(nil)
+	if releasedOnClose(t, quotas, events, "tunnel-3") {
+		t.Errorf("released by the removed manager")
+	}
+}
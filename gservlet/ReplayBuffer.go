@@ -0,0 +1,139 @@
+package gservlet
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+// DEFAULT_REPLAY_BUFFER_SIZE The default number of bytes kept by a ReplayBuffer.
+const DEFAULT_REPLAY_BUFFER_SIZE = 1024 * 1024
+
+/**
+ * The instructions sent after one "sync" instruction, up to and including
+ * the next.
+ */
+type replaySegment struct {
+	/**
+	 * The timestamp of the "sync" instruction preceding this segment, or -1
+	 * for the instructions sent before the first "sync".
+	 */
+	timestamp int64
+	data      []byte
+}
+
+// ReplayBuffer *
+//  * A bounded record of the instructions most recently sent to a client,
+//  * split at each "sync" instruction, from which a client reconnecting to
+//  * a tunnel can be resent everything after the last "sync" it received.
+//  * The oldest segments are discarded once the buffer exceeds its limit,
+//  * but the segment being filled is always kept.
+type ReplayBuffer struct {
+	segments []replaySegment
+	size     int
+	limit    int
+	lock     sync.Mutex
+}
+
+/*NewReplayBuffer *
+ * Creates a new, empty ReplayBuffer.
+ *
+ * @param limit
+ *     The number of bytes to keep, or zero for DEFAULT_REPLAY_BUFFER_SIZE.
+ */
+func NewReplayBuffer(limit int) (ret *ReplayBuffer) {
+	if limit <= 0 {
+		limit = DEFAULT_REPLAY_BUFFER_SIZE
+	}
+	ret = &ReplayBuffer{
+		segments: []replaySegment{{timestamp: -1}},
+		limit:    limit,
+	}
+	return
+}
+
+var syncPrefix = []byte("4.sync,")
+
+/**
+ * Returns the timestamp of the given instruction if it is a "sync"
+ * instruction.
+ */
+func syncTimestamp(instruction []byte) (timestamp int64, ok bool) {
+	if !bytes.HasPrefix(instruction, syncPrefix) {
+		return
+	}
+	parser := gprotocol.NewGuacamoleStreamParser()
+	parsed, err := parser.Append(instruction)
+	if err != nil || len(parsed) == 0 || len(parsed[0].GetArgs()) == 0 {
+		return
+	}
+	timestamp, e := strconv.ParseInt(parsed[0].GetArgs()[0], 10, 64)
+	return timestamp, e == nil
+}
+
+/*Append *
+ * Records the given instruction as sent to the client.
+ *
+ * @param instruction
+ *     One complete instruction, in protocol form.
+ */
+func (opt *ReplayBuffer) Append(instruction []byte) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+
+	last := &opt.segments[len(opt.segments)-1]
+	last.data = append(last.data, instruction...)
+	opt.size += len(instruction)
+
+	if timestamp, ok := syncTimestamp(instruction); ok {
+		opt.segments = append(opt.segments, replaySegment{timestamp: timestamp})
+	}
+
+	// Discard the oldest segments, never the one being filled
+	for opt.size > opt.limit && len(opt.segments) > 1 {
+		opt.size -= len(opt.segments[0].data)
+		opt.segments[0] = replaySegment{}
+		opt.segments = opt.segments[1:]
+	}
+}
+
+/*Since *
+ * Returns every instruction sent after the "sync" instruction having the
+ * given timestamp.
+ *
+ * @param timestamp
+ *     The timestamp of the last "sync" instruction received by the client.
+ *
+ * @throws GuacamoleException
+ *     If the instructions sent after that "sync" are no longer, or were
+ *     never, in the buffer.
+ */
+func (opt *ReplayBuffer) Since(timestamp int64) (ret []byte, err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+
+	for i, segment := range opt.segments {
+		if segment.timestamp != timestamp {
+			continue
+		}
+		for _, one := range opt.segments[i:] {
+			ret = append(ret, one.data...)
+		}
+		return
+	}
+	err = exp.GuacamoleResourceClosedException.Throw("Instructions since sync " +
+		strconv.FormatInt(timestamp, 10) + " are no longer available.")
+	return
+}
+
+/*Size *
+ * Returns the number of bytes currently kept.
+ */
+func (opt *ReplayBuffer) Size() int {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	return opt.size
+}
@@ -0,0 +1,77 @@
+package gservlet
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+const (
+	testSync1 = "4.sync,4.1000;"
+	testSync2 = "4.sync,4.2000;"
+	testSync3 = "4.sync,4.3000;"
+	testImg   = "3.img,1.1,2.12,2.-1,9.image/png,1.0,1.0;"
+	testBlob  = "4.blob,1.1,8.AAAAAAAA;"
+)
+
+func Test_ReplayBufferSince(t *testing.T) {
+	buffer := NewReplayBuffer(0)
+	for _, instruction := range []string{testImg, testSync1, testBlob, testSync2, testImg} {
+		buffer.Append([]byte(instruction))
+	}
+
+	cases := map[int64]string{
+		-1:   testImg + testSync1 + testBlob + testSync2 + testImg,
+		1000: testBlob + testSync2 + testImg,
+		2000: testImg,
+	}
+	for timestamp, expected := range cases {
+		if replayed, err := buffer.Since(timestamp); err != nil || string(replayed) != expected {
+			t.Errorf("since %v: %q, err %v", timestamp, replayed, err)
+		}
+	}
+
+	// Only timestamps of "sync" instructions sent are known
+	if _, err := buffer.Since(1500); !errors.Is(err, exp.GuacamoleResourceClosedException) {
+		t.Errorf("unknown sync: err %v", err)
+	}
+	if buffer.Size() != len(cases[-1]) {
+		t.Errorf("size = %v", buffer.Size())
+	}
+}
+
+func Test_ReplayBufferLimit(t *testing.T) {
+	segment := testBlob + testSync1
+	buffer := NewReplayBuffer(2 * len(segment))
+
+	// The oldest segments are evicted beyond the limit
+	for _, instruction := range []string{testBlob, testSync1, testBlob, testSync2, testBlob, testSync3} {
+		buffer.Append([]byte(instruction))
+		if buffer.Size() > 2*len(segment) {
+			t.Fatalf("size %v beyond the limit", buffer.Size())
+		}
+	}
+	if _, err := buffer.Since(-1); !errors.Is(err, exp.GuacamoleResourceClosedException) {
+		t.Errorf("evicted segment replayed, err %v", err)
+	}
+	if replayed, err := buffer.Since(1000); err != nil || string(replayed) != testBlob+testSync2+testBlob+testSync3 {
+		t.Errorf("since 1000: %q, err %v", replayed, err)
+	}
+
+	// The segment being filled is kept even beyond the limit
+	blob := gprotocol.NewGuacamoleInstruction("blob", "1", strings.Repeat("A", 3*len(segment)))
+	large := blob.String()
+	buffer.Append([]byte(large))
+	if replayed, err := buffer.Since(3000); err != nil || string(replayed) != large {
+		t.Errorf("since 3000: %v bytes, err %v", len(replayed), err)
+	}
+	if _, err := buffer.Since(2000); err == nil {
+		t.Errorf("segment before the large one kept")
+	}
+	if buffer.Size() != len(large) {
+		t.Errorf("size = %v, expected %v", buffer.Size(), len(large))
+	}
+}
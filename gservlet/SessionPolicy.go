@@ -0,0 +1,131 @@
+package gservlet
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+const (
+	/*NOTICE_STREAM_INDEX *
+	 * The index of the stream over which notices are sent to the client.
+	 * guacd allocates stream indices from zero, far below this value.
+	 */
+	NOTICE_STREAM_INDEX = 65535
+
+	/*NOTICE_STREAM_NAME *
+	 * The name of the "pipe" stream carrying notices, as received by the
+	 * onpipe handler of the JavaScript Guacamole.Client. The stock client
+	 * has no such handler: see NOTICE_SCRIPT.
+	 */
+	NOTICE_STREAM_NAME = "guacamole-notice"
+
+	/*TOMBSTONE_LIFETIME *
+	 * How long a tunnel closed by a SessionPolicy is remembered, so that
+	 * further requests for it fail with SESSION_TIMEOUT rather than
+	 * RESOURCE_NOT_FOUND.
+	 */
+	TOMBSTONE_LIFETIME = time.Minute
+)
+
+// SessionPolicy *
+//  * Limits on the length of HTTP tunnel sessions, enforced by
+//  * GuacamoleHTTPTunnelMap. The user is sent a notice when a limit is near
+//  * and the tunnel is then closed with SESSION_TIMEOUT. Zero durations
+//  * disable the corresponding limit or warning.
+type SessionPolicy struct {
+	/**
+	 * The time after the user's last "key" or "mouse" instruction after
+	 * which the tunnel is closed.
+	 */
+	IdleTimeout time.Duration
+
+	/**
+	 * How long before the idle timeout the user is warned.
+	 */
+	IdleWarning time.Duration
+
+	/**
+	 * The time after the tunnel was created after which it is closed,
+	 * regardless of activity.
+	 */
+	MaxDuration time.Duration
+
+	/**
+	 * How long before the maximum duration the user is warned.
+	 */
+	MaxDurationWarning time.Duration
+}
+
+/**
+ * Returns whether the given instruction is keyboard or mouse input from
+ * the user.
+ */
+func isUserInput(instruction gprotocol.GuacamoleInstruction) bool {
+	opcode := instruction.GetOpcode()
+	return opcode == "key" || opcode == "mouse"
+}
+
+/*NewNoticeInstructions *
+ * Returns the instructions sending the given text to the client over the
+ * notice stream, which the JavaScript client displays once NOTICE_SCRIPT
+ * is installed.
+ *
+ * @param text The text of the notice.
+ */
+func NewNoticeInstructions(text string) []gprotocol.GuacamoleInstruction {
+	index := strconv.Itoa(NOTICE_STREAM_INDEX)
+	return []gprotocol.GuacamoleInstruction{
+		gprotocol.NewGuacamoleInstruction("pipe", index, "text/plain", NOTICE_STREAM_NAME),
+		gprotocol.NewGuacamoleInstruction("blob", index, base64.StdEncoding.EncodeToString([]byte(text))),
+		gprotocol.NewGuacamoleInstruction("end", index),
+	}
+}
+
+/*NewErrorInstruction *
+ * Returns the "error" instruction informing the client that its session
+ * was closed with the status and message of the given error.
+ *
+ * @param err The error closing the session.
+ */
+func NewErrorInstruction(err exp.ExceptionInterface) gprotocol.GuacamoleInstruction {
+	return gprotocol.NewErrorInstruction(err)
+}
+
+/**
+ * Returns an error of the kind corresponding to the given status. SUCCESS
+ * and unknown statuses are reported as SESSION_CLOSED.
+ */
+func newStatusException(status exp.GuacamoleStatus, message string) exp.ExceptionInterface {
+	if status == exp.SUCCESS || status == exp.Undifined {
+		return exp.GuacamoleSessionClosedException.Throw(message)
+	}
+	return exp.ExceptionKindFromStatus(status).Throw(message)
+}
+
+/**
+ * Formats the given remaining time for a notice, to the second.
+ */
+func formatRemaining(remaining time.Duration) string {
+	if remaining < time.Second {
+		remaining = time.Second
+	}
+	return fmt.Sprintf("%v", remaining.Round(time.Second))
+}
+
+/*DrainNotice *
+ * Returns the notice sent to every active session when a drain starts,
+ * mentioning the time left before the given deadline, if any.
+ *
+ * @param deadline The time sessions will be closed, or the zero time.
+ */
+func DrainNotice(deadline time.Time) string {
+	if deadline.IsZero() {
+		return DRAIN_NOTICE
+	}
+	return DRAIN_NOTICE + " Your session will end in " + formatRemaining(time.Until(deadline)) + "."
+}
@@ -1,6 +1,9 @@
 package gservlet
 
 import (
+	"context"
+
+	exp "github.com/hsfish/guacamole_client_go"
 	"github.com/hsfish/guacamole_client_go/gnet"
 )
 
@@ -11,6 +14,28 @@ type HTTPServletRequestInterface interface {
 	Read([]byte) (int, error)
 }
 
+// HTTPServletRequestHeaderInterface Optional interface of HTTPServletRequestInterface
+//  * Implemented by requests which expose their HTTP headers. Used to read
+//  * the "traceparent" header of incoming connect requests.
+type HTTPServletRequestHeaderInterface interface {
+	// Returns the value of the specified request header, or "" if the request did not include a header of the specified name.
+	GetHeader(name string) string
+}
+
+// HTTPServletRequestCookieInterface Optional interface of HTTPServletRequestInterface
+//  * Implemented by requests which expose their cookies.
+type HTTPServletRequestCookieInterface interface {
+	// Returns the value of the cookie having the given name, and whether the request included it.
+	GetCookie(name string) (string, bool)
+}
+
+// HTTPServletRequestRemoteAddrInterface Optional interface of HTTPServletRequestInterface
+//  * Implemented by requests which expose the address of the client.
+type HTTPServletRequestRemoteAddrInterface interface {
+	// Returns the Internet Protocol (IP) address of the client or last proxy that sent the request, without port.
+	GetRemoteAddr() string
+}
+
 // HTTPServletResponseInterface convert http response
 type HTTPServletResponseInterface interface {
 	// Returns a boolean indicating if the response has been committed. A committed response has already had its status code and headers written.
@@ -43,6 +68,52 @@ type HTTPServletResponseInterface interface {
 // DoConnectInterface Tool interface for GuacamoleHTTPTunnelServlet
 type DoConnectInterface func(request HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error)
 
+// DoConnectContextInterface Tool interface for GuacamoleHTTPTunnelServlet
+//  * As DoConnectInterface, additionally receiving the context of the
+//  * connect request, which carries its tracing span.
+type DoConnectContextInterface func(ctx context.Context, request HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error)
+
+// TunnelOwnerResolverInterface Tool interface for GuacamoleHTTPTunnelServlet
+//  * Returns the principal on whose behalf the given request is made, such as
+//  * a session ID or authenticated subject. A tunnel is bound to the
+//  * principal of its connect request, and all later requests for it must
+//  * resolve to the same principal.
+type TunnelOwnerResolverInterface func(request HTTPServletRequestInterface) (owner string, err exp.ExceptionInterface)
+
+// TunnelOperationInterface Tool interface for GuacamoleHTTPTunnelServlet
+//  * Handles a custom operation requested for the given tunnel, whose owner
+//  * has been verified. See GuacamoleHTTPTunnelServlet.SetOperation.
+type TunnelOperationInterface func(tunnel *GuacamoleHTTPTunnel, tunnelRequest TunnelRequest,
+	request HTTPServletRequestInterface, response HTTPServletResponseInterface) exp.ExceptionInterface
+
 type DoConnectSuccInterface func(tunnel gnet.GuacamoleTunnel)
 
 type DoConnectStopInterface func(tunnel gnet.GuacamoleTunnel)
+
+/**
+ * Response which records whether writing to the client failed, so that a
+ * lost client connection can be told apart from a failure of the tunnel.
+ */
+type trackedResponse struct {
+	HTTPServletResponseInterface
+	failed bool
+}
+
+func (opt *trackedResponse) track(e error) error {
+	if e != nil {
+		opt.failed = true
+	}
+	return e
+}
+
+func (opt *trackedResponse) WriteString(data string) error {
+	return opt.track(opt.HTTPServletResponseInterface.WriteString(data))
+}
+
+func (opt *trackedResponse) Write(data []byte) error {
+	return opt.track(opt.HTTPServletResponseInterface.Write(data))
+}
+
+func (opt *trackedResponse) FlushBuffer() error {
+	return opt.track(opt.HTTPServletResponseInterface.FlushBuffer())
+}
@@ -0,0 +1,355 @@
+package gservlet
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gcluster"
+	"github.com/hsfish/guacamole_client_go/gtrace"
+	logger "github.com/sirupsen/logrus"
+)
+
+// FORWARDED_HEADER The header naming the node which forwarded a request.
+const FORWARDED_HEADER = "Guacamole-Forwarded-By"
+
+// FORWARDED_SIGNATURE_HEADER The header authenticating a forwarded request
+// as "<unix time>.<hex HMAC-SHA256>", signed with the cluster secret.
+const FORWARDED_SIGNATURE_HEADER = "Guacamole-Forwarded-Signature"
+
+// FORWARDED_MAX_AGE How long a forwarded request remains valid after it was signed.
+const FORWARDED_MAX_AGE = 30 * time.Second
+
+// FORWARDED_MAX_BODY_SIZE The largest body of a forwarded request, which is
+// read whole to be signed. Write requests of the JavaScript client are far
+// smaller.
+const FORWARDED_MAX_BODY_SIZE = 1024 * 1024
+
+// FORWARD_DIAL_TIMEOUT How long connecting to the node owning a tunnel may take.
+const FORWARD_DIAL_TIMEOUT = 5 * time.Second
+
+// FORWARD_RESPONSE_HEADER_TIMEOUT How long the node owning a tunnel may take
+// to answer a forwarded request. Its tunnels send data at least every few
+// seconds, as guacd keeps its connections alive with "nop" instructions.
+const FORWARD_RESPONSE_HEADER_TIMEOUT = 30 * time.Second
+
+/**
+ * The headers set by the node forwarding a request, which are only
+ * trusted once the signature of the request has been verified.
+ */
+var forwardingHeaders = []string{FORWARDED_HEADER, FORWARDED_FOR_HEADER, FORWARDED_SIGNATURE_HEADER}
+
+/**
+ * Returns the HMAC-SHA256 of the given forwarded request, in hex. The
+ * relayed ownerHeaders of the request, read from the given headers if not
+ * nil, and its body are covered through their SHA-256, so that a captured
+ * request cannot be replayed on behalf of another principal or with other
+ * input.
+ */
+func signForwarded(secret []byte, node, address, query string, headers HTTPServletRequestHeaderInterface,
+	body []byte, timestamp int64) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, node+"\n"+address+"\n"+strconv.FormatInt(timestamp, 10)+"\n"+query)
+	for _, name := range ownerHeaders {
+		value := ""
+		if headers != nil {
+			value = headers.GetHeader(name)
+		}
+		digest := sha256.Sum256([]byte(value))
+		io.WriteString(mac, "\n"+hex.EncodeToString(digest[:]))
+	}
+	digest := sha256.Sum256(body)
+	io.WriteString(mac, "\n"+hex.EncodeToString(digest[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * Returns whether the given signature was made with the given secret for
+ * the given forwarded request, no longer than FORWARDED_MAX_AGE ago.
+ */
+func verifyForwarded(secret []byte, node, address, query string, headers HTTPServletRequestHeaderInterface,
+	body []byte, signature string, now time.Time) bool {
+	if len(secret) == 0 || len(node) == 0 {
+		return false
+	}
+	parts := strings.SplitN(signature, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	timestamp, e := strconv.ParseInt(parts[0], 10, 64)
+	if e != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > FORWARDED_MAX_AGE || age < -FORWARDED_MAX_AGE {
+		return false
+	}
+	expected := signForwarded(secret, node, address, query, headers, body, timestamp)
+	return hmac.Equal([]byte(expected), []byte(parts[1]))
+}
+
+/**
+ * Returns whether the given request carries a single value of each of the
+ * ownerHeaders, as relayed by Forward, the signature covering only the
+ * first.
+ */
+func hasSingleOwnerHeaders(request HTTPServletRequestInterface) bool {
+	adapter, ok := request.(*HTTPRequestAdapter)
+	if !ok {
+		return true
+	}
+	for _, name := range ownerHeaders {
+		if len(adapter.GetRequest().Header[http.CanonicalHeaderKey(name)]) > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// forwardedRequest *
+//  * A request carrying forwarding headers. If they were signed by a node
+//  * of the cluster, the request is trusted as forwarded and the address
+//  * relayed by that node is its remote address. Otherwise the headers are
+//  * hidden, as sent by a client.
+type forwardedRequest struct {
+	HTTPServletRequestInterface
+	trusted bool
+	address string
+
+	/**
+	 * The body of the request, read ahead to verify its signature.
+	 */
+	body io.Reader
+}
+
+/**
+ * Returns the given request, wrapped if it carries forwarding headers so
+ * that they are only seen if signed with the given cluster secret.
+ */
+func authenticateForwarded(request HTTPServletRequestInterface, secret []byte) HTTPServletRequestInterface {
+	headers, ok := request.(HTTPServletRequestHeaderInterface)
+	if !ok {
+		return request
+	}
+	present := false
+	for _, name := range forwardingHeaders {
+		present = present || len(headers.GetHeader(name)) > 0
+	}
+	if !present {
+		return request
+	}
+
+	// The body is signed, so read ahead of the handler of the request,
+	// which reads it back from the wrapper
+	body, e := ioutil.ReadAll(io.LimitReader(request, FORWARDED_MAX_BODY_SIZE+1))
+	node, address := headers.GetHeader(FORWARDED_HEADER), headers.GetHeader(FORWARDED_FOR_HEADER)
+	trusted := e == nil && len(body) <= FORWARDED_MAX_BODY_SIZE && hasSingleOwnerHeaders(request) &&
+		verifyForwarded(secret, node, address, request.GetQueryString(), headers, body,
+			headers.GetHeader(FORWARDED_SIGNATURE_HEADER), time.Now())
+	if !trusted {
+		logger.Warnf("Ignoring forwarding headers of request claiming to come from node \"%v\": invalid signature.", node)
+	}
+	return &forwardedRequest{HTTPServletRequestInterface: request, trusted: trusted, address: address,
+		body: io.MultiReader(bytes.NewReader(body), request)}
+}
+
+// Read Override HTTPServletRequestInterface.Read
+func (opt *forwardedRequest) Read(buffer []byte) (int, error) {
+	return opt.body.Read(buffer)
+}
+
+// GetHeader Override HTTPServletRequestHeaderInterface.GetHeader
+func (opt *forwardedRequest) GetHeader(name string) string {
+	if !opt.trusted {
+		for _, hidden := range forwardingHeaders {
+			if strings.EqualFold(name, hidden) {
+				return ""
+			}
+		}
+	}
+	return opt.HTTPServletRequestInterface.(HTTPServletRequestHeaderInterface).GetHeader(name)
+}
+
+// GetCookie Override HTTPServletRequestCookieInterface.GetCookie
+func (opt *forwardedRequest) GetCookie(name string) (string, bool) {
+	if cookies, ok := opt.HTTPServletRequestInterface.(HTTPServletRequestCookieInterface); ok {
+		return cookies.GetCookie(name)
+	}
+	return "", false
+}
+
+// GetRemoteAddr Override HTTPServletRequestRemoteAddrInterface.GetRemoteAddr
+func (opt *forwardedRequest) GetRemoteAddr() string {
+	if opt.trusted {
+		return opt.address
+	}
+	if addr, ok := opt.HTTPServletRequestInterface.(HTTPServletRequestRemoteAddrInterface); ok {
+		return addr.GetRemoteAddr()
+	}
+	return ""
+}
+
+/**
+ * Returns whether the given request was forwarded by another node, as
+ * verified by authenticateForwarded.
+ */
+func isForwarded(request HTTPServletRequestInterface) bool {
+	forwarded, ok := request.(*forwardedRequest)
+	return ok && forwarded.trusted
+}
+
+// TunnelForwarder *
+//  * Relays HTTP tunnel requests to the node owning the tunnel, streaming
+//  * the owner's response back unchanged, including any error status.
+type TunnelForwarder struct {
+	node   gcluster.NodeInfo
+	secret []byte
+	client *http.Client
+}
+
+/*NewTunnelForwarder *
+ * Creates a TunnelForwarder relaying requests on behalf of the given node.
+ * Reaching the owner and receiving the headers of its response are bounded
+ * by FORWARD_DIAL_TIMEOUT and FORWARD_RESPONSE_HEADER_TIMEOUT, while the
+ * body has no timeout, as read requests are long polls ended by the owner.
+ *
+ * @param node The node forwarding requests.
+ * @param secret The secret shared by every node, signing each request.
+ */
+func NewTunnelForwarder(node gcluster.NodeInfo, secret []byte) (ret *TunnelForwarder) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   FORWARD_DIAL_TIMEOUT,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   FORWARD_DIAL_TIMEOUT,
+		ResponseHeaderTimeout: FORWARD_RESPONSE_HEADER_TIMEOUT,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	ret = &TunnelForwarder{node: node, secret: secret, client: &http.Client{Transport: transport}}
+	return
+}
+
+/*Forward *
+ * Relays the given tunnel request to the given node.
+ *
+ * @param owner The node owning the tunnel.
+ * @param query The query string of the request.
+ * @param request The request received.
+ * @param response The response to the request received.
+ * @throws GuacamoleException If the owner cannot be reached.
+ */
+func (opt *TunnelForwarder) Forward(owner gcluster.NodeInfo, query string,
+	request HTTPServletRequestInterface, response HTTPServletResponseInterface) (err exp.ExceptionInterface) {
+
+	// Only write requests carry a body, read whole to be signed
+	method, body := http.MethodGet, []byte(nil)
+	if strings.HasPrefix(query, WRITE_PREFIX) {
+		method = http.MethodPost
+		data, e := ioutil.ReadAll(io.LimitReader(request, FORWARDED_MAX_BODY_SIZE+1))
+		if e != nil {
+			return exp.GuacamoleClientException.Wrap(e, "Unable to read request body.")
+		}
+		if len(data) > FORWARDED_MAX_BODY_SIZE {
+			return exp.GuacamoleClientOverrunException.Throw("Request body too large to forward.")
+		}
+		body = data
+	}
+
+	forwarded, e := http.NewRequest(method, owner.Address+"?"+query, bytes.NewReader(body))
+	if e != nil {
+		return exp.GuacamoleServerException.Wrap(e, "Invalid address of node \""+owner.ID+"\".")
+	}
+	forwarded.Header.Set(FORWARDED_HEADER, opt.node.ID)
+	forwarded.Header.Set("Content-Type", "application/octet-stream")
+	if headers, ok := request.(HTTPServletRequestHeaderInterface); ok {
+		if traceparent := headers.GetHeader(gtrace.TRACEPARENT_HEADER); len(traceparent) > 0 {
+			forwarded.Header.Set(gtrace.TRACEPARENT_HEADER, traceparent)
+		}
+		// Relay the principal of the request for the owner to verify
+		for _, name := range ownerHeaders {
+			if value := headers.GetHeader(name); len(value) > 0 {
+				forwarded.Header.Set(name, value)
+			}
+		}
+		// Relay how errors are to be reported by the owner
+		for _, name := range []string{"Accept", CORRELATION_ID_HEADER} {
+			if value := headers.GetHeader(name); len(value) > 0 {
+				forwarded.Header.Set(name, value)
+			}
+		}
+	}
+	address := remoteAddress(request)
+	if len(address) > 0 {
+		forwarded.Header.Set(FORWARDED_FOR_HEADER, address)
+	}
+	headers, _ := request.(HTTPServletRequestHeaderInterface)
+	timestamp := time.Now().Unix()
+	forwarded.Header.Set(FORWARDED_SIGNATURE_HEADER, strconv.FormatInt(timestamp, 10)+"."+
+		signForwarded(opt.secret, opt.node.ID, address, query, headers, body, timestamp))
+
+	reply, e := opt.client.Do(forwarded)
+	if e != nil {
+		return exp.GuacamoleUpstreamUnavailableException.Wrap(e, "Unable to reach node \""+owner.ID+"\".")
+	}
+	defer reply.Body.Close()
+
+	// Relay errors as sent by the owner
+	if reply.StatusCode != http.StatusOK {
+		for _, name := range []string{"Guacamole-Status-Code", "Guacamole-Error-Message", CORRELATION_ID_HEADER} {
+			if value := reply.Header.Get(name); len(value) > 0 {
+				response.AddHeader(name, value)
+			}
+		}
+		contentType := reply.Header.Get("Content-Type")
+		if len(contentType) > 0 {
+			response.SetContentType(contentType)
+		}
+		if e = response.SendError(reply.StatusCode); e != nil {
+			return exp.GuacamoleServerException.Wrap(e)
+		}
+		// Relay the ErrorResponse, if any
+		if body, e := ioutil.ReadAll(reply.Body); e == nil && len(body) > 0 {
+			response.Write(body)
+		}
+		return
+	}
+
+	contentType := reply.Header.Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	response.SetContentType(contentType)
+	response.SetHeader("Cache-Control", "no-cache")
+
+	// Stream the owner's response, flushing as data arrives
+	buffer := make([]byte, 8192)
+	for {
+		length, e := reply.Body.Read(buffer)
+		if length > 0 {
+			if we := response.Write(buffer[:length]); we != nil {
+				return exp.GuacamoleServerException.Wrap(we)
+			}
+			if we := response.FlushBuffer(); we != nil {
+				return exp.GuacamoleServerException.Wrap(we)
+			}
+		}
+		if e == io.EOF {
+			return
+		}
+		if e != nil {
+			return exp.GuacamoleUpstreamException.Wrap(e, "Lost connection to node \""+owner.ID+"\".")
+		}
+	}
+}
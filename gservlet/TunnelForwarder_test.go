@@ -0,0 +1,150 @@
+package gservlet
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gcluster"
+	"github.com/hsfish/guacamole_client_go/gnet"
+)
+
+func Test_TunnelForwarder(t *testing.T) {
+	registry, secret := gcluster.NewMemoryTunnelRegistry(), []byte("cluster secret")
+	tunnel, guacd := newTestTunnel()
+	defer guacd.Close()
+
+	owner := NewGuacamoleHTTPTunnelServlet(func(request HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error) {
+		return tunnel, nil
+	}, nil, nil)
+	defer owner.Destroy()
+	server := httptest.NewServer(&owner)
+	defer server.Close()
+	owner.SetOwnerResolver(RemoteAddressOwnerResolver(32, 128))
+	owner.SetTunnelRegistry(registry, gcluster.NodeInfo{ID: "node-1", Address: server.URL + "/tunnel"}, secret)
+
+	other := NewGuacamoleHTTPTunnelServlet(nil, nil, nil)
+	defer other.Destroy()
+	other.SetOwnerResolver(RemoteAddressOwnerResolver(32, 128))
+	other.SetTunnelRegistry(registry, gcluster.NodeInfo{ID: "node-2", Address: "http://node-2/tunnel"}, secret)
+
+	recorder := httptest.NewRecorder()
+	owner.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/tunnel?connect", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("connect: code %v", recorder.Code)
+	}
+	query := "/tunnel?status:" + tunnel.GetUUID().String()
+
+	// The owner answers on behalf of the client reaching the other node
+	recorder = httptest.NewRecorder()
+	other.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, query, nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/json" ||
+		!strings.Contains(recorder.Body.String(), tunnel.GetUUID().String()) {
+		t.Errorf("forwarded status: code %v, headers %v, body %q", recorder.Code, recorder.Header(), recorder.Body.String())
+	}
+
+	// Written input reaches guacd through the owner
+	const key = "3.key,5.65307,1.1;"
+	received := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(guacd).ReadString(';')
+		received <- line
+	}()
+	recorder = httptest.NewRecorder()
+	other.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/tunnel?write:"+tunnel.GetUUID().String(),
+		strings.NewReader(key)))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("forwarded write: code %v, headers %v", recorder.Code, recorder.Header())
+	}
+	select {
+	case line := <-received:
+		if line != key {
+			t.Errorf("guacd received %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("forwarded write not received by guacd")
+	}
+
+	// Errors of the owner are relayed, here refusing another client
+	request := httptest.NewRequest(http.MethodGet, query, nil)
+	request.RemoteAddr = "203.0.113.9:6000"
+	recorder = httptest.NewRecorder()
+	other.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden || recorder.Header().Get("Guacamole-Status-Code") != "771" {
+		t.Errorf("forwarded refusal: code %v, headers %v", recorder.Code, recorder.Header())
+	}
+}
+
+func Test_TunnelForwarderTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		<-hung
+	}))
+	defer server.Close()
+	defer close(hung)
+
+	forwarder := NewTunnelForwarder(gcluster.NodeInfo{ID: "node-2"}, []byte("cluster secret"))
+	forwarder.client.Transport.(*http.Transport).ResponseHeaderTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	request := NewHTTPRequestAdapter(httptest.NewRequest(http.MethodGet, "/tunnel?status:"+testUUID, nil))
+	err := forwarder.Forward(gcluster.NodeInfo{ID: "node-1", Address: server.URL}, "status:"+testUUID,
+		request, NewHTTPResponseAdapter(httptest.NewRecorder()))
+	if !errors.Is(err, exp.GuacamoleUpstreamUnavailableException) || time.Since(start) > 5*time.Second {
+		t.Errorf("Forward to hung node = %v after %v", err, time.Since(start))
+	}
+}
+
+func Test_ForwardedSignatureCoversBody(t *testing.T) {
+	secret, query := []byte("cluster secret"), "write:"+testUUID
+	const key = "3.key,5.65307,1.1;"
+
+	// A request signed by node-2, then altered by whoever captured it
+	signed := func(alter func(request *http.Request)) *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/tunnel?"+query, strings.NewReader(key))
+		request.RemoteAddr = "203.0.113.9:6000"
+		request.Header.Set("Cookie", "session=owner")
+		request.Header.Set(FORWARDED_HEADER, "node-2")
+		request.Header.Set(FORWARDED_FOR_HEADER, "198.51.100.7")
+		now := time.Now().Unix()
+		signature := signForwarded(secret, "node-2", "198.51.100.7", query, NewHTTPRequestAdapter(request),
+			[]byte(key), now)
+		request.Header.Set(FORWARDED_SIGNATURE_HEADER, strconv.FormatInt(now, 10)+"."+signature)
+		if alter != nil {
+			alter(request)
+		}
+		return request
+	}
+	cases := map[string]struct {
+		alter   func(request *http.Request)
+		trusted bool
+	}{
+		"unaltered": {nil, true},
+		"body": {func(request *http.Request) {
+			request.Body = ioutil.NopCloser(strings.NewReader("3.key,5.65307,1.0;"))
+		}, false},
+		"cookie":        {func(request *http.Request) { request.Header.Set("Cookie", "session=other") }, false},
+		"second cookie": {func(request *http.Request) { request.Header.Add("Cookie", "session=other") }, false},
+		"authorization": {func(request *http.Request) { request.Header.Set("Authorization", "Bearer other") }, false},
+	}
+	for name, one := range cases {
+		request := authenticateForwarded(NewHTTPRequestAdapter(signed(one.alter)), secret)
+		if isForwarded(request) != one.trusted {
+			t.Errorf("%v: trusted %v", name, isForwarded(request))
+		}
+
+		// The body read to verify the signature is still read by the
+		// handler of the request
+		body, e := ioutil.ReadAll(request)
+		if e != nil || (one.trusted && string(body) != key) {
+			t.Errorf("%v: body %q, err %v", name, body, e)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package gservlet
+
+import (
+	"crypto/subtle"
+	"net"
+	"strings"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+// FORWARDED_FOR_HEADER The header carrying the remote address of a forwarded request.
+const FORWARDED_FOR_HEADER = "Guacamole-Forwarded-For"
+
+/**
+ * Headers identifying the principal of a request, relayed along with
+ * forwarded requests so that the owning node can verify them.
+ */
+var ownerHeaders = []string{"Cookie", "Authorization"}
+
+/*CookieOwnerResolver *
+ * Returns a TunnelOwnerResolverInterface binding tunnels to the value of
+ * the given cookie, typically the session cookie of the application. The
+ * request must implement HTTPServletRequestCookieInterface.
+ *
+ * @param name The name of the cookie.
+ */
+func CookieOwnerResolver(name string) TunnelOwnerResolverInterface {
+	return func(request HTTPServletRequestInterface) (owner string, err exp.ExceptionInterface) {
+		cookies, ok := request.(HTTPServletRequestCookieInterface)
+		if !ok {
+			return "", exp.GuacamoleServerException.Throw("Request does not provide cookies.")
+		}
+		value, ok := cookies.GetCookie(name)
+		if !ok || len(value) == 0 {
+			return "", exp.GuacamoleSecurityException.Throw("Missing cookie \"" + name + "\".")
+		}
+		return "cookie:" + name + "=" + value, nil
+	}
+}
+
+/*HeaderOwnerResolver *
+ * Returns a TunnelOwnerResolverInterface binding tunnels to the value of
+ * the given header, such as an authenticated subject set by a trusted
+ * proxy. The request must implement HTTPServletRequestHeaderInterface.
+ *
+ * @param name The name of the header.
+ */
+func HeaderOwnerResolver(name string) TunnelOwnerResolverInterface {
+	return func(request HTTPServletRequestInterface) (owner string, err exp.ExceptionInterface) {
+		headers, ok := request.(HTTPServletRequestHeaderInterface)
+		if !ok {
+			return "", exp.GuacamoleServerException.Throw("Request does not provide headers.")
+		}
+		value := headers.GetHeader(name)
+		if len(value) == 0 {
+			return "", exp.GuacamoleSecurityException.Throw("Missing header \"" + name + "\".")
+		}
+		return "header:" + name + "=" + value, nil
+	}
+}
+
+/*RemoteAddressOwnerResolver *
+ * Returns a TunnelOwnerResolverInterface binding tunnels to the network of
+ * the client, so that a client roaming within that network keeps access.
+ * The request must implement HTTPServletRequestRemoteAddrInterface. For
+ * requests forwarded by another node, the address relayed by that node is
+ * used instead, once the signature of the request has been verified with
+ * the cluster secret.
+ *
+ * @param ipv4Bits
+ *     The prefix length of the IPv4 network, 32 requiring the same address.
+ *
+ * @param ipv6Bits
+ *     The prefix length of the IPv6 network, 128 requiring the same
+ *     address.
+ */
+func RemoteAddressOwnerResolver(ipv4Bits, ipv6Bits int) TunnelOwnerResolverInterface {
+	return func(request HTTPServletRequestInterface) (owner string, err exp.ExceptionInterface) {
+		address := remoteAddress(request)
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return "", exp.GuacamoleSecurityException.Throw("Unknown client address \"" + address + "\".")
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return "ip:" + ip4.Mask(net.CIDRMask(ipv4Bits, 32)).String(), nil
+		}
+		return "ip:" + ip.Mask(net.CIDRMask(ipv6Bits, 128)).String(), nil
+	}
+}
+
+/*CombinedOwnerResolver *
+ * Returns a TunnelOwnerResolverInterface binding tunnels to the principals
+ * of all the given resolvers, such as both a session cookie and the
+ * client's network.
+ *
+ * @param resolvers The resolvers, all of which must succeed.
+ */
+func CombinedOwnerResolver(resolvers ...TunnelOwnerResolverInterface) TunnelOwnerResolverInterface {
+	return func(request HTTPServletRequestInterface) (owner string, err exp.ExceptionInterface) {
+		principals := make([]string, 0, len(resolvers))
+		for _, resolver := range resolvers {
+			principal, err := resolver(request)
+			if err != nil {
+				return "", err
+			}
+			principals = append(principals, principal)
+		}
+		return strings.Join(principals, "\n"), nil
+	}
+}
+
+/**
+ * Returns the address of the client which sent the given request, as
+ * relayed by the forwarding node if the request was forwarded, or "" if
+ * unknown.
+ */
+func remoteAddress(request HTTPServletRequestInterface) string {
+	if addr, ok := request.(HTTPServletRequestRemoteAddrInterface); ok {
+		return addr.GetRemoteAddr()
+	}
+	return ""
+}
+
+/**
+ * Returns whether the given principal is the given owner of a tunnel,
+ * taking the same time regardless of where they differ. Tunnels without
+ * owner, registered while no resolver was set, are owned by everyone.
+ */
+func isOwner(owner, principal string) bool {
+	if len(owner) == 0 {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(owner), []byte(principal)) == 1
+}
@@ -0,0 +1,121 @@
+package gservlet
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gcluster"
+	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+)
+
+/**
+ * A socket to a fake guacd, played by the other end of a pipe.
+ */
+type testSocket struct {
+	conn   net.Conn
+	reader gio.GuacamoleReader
+	writer gio.GuacamoleWriter
+	closed int32
+}
+
+func (opt *testSocket) GetReader() gio.GuacamoleReader { return opt.reader }
+func (opt *testSocket) GetWriter() gio.GuacamoleWriter { return opt.writer }
+func (opt *testSocket) IsOpen() bool                   { return atomic.LoadInt32(&opt.closed) == 0 }
+
+func (opt *testSocket) Close() exp.ExceptionInterface {
+	atomic.StoreInt32(&opt.closed, 1)
+	opt.conn.Close()
+	return nil
+}
+
+/**
+ * Returns a tunnel to a fake guacd, and the connection of that guacd.
+ */
+func newTestTunnel() (gnet.GuacamoleTunnel, net.Conn) {
+	client, guacd := net.Pipe()
+	stream := gio.NewStream(client, 15*time.Second)
+	socket := &testSocket{
+		conn:   client,
+		reader: gio.NewReaderGuacamoleReader(stream),
+		writer: gio.NewWriterGuacamoleWriter(stream),
+	}
+	return gnet.NewSimpleGuacamoleTunnel(socket, gprotocol.NewGuacamoleConfiguration()), guacd
+}
+
+func Test_RemoteAddressOwnerForwarded(t *testing.T) {
+	tunnel, guacd := newTestTunnel()
+	defer guacd.Close()
+	servlet := NewGuacamoleHTTPTunnelServlet(func(request HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error) {
+		return tunnel, nil
+	}, nil, nil)
+	defer servlet.Destroy()
+	secret := []byte("cluster secret")
+	servlet.SetOwnerResolver(RemoteAddressOwnerResolver(32, 128))
+	servlet.SetTunnelRegistry(gcluster.NewMemoryTunnelRegistry(),
+		gcluster.NodeInfo{ID: "node-1", Address: "http://node-1/tunnel"}, secret)
+
+	connect := httptest.NewRequest(http.MethodPost, "/tunnel?connect", nil)
+	connect.RemoteAddr = "198.51.100.7:5000"
+	recorder := httptest.NewRecorder()
+	servlet.ServeHTTP(recorder, connect)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != tunnel.GetUUID().String() {
+		t.Fatalf("connect: code %v, body %q", recorder.Code, recorder.Body.String())
+	}
+
+	// Requests from another client, claiming to be forwarded on behalf of
+	// the owner
+	query := "status:" + tunnel.GetUUID().String()
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	cases := map[string]struct {
+		signature string
+		code      int
+	}{
+		"unsigned":     {"", http.StatusForbidden},
+		"wrong secret": {now + "." + signForwarded([]byte("guess"), "node-2", "198.51.100.7", query, nil, nil, time.Now().Unix()), http.StatusForbidden},
+		"malformed":    {"0." + signForwarded(secret, "node-2", "198.51.100.7", query, nil, nil, 0), http.StatusForbidden},
+		"signed":       {now + "." + signForwarded(secret, "node-2", "198.51.100.7", query, nil, nil, time.Now().Unix()), http.StatusOK},
+	}
+	for name, one := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/tunnel?"+query, nil)
+		request.RemoteAddr = "203.0.113.9:6000"
+		request.Header.Set(FORWARDED_HEADER, "node-2")
+		request.Header.Set(FORWARDED_FOR_HEADER, "198.51.100.7")
+		if len(one.signature) > 0 {
+			request.Header.Set(FORWARDED_SIGNATURE_HEADER, one.signature)
+		}
+		recorder := httptest.NewRecorder()
+		servlet.ServeHTTP(recorder, request)
+		if recorder.Code != one.code {
+			t.Errorf("%v: code %v, expected %v", name, recorder.Code, one.code)
+		}
+	}
+}
+
+func Test_AuthenticateForwardedStripsHeaders(t *testing.T) {
+	request := NewHTTPRequestAdapter(httptest.NewRequest(http.MethodGet, "/tunnel?read:"+testUUID+":0", nil))
+	request.GetRequest().RemoteAddr = "203.0.113.9:6000"
+	request.GetRequest().Header.Set(FORWARDED_HEADER, "node-2")
+	request.GetRequest().Header.Set(FORWARDED_FOR_HEADER, "198.51.100.7")
+
+	spoofed := authenticateForwarded(request, []byte("cluster secret"))
+	if isForwarded(spoofed) || remoteAddress(spoofed) != "203.0.113.9" {
+		t.Errorf("spoofed request trusted as forwarded for %v", remoteAddress(spoofed))
+	}
+	headers := spoofed.(HTTPServletRequestHeaderInterface)
+	if len(headers.GetHeader(FORWARDED_HEADER)) > 0 || len(headers.GetHeader(FORWARDED_FOR_HEADER)) > 0 {
+		t.Errorf("forwarding headers of spoofed request not stripped")
+	}
+
+	// Standalone servlets have no secret and trust no request
+	if isForwarded(authenticateForwarded(request, nil)) {
+		t.Errorf("request trusted without secret")
+	}
+}
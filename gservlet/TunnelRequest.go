@@ -0,0 +1,176 @@
+package gservlet
+
+import (
+	"strconv"
+	"strings"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+const (
+	/*CONNECT_OPERATION *
+	 * The query string of a connect request.
+	 */
+	CONNECT_OPERATION = "connect"
+
+	/*READ_OPERATION *
+	 * The operation of a read request, "read:UUID[:COUNTER]".
+	 */
+	READ_OPERATION = "read"
+
+	/*WRITE_OPERATION *
+	 * The operation of a write request, "write:UUID[:COUNTER]".
+	 */
+	WRITE_OPERATION = "write"
+
+	/*RESUME_OPERATION *
+	 * The operation of a resume request, "resume:UUID:TIMESTAMP[:COUNTER]".
+	 */
+	RESUME_OPERATION = "resume"
+
+	/*STATUS_OPERATION *
+	 * The operation of a status request, "status:UUID", answered with the
+	 * TunnelStatus of the tunnel.
+	 */
+	STATUS_OPERATION = "status"
+
+	/*CLOSE_OPERATION *
+	 * The operation of a close request, "close:UUID", closing the tunnel.
+	 */
+	CLOSE_OPERATION = "close"
+
+	/*MAX_QUERY_LENGTH *
+	 * The maximum length of the query string of a tunnel request, in
+	 * characters.
+	 */
+	MAX_QUERY_LENGTH = 1024
+)
+
+// TunnelRequest *
+//  * A tunnel request parsed from its query string, of the form
+//  * "OPERATION:UUID[:ARGUMENT...]", or "connect".
+type TunnelRequest struct {
+	/**
+	 * The operation requested, such as READ_OPERATION.
+	 */
+	Operation string
+
+	/**
+	 * The UUID of the tunnel, or "" for connect requests.
+	 */
+	TunnelUUID string
+
+	/**
+	 * The request counter appended by the JavaScript client to read, write
+	 * and resume requests to defeat caching, or -1 if absent.
+	 */
+	Counter int64
+
+	/**
+	 * The timestamp of the last "sync" received by the client, for resume
+	 * requests.
+	 */
+	Timestamp int64
+
+	/**
+	 * The arguments following the UUID of any other operation.
+	 */
+	Arguments []string
+}
+
+/*ParseTunnelRequest *
+ * Parses the query string of a tunnel request, validating the UUID of the
+ * tunnel and the arguments of the built-in operations. Operations other
+ * than the built-in ones are accepted with any arguments.
+ *
+ * @param query The query string of the request.
+ * @return The parsed request.
+ * @throws GuacamoleClientException If the query string is malformed.
+ */
+func ParseTunnelRequest(query string) (ret TunnelRequest, err exp.ExceptionInterface) {
+	ret.Counter = -1
+	if len(query) == 0 {
+		return ret, exp.GuacamoleClientException.Throw("No query string provided.")
+	}
+	if len(query) > MAX_QUERY_LENGTH {
+		return ret, exp.GuacamoleClientException.Throw("Query string too long.")
+	}
+
+	parts := strings.Split(query, ":")
+	ret.Operation = parts[0]
+	if ret.Operation == CONNECT_OPERATION {
+		if len(parts) > 1 {
+			return ret, exp.GuacamoleClientException.Throw("Invalid connect operation: " + query)
+		}
+		return
+	}
+	if len(ret.Operation) == 0 || len(parts) < 2 {
+		return ret, exp.GuacamoleClientException.Throw("Invalid tunnel operation: " + query)
+	}
+	if !IsValidTunnelUUID(parts[1]) {
+		return ret, exp.GuacamoleClientException.Throw("Invalid tunnel UUID: " + parts[1])
+	}
+	ret.TunnelUUID = parts[1]
+	arguments := parts[2:]
+
+	switch ret.Operation {
+	case READ_OPERATION, WRITE_OPERATION:
+		if len(arguments) > 1 {
+			return ret, exp.GuacamoleClientException.Throw("Invalid " + ret.Operation + " operation: " + query)
+		}
+		if len(arguments) == 1 {
+			ret.Counter, err = parseCounter(arguments[0])
+		}
+	case RESUME_OPERATION:
+		if len(arguments) < 1 || len(arguments) > 2 {
+			return ret, exp.GuacamoleClientException.Throw("Invalid resume operation: " + query)
+		}
+		timestamp, e := strconv.ParseInt(arguments[0], 10, 64)
+		if e != nil || timestamp < -1 {
+			return ret, exp.GuacamoleClientException.Throw("Invalid resume timestamp: " + arguments[0])
+		}
+		ret.Timestamp = timestamp
+		if len(arguments) == 2 {
+			ret.Counter, err = parseCounter(arguments[1])
+		}
+	default:
+		ret.Arguments = arguments
+	}
+	return
+}
+
+/**
+ * Parses the request counter of a read, write or resume request.
+ */
+func parseCounter(value string) (int64, exp.ExceptionInterface) {
+	counter, e := strconv.ParseInt(value, 10, 64)
+	if e != nil || counter < 0 {
+		return -1, exp.GuacamoleClientException.Throw("Invalid request counter: " + value)
+	}
+	return counter, nil
+}
+
+/*IsValidTunnelUUID *
+ * Returns whether the given string is a UUID in its canonical textual
+ * form, as generated for every tunnel.
+ *
+ * @param value The string to test.
+ */
+func IsValidTunnelUUID(value string) bool {
+	if len(value) != UUID_LENGTH {
+		return false
+	}
+	for i, c := range value {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package gservlet
+
+import (
+	"testing"
+
+	exp "github.com/hsfish/guacamole_client_go"
+)
+
+const testUUID = "0f8fad5b-d9cb-469f-a165-70867728950e"
+
+func Test_ParseTunnelRequest(t *testing.T) {
+	cases := map[string]TunnelRequest{
+		"connect":                      {Operation: "connect", Counter: -1},
+		"read:" + testUUID:             {Operation: "read", TunnelUUID: testUUID, Counter: -1},
+		"read:" + testUUID + ":7":      {Operation: "read", TunnelUUID: testUUID, Counter: 7},
+		"write:" + testUUID + ":0":     {Operation: "write", TunnelUUID: testUUID, Counter: 0},
+		"resume:" + testUUID + ":-1":   {Operation: "resume", TunnelUUID: testUUID, Counter: -1, Timestamp: -1},
+		"resume:" + testUUID + ":42:3": {Operation: "resume", TunnelUUID: testUUID, Counter: 3, Timestamp: 42},
+		"status:" + testUUID:           {Operation: "status", TunnelUUID: testUUID, Counter: -1},
+	}
+	for query, expected := range cases {
+		parsed, err := ParseTunnelRequest(query)
+		if err != nil {
+			t.Errorf("ParseTunnelRequest(%q) failed: %v", query, err)
+			continue
+		}
+		if parsed.Operation != expected.Operation || parsed.TunnelUUID != expected.TunnelUUID ||
+			parsed.Counter != expected.Counter || parsed.Timestamp != expected.Timestamp {
+			t.Errorf("ParseTunnelRequest(%q) = %+v, expected %+v", query, parsed, expected)
+		}
+	}
+
+	parsed, _ := ParseTunnelRequest("custom:" + testUUID + ":a:b")
+	if len(parsed.Arguments) != 2 || parsed.Arguments[0] != "a" || parsed.Arguments[1] != "b" {
+		t.Errorf("custom arguments = %v", parsed.Arguments)
+	}
+}
+
+func Test_ParseTunnelRequestMalformed(t *testing.T) {
+	queries := []string{
+		"",
+		"read:",
+		"read:short",
+		"read:" + testUUID[:35],
+		"read:" + testUUID + "x",
+		"read:" + testUUID + ":-2",
+		"read:" + testUUID + ":1:2",
+		"write:0f8fad5b_d9cb_469f_a165_70867728950e",
+		"resume:" + testUUID,
+		"resume:" + testUUID + ":x",
+		"resume:" + testUUID + ":-5",
+		"connect:" + testUUID,
+		":" + testUUID,
+		"unknown",
+	}
+	for _, query := range queries {
+		_, err := ParseTunnelRequest(query)
+		if err == nil {
+			t.Errorf("ParseTunnelRequest(%q) succeeded", query)
+		} else if err.GetStatus() != exp.CLIENT_BAD_REQUEST {
+			t.Errorf("ParseTunnelRequest(%q) status = %v", query, err.GetStatus())
+		}
+	}
+}
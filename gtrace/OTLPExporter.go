@@ -0,0 +1,201 @@
+package gtrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// INSTRUMENTATION_SCOPE The name of the instrumentation scope of all spans.
+const INSTRUMENTATION_SCOPE = "github.com/hsfish/guacamole_client_go"
+
+// OTLP span status codes
+const (
+	otlpStatusOk    = 1
+	otlpStatusError = 2
+
+	otlpSpanKindInternal = 1
+)
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTracesData struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttribute(key string, value interface{}) (ret otlpKeyValue) {
+	ret.Key = key
+	switch v := value.(type) {
+	case string:
+		ret.Value.StringValue = &v
+	case bool:
+		ret.Value.BoolValue = &v
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		ret.Value.IntValue = &s
+	case int32:
+		s := strconv.FormatInt(int64(v), 10)
+		ret.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		ret.Value.IntValue = &s
+	case float32:
+		f := float64(v)
+		ret.Value.DoubleValue = &f
+	case float64:
+		ret.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		ret.Value.StringValue = &s
+	}
+	return
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+/*EncodeOTLPJSON *
+ * Encodes the given spans as an OTLP/JSON ExportTraceServiceRequest.
+ *
+ * @param serviceName The value of the "service.name" resource attribute.
+ * @param spans The spans to encode.
+ */
+func EncodeOTLPJSON(serviceName string, spans []SpanData) ([]byte, error) {
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	scope.Scope.Name = INSTRUMENTATION_SCOPE
+
+	for _, data := range spans {
+		one := otlpSpan{
+			TraceID:           data.Context.TraceID.String(),
+			SpanID:            data.Context.SpanID.String(),
+			Name:              data.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: otlpTime(data.StartTime),
+			EndTimeUnixNano:   otlpTime(data.EndTime),
+			Status:            otlpStatus{Code: otlpStatusOk},
+		}
+		if data.ParentSpanID.IsValid() {
+			one.ParentSpanID = data.ParentSpanID.String()
+		}
+		if data.Failed {
+			one.Status = otlpStatus{Code: otlpStatusError, Message: data.Message}
+		}
+
+		keys := make([]string, 0, len(data.Attributes))
+		for key := range data.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			one.Attributes = append(one.Attributes, otlpAttribute(key, data.Attributes[key]))
+		}
+		scope.Spans = append(scope.Spans, one)
+	}
+
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = []otlpKeyValue{otlpAttribute("service.name", serviceName)}
+	return json.Marshal(otlpTracesData{ResourceSpans: []otlpResourceSpans{resource}})
+}
+
+/*NewOTLPFileExporter *
+ * Returns an exporter which appends each batch of spans to the given file
+ * as one line of OTLP/JSON, the format read by the OpenTelemetry
+ * Collector's "otlpjsonfile" receiver.
+ *
+ * @param serviceName The value of the "service.name" resource attribute.
+ * @param path The file to append to, created if necessary.
+ */
+func NewOTLPFileExporter(serviceName, path string) SpanExporterInterface {
+	var lock sync.Mutex
+	return func(spans []SpanData) error {
+		data, e := EncodeOTLPJSON(serviceName, spans)
+		if e != nil {
+			return e
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		file, e := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if e != nil {
+			return e
+		}
+		_, e = file.Write(append(data, '\n'))
+		if ce := file.Close(); e == nil {
+			e = ce
+		}
+		return e
+	}
+}
+
+/*NewOTLPHTTPExporter *
+ * Returns an exporter which posts each batch of spans as OTLP/JSON to the
+ * given collector endpoint, such as "http://localhost:4318/v1/traces".
+ *
+ * @param serviceName The value of the "service.name" resource attribute.
+ * @param endpoint The URL of the collector's OTLP/HTTP traces endpoint.
+ */
+func NewOTLPHTTPExporter(serviceName, endpoint string) SpanExporterInterface {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(spans []SpanData) error {
+		data, e := EncodeOTLPJSON(serviceName, spans)
+		if e != nil {
+			return e
+		}
+		response, e := client.Post(endpoint, "application/json", bytes.NewReader(data))
+		if e != nil {
+			return e
+		}
+		response.Body.Close()
+		if response.StatusCode/100 != 2 {
+			return fmt.Errorf("collector returned HTTP %v", response.StatusCode)
+		}
+		return nil
+	}
+}
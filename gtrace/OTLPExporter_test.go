@@ -0,0 +1,200 @@
+package gtrace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/**
+ * Ends spans through a tracer whose batches are collected, returning the
+ * spans once the tracer is closed.
+ */
+func recordTestSpans(t *testing.T) []SpanData {
+	t.Helper()
+	batches := make(chan []SpanData, 8)
+	tracer := NewRecordingTracer2(func(spans []SpanData) error {
+		batches <- spans
+		return nil
+	}, time.Hour)
+	tracer.BatchSize = 2
+
+	remote, _ := ParseTraceparent(testTraceparent)
+	ctx, parent := tracer.Start(ContextWithRemoteParent(context.Background(), remote), "tunnel")
+	parent.SetAttribute("tunnel.protocol", "rdp")
+	parent.SetAttribute("tunnel.width", 1024)
+	parent.SetAttribute("tunnel.ratio", 1.5)
+	parent.SetAttribute("tunnel.shared", true)
+	parent.SetAttribute("tunnel.duration", time.Second)
+	_, child := tracer.Start(ctx, "connect")
+	child.SetError("Upstream not found.")
+	child.End()
+	parent.End()
+
+	// A full batch is exported without waiting for the interval
+	var spans []SpanData
+	select {
+	case spans = <-batches:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not exported")
+	}
+
+	// Spans are only exported once, and changes after End are ignored
+	parent.End()
+	parent.SetAttribute("late", true)
+	child.SetError("late")
+	_, last := tracer.Start(nil, "last")
+	last.End()
+	tracer.Close()
+	tracer.Close()
+	select {
+	case batch := <-batches:
+		if len(batch) != 1 || batch[0].Name != "last" {
+			t.Errorf("last batch %+v", batch)
+		}
+	default:
+		t.Errorf("remaining spans not exported on close")
+	}
+	if len(spans) != 2 || spans[0].Name != "connect" || spans[1].Name != "tunnel" {
+		t.Fatalf("spans %+v", spans)
+	}
+	if _, ok := spans[1].Attributes["late"]; ok || spans[0].Message != "Upstream not found." {
+		t.Errorf("spans changed after End")
+	}
+	return spans
+}
+
+/**
+ * Checks that the given OTLP/JSON request holds the spans recorded by
+ * recordTestSpans.
+ */
+func checkOTLPJSON(t *testing.T, data []byte, spans []SpanData) {
+	t.Helper()
+	var request otlpTracesData
+	if e := json.Unmarshal(data, &request); e != nil {
+		t.Fatal(e)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("request %s", data)
+	}
+	resource := request.ResourceSpans[0]
+	if attributes := resource.Resource.Attributes; len(attributes) != 1 || attributes[0].Key != "service.name" ||
+		*attributes[0].Value.StringValue != "test-service" {
+		t.Errorf("resource %s", data)
+	}
+	scope := resource.ScopeSpans[0]
+	if scope.Scope.Name != INSTRUMENTATION_SCOPE || len(scope.Spans) != 2 {
+		t.Fatalf("scope %s", data)
+	}
+
+	child, parent := scope.Spans[0], scope.Spans[1]
+	if parent.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || parent.ParentSpanID != "00f067aa0ba902b7" ||
+		child.TraceID != parent.TraceID || child.ParentSpanID != parent.SpanID || parent.SpanID != spans[1].Context.SpanID.String() {
+		t.Errorf("identifiers %s", data)
+	}
+	if child.Status.Code != otlpStatusError || child.Status.Message != "Upstream not found." ||
+		parent.Status.Code != otlpStatusOk || parent.Kind != otlpSpanKindInternal {
+		t.Errorf("status %s", data)
+	}
+	if parent.StartTimeUnixNano != strconv.FormatInt(spans[1].StartTime.UnixNano(), 10) ||
+		parent.EndTimeUnixNano != strconv.FormatInt(spans[1].EndTime.UnixNano(), 10) {
+		t.Errorf("times %s", data)
+	}
+
+	// Attributes are sorted by key, and typed by value
+	attributes := parent.Attributes
+	if len(attributes) != 5 {
+		t.Fatalf("attributes %s", data)
+	}
+	keys := []string{"tunnel.duration", "tunnel.protocol", "tunnel.ratio", "tunnel.shared", "tunnel.width"}
+	for i, key := range keys {
+		if attributes[i].Key != key {
+			t.Errorf("attribute %v is %q, expected %q", i, attributes[i].Key, key)
+		}
+	}
+	if value := attributes[0].Value.StringValue; value == nil || *value != "1s" {
+		t.Errorf("duration %s", data)
+	}
+	if value := attributes[1].Value.StringValue; value == nil || *value != "rdp" {
+		t.Errorf("protocol %s", data)
+	}
+	if value := attributes[2].Value.DoubleValue; value == nil || *value != 1.5 {
+		t.Errorf("ratio %s", data)
+	}
+	if value := attributes[3].Value.BoolValue; value == nil || !*value {
+		t.Errorf("shared %s", data)
+	}
+	if value := attributes[4].Value.IntValue; value == nil || *value != "1024" {
+		t.Errorf("width %s", data)
+	}
+}
+
+func Test_OTLPHTTPExporter(t *testing.T) {
+	spans := recordTestSpans(t)
+	requests := make(chan []byte, 2)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost || request.URL.Path != "/v1/traces" ||
+			request.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %v %v", request.Method, request.URL)
+		}
+		data, _ := ioutil.ReadAll(request.Body)
+		requests <- data
+		response.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPHTTPExporter("test-service", server.URL+"/v1/traces")
+	if e := exporter(spans); e != nil {
+		t.Fatal(e)
+	}
+	checkOTLPJSON(t, <-requests, spans)
+
+	// Collectors refusing the spans are reported
+	status = http.StatusServiceUnavailable
+	if e := exporter(spans); e == nil {
+		t.Errorf("refusal not reported")
+	}
+}
+
+func Test_OTLPFileExporter(t *testing.T) {
+	spans := recordTestSpans(t)
+	dir, e := ioutil.TempDir("", "gtrace")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.jsonl")
+
+	// Each batch is appended as one line
+	exporter := NewOTLPFileExporter("test-service", path)
+	for i := 0; i < 2; i++ {
+		if e = exporter(spans); e != nil {
+			t.Fatal(e)
+		}
+	}
+	file, e := os.Open(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer file.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+		checkOTLPJSON(t, scanner.Bytes(), spans)
+	}
+	if lines != 2 {
+		t.Errorf("%v lines written", lines)
+	}
+
+	if e = NewOTLPFileExporter("test-service", filepath.Join(dir, "missing", "traces.jsonl"))(spans); e == nil {
+		t.Errorf("unwritable file not reported")
+	}
+}
@@ -0,0 +1,197 @@
+package gtrace
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// SpanData *
+//  * A finished span, as handed to a SpanExporterInterface.
+type SpanData struct {
+	Name         string
+	Context      SpanContext
+	ParentSpanID SpanID
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]interface{}
+	Failed       bool
+	Message      string
+}
+
+// SpanExporterInterface Tool interface for RecordingTracer
+//  * Sends a batch of finished spans to their destination.
+type SpanExporterInterface func(spans []SpanData) error
+
+/**
+ * Span which records its data and hands it to its tracer once ended.
+ */
+type recordingSpan struct {
+	tracer *RecordingTracer
+	data   SpanData
+	ended  bool
+	lock   sync.Mutex
+}
+
+func (opt *recordingSpan) SpanContext() SpanContext {
+	return opt.data.Context
+}
+
+func (opt *recordingSpan) SetAttribute(key string, value interface{}) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if !opt.ended {
+		opt.data.Attributes[key] = value
+	}
+}
+
+func (opt *recordingSpan) SetError(message string) {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	if !opt.ended {
+		opt.data.Failed = true
+		opt.data.Message = message
+	}
+}
+
+func (opt *recordingSpan) End() {
+	opt.lock.Lock()
+	if opt.ended {
+		opt.lock.Unlock()
+		return
+	}
+	opt.ended = true
+	opt.data.EndTime = time.Now()
+	data := opt.data
+	opt.lock.Unlock()
+	opt.tracer.finish(data)
+}
+
+// RecordingTracer ==> Tracer
+//  * Tracer which records every span and exports finished spans in batches,
+//  * either once BatchSize spans are waiting or at the flush interval.
+type RecordingTracer struct {
+	exporter SpanExporterInterface
+
+	/**
+	 * The number of finished spans which triggers an export.
+	 */
+	BatchSize int
+
+	pending []SpanData
+	lock    sync.Mutex
+	flush   chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	closed  sync.Once
+}
+
+// DEFAULT_FLUSH_INTERVAL The default time between exports of finished spans.
+const DEFAULT_FLUSH_INTERVAL = 5 * time.Second
+
+/*NewRecordingTracer *
+ * Creates a RecordingTracer which exports finished spans through the given
+ * exporter every DEFAULT_FLUSH_INTERVAL.
+ */
+func NewRecordingTracer(exporter SpanExporterInterface) *RecordingTracer {
+	return NewRecordingTracer2(exporter, DEFAULT_FLUSH_INTERVAL)
+}
+
+/*NewRecordingTracer2 *
+ * Creates a RecordingTracer which exports finished spans through the given
+ * exporter at the given interval.
+ *
+ * @param exporter Receives every batch of finished spans.
+ * @param interval The maximum time a finished span waits before export.
+ */
+func NewRecordingTracer2(exporter SpanExporterInterface, interval time.Duration) (ret *RecordingTracer) {
+	ret = &RecordingTracer{
+		exporter:  exporter,
+		BatchSize: 512,
+		pending:   make([]SpanData, 0, 64),
+		flush:     make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go ret.run(interval)
+	return
+}
+
+// Start override Tracer.Start
+func (opt *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	parent := parentFromContext(ctx)
+
+	span := &recordingSpan{tracer: opt}
+	span.data.Name = name
+	span.data.StartTime = time.Now()
+	span.data.Attributes = make(map[string]interface{})
+	span.data.Context.SpanID = newSpanID()
+	span.data.Context.Sampled = true
+	if parent.IsValid() {
+		span.data.Context.TraceID = parent.TraceID
+		span.data.ParentSpanID = parent.SpanID
+	} else {
+		span.data.Context.TraceID = newTraceID()
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+func (opt *RecordingTracer) finish(data SpanData) {
+	opt.lock.Lock()
+	opt.pending = append(opt.pending, data)
+	full := len(opt.pending) >= opt.BatchSize
+	opt.lock.Unlock()
+	if full {
+		select {
+		case opt.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (opt *RecordingTracer) run(interval time.Duration) {
+	defer close(opt.done)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-opt.flush:
+		case <-opt.stop:
+			opt.Flush()
+			return
+		}
+		opt.Flush()
+	}
+}
+
+/*Flush *
+ * Exports every finished span which has not yet been exported.
+ */
+func (opt *RecordingTracer) Flush() {
+	opt.lock.Lock()
+	spans := opt.pending
+	opt.pending = make([]SpanData, 0, 64)
+	opt.lock.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+	if e := opt.exporter(spans); e != nil {
+		logger.Warnf("Unable to export %v spans: %v", len(spans), e)
+	}
+}
+
+/*Close *
+ * Stops periodic export after exporting any remaining spans. Spans ended
+ * after Close are exported only by explicit calls to Flush.
+ */
+func (opt *RecordingTracer) Close() {
+	opt.closed.Do(func() { close(opt.stop) })
+	<-opt.done
+}
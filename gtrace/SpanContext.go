@@ -0,0 +1,174 @@
+package gtrace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// TRACEPARENT_HEADER The W3C Trace Context header carrying the parent span.
+const TRACEPARENT_HEADER = "traceparent"
+
+// TraceID The 16-byte identifier shared by every span of a trace.
+type TraceID [16]byte
+
+// SpanID The 8-byte identifier of a single span.
+type SpanID [8]byte
+
+// IsValid Returns whether the ID is not all zeroes.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid Returns whether the ID is not all zeroes.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext *
+//  * The identity of a span, as propagated between processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+
+	/**
+	 * Whether this context was received from another process rather than
+	 * created by a local span.
+	 */
+	Remote bool
+}
+
+// IsValid Returns whether both the trace and span IDs are set.
+func (opt SpanContext) IsValid() bool {
+	return opt.TraceID.IsValid() && opt.SpanID.IsValid()
+}
+
+/*ParseTraceparent *
+ * Parses the value of a W3C "traceparent" header, such as
+ * "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+ *
+ * @param value The header value.
+ * @return The remote span context, and whether the value was valid.
+ */
+func ParseTraceparent(value string) (ret SpanContext, ok bool) {
+	// Fields are lowercase hex only
+	value = strings.TrimSpace(value)
+	if strings.ToLower(value) != value {
+		return
+	}
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return
+	}
+	// Version 00 has exactly four fields; later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return
+	}
+	version, e := hex.DecodeString(parts[0])
+	if e != nil || len(version) != 1 {
+		return
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+	if _, e = hex.Decode(ret.TraceID[:], []byte(parts[1])); e != nil {
+		return
+	}
+	if _, e = hex.Decode(ret.SpanID[:], []byte(parts[2])); e != nil {
+		return
+	}
+	flags, e := hex.DecodeString(parts[3])
+	if e != nil {
+		return
+	}
+	ret.Sampled = flags[0]&0x01 != 0
+	ret.Remote = true
+	ok = ret.IsValid()
+	return
+}
+
+/*FormatTraceparent *
+ * Returns the W3C "traceparent" header value of the given span context.
+ */
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+func newTraceID() (ret TraceID) {
+	rand.Read(ret[:])
+	return
+}
+
+func newSpanID() (ret SpanID) {
+	rand.Read(ret[:])
+	return
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+/*ContextWithSpan *
+ * Returns a copy of the given context carrying the given span, which becomes
+ * the parent of spans started from the returned context.
+ */
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+/*SpanFromContext *
+ * Returns the span carried by the given context, or a no-op span if there
+ * is none.
+ */
+func SpanFromContext(ctx context.Context) Span {
+	if ctx != nil {
+		if span, ok := ctx.Value(spanKey{}).(Span); ok {
+			return span
+		}
+	}
+	return noopSpan{}
+}
+
+/*ContextWithRemoteParent *
+ * Returns a copy of the given context whose next span will be a child of
+ * the given remote span context, typically parsed from an incoming
+ * "traceparent" header.
+ */
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+/**
+ * Returns the span context which new spans started from the given context
+ * should use as their parent.
+ */
+func parentFromContext(ctx context.Context) (ret SpanContext) {
+	if ctx == nil {
+		return
+	}
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		if sc := span.SpanContext(); sc.IsValid() {
+			return sc
+		}
+	}
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		return sc
+	}
+	return
+}
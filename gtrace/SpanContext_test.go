@@ -0,0 +1,99 @@
+package gtrace
+
+import (
+	"context"
+	"testing"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func Test_ParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent(" " + testTraceparent + " ")
+	if !ok || !sc.Sampled || !sc.Remote {
+		t.Fatalf("parsed %+v, %v", sc, ok)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("parsed %v, %v", sc.TraceID, sc.SpanID)
+	}
+	if formatted := FormatTraceparent(sc); formatted != testTraceparent {
+		t.Errorf("formatted %q", formatted)
+	}
+	sc.Sampled = false
+	if formatted := FormatTraceparent(sc); formatted != testTraceparent[:len(testTraceparent)-2]+"00" {
+		t.Errorf("formatted %q", formatted)
+	}
+
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"0-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+	}
+	for _, test := range tests {
+		if _, ok := ParseTraceparent(test.value); ok != test.ok {
+			t.Errorf("%q parsed %v, expected %v", test.value, ok, test.ok)
+		}
+	}
+}
+
+func Test_TracePropagation(t *testing.T) {
+	remote, _ := ParseTraceparent(testTraceparent)
+	tracer := NewRecordingTracer(func(spans []SpanData) error { return nil })
+	defer tracer.Close()
+
+	// Spans continue the remote trace, then their own
+	ctx, parent := tracer.Start(ContextWithRemoteParent(context.Background(), remote), "parent")
+	_, child := tracer.Start(ctx, "child")
+	parentData := parent.(*recordingSpan).data
+	childData := child.(*recordingSpan).data
+	if parentData.Context.TraceID != remote.TraceID || parentData.ParentSpanID != remote.SpanID {
+		t.Errorf("parent %v/%v of remote %v/%v", parentData.Context.TraceID, parentData.ParentSpanID,
+			remote.TraceID, remote.SpanID)
+	}
+	if childData.Context.TraceID != remote.TraceID || childData.ParentSpanID != parent.SpanContext().SpanID {
+		t.Errorf("child %v/%v of parent %v", childData.Context.TraceID, childData.ParentSpanID, parent.SpanContext())
+	}
+	if SpanFromContext(ctx) != parent || parent.SpanContext().Remote {
+		t.Errorf("context carries %v", SpanFromContext(ctx))
+	}
+
+	// Without a parent, a new trace begins
+	_, root := tracer.Start(nil, "root")
+	if rootData := root.(*recordingSpan).data; rootData.ParentSpanID.IsValid() || !rootData.Context.IsValid() ||
+		rootData.Context.TraceID == remote.TraceID {
+		t.Errorf("root %+v", rootData.Context)
+	}
+	if ContextWithRemoteParent(context.Background(), SpanContext{}) != context.Background() {
+		t.Errorf("invalid remote parent carried")
+	}
+
+	// Untraced processes still pass the remote parent on
+	SetTracer(nil)
+	ctx, span := Start(ContextWithRemoteParent(context.Background(), remote), "untraced")
+	if span.SpanContext() != remote || SpanFromContext(ctx).SpanContext() != remote {
+		t.Errorf("no-op span %+v", span.SpanContext())
+	}
+	if sc := SpanFromContext(nil).SpanContext(); sc.IsValid() {
+		t.Errorf("span without context %+v", sc)
+	}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+	if GetTracer() != tracer {
+		t.Errorf("tracer not set")
+	}
+}
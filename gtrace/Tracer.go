@@ -0,0 +1,94 @@
+package gtrace
+
+import (
+	"context"
+	"sync"
+)
+
+// Span *
+//  * A timed operation within a trace. Spans must be ended exactly once;
+//  * further calls to End are ignored.
+type Span interface {
+	// Returns the identity of this span.
+	SpanContext() SpanContext
+
+	// Sets an attribute of this span. Values should be strings, bools,
+	// integers or floats.
+	SetAttribute(key string, value interface{})
+
+	// Marks this span as failed with the given message.
+	SetError(message string)
+
+	// Ends this span, recording its end time.
+	End()
+}
+
+// Tracer *
+//  * Creates spans. The span started is the child of the span, or the remote
+//  * parent, carried by the given context, and the returned context carries
+//  * the new span.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+/**
+ * Span which records nothing.
+ */
+type noopSpan struct {
+	sc SpanContext
+}
+
+func (opt noopSpan) SpanContext() SpanContext            { return opt.sc }
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) SetError(message string)                    {}
+func (noopSpan) End()                                       {}
+
+// NoopTracer ==> Tracer
+//  * Tracer which records nothing. Remote parents are still carried through,
+//  * so that trace IDs survive across an untraced process.
+type NoopTracer struct{}
+
+// Start override Tracer.Start
+func (NoopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span := noopSpan{sc: parentFromContext(ctx)}
+	return ContextWithSpan(ctx, span), span
+}
+
+var (
+	globalTracer     Tracer = NoopTracer{}
+	globalTracerLock sync.RWMutex
+)
+
+/*SetTracer *
+ * Sets the tracer used by this library. Passing nil restores the default
+ * NoopTracer.
+ */
+func SetTracer(tracer Tracer) {
+	if tracer == nil {
+		tracer = NoopTracer{}
+	}
+	globalTracerLock.Lock()
+	globalTracer = tracer
+	globalTracerLock.Unlock()
+}
+
+// GetTracer Returns the tracer used by this library.
+func GetTracer() Tracer {
+	globalTracerLock.RLock()
+	defer globalTracerLock.RUnlock()
+	return globalTracer
+}
+
+/*Start *
+ * Starts a span using the tracer set with SetTracer.
+ *
+ * @param ctx The context carrying the parent span, or nil.
+ * @param name The name of the span.
+ * @return The context carrying the new span, and the span itself.
+ */
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	return GetTracer().Start(ctx, name)
+}
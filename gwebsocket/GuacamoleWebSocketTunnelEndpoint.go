@@ -0,0 +1,620 @@
+package gwebsocket
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gevent"
+	"github.com/hsfish/guacamole_client_go/gmetrics"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	"github.com/hsfish/guacamole_client_go/gservlet"
+	"github.com/hsfish/guacamole_client_go/gtrace"
+	logger "github.com/sirupsen/logrus"
+)
+
+const (
+	/*GUACAMOLE_PROTOCOL *
+	 * The WebSocket subprotocol spoken by the JavaScript Guacamole client.
+	 */
+	GUACAMOLE_PROTOCOL = "guacamole"
+
+	/*BUFFER_SIZE *
+	 * The maximum number of bytes of instructions sent to the client within
+	 * one WebSocket message.
+	 */
+	BUFFER_SIZE = 8192
+
+	/*PING_OPCODE *
+	 * The argument of the internal instruction with which the client
+	 * measures the round trip time. It is echoed back unchanged.
+	 */
+	PING_OPCODE = "ping"
+
+	/*INTERNAL_DATA_OPCODE *
+	 * The opcode of instructions used internally by the tunnel itself,
+	 * which are not forwarded to guacd.
+	 */
+	INTERNAL_DATA_OPCODE = ""
+)
+
+// GuacamoleWebSocketTunnelEndpoint ==> GuacamoleWebSocketTunnelEndpoint*
+//  * An http.Handler implementing the WebSocket tunnel of the JavaScript
+//  * Guacamole client. The data given to Guacamole.WebSocketTunnel.connect()
+//  * arrives as the query string of the upgrade request.
+type GuacamoleWebSocketTunnelEndpoint struct {
+	/**
+	 * Creates the tunnel of each new connection, as the doConnect of
+	 * GuacamoleHTTPTunnelServlet does.
+	 */
+	doConnect gservlet.DoConnectContextInterface
+
+	/**
+	 * The bus receiving every lifecycle event of the tunnels of this
+	 * endpoint.
+	 */
+	events *gevent.EventBus
+
+	upgrader websocket.Upgrader
+
+	/**
+	 * Counts the tunnels connected against their quotas, or nil if the
+	 * number of tunnels is unlimited, and the subscription through which
+	 * it releases them.
+	 */
+	quotas            *gservlet.QuotaManager
+	quotaSubscription int
+
+	/**
+	 * Limits the input written by clients, or nil if input is unlimited,
+	 * and resolves the user whose limits apply to each connection, or nil.
+	 */
+	inputLimiter *gservlet.InputLimiter
+	inputOwners  gservlet.TunnelOwnerResolverInterface
+
+	/**
+	 * The active sessions, by tunnel UUID, and whether new connections are
+	 * refused because the endpoint is draining or destroyed.
+	 */
+	sessions map[string]*webSocketSession
+	closing  bool
+	lock     sync.Mutex
+}
+
+/*NewGuacamoleWebSocketTunnelEndpoint *
+ * Creates a new endpoint establishing tunnels with the given function and
+ * publishing their events on the given bus.
+ *
+ * @param doConnect
+ *     Creates the tunnel of each new connection.
+ *
+ * @param events
+ *     The bus receiving tunnel events, or nil to create one.
+ */
+func NewGuacamoleWebSocketTunnelEndpoint(doConnect gservlet.DoConnectContextInterface,
+	events *gevent.EventBus) (ret *GuacamoleWebSocketTunnelEndpoint) {
+	if events == nil {
+		events = gevent.NewEventBus()
+	}
+	ret = &GuacamoleWebSocketTunnelEndpoint{
+		doConnect: doConnect,
+		events:    events,
+		sessions:  make(map[string]*webSocketSession),
+	}
+	ret.upgrader.Subprotocols = []string{GUACAMOLE_PROTOCOL}
+	ret.upgrader.ReadBufferSize = BUFFER_SIZE
+	ret.upgrader.WriteBufferSize = BUFFER_SIZE
+	return
+}
+
+/*GetEventBus *
+ * Returns the bus receiving every lifecycle event of the tunnels of this
+ * endpoint.
+ */
+func (opt *GuacamoleWebSocketTunnelEndpoint) GetEventBus() *gevent.EventBus {
+	return opt.events
+}
+
+/*SetCheckOrigin *
+ * Sets the function deciding whether upgrade requests from the origin of
+ * the given request are allowed. By default, only requests whose Origin
+ * matches their Host are. Must be called before any request is handled.
+ */
+func (opt *GuacamoleWebSocketTunnelEndpoint) SetCheckOrigin(checkOrigin func(request *http.Request) bool) {
+	opt.upgrader.CheckOrigin = checkOrigin
+}
+
+/*SetQuotaManager *
+ * Counts the tunnels of this endpoint against the quotas of the given
+ * manager, which may be shared with a GuacamoleHTTPTunnelServlet.
+ * Connections beyond the quotas are closed with CLIENT_TOO_MANY or
+ * SERVER_BUSY. Must be called before any request is handled.
+ *
+ * @param quotas
+ *     The quota manager, replacing any set before, or nil for no quotas.
+ */
+func (opt *GuacamoleWebSocketTunnelEndpoint) SetQuotaManager(quotas *gservlet.QuotaManager) {
+	if opt.quotas != nil {
+		opt.events.Unsubscribe(opt.quotaSubscription)
+	}
+	opt.quotas = quotas
+	if quotas != nil {
+		opt.quotaSubscription = quotas.Subscribe(opt.events)
+	}
+}
+
+/*SetInputLimiter *
+ * Limits the input written by clients with the given limiter, which may be
+ * shared with a GuacamoleHTTPTunnelServlet. Messages exceeding the rates
+ * are delayed, and close their connection with CLIENT_TOO_MANY if they
+ * would be delayed longer than gservlet.INPUT_MAX_DELAY. Instructions
+ * exceeding the sizes close their connection with CLIENT_OVERRUN. Must be
+ * called before any request is handled.
+ *
+ * @param limiter
+ *     The input limiter.
+ *
+ * @param owners
+ *     Resolves the user whose limits apply to each connection, or nil to
+ *     limit each connection alone.
+ */
+func (opt *GuacamoleWebSocketTunnelEndpoint) SetInputLimiter(limiter *gservlet.InputLimiter,
+	owners gservlet.TunnelOwnerResolverInterface) {
+	opt.inputLimiter = limiter
+	opt.inputOwners = owners
+}
+
+/*Size *
+ * Returns the number of active sessions.
+ */
+func (opt *GuacamoleWebSocketTunnelEndpoint) Size() int {
+	opt.lock.Lock()
+	defer opt.lock.Unlock()
+	return len(opt.sessions)
+}
+
+// ServeHTTP Override http.Handler.ServeHTTP
+func (opt *GuacamoleWebSocketTunnelEndpoint) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	conn, e := opt.upgrader.Upgrade(writer, request, nil)
+	if e != nil {
+		logger.Debug("WebSocket upgrade failed: ", e)
+		return
+	}
+	session := &webSocketSession{endpoint: opt, conn: conn}
+
+	// Refuse new tunnels while draining or destroyed
+	opt.lock.Lock()
+	closing := opt.closing
+	opt.lock.Unlock()
+	if closing {
+		session.closeConnection(exp.GuacamoleServerBusyException.Throw("Server is not accepting new connections."))
+		return
+	}
+
+	// Take a share of the quotas, completed by doConnect
+	ctx, adapter := requestContext(request), gservlet.NewHTTPRequestAdapter(request)
+	var reservation *gservlet.QuotaReservation
+	if opt.quotas != nil {
+		var err exp.ExceptionInterface
+		if reservation, err = opt.quotas.ReserveRequest(adapter); err != nil {
+			session.closeConnection(err)
+			return
+		}
+		ctx = gservlet.WithQuotaReservation(ctx, reservation)
+	}
+
+	// Limit the input of the client as that of its user, if known
+	if opt.inputLimiter != nil {
+		owner := ""
+		if opt.inputOwners != nil {
+			var err exp.ExceptionInterface
+			if owner, err = opt.inputOwners(adapter); err != nil {
+				reservation.Release()
+				session.closeConnection(err)
+				return
+			}
+		}
+		session.input = opt.inputLimiter.Open(owner)
+	}
+
+	tunnel, err := opt.connect(ctx, adapter)
+	if err != nil {
+		reservation.Release()
+		logger.Warn("Creation of WebSocket tunnel to guacd failed: ", err.GetMessage())
+		session.closeConnection(err)
+		return
+	}
+	session.tunnel = tunnel
+
+	// Register the session unless a drain started meanwhile. Its CLOSED
+	// event releases its reservation.
+	uuid := tunnel.GetUUID().String()
+	reservation.Bind(uuid)
+	opt.lock.Lock()
+	if opt.closing {
+		opt.lock.Unlock()
+		reservation.Release()
+		tunnel.Close()
+		session.closeConnection(exp.GuacamoleServerBusyException.Throw("Server is not accepting new connections."))
+		return
+	}
+	opt.sessions[uuid] = session
+	opt.lock.Unlock()
+	gmetrics.TunnelsActive.Inc(tunnelProtocol(tunnel))
+	opt.events.Publish(gevent.NewTunnelEvent(gevent.READY, tunnel))
+
+	// Send tunnel UUID
+	uuidInstruction := gprotocol.NewGuacamoleInstruction(INTERNAL_DATA_OPCODE, uuid)
+	e = session.send([]byte(uuidInstruction.String()))
+	if e != nil {
+		session.finish(gevent.CLOSE_REASON_IO_ERROR, exp.GuacamoleConnectionClosedException.Wrap(e))
+		return
+	}
+
+	go session.relayToClient()
+	session.relayToServer()
+}
+
+/**
+ * Calls doConnect within a "doConnect" span, publishing the events of the
+ * attempt.
+ */
+func (opt *GuacamoleWebSocketTunnelEndpoint) connect(ctx context.Context,
+	request gservlet.HTTPServletRequestInterface) (tunnel gnet.GuacamoleTunnel, err exp.ExceptionInterface) {
+
+	ctx, span := gtrace.Start(ctx, "doConnect")
+	defer span.End()
+
+	opt.events.Publish(gevent.NewTunnelEvent(gevent.CONNECTING, nil))
+
+	tunnel, e := opt.doConnect(ctx, request)
+	switch {
+	case e != nil:
+		if one, ok := e.(exp.ExceptionInterface); ok {
+			err = one
+		} else {
+			err = exp.GuacamoleServerException.Wrap(e)
+		}
+	case tunnel == nil:
+		err = exp.GuacamoleResourceNotFoundException.Throw("No tunnel created.")
+	}
+	if err != nil {
+		span.SetError(err.GetMessage())
+		opt.events.Publish(gevent.NewTunnelEvent(gevent.UPSTREAM_ERROR, nil).WithError(err))
+		return nil, err
+	}
+
+	span.SetAttribute("guacamole.tunnel_uuid", tunnel.GetUUID().String())
+	opt.events.Publish(gevent.NewTunnelEvent(gevent.HANDSHAKE_COMPLETE, tunnel))
+	return
+}
+
+/*Drain *
+ * Stops accepting connections, sends every active session the
+ * gservlet.DRAIN_NOTICE, and waits for the sessions to end or for the
+ * given context to be done. Sessions left are then closed with
+ * SERVER_BUSY, so that the JavaScript client reconnects elsewhere.
+ *
+ * @param ctx
+ *     The context bounding the drain. Its deadline, if any, is announced
+ *     to the users.
+ *
+ * @return
+ *     The number of sessions closed when the context was done.
+ */
+func (opt *GuacamoleWebSocketTunnelEndpoint) Drain(ctx context.Context) int {
+	deadline, _ := ctx.Deadline()
+	notice := gservlet.DrainNotice(deadline)
+
+	opt.lock.Lock()
+	opt.closing = true
+	sessions := opt.snapshotLocked()
+	opt.lock.Unlock()
+	logger.Infof("Draining WebSocket tunnels, %v active.", len(sessions))
+
+	for _, session := range sessions {
+		session.sendInstructions(gservlet.NewNoticeInstructions(notice))
+	}
+
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for opt.Size() > 0 {
+		select {
+		case <-tick.C:
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+
+	remaining := opt.Size()
+	if remaining > 0 {
+		logger.Infof("Drain deadline reached, closing %v remaining WebSocket tunnels.", remaining)
+		opt.closeAll(gevent.CLOSE_REASON_SHUTDOWN, exp.GuacamoleServerBusyException.Throw(gservlet.DRAIN_CLOSE_MESSAGE))
+	}
+	return remaining
+}
+
+/*Destroy *
+ * Stops accepting connections and closes every active session with
+ * SESSION_CLOSED.
+ */
+func (opt *GuacamoleWebSocketTunnelEndpoint) Destroy() {
+	opt.lock.Lock()
+	opt.closing = true
+	opt.lock.Unlock()
+	opt.closeAll(gevent.CLOSE_REASON_SHUTDOWN, exp.GuacamoleSessionClosedException.Throw("Server is shutting down."))
+}
+
+func (opt *GuacamoleWebSocketTunnelEndpoint) snapshotLocked() (ret []*webSocketSession) {
+	ret = make([]*webSocketSession, 0, len(opt.sessions))
+	for _, session := range opt.sessions {
+		ret = append(ret, session)
+	}
+	return
+}
+
+func (opt *GuacamoleWebSocketTunnelEndpoint) closeAll(reason string, err exp.ExceptionInterface) {
+	opt.lock.Lock()
+	sessions := opt.snapshotLocked()
+	opt.lock.Unlock()
+	for _, session := range sessions {
+		session.finish(reason, err)
+	}
+}
+
+/**
+ * Returns the context of the given request, carrying the remote parent
+ * span of its "traceparent" header, if any.
+ */
+func requestContext(request *http.Request) context.Context {
+	ctx := context.Background()
+	if parent, ok := gtrace.ParseTraceparent(request.Header.Get(gtrace.TRACEPARENT_HEADER)); ok {
+		ctx = gtrace.ContextWithRemoteParent(ctx, parent)
+	}
+	return ctx
+}
+
+/**
+ * Returns the protocol of the given tunnel, for use as a metric label.
+ */
+func tunnelProtocol(tunnel gnet.GuacamoleTunnel) string {
+	if config := tunnel.GetConfiguration(); config != nil {
+		return config.GetProtocol()
+	}
+	return ""
+}
+
+/**
+ * The WebSocket connection of one client and its tunnel.
+ */
+type webSocketSession struct {
+	endpoint *GuacamoleWebSocketTunnelEndpoint
+	conn     *websocket.Conn
+	tunnel   gnet.GuacamoleTunnel
+
+	/**
+	 * The gate limiting the input of the client, or nil if unlimited.
+	 * Only used by the relay to the server.
+	 */
+	input *gservlet.InputGate
+
+	/**
+	 * Serializes the messages sent to the client by the relay to the
+	 * client, ping replies and notices.
+	 */
+	writeLock sync.Mutex
+
+	finishOnce sync.Once
+}
+
+/**
+ * Sends the given instruction data to the client as one text message.
+ */
+func (opt *webSocketSession) send(data []byte) error {
+	opt.writeLock.Lock()
+	defer opt.writeLock.Unlock()
+	return opt.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (opt *webSocketSession) sendInstructions(instructions []gprotocol.GuacamoleInstruction) {
+	var buffer bytes.Buffer
+	for _, instruction := range instructions {
+		buffer.WriteString(instruction.String())
+	}
+	if e := opt.send(buffer.Bytes()); e != nil {
+		logger.Debug("Unable to send instructions over WebSocket: ", e)
+	}
+}
+
+/**
+ * Closes the WebSocket connection, first sending the "error" instruction
+ * corresponding to the given error, if any.
+ */
+func (opt *webSocketSession) closeConnection(err exp.ExceptionInterface) {
+	if err == nil {
+		opt.closeConnection2(exp.SUCCESS, nil)
+		return
+	}
+	instruction := gservlet.NewErrorInstruction(err)
+	opt.closeConnection2(err.GetStatus(), []byte(instruction.String()))
+}
+
+/**
+ * Closes the WebSocket connection with the given status, first sending
+ * the given "error" instruction, if any. The close code is the WebSocket
+ * code of the status, and the close reason is its Guacamole status code,
+ * as the JavaScript client expects.
+ */
+func (opt *webSocketSession) closeConnection2(status exp.GuacamoleStatus, errorInstruction []byte) {
+	if len(errorInstruction) > 0 {
+		if e := opt.send(errorInstruction); e != nil {
+			logger.Debug("Unable to send error over WebSocket: ", e)
+		}
+	}
+
+	opt.writeLock.Lock()
+	message := websocket.FormatCloseMessage(status.GetWebSocketCode(), strconv.Itoa(status.GetGuacamoleStatusCode()))
+	opt.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	opt.writeLock.Unlock()
+	opt.conn.Close()
+}
+
+/**
+ * Ends the session with the given reason and error, if any.
+ */
+func (opt *webSocketSession) finish(reason string, err exp.ExceptionInterface) {
+	closed := gevent.NewTunnelEvent(gevent.CLOSED, nil).WithError(err)
+	closed.Reason = reason
+	var instruction []byte
+	if err != nil {
+		one := gservlet.NewErrorInstruction(err)
+		instruction = []byte(one.String())
+	}
+	opt.finish2(closed, instruction)
+}
+
+/**
+ * Ends the session once: deregisters it, closes its tunnel, closes the
+ * connection with the status of the given CLOSED event after sending the
+ * given "error" instruction, if any, and publishes the event.
+ */
+func (opt *webSocketSession) finish2(closed gevent.TunnelEvent, errorInstruction []byte) {
+	opt.finishOnce.Do(func() {
+		uuid := opt.tunnel.GetUUID().String()
+		opt.endpoint.lock.Lock()
+		delete(opt.endpoint.sessions, uuid)
+		opt.endpoint.lock.Unlock()
+
+		opt.tunnel.Close()
+		opt.closeConnection2(closed.Status, errorInstruction)
+		gmetrics.TunnelsActive.Dec(tunnelProtocol(opt.tunnel))
+
+		closed.Tunnel = opt.tunnel
+		closed.TunnelUUID = uuid
+		opt.endpoint.events.Publish(closed)
+		logger.Debugf("WebSocket tunnel \"%v\" closed: %v", uuid, closed.Reason)
+	})
+}
+
+/**
+ * Relays instructions read from the tunnel to the client, batching those
+ * available without blocking, until the tunnel ends.
+ */
+func (opt *webSocketSession) relayToClient() {
+	reader := opt.tunnel.AcquireReader()
+	defer opt.tunnel.ReleaseReader()
+
+	var buffer bytes.Buffer
+	for {
+		message, err := reader.Read()
+		if err != nil {
+			if err.Kind() == exp.GuacamoleConnectionClosedException {
+				opt.finish(gevent.CLOSE_REASON_UPSTREAM, nil)
+			} else {
+				opt.endpoint.events.Publish(gevent.NewTunnelEvent(gevent.READER_ERROR, opt.tunnel).WithError(err))
+				opt.finish(gevent.CLOSE_REASON_IO_ERROR, err)
+			}
+			return
+		}
+		if len(message) == 0 {
+			opt.finish(gevent.CLOSE_REASON_UPSTREAM, nil)
+			return
+		}
+		buffer.Write(message)
+		gmetrics.TunnelBytes.Add(float64(len(message)), gmetrics.SERVER_TO_CLIENT)
+		gmetrics.TunnelInstructions.Inc(gmetrics.SERVER_TO_CLIENT)
+
+		// guacd ended the session, its "error" instruction is sent along
+		// with the close
+		if gprotocol.IsErrorInstruction(message) {
+			upstream := gprotocol.ParseErrorInstructionException(string(message))
+			opt.endpoint.events.Publish(gevent.NewTunnelEvent(gevent.UPSTREAM_ERROR, opt.tunnel).WithError(upstream))
+
+			buffer.Truncate(buffer.Len() - len(message))
+			if buffer.Len() > 0 {
+				opt.send(buffer.Bytes())
+			}
+			closed := gevent.NewTunnelEvent(gevent.CLOSED, nil).WithError(upstream)
+			closed.Reason = gevent.CLOSE_REASON_UPSTREAM
+			opt.finish2(closed, message)
+			return
+		}
+
+		// Send once the buffer is full or no more data is available
+		available, _ := reader.Available()
+		if !available || buffer.Len() >= BUFFER_SIZE {
+			if e := opt.send(buffer.Bytes()); e != nil {
+				opt.finish(gevent.CLOSE_REASON_CLIENT, nil)
+				return
+			}
+			buffer.Reset()
+		}
+	}
+}
+
+/**
+ * Relays messages received from the client to the tunnel, answering
+ * internal instructions itself, until the client disconnects.
+ */
+func (opt *webSocketSession) relayToServer() {
+	for {
+		kind, message, e := opt.conn.ReadMessage()
+		if e != nil {
+			opt.finish(gevent.CLOSE_REASON_CLIENT, nil)
+			return
+		}
+		if kind != websocket.TextMessage {
+			continue
+		}
+
+		// Answer internal instructions rather than forwarding them
+		if bytes.HasPrefix(message, []byte("0.")) {
+			parser := gprotocol.NewGuacamoleStreamParser()
+			instructions, err := parser.Append(message)
+			if err == nil && len(instructions) > 0 && instructions[0].GetOpcode() == INTERNAL_DATA_OPCODE {
+				args := instructions[0].GetArgs()
+				if len(args) > 0 && args[0] == PING_OPCODE {
+					opt.send(message)
+				}
+			}
+			continue
+		}
+
+		// Limited input is delayed, or refused as a whole, closing the
+		// connection rather than dropping instructions
+		if opt.input != nil {
+			instructions, delay, refused := opt.input.Admit(message)
+			if refused != nil {
+				opt.finish(gevent.CLOSE_REASON_CLIENT, refused)
+				return
+			}
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+			var data bytes.Buffer
+			for _, instruction := range instructions {
+				data.WriteString(instruction.String())
+			}
+			if message = data.Bytes(); len(message) == 0 {
+				continue
+			}
+		}
+
+		writer := opt.tunnel.AcquireWriter()
+		err := writer.Write(message, 0, len(message))
+		opt.tunnel.ReleaseWriter()
+		if err != nil {
+			opt.endpoint.events.Publish(gevent.NewTunnelEvent(gevent.WRITER_ERROR, opt.tunnel).WithError(err))
+			opt.finish(gevent.CLOSE_REASON_IO_ERROR, err)
+			return
+		}
+		gmetrics.TunnelBytes.Add(float64(len(message)), gmetrics.CLIENT_TO_SERVER)
+	}
+}
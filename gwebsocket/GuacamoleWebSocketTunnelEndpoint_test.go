@@ -0,0 +1,366 @@
+package gwebsocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	exp "github.com/hsfish/guacamole_client_go"
+	"github.com/hsfish/guacamole_client_go/gevent"
+	"github.com/hsfish/guacamole_client_go/gio"
+	"github.com/hsfish/guacamole_client_go/gnet"
+	"github.com/hsfish/guacamole_client_go/gprotocol"
+	"github.com/hsfish/guacamole_client_go/gservlet"
+)
+
+/**
+ * A socket to a fake guacd, played by the other end of a pipe.
+ */
+type testSocket struct {
+	conn   net.Conn
+	reader gio.GuacamoleReader
+	writer gio.GuacamoleWriter
+	closed int32
+}
+
+func (opt *testSocket) GetReader() gio.GuacamoleReader { return opt.reader }
+func (opt *testSocket) GetWriter() gio.GuacamoleWriter { return opt.writer }
+func (opt *testSocket) IsOpen() bool                   { return atomic.LoadInt32(&opt.closed) == 0 }
+
+func (opt *testSocket) Close() exp.ExceptionInterface {
+	atomic.StoreInt32(&opt.closed, 1)
+	opt.conn.Close()
+	return nil
+}
+
+/**
+ * Returns a tunnel to a fake guacd, and the connection of that guacd.
+ */
+func newTestTunnel() (gnet.GuacamoleTunnel, net.Conn) {
+	client, guacd := net.Pipe()
+	stream := gio.NewStream(client, 15*time.Second)
+	socket := &testSocket{
+		conn:   client,
+		reader: gio.NewReaderGuacamoleReader(stream),
+		writer: gio.NewWriterGuacamoleWriter(stream),
+	}
+	return gnet.NewSimpleGuacamoleTunnel(socket, gprotocol.NewGuacamoleConfiguration()), guacd
+}
+
+/**
+ * Starts an endpoint limiting input with the given limits, each tunnel of
+ * which leads to a new fake guacd, whose connection is sent on the
+ * returned channel.
+ */
+func newTestEndpoint(limits gservlet.InputLimits) (*GuacamoleWebSocketTunnelEndpoint, *httptest.Server, chan net.Conn) {
+	guacds := make(chan net.Conn, 16)
+	endpoint := NewGuacamoleWebSocketTunnelEndpoint(func(ctx context.Context,
+		request gservlet.HTTPServletRequestInterface) (gnet.GuacamoleTunnel, error) {
+		tunnel, guacd := newTestTunnel()
+		guacds <- guacd
+		return tunnel, nil
+	}, nil)
+	endpoint.SetInputLimiter(gservlet.NewInputLimiter(limits), nil)
+	return endpoint, httptest.NewServer(endpoint), guacds
+}
+
+/**
+ * Connects a client to the endpoint served by the given server.
+ */
+func dialTestEndpoint(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: []string{GUACAMOLE_PROTOCOL}}
+	conn, _, e := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return conn
+}
+
+/**
+ * Connects a client to the endpoint served by the given server, returning
+ * the UUID of its tunnel.
+ */
+func openTestSession(t *testing.T, server *httptest.Server) (*websocket.Conn, string) {
+	t.Helper()
+	conn := dialTestEndpoint(t, server)
+	parser := gprotocol.NewGuacamoleStreamParser()
+	_, message, e := conn.ReadMessage()
+	if e != nil {
+		t.Fatal(e)
+	}
+	instructions, err := parser.Append(message)
+	if err != nil || len(instructions) != 1 || instructions[0].GetOpcode() != INTERNAL_DATA_OPCODE ||
+		len(instructions[0].GetArgs()) != 1 {
+		t.Fatalf("UUID instruction %q", message)
+	}
+	return conn, instructions[0].GetArgs()[0]
+}
+
+/**
+ * Checks that the given client receives the "error" instruction of the
+ * given status, then the close of its connection.
+ */
+func checkClosed(t *testing.T, conn *websocket.Conn, status exp.GuacamoleStatus) {
+	t.Helper()
+	_, message, e := conn.ReadMessage()
+	code := strconv.Itoa(status.GetGuacamoleStatusCode())
+	if e != nil || !strings.HasPrefix(string(message), "5.error,") || !strings.HasSuffix(string(message), code+";") {
+		t.Fatalf("error %q, err %v", message, e)
+	}
+	if _, _, e = conn.ReadMessage(); !websocket.IsCloseError(e, status.GetWebSocketCode()) {
+		t.Errorf("connection closed with %v", e)
+	}
+}
+
+/**
+ * Starts an endpoint limiting input with the given limits, and connects a
+ * client to it.
+ */
+func newTestSession(t *testing.T, limits gservlet.InputLimits) (*websocket.Conn, net.Conn, *gevent.EventBus, func()) {
+	endpoint, server, guacds := newTestEndpoint(limits)
+	conn, _ := openTestSession(t, server)
+	guacd := <-guacds
+	return conn, guacd, endpoint.GetEventBus(), func() {
+		// The session ends before guacd goes away, so that no write to
+		// guacd is in progress
+		conn.Close()
+		for deadline := time.Now().Add(5 * time.Second); endpoint.Size() > 0 && time.Now().Before(deadline); {
+			time.Sleep(5 * time.Millisecond)
+		}
+		guacd.Close()
+		endpoint.Destroy()
+		server.Close()
+	}
+}
+
+func Test_WebSocketInputLimits(t *testing.T) {
+	conn, guacd, events, cleanup := newTestSession(t, gservlet.InputLimits{MaxInstructionLength: 32})
+	defer cleanup()
+	closed := make(chan gevent.TunnelEvent, 1)
+	events.Subscribe(func(event gevent.TunnelEvent) { closed <- event }, gevent.CLOSED)
+
+	// Admitted input reaches guacd, pings are answered by the endpoint
+	const key = "3.key,5.65307,1.1;"
+	if e := conn.WriteMessage(websocket.TextMessage, []byte(key)); e != nil {
+		t.Fatal(e)
+	}
+	received := bufio.NewReader(guacd)
+	if line, e := received.ReadString(';'); e != nil || line != key {
+		t.Fatalf("guacd received %q, err %v", line, e)
+	}
+	ping := gprotocol.NewGuacamoleInstruction(INTERNAL_DATA_OPCODE, PING_OPCODE, "1")
+	conn.WriteMessage(websocket.TextMessage, []byte(ping.String()))
+	if _, message, e := conn.ReadMessage(); e != nil || string(message) != ping.String() {
+		t.Fatalf("ping answered %q, err %v", message, e)
+	}
+
+	// Oversized instructions close the connection rather than being
+	// dropped
+	conn.WriteMessage(websocket.TextMessage, []byte("9.clipboard,40."+strings.Repeat("a", 40)+";"))
+	checkClosed(t, conn, exp.CLIENT_OVERRUN)
+	select {
+	case event := <-closed:
+		if event.Reason != gevent.CLOSE_REASON_CLIENT || event.Status != exp.CLIENT_OVERRUN {
+			t.Errorf("closed with reason %q, status %v", event.Reason, event.Status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel not closed")
+	}
+	if line, e := received.ReadString(';'); e == nil {
+		t.Errorf("guacd received %q after the refusal", line)
+	}
+}
+
+func Test_WebSocketInputDelay(t *testing.T) {
+	conn, guacd, _, cleanup := newTestSession(t, gservlet.InputLimits{TunnelInstructionsPerSecond: 10})
+	defer cleanup()
+
+	// Input beyond the burst is delayed rather than dropped
+	const key = "3.key,5.65307,1.1;"
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		if e := conn.WriteMessage(websocket.TextMessage, []byte(key)); e != nil {
+			t.Fatal(e)
+		}
+	}
+	received := bufio.NewReader(guacd)
+	for i := 0; i < 12; i++ {
+		if line, e := received.ReadString(';'); e != nil || line != key {
+			t.Fatalf("guacd received %q, err %v", line, e)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("input delivered within %v", elapsed)
+	}
+}
+
+/**
+ * Checks that the given client receives the drain notice, mentioning the
+ * time left if given a deadline.
+ */
+func checkDrainNotice(t *testing.T, conn *websocket.Conn, deadline bool) {
+	t.Helper()
+	_, message, e := conn.ReadMessage()
+	if e != nil {
+		t.Fatal(e)
+	}
+	parser := gprotocol.NewGuacamoleStreamParser()
+	instructions, err := parser.Append(message)
+	if err != nil || len(instructions) != 3 {
+		t.Fatalf("notice %q", message)
+	}
+	index := strconv.Itoa(gservlet.NOTICE_STREAM_INDEX)
+	var opcodes []string
+	for _, instruction := range instructions {
+		if args := instruction.GetArgs(); len(args) == 0 || args[0] != index {
+			t.Errorf("%v instruction sent outside the notice stream", instruction.GetOpcode())
+		}
+		opcodes = append(opcodes, instruction.GetOpcode())
+	}
+	text, _ := base64.StdEncoding.DecodeString(instructions[1].GetArgs()[1])
+	expected := string(text) == gservlet.DRAIN_NOTICE
+	if deadline {
+		expected = strings.HasPrefix(string(text), gservlet.DRAIN_NOTICE+" Your session will end in ")
+	}
+	if strings.Join(opcodes, ",") != "pipe,blob,end" || !expected {
+		t.Errorf("notice %v %q", opcodes, text)
+	}
+}
+
+func Test_WebSocketDrain(t *testing.T) {
+	endpoint, server, guacds := newTestEndpoint(gservlet.InputLimits{})
+	defer server.Close()
+	defer endpoint.Destroy()
+	first, _ := openTestSession(t, server)
+	defer first.Close()
+	firstGuacd := <-guacds
+	defer firstGuacd.Close()
+	second, _ := openTestSession(t, server)
+	defer second.Close()
+	secondGuacd := <-guacds
+	defer secondGuacd.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	drained := make(chan int, 1)
+	go func() { drained <- endpoint.Drain(ctx) }()
+
+	// Every user is told when their session will end
+	checkDrainNotice(t, first, true)
+	checkDrainNotice(t, second, true)
+
+	// New connections are refused
+	refused := dialTestEndpoint(t, server)
+	defer refused.Close()
+	checkClosed(t, refused, exp.SERVER_BUSY)
+
+	// The drain ends with the last session, none having to be closed
+	firstGuacd.Close()
+	second.Close()
+	select {
+	case remaining := <-drained:
+		if remaining != 0 {
+			t.Errorf("drain closed %v sessions", remaining)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("drain continued after the last session ended")
+	}
+}
+
+func Test_WebSocketDrainDeadline(t *testing.T) {
+	endpoint, server, guacds := newTestEndpoint(gservlet.InputLimits{})
+	defer server.Close()
+	defer endpoint.Destroy()
+	closed := make(chan gevent.TunnelEvent, 2)
+	endpoint.GetEventBus().Subscribe(func(event gevent.TunnelEvent) { closed <- event }, gevent.CLOSED)
+
+	var conns []*websocket.Conn
+	uuids := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		conn, uuid := openTestSession(t, server)
+		defer conn.Close()
+		guacd := <-guacds
+		defer guacd.Close()
+		conns = append(conns, conn)
+		uuids[uuid] = true
+	}
+
+	// Sessions left when the context is done are closed and counted, the
+	// notice being sent without a deadline
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	if remaining := endpoint.Drain(ctx); remaining != 2 {
+		t.Errorf("drain closed %v sessions", remaining)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("drain ended within %v", elapsed)
+	}
+	for _, conn := range conns {
+		checkDrainNotice(t, conn, false)
+		checkClosed(t, conn, exp.SERVER_BUSY)
+	}
+	for range conns {
+		select {
+		case event := <-closed:
+			if !uuids[event.TunnelUUID] || event.Reason != gevent.CLOSE_REASON_SHUTDOWN || event.Status != exp.SERVER_BUSY {
+				t.Errorf("%v closed with reason %q, status %v", event.TunnelUUID, event.Reason, event.Status)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("tunnel not closed")
+		}
+	}
+	if endpoint.Size() != 0 {
+		t.Errorf("%v sessions left", endpoint.Size())
+	}
+}
+
+/**
+ * Returns whether a share of the given quotas reserved for a tunnel is
+ * released when the CLOSED event of that tunnel is published on the given
+ * bus.
+ */
+func releasedOnClose(t *testing.T, quotas *gservlet.QuotaManager, events *gevent.EventBus) bool {
+	t.Helper()
+	reservation, err := quotas.Reserve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reservation.Bind("tunnel-1")
+	closed := gevent.NewTunnelEvent(gevent.CLOSED, nil)
+	closed.TunnelUUID = "tunnel-1"
+	events.Publish(closed)
+	released := quotas.Usage().Total == 0
+	reservation.Release()
+	return released
+}
+
+func Test_WebSocketSetQuotaManager(t *testing.T) {
+	endpoint := NewGuacamoleWebSocketTunnelEndpoint(nil, nil)
+	defer endpoint.Destroy()
+	events := endpoint.GetEventBus()
+
+	// Nil means no quotas, and a manager replaced or removed no longer
+	// releases the tunnels of the endpoint
+	endpoint.SetQuotaManager(nil)
+	replaced, quotas := gservlet.NewQuotaManager(gservlet.QuotaLimits{}), gservlet.NewQuotaManager(gservlet.QuotaLimits{})
+	endpoint.SetQuotaManager(replaced)
+	endpoint.SetQuotaManager(quotas)
+	if releasedOnClose(t, replaced, events) || !releasedOnClose(t, quotas, events) {
+		t.Errorf("released by the replaced manager, or not by the current one")
+	}
+	endpoint.SetQuotaManager(nil)
+	if releasedOnClose(t, quotas, events) {
+		t.Errorf("released by the removed manager")
+	}
+}